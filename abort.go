@@ -0,0 +1,127 @@
+package memdb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AbortKind classifies the reason a write transaction was aborted.
+type AbortKind string
+
+const (
+	// AbortUnspecified is used for transactions aborted with Abort.
+	AbortUnspecified AbortKind = "unspecified"
+
+	// AbortCancelled is used for context.Canceled.
+	AbortCancelled AbortKind = "cancelled"
+
+	// AbortTimeout is used for context.DeadlineExceeded and for errors
+	// with a Timeout method returning true.
+	AbortTimeout AbortKind = "timeout"
+
+	// AbortConflict is used for conflicts with other transactions.
+	AbortConflict AbortKind = "conflict"
+
+	// AbortValidation is used for objects rejected by the application.
+	AbortValidation AbortKind = "validation"
+
+	// AbortPanic is used for a *PanicError.
+	AbortPanic AbortKind = "panic"
+
+	// AbortLimit is used for a *TxnLimitError and for ErrThrottled.
+	AbortLimit AbortKind = "limit"
+
+	// AbortError is used for any other error.
+	AbortError AbortKind = "error"
+)
+
+// ClassifyAbort returns the AbortKind of an abort reason. Errors can choose
+// their own kind by implementing an AbortKind() AbortKind method, which is
+// how application errors are reported as AbortValidation or AbortConflict.
+func ClassifyAbort(reason error) AbortKind {
+	switch err := reason.(type) {
+	case nil:
+		return AbortUnspecified
+	case interface{ AbortKind() AbortKind }:
+		return err.AbortKind()
+	case *PanicError:
+		return AbortPanic
+	case *TxnLimitError:
+		return AbortLimit
+	case interface{ Timeout() bool }:
+		if err.Timeout() {
+			return AbortTimeout
+		}
+	}
+	switch reason {
+	case context.Canceled:
+		return AbortCancelled
+	case context.DeadlineExceeded:
+		return AbortTimeout
+	case ErrThrottled:
+		return AbortLimit
+	case ErrConflict:
+		return AbortConflict
+	}
+	return AbortError
+}
+
+// AbortInfo describes an aborted write transaction.
+type AbortInfo struct {
+	Kind AbortKind
+
+	// Reason is the error given to AbortWithReason, or nil.
+	Reason error
+
+	// Objects is the number of objects the transaction had changed, and
+	// Duration how long it held the writer lock.
+	Objects  int
+	Duration time.Duration
+}
+
+// AbortFunc is called with the AbortInfo of each aborted transaction.
+type AbortFunc func(*AbortInfo)
+
+// WithAbortHook registers a function that is called after a write
+// transaction is aborted, once the writer lock has been released.
+func WithAbortHook(fn AbortFunc) Option {
+	return func(db *MemDB) {
+		db.abortFn = fn
+	}
+}
+
+// abortCounts counts the aborted transactions by kind.
+type abortCounts struct {
+	l      sync.Mutex
+	counts map[AbortKind]uint64
+}
+
+// AbortCounts returns the number of write transactions aborted so far, by
+// kind. Transactions that were never written to are counted too.
+func (db *MemDB) AbortCounts() map[AbortKind]uint64 {
+	db.aborts.l.Lock()
+	defer db.aborts.l.Unlock()
+	counts := make(map[AbortKind]uint64, len(db.aborts.counts))
+	for kind, n := range db.aborts.counts {
+		counts[kind] = n
+	}
+	return counts
+}
+
+// recordAbort counts an aborted transaction and reports it to the hook.
+func (db *MemDB) recordAbort(info *AbortInfo) {
+	db.aborts.l.Lock()
+	if db.aborts.counts == nil {
+		db.aborts.counts = make(map[AbortKind]uint64)
+	}
+	db.aborts.counts[info.Kind]++
+	db.aborts.l.Unlock()
+
+	if info.Reason != nil {
+		db.logger.Debug("transaction aborted", "kind", info.Kind, "reason", info.Reason)
+	}
+	if db.abortFn != nil {
+		db.abortFn(info)
+	}
+}
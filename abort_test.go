@@ -0,0 +1,69 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type testConflictError struct{}
+
+func (testConflictError) Error() string        { return "conflict" }
+func (testConflictError) AbortKind() AbortKind { return AbortConflict }
+
+func TestTxn_AbortWithReason(t *testing.T) {
+	var infos []*AbortInfo
+	db, err := NewMemDB(testValidSchema(), WithAbortHook(func(info *AbortInfo) {
+		infos = append(infos, info)
+	}), WithTxnLimits(TxnLimits{MaxOps: 1}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"q"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.AbortWithReason(context.DeadlineExceeded)
+	txn.Abort()
+
+	db.Txn(true).AbortWithReason(testConflictError{})
+	db.Txn(true).AbortWithReason(context.Canceled)
+	db.Txn(true).AbortWithReason(fmt.Errorf("boom"))
+	db.Txn(true).Abort()
+	db.Txn(false).AbortWithReason(fmt.Errorf("ignored"))
+
+	// Internal aborts carry their reason
+	txn = db.Txn(true)
+	for _, id := range []string{"a", "b"} {
+		txn.Insert("main", &TestObject{ID: id, Foo: "x", Qux: []string{"q"}})
+	}
+	err = Retry(db, func(txn *Txn) error {
+		return &PanicError{Op: "test"}
+	})
+	if _, ok := err.(*PanicError); !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	if len(infos) != 7 || infos[0].Kind != AbortTimeout || infos[0].Objects != 1 {
+		t.Fatalf("bad: %#v", infos[0])
+	}
+	expect := map[AbortKind]uint64{
+		AbortTimeout:     1,
+		AbortConflict:    1,
+		AbortCancelled:   1,
+		AbortError:       1,
+		AbortUnspecified: 1,
+		AbortLimit:       1,
+		AbortPanic:       1,
+	}
+	counts := db.AbortCounts()
+	if len(counts) != len(expect) {
+		t.Fatalf("bad: %#v", counts)
+	}
+	for kind, n := range expect {
+		if counts[kind] != n {
+			t.Fatalf("bad: %#v", counts)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package memdb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrThrottled is returned when a write is refused by admission control
+	// because its TokenBucket is empty. The write may be retried later.
+	ErrThrottled = fmt.Errorf("write throttled")
+)
+
+// AdmissionStats counts the decisions made by a TokenBucket.
+type AdmissionStats struct {
+	Admitted  uint64
+	Throttled uint64
+}
+
+// TokenBucket is a token bucket used for admission control of writes and
+// background jobs. Tokens are added at a fixed rate up to the burst size,
+// and every admitted operation takes one. Giving each class of writer its
+// own bucket keeps bulk writers from starving latency-sensitive ones, since
+// the writer lock is only requested once a token was taken.
+//
+// TokenBucket 是用于写入和后台任务准入控制的令牌桶。
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	l      sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  AdmissionStats
+}
+
+// NewTokenBucket returns a full TokenBucket that refills at rate tokens per
+// second, holding at most burst tokens.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// Allow takes a token if one is available at the given time, and returns
+// whether it did.
+func (b *TokenBucket) Allow(now time.Time) bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	if b.last.IsZero() || now.After(b.last) {
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		b.stats.Throttled++
+		return false
+	}
+	b.tokens--
+	b.stats.Admitted++
+	return true
+}
+
+// Stats returns the number of operations admitted and throttled so far.
+func (b *TokenBucket) Stats() AdmissionStats {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.stats
+}
+
+// WithJobAdmission subjects the background jobs of the MemDB, such as
+// reapers and dispatchers, to the given TokenBucket. A job run that is
+// throttled is skipped until the job is next due.
+func WithJobAdmission(limiter *TokenBucket) Option {
+	return func(db *MemDB) {
+		db.jobLimiter = limiter
+	}
+}
+
+// AdmitTxn starts a write transaction if the limiter has a token available
+// according to the DB's clock, and returns ErrThrottled otherwise without
+// waiting for the writer lock.
+//
+// AdmitTxn 在令牌桶允许时开启写事务，否则返回 ErrThrottled 。
+func (db *MemDB) AdmitTxn(limiter *TokenBucket) (*Txn, error) {
+	if !limiter.Allow(db.clock.Now()) {
+		return nil, ErrThrottled
+	}
+	return db.Txn(true), nil
+}
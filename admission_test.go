@@ -0,0 +1,98 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewTokenBucket(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if !b.Allow(now) {
+			t.Fatalf("should allow burst %d", i)
+		}
+	}
+	if b.Allow(now) {
+		t.Fatalf("should throttle")
+	}
+
+	// Two tokens per second
+	now = now.Add(500 * time.Millisecond)
+	if !b.Allow(now) || b.Allow(now) {
+		t.Fatalf("should allow exactly one")
+	}
+
+	// Refill is capped at the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		b.Allow(now)
+	}
+	if b.Allow(now) {
+		t.Fatalf("should throttle")
+	}
+
+	if stats := b.Stats(); stats.Admitted != 7 || stats.Throttled != 3 {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
+
+func TestMemDB_AdmitTxn(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	db, err := NewMemDB(testValidSchema(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	limiter := NewTokenBucket(1, 1)
+
+	txn, err := db.AdmitTxn(limiter)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", testObj()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	if _, err := db.AdmitTxn(limiter); err != ErrThrottled {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Other writers are not affected
+	db.Txn(true).Abort()
+
+	clock.Advance(time.Second)
+	txn, err = db.AdmitTxn(limiter)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Abort()
+}
+
+func TestMemDB_JobAdmission(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	limiter := NewTokenBucket(0.5, 1)
+	db, err := NewMemDB(testValidSchema(), WithClock(clock), WithDeterministicMode(),
+		WithJobAdmission(limiter))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer db.Close()
+
+	runs := 0
+	db.startJob("test", time.Second, func(time.Time) {
+		runs++
+	})
+
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Second)
+		db.Tick()
+	}
+	if runs != 2 {
+		t.Fatalf("bad: %d", runs)
+	}
+	if stats := limiter.Stats(); stats.Admitted != 2 || stats.Throttled != 2 {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
@@ -0,0 +1,123 @@
+package memdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// LockInfo is the row type of the LocksTable. It describes an advisory lock
+// taken with Txn.TryLock.
+type LockInfo struct {
+	Key      string
+	Owner    string
+	Acquired time.Time
+
+	// Expires is when the lock is released unless it is taken again by its
+	// owner. It is zero for locks that don't expire.
+	Expires time.Time
+}
+
+// expired returns true if the lock expired at now.
+func (l *LockInfo) expired(now time.Time) bool {
+	return !l.Expires.IsZero() && !now.Before(l.Expires)
+}
+
+// TryLock takes the advisory lock on the key for the owner, and returns
+// false without waiting if another owner holds it. A ttl greater than zero
+// makes the lock expire, so that it is released if its owner goes away;
+// taking the lock again before it expires extends it. Locks are stored in
+// the LocksTable and taken within the transaction, so they only hold once
+// it commits, and callers can wait for a lock to be released by watching
+// its row.
+//
+// Locks are only advisory: they don't restrict what the transactions of
+// other owners can write. Locks can't be taken or released in optimistic
+// transactions, whose commits only replay the changes made to the tables of
+// the schema.
+//
+// TryLock 尝试获取以字符串为键的咨询锁，锁被其他持有者占用时立即返回 false 。
+func (txn *Txn) TryLock(key, owner string, ttl time.Duration) (bool, error) {
+	current, err := txn.lockInfo(key)
+	if err != nil {
+		return false, err
+	}
+	now := txn.db.clock.Now()
+	if current != nil && current.Owner != owner && !current.expired(now) {
+		return false, nil
+	}
+
+	lock := &LockInfo{
+		Key:      key,
+		Owner:    owner,
+		Acquired: now,
+	}
+	if current != nil && current.Owner == owner && !current.expired(now) {
+		lock.Acquired = current.Acquired
+	}
+	if ttl > 0 {
+		lock.Expires = now.Add(ttl)
+	}
+	txn.writableIndex(LocksTable, id).Insert(lockKey(key), lock)
+	return true, nil
+}
+
+// Unlock releases the advisory lock on the key held by the owner. It
+// returns ErrNotFound if the owner doesn't hold the lock, including when it
+// expired.
+func (txn *Txn) Unlock(key, owner string) error {
+	current, err := txn.lockInfo(key)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Owner != owner || current.expired(txn.db.clock.Now()) {
+		return ErrNotFound
+	}
+	txn.writableIndex(LocksTable, id).Delete(lockKey(key))
+	return nil
+}
+
+// lockInfo returns the current row of the lock on the key, if any.
+func (txn *Txn) lockInfo(key string) (*LockInfo, error) {
+	if !txn.write {
+		return nil, fmt.Errorf("cannot lock in read-only transaction")
+	}
+	if txn.optimistic {
+		return nil, fmt.Errorf("cannot lock in optimistic transaction")
+	}
+	if err := txn.checkWritable(LocksTable); err != nil {
+		return nil, err
+	}
+	raw, ok := txn.writableIndex(LocksTable, id).Get(lockKey(key))
+	if !ok {
+		return nil, nil
+	}
+	return raw.(*LockInfo), nil
+}
+
+// lockKey returns the key of a lock in the LocksTable.
+func lockKey(key string) []byte {
+	return append([]byte(key), 0)
+}
+
+// TryLock takes the advisory lock on the key in a transaction of its own.
+// See Txn.TryLock.
+func (db *MemDB) TryLock(key, owner string, ttl time.Duration) (bool, error) {
+	txn := db.Txn(true)
+	defer txn.Abort()
+	ok, err := txn.TryLock(key, owner, ttl)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, txn.TryCommit()
+}
+
+// Unlock releases the advisory lock on the key in a transaction of its
+// own. See Txn.Unlock.
+func (db *MemDB) Unlock(key, owner string) error {
+	txn := db.Txn(true)
+	defer txn.Abort()
+	if err := txn.Unlock(key, owner); err != nil {
+		return err
+	}
+	return txn.TryCommit()
+}
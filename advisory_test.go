@@ -0,0 +1,63 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemDB_AdvisoryLock(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	db, err := NewMemDB(testValidSchema(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if ok, err := db.TryLock("leader", "a", 10*time.Second); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	if ok, err := db.TryLock("leader", "b", 10*time.Second); err != nil || ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	if err := db.Unlock("leader", "b"); err != ErrNotFound {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// The lock row can be read and watched
+	watchCh, raw, err := db.Txn(false).FirstWatch(LocksTable, "id", "leader")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if lock := raw.(*LockInfo); lock.Owner != "a" || !lock.Expires.Equal(time.Unix(1010, 0)) {
+		t.Fatalf("bad: %#v", lock)
+	}
+	if err := db.Unlock("leader", "a"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case <-watchCh:
+	default:
+		t.Fatalf("should be notified")
+	}
+
+	// Expired locks can be taken by others
+	if ok, err := db.TryLock("leader", "a", 10*time.Second); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	clock.Advance(10 * time.Second)
+	if ok, err := db.TryLock("leader", "b", 0); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	if err := db.Unlock("leader", "a"); err != ErrNotFound {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Locks taken in an aborted transaction don't hold
+	txn := db.Txn(true)
+	if ok, err := txn.TryLock("other", "a", 0); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	txn.Abort()
+	if raw, err := db.Txn(false).First(LocksTable, "id", "other"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
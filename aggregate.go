@@ -0,0 +1,407 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// AggregateOp is the function computed by an aggregate.
+type AggregateOp int
+
+const (
+	// AggregateCount counts the matching rows.
+	AggregateCount AggregateOp = iota
+
+	// AggregateMax keeps the largest value of a field among the matching
+	// rows, or nil if there are none.
+	AggregateMax
+)
+
+// AggregateSchema declares a value computed over the rows of a table that
+// is maintained as the table is written, such as the number of pending jobs
+// or the highest version of a row. Its current value is stored in the
+// AggregatesTable, so it is read with the same consistency as the table,
+// including the uncommitted writes of a write transaction, and watches on
+// it only fire when the value actually changes.
+//
+// AggregateSchema 声明一个随表写入而维护的聚合值（计数或最大值），可以被监听。
+type AggregateSchema struct {
+	// Name of the aggregate. This must match the key in the Aggregates map
+	// of the DBSchema.
+	Name string
+
+	Table string
+
+	// Index and Args select the rows that are aggregated, the same way as
+	// they would with Txn.Get. The index may be a prefix index. If Index is
+	// empty, all rows of the table are aggregated. Rows are counted once
+	// even if several values of a multi-value index match.
+	Index string
+	Args  []interface{}
+
+	Op AggregateOp
+
+	// Field is the name of the field used by AggregateMax. It must hold an
+	// integer, float, string or time.Time.
+	Field string
+}
+
+// AggregateValue is the row type of the AggregatesTable. Value is an int
+// for AggregateCount, and the value of the field for AggregateMax.
+type AggregateValue struct {
+	Name  string
+	Value interface{}
+}
+
+// Validate is used to validate the aggregate against the schema of the
+// database.
+func (s *AggregateSchema) Validate(schema *DBSchema) error {
+	if s.Name == "" {
+		return fmt.Errorf("missing aggregate name")
+	}
+	tableSchema, ok := schema.Tables[s.Table]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", s.Table)
+	}
+	if s.Index != "" {
+		if _, ok := tableSchema.Indexes[strings.TrimSuffix(s.Index, "_prefix")]; !ok {
+			return fmt.Errorf("invalid index '%s'", s.Index)
+		}
+	} else if len(s.Args) != 0 {
+		return fmt.Errorf("arguments given without an index")
+	}
+	switch s.Op {
+	case AggregateCount:
+	case AggregateMax:
+		if s.Field == "" {
+			return fmt.Errorf("missing field")
+		}
+	default:
+		return fmt.Errorf("invalid op %d", s.Op)
+	}
+	return nil
+}
+
+// Aggregate returns the current value of the named aggregate.
+func (txn *Txn) Aggregate(name string) (interface{}, error) {
+	_, value, err := txn.AggregateWatch(name)
+	return value, err
+}
+
+// AggregateWatch returns the current value of the named aggregate, along
+// with a channel that is closed when the value changes.
+func (txn *Txn) AggregateWatch(name string) (<-chan struct{}, interface{}, error) {
+	if _, ok := txn.schema.Aggregates[name]; !ok {
+		return nil, nil, fmt.Errorf("invalid aggregate '%s'", name)
+	}
+	watchCh, raw, err := txn.FirstWatch(AggregatesTable, id, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return watchCh, raw.(*AggregateValue).Value, nil
+}
+
+// initAggregates stores the values of the aggregates for an empty DB.
+func initAggregates(schema *DBSchema) *iradix.Tree {
+	indexTxn := iradix.New().Txn()
+	for name, aggregate := range schema.Aggregates {
+		value := &AggregateValue{Name: name}
+		if aggregate.Op == AggregateCount {
+			value.Value = 0
+		}
+		indexTxn.Insert(aggregateKey(name), value)
+	}
+	return indexTxn.CommitOnly()
+}
+
+// aggregateKey returns the key of an aggregate in the AggregatesTable.
+func aggregateKey(name string) []byte {
+	return append([]byte(name), 0)
+}
+
+// updateAggregates maintains the aggregates over the table after an object
+// was replaced. Either before or after is nil for inserts and deletes.
+func (txn *Txn) updateAggregates(table string, before, after interface{}) error {
+	for name, aggregate := range txn.schema.Aggregates {
+		if aggregate.Table != table {
+			continue
+		}
+
+		beforeOk, err := txn.aggregateMatch(aggregate, before)
+		if err != nil {
+			return err
+		}
+		afterOk, err := txn.aggregateMatch(aggregate, after)
+		if err != nil {
+			return err
+		}
+		if !beforeOk && !afterOk {
+			continue
+		}
+		current, err := txn.Aggregate(name)
+		if err != nil {
+			return err
+		}
+
+		switch aggregate.Op {
+		case AggregateCount:
+			count := current.(int)
+			if beforeOk {
+				count--
+			}
+			if afterOk {
+				count++
+			}
+			txn.setAggregate(name, current, count)
+
+		case AggregateMax:
+			// Removing the row holding the maximum requires a scan to
+			// find the next one.
+			if beforeOk && current != nil {
+				value, err := aggregateField(aggregate, before)
+				if err != nil {
+					return err
+				}
+				if cmp, err := compareAggregateValues(value, current); err != nil {
+					return err
+				} else if cmp >= 0 {
+					if err := txn.recomputeAggregate(aggregate); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if afterOk {
+				value, err := aggregateField(aggregate, after)
+				if err != nil {
+					return err
+				}
+				if current == nil {
+					txn.setAggregate(name, current, value)
+					continue
+				}
+				if cmp, err := compareAggregateValues(value, current); err != nil {
+					return err
+				} else if cmp > 0 {
+					txn.setAggregate(name, current, value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// recomputeAggregates computes the aggregates over the table from scratch,
+// for writes that don't go through Insert and Delete.
+func (txn *Txn) recomputeAggregates(table string) error {
+	for _, aggregate := range txn.schema.Aggregates {
+		if aggregate.Table != table {
+			continue
+		}
+		if err := txn.recomputeAggregate(aggregate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetAggregates sets the aggregates over the table to their values for
+// an empty table.
+func (txn *Txn) resetAggregates(table string) {
+	for name, aggregate := range txn.schema.Aggregates {
+		if aggregate.Table != table {
+			continue
+		}
+		current, err := txn.Aggregate(name)
+		if err != nil {
+			panic(err)
+		}
+		var value interface{}
+		if aggregate.Op == AggregateCount {
+			value = 0
+		}
+		txn.setAggregate(name, current, value)
+	}
+}
+
+// recomputeAggregate scans the matching rows to compute an aggregate.
+func (txn *Txn) recomputeAggregate(aggregate *AggregateSchema) error {
+	rows, err := txn.aggregateRows(aggregate)
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	for _, obj := range rows {
+		if aggregate.Op != AggregateMax {
+			break
+		}
+		field, err := aggregateField(aggregate, obj)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			value = field
+		} else if cmp, err := compareAggregateValues(field, value); err != nil {
+			return err
+		} else if cmp > 0 {
+			value = field
+		}
+	}
+	if aggregate.Op == AggregateCount {
+		value = len(rows)
+	}
+
+	current, err := txn.Aggregate(aggregate.Name)
+	if err != nil {
+		return err
+	}
+	txn.setAggregate(aggregate.Name, current, value)
+	return nil
+}
+
+// aggregateRows returns the rows selected by the aggregate, once each.
+func (txn *Txn) aggregateRows(aggregate *AggregateSchema) ([]interface{}, error) {
+	var rows []interface{}
+	if aggregate.Index == "" {
+		iter := txn.readableIndex(aggregate.Table, id).Root().Iterator()
+		for _, raw, ok := iter.Next(); ok; _, raw, ok = iter.Next() {
+			obj, err := txn.resolve(aggregate.Table, raw)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, obj)
+		}
+		return rows, nil
+	}
+
+	iter, err := txn.Get(aggregate.Table, aggregate.Index, aggregate.Args...)
+	if err != nil {
+		return nil, err
+	}
+	// Rows are listed once per matching value of multi-value indexes.
+	idIndexer := txn.schema.Tables[aggregate.Table].Indexes[id].Indexer.(SingleIndexer)
+	seen := make(map[string]struct{})
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		_, val, err := idIndexer.FromObject(obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build primary index: %v", err)
+		}
+		if _, ok := seen[string(val)]; ok {
+			continue
+		}
+		seen[string(val)] = struct{}{}
+		rows = append(rows, obj)
+	}
+	if err := IteratorErr(iter); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// setAggregate stores a new value for the aggregate, unless it is the same
+// as the current one, so that watches only fire on actual changes.
+func (txn *Txn) setAggregate(name string, current, value interface{}) {
+	if current == value {
+		return
+	}
+	if a, ok := current.(time.Time); ok {
+		if b, ok := value.(time.Time); ok && a.Equal(b) {
+			return
+		}
+	}
+	txn.writableIndex(AggregatesTable, id).Insert(aggregateKey(name), &AggregateValue{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// aggregateMatch returns true if the object is selected by the aggregate.
+// A nil object never is.
+func (txn *Txn) aggregateMatch(aggregate *AggregateSchema, obj interface{}) (bool, error) {
+	if obj == nil {
+		return false, nil
+	}
+	if aggregate.Index == "" {
+		return true, nil
+	}
+
+	indexSchema, prefix, err := txn.getIndexValue(aggregate.Table, aggregate.Index, aggregate.Args...)
+	if err != nil {
+		return false, err
+	}
+	ok, vals, err := indexValues(indexSchema, obj)
+	if err != nil {
+		return false, fmt.Errorf("failed to build index '%s': %v", indexSchema.Name, err)
+	}
+	if !ok {
+		return false, nil
+	}
+	for _, val := range vals {
+		if bytes.HasPrefix(val, prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// aggregateField returns the value of the aggregated field of an object.
+func aggregateField(aggregate *AggregateSchema, obj interface{}) (interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("aggregate '%s' requires struct objects, got %T", aggregate.Name, obj)
+	}
+	fv := v.FieldByName(aggregate.Field)
+	if !fv.IsValid() {
+		return nil, fmt.Errorf("field '%s' for %#v is invalid", aggregate.Field, obj)
+	}
+	return fv.Interface(), nil
+}
+
+// compareAggregateValues compares two values of an aggregated field.
+func compareAggregateValues(a, b interface{}) (int, error) {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		if !ok {
+			return 0, fmt.Errorf("can't compare %T and %T", a, b)
+		}
+		switch {
+		case at.Before(bt):
+			return -1, nil
+		case at.After(bt):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	if av.Kind() != bv.Kind() {
+		return 0, fmt.Errorf("can't compare %T and %T", a, b)
+	}
+	switch {
+	case isIntKind(av.Kind()):
+		return compareOrdered(av.Int() < bv.Int(), av.Int() > bv.Int()), nil
+	case isUintKind(av.Kind()):
+		return compareOrdered(av.Uint() < bv.Uint(), av.Uint() > bv.Uint()), nil
+	case isFloatKind(av.Kind()):
+		return compareOrdered(av.Float() < bv.Float(), av.Float() > bv.Float()), nil
+	case av.Kind() == reflect.String:
+		return strings.Compare(av.String(), bv.String()), nil
+	}
+	return 0, fmt.Errorf("can't compare values of type %T", a)
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+type testJob struct {
+	ID       string
+	State    string
+	Priority int
+}
+
+func testAggregateDB(t *testing.T) *MemDB {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"jobs": &TableSchema{
+				Name: "jobs",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"state": &IndexSchema{
+						Name:    "state",
+						Indexer: &StringFieldIndex{Field: "State"},
+					},
+				},
+			},
+		},
+		Aggregates: map[string]*AggregateSchema{
+			"pending": &AggregateSchema{
+				Name:  "pending",
+				Table: "jobs",
+				Index: "state",
+				Args:  []interface{}{"pending"},
+				Op:    AggregateCount,
+			},
+			"priority": &AggregateSchema{
+				Name:  "priority",
+				Table: "jobs",
+				Op:    AggregateMax,
+				Field: "Priority",
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return db
+}
+
+func TestTxn_Aggregate(t *testing.T) {
+	db := testAggregateDB(t)
+
+	value := func(txn *Txn, name string) interface{} {
+		value, err := txn.Aggregate(name)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return value
+	}
+
+	txn := db.Txn(false)
+	if v := value(txn, "pending"); v != 0 {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v := value(txn, "priority"); v != nil {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	wtxn := db.Txn(true)
+	for _, job := range []*testJob{
+		&testJob{ID: "a", State: "pending", Priority: 2},
+		&testJob{ID: "b", State: "pending", Priority: 7},
+		&testJob{ID: "c", State: "running", Priority: 5},
+	} {
+		if err := wtxn.Insert("jobs", job); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Uncommitted writes are reflected in the transaction only
+	if v := value(wtxn, "pending"); v != 2 {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v := value(txn, "pending"); v != 0 {
+		t.Fatalf("bad: %#v", v)
+	}
+	wtxn.Commit()
+
+	txn = db.Txn(false)
+	if v := value(txn, "priority"); v != 7 {
+		t.Fatalf("bad: %#v", v)
+	}
+	pendingWatch, _, err := txn.AggregateWatch("pending")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	priorityWatch, _, err := txn.AggregateWatch("priority")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Changing a row without changing the count doesn't fire the watch
+	wtxn = db.Txn(true)
+	if err := wtxn.Insert("jobs", &testJob{ID: "c", State: "running", Priority: 1}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	wtxn.Commit()
+	select {
+	case <-pendingWatch:
+		t.Fatalf("should not fire")
+	case <-priorityWatch:
+		t.Fatalf("should not fire")
+	default:
+	}
+
+	// Removing the maximum finds the next one
+	wtxn = db.Txn(true)
+	if err := wtxn.Insert("jobs", &testJob{ID: "b", State: "running", Priority: 3}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	wtxn.Commit()
+	for _, ch := range []<-chan struct{}{pendingWatch, priorityWatch} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("should fire")
+		}
+	}
+
+	txn = db.Txn(false)
+	if v := value(txn, "pending"); v != 1 {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v := value(txn, "priority"); v != 3 {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	// Bulk deletes are accounted for as well
+	wtxn = db.Txn(true)
+	if _, err := wtxn.DeletePrefix("jobs", "state_prefix", "pend"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if v := value(wtxn, "pending"); v != 0 {
+		t.Fatalf("bad: %#v", v)
+	}
+	if _, err := wtxn.DeleteAll("jobs", "id_prefix", ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if v := value(wtxn, "priority"); v != nil {
+		t.Fatalf("bad: %#v", v)
+	}
+	wtxn.Commit()
+
+	if _, err := txn.Aggregate("nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestAggregateSchema_Validate(t *testing.T) {
+	schema := testAggregateDB(t).getSchema()
+	cases := []*AggregateSchema{
+		&AggregateSchema{Name: "x", Table: "nope"},
+		&AggregateSchema{Name: "x", Table: "jobs", Index: "nope"},
+		&AggregateSchema{Name: "x", Table: "jobs", Args: []interface{}{"a"}},
+		&AggregateSchema{Name: "x", Table: "jobs", Op: AggregateMax},
+	}
+	for _, aggregate := range cases {
+		if err := aggregate.Validate(schema); err == nil {
+			t.Fatalf("should not validate: %#v", aggregate)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package memdb
+
+import (
+	"fmt"
+	"time"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// alarmEntryOverhead is the rough number of bytes an index entry takes up in
+// a radix tree, including its node, leaf and watch channels, used to
+// estimate the memory of a table.
+const alarmEntryOverhead = 128
+
+// AlarmMetric is a metric of a table watched by an Alarm.
+type AlarmMetric int
+
+const (
+	// AlarmRows is the number of rows in the table.
+	AlarmRows AlarmMetric = iota
+
+	// AlarmMemory is a rough estimate of the bytes used by the table: a
+	// fixed overhead per index entry, plus RowSize bytes per row for the
+	// objects themselves.
+	AlarmMemory
+
+	// AlarmChangeRate is the number of objects inserted, updated or deleted
+	// in the table per second, averaged over the alarm's Window.
+	AlarmChangeRate
+)
+
+// Alarm is a threshold on a metric of a table. Alarms are checked after
+// every commit that modifies their table, and their function is called
+// when the metric rises above the threshold, and again when it falls back
+// to or below it. This gives embedders a chance to shed load or page
+// someone before memory is exhausted.
+//
+// Alarm 是表指标（行数、内存、变更速率）上的阈值，越过阈值时回调。
+type Alarm struct {
+	Table     string
+	Metric    AlarmMetric
+	Threshold float64
+
+	// Window is the period over which AlarmChangeRate is averaged. If zero,
+	// one second is used.
+	Window time.Duration
+
+	// RowSize is the estimated size in bytes of an object of the table,
+	// used by AlarmMemory.
+	RowSize int
+
+	// Fn is called with each crossing of the threshold. It is called
+	// after the commit has completed and the writer lock was released, in
+	// commit order.
+	Fn AlarmFunc
+
+	// firing is set while the metric is above the threshold, and samples
+	// holds the recent changes for AlarmChangeRate. Both are only used
+	// under the writer lock.
+	firing  bool
+	samples []alarmSample
+}
+
+// AlarmEvent describes a crossing of an alarm's threshold.
+type AlarmEvent struct {
+	Alarm *Alarm
+
+	// Commit is the index of the commit that caused the crossing.
+	Commit uint64
+
+	// Value is the value of the metric after the commit, and Firing is
+	// true if it is now above the threshold.
+	Value  float64
+	Firing bool
+}
+
+// AlarmFunc is called with the crossings of an alarm's threshold.
+type AlarmFunc func(AlarmEvent)
+
+// alarmSample is the number of objects of a table changed by a commit.
+type alarmSample struct {
+	time    time.Time
+	changes int
+}
+
+// WithAlarm registers an alarm with the MemDB. An Alarm must not be
+// registered with more than one MemDB.
+func WithAlarm(alarm *Alarm) Option {
+	return func(db *MemDB) {
+		db.alarms = append(db.alarms, alarm)
+	}
+}
+
+// validate checks the alarm against the schema of the MemDB.
+func (a *Alarm) validate(schema *DBSchema) error {
+	if _, ok := schema.Tables[a.Table]; !ok {
+		return fmt.Errorf("invalid table '%s'", a.Table)
+	}
+	switch a.Metric {
+	case AlarmRows, AlarmMemory, AlarmChangeRate:
+	default:
+		return fmt.Errorf("invalid metric %d", a.Metric)
+	}
+	if a.Fn == nil {
+		return fmt.Errorf("missing alarm function")
+	}
+	return nil
+}
+
+// window returns the period over which the change rate is averaged.
+func (a *Alarm) window() time.Duration {
+	if a.Window == 0 {
+		return time.Second
+	}
+	return a.Window
+}
+
+// checkAlarms measures the metrics of the alarms on the tables modified by
+// the transaction, as of the committed root, and returns the crossings.
+// This must only be called while holding the writer lock.
+func (txn *Txn) checkAlarms(root *iradix.Tree, commit *CommitInfo) []AlarmEvent {
+	if len(txn.db.alarms) == 0 {
+		return nil
+	}
+
+	modified := make(map[string]bool)
+	for _, table := range commit.Tables {
+		modified[table] = true
+	}
+	view := &Txn{db: txn.db, schema: txn.schema, rootTxn: root.Txn()}
+
+	var events []AlarmEvent
+	for _, alarm := range txn.db.alarms {
+		if !modified[alarm.Table] {
+			continue
+		}
+
+		var value float64
+		switch alarm.Metric {
+		case AlarmRows:
+			value = float64(view.indexLen(alarm.Table, id))
+
+		case AlarmMemory:
+			rows := view.indexLen(alarm.Table, id)
+			entries := 0
+			for index := range txn.schema.Tables[alarm.Table].Indexes {
+				entries += view.indexLen(alarm.Table, index)
+			}
+			value = float64(entries*alarmEntryOverhead + rows*alarm.RowSize)
+
+		case AlarmChangeRate:
+			// Every object change writes the primary index once.
+			changes := 0
+			if stats, ok := txn.indexWrites[tableIndex{alarm.Table, id}]; ok {
+				changes = stats.Inserts + stats.Deletes
+			}
+			alarm.samples = append(alarm.samples, alarmSample{commit.Time, changes})
+
+			cutoff := commit.Time.Add(-alarm.window())
+			for len(alarm.samples) > 0 && !alarm.samples[0].time.After(cutoff) {
+				alarm.samples = alarm.samples[1:]
+			}
+			total := 0
+			for _, sample := range alarm.samples {
+				total += sample.changes
+			}
+			value = float64(total) / alarm.window().Seconds()
+		}
+
+		if firing := value > alarm.Threshold; firing != alarm.firing {
+			alarm.firing = firing
+			events = append(events, AlarmEvent{
+				Alarm:  alarm,
+				Commit: commit.Index,
+				Value:  value,
+				Firing: firing,
+			})
+		}
+	}
+	return events
+}
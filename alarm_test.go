@@ -0,0 +1,72 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemDB_Alarms(t *testing.T) {
+	var events []string
+	record := func(event AlarmEvent) {
+		events = append(events, fmt.Sprintf("%d:%v:%v", event.Alarm.Metric, event.Value, event.Firing))
+	}
+
+	clock := NewManualClock(time.Unix(0, 0))
+	db, err := NewMemDB(testValidSchema(),
+		WithClock(clock),
+		WithAlarm(&Alarm{Table: "main", Metric: AlarmRows, Threshold: 2, Fn: record}),
+		WithAlarm(&Alarm{Table: "main", Metric: AlarmMemory, Threshold: 1000, RowSize: 100, Fn: record}),
+		WithAlarm(&Alarm{Table: "main", Metric: AlarmChangeRate, Threshold: 2.5, Window: 2 * time.Second, Fn: record}),
+	)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	insert := func(ids ...string) {
+		txn := db.Txn(true)
+		for _, id := range ids {
+			if err := txn.Insert("main", &TestObject{ID: id, Foo: "abc", Qux: []string{id}}); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		txn.Commit()
+	}
+
+	// Each row takes three index entries and its own size.
+	insert("a", "b")
+	if len(events) != 0 {
+		t.Fatalf("bad: %#v", events)
+	}
+	insert("c", "d", "e", "f")
+	expect := []string{"0:6:true", "1:2904:true", "2:3:true"}
+	if !reflect.DeepEqual(events, expect) {
+		t.Fatalf("bad: %#v", events)
+	}
+
+	// The deletes keep the change rate up, while the earlier commits left
+	// the window
+	events = nil
+	clock.Advance(3 * time.Second)
+	txn := db.Txn(true)
+	if _, err := txn.DeleteAll("main", "id_prefix", ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	expect = []string{"0:0:false", "1:0:false"}
+	if !reflect.DeepEqual(events, expect) {
+		t.Fatalf("bad: %#v", events)
+	}
+
+	events = nil
+	clock.Advance(3 * time.Second)
+	insert("a")
+	if !reflect.DeepEqual(events, []string{"2:0.5:false"}) {
+		t.Fatalf("bad: %#v", events)
+	}
+
+	if _, err := NewMemDB(testValidSchema(), WithAlarm(&Alarm{Table: "nope", Fn: record})); err == nil {
+		t.Fatalf("expected error")
+	}
+}
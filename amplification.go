@@ -0,0 +1,109 @@
+package memdb
+
+import "strings"
+
+// QueryStats reports the read amplification of a query: the number of index
+// entries its iterator visited, against the number of results it returned
+// to the caller. Results dropped by a FilterIterator or skipped by an
+// OffsetIterator wrapping the iterator count as visited but not returned,
+// so a query scanning a large index to return a few results stands out.
+//
+// QueryStats 记录查询访问的索引条目数与返回的结果数。
+type QueryStats struct {
+	Table string
+	Index string
+
+	Visited  int
+	Returned int
+}
+
+// Amplification returns the number of entries visited per result returned,
+// or the number of entries visited if there was no result.
+func (s QueryStats) Amplification() float64 {
+	if s.Returned == 0 {
+		return float64(s.Visited)
+	}
+	return float64(s.Visited) / float64(s.Returned)
+}
+
+// TrackQueryStats enables the reporting of read amplification for the
+// transaction. Each iterator created by Get, GetReverse, LowerBound,
+// ReverseLowerBound and GetByPKPrefix after it is called is
+// recorded, and counts its entries as it is iterated. Iterators reading
+// live data aren't recorded.
+//
+// TrackQueryStats 开启事务的读放大统计。
+func (txn *Txn) TrackQueryStats() {
+	if txn.queryStats == nil {
+		txn.queryStats = make([]*QueryStats, 0, 1)
+	}
+}
+
+// QueryStats returns the stats of the iterators created since
+// TrackQueryStats was called, in the order they were created. It returns nil
+// if the reporting is not enabled.
+func (txn *Txn) QueryStats() []QueryStats {
+	if txn.queryStats == nil {
+		return nil
+	}
+	stats := make([]QueryStats, len(txn.queryStats))
+	for i, s := range txn.queryStats {
+		stats[i] = *s
+	}
+	return stats
+}
+
+// newQueryStats records a query of an index and returns its stats, or nil
+// if the reporting is not enabled. The index may have the "_prefix" suffix.
+func (txn *Txn) newQueryStats(table, index string) *QueryStats {
+	if txn.queryStats == nil {
+		return nil
+	}
+	stats := &QueryStats{Table: table, Index: strings.TrimSuffix(index, "_prefix")}
+	txn.queryStats = append(txn.queryStats, stats)
+	return stats
+}
+
+// statsIterator is implemented by the iterators counting their entries, and
+// by the iterators wrapping them.
+type statsIterator interface {
+	queryStats() *QueryStats
+}
+
+// visit counts an entry returned by an iterator.
+func (s *QueryStats) visit() {
+	if s != nil {
+		s.Visited++
+		s.Returned++
+	}
+}
+
+// discardResult uncounts a result of the iterator that a wrapping iterator
+// didn't return.
+func discardResult(iter ResultIterator) {
+	if s := iteratorStats(iter); s != nil {
+		s.Returned--
+	}
+}
+
+// queryStats returns the stats of the iterator, if any.
+func (o *iteratorOptions) queryStats() *QueryStats {
+	if o == nil {
+		return nil
+	}
+	return o.stats
+}
+
+// iteratorStats returns the stats of an iterator, or nil if it has none.
+func iteratorStats(iter ResultIterator) *QueryStats {
+	if it, ok := iter.(statsIterator); ok {
+		return it.queryStats()
+	}
+	return nil
+}
+
+func (r *radixIterator) queryStats() *QueryStats        { return r.opts.queryStats() }
+func (r *radixReverseIterator) queryStats() *QueryStats { return r.opts.queryStats() }
+func (f *FilterIterator) queryStats() *QueryStats       { return iteratorStats(f.iter) }
+func (l *LimitIterator) queryStats() *QueryStats        { return iteratorStats(l.iter) }
+func (o *OffsetIterator) queryStats() *QueryStats       { return iteratorStats(o.iter) }
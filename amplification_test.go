@@ -0,0 +1,79 @@
+package memdb
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestTxn_QueryStats(t *testing.T) {
+	db := testDB(t)
+
+	txn := db.Txn(true)
+	for i := 0; i < 10; i++ {
+		obj := &TestObject{ID: strconv.Itoa(i), Foo: "abc", Qux: []string{"x"}}
+		if i%5 == 0 {
+			obj.Foo = "xyz"
+		}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	if stats := txn.QueryStats(); stats != nil {
+		t.Fatalf("bad: %#v", stats)
+	}
+	txn.TrackQueryStats()
+
+	drain := func(iter ResultIterator) int {
+		n := 0
+		for obj := iter.Next(); obj != nil; obj = iter.Next() {
+			n++
+		}
+		return n
+	}
+
+	// Results dropped by a filter are visited but not returned
+	iter, err := txn.Get("main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	filtered := NewFilterIterator(iter, func(obj interface{}) bool {
+		return obj.(*TestObject).Foo != "xyz"
+	})
+	if n := drain(filtered); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// So are the results skipped by an offset, while a limit stops the scan
+	iter, err = txn.Get("main", "foo_prefix", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := drain(NewLimitIterator(NewOffsetIterator(iter, 3), 2)); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	iter, err = txn.GetReverse("main", "foo", "xyz")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := drain(iter); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	expect := []QueryStats{
+		{Table: "main", Index: "id", Visited: 10, Returned: 2},
+		{Table: "main", Index: "foo", Visited: 5, Returned: 2},
+		{Table: "main", Index: "foo", Visited: 2, Returned: 2},
+	}
+	stats := txn.QueryStats()
+	if !reflect.DeepEqual(stats, expect) {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if a := stats[0].Amplification(); a != 5 {
+		t.Fatalf("bad: %v", a)
+	}
+}
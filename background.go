@@ -0,0 +1,198 @@
+package memdb
+
+import (
+	"sync"
+	"time"
+)
+
+// backgroundJob is a periodic task run on behalf of a MemDB, such as
+// expiring rows or fanning out changes.
+type backgroundJob struct {
+	name     string
+	interval time.Duration
+	fn       func(now time.Time)
+
+	// next is when the job is due. It is only used in deterministic mode.
+	next time.Time
+}
+
+// WithDeterministicMode configures the MemDB so that no background goroutines
+// are started. Instead, background jobs only run when Tick is called, which
+// together with a ManualClock allows simulations to replay exactly.
+//
+// 确定性模式：不启动后台 goroutine ，后台任务仅在调用 Tick 时同步执行。
+func WithDeterministicMode() Option {
+	return func(db *MemDB) {
+		db.deterministic = true
+	}
+}
+
+// startJob registers a background job that runs fn every interval until the
+// MemDB is closed. In deterministic mode the job is only run by Tick.
+func (db *MemDB) startJob(name string, interval time.Duration, fn func(now time.Time)) {
+	job := &backgroundJob{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+	}
+
+	db.jobsLock.Lock()
+	defer db.jobsLock.Unlock()
+
+	if db.shutdown {
+		return
+	}
+	if db.shutdownCh == nil {
+		db.shutdownCh = make(chan struct{})
+	}
+
+	if db.deterministic {
+		job.next = db.clock.Now().Add(interval)
+		db.jobs = append(db.jobs, job)
+		return
+	}
+
+	db.jobsWg.Add(1)
+	go db.runJob(job, db.shutdownCh)
+}
+
+// runJob runs a background job on its interval until shutdownCh is closed.
+func (db *MemDB) runJob(job *backgroundJob, shutdownCh chan struct{}) {
+	defer db.jobsWg.Done()
+	for {
+		select {
+		case <-db.clock.After(job.interval):
+			db.runJobOnce(job, db.clock.Now())
+		case <-shutdownCh:
+			return
+		}
+	}
+}
+
+// Tick runs every background job that is due according to the DB's clock,
+// in the order the jobs were registered. It returns the number of jobs that
+// ran. Tick is only meaningful in deterministic mode; otherwise it is a noop.
+func (db *MemDB) Tick() int {
+	db.jobsLock.Lock()
+	var due []*backgroundJob
+	now := db.clock.Now()
+	for _, job := range db.jobs {
+		if !now.Before(job.next) {
+			job.next = now.Add(job.interval)
+			due = append(due, job)
+		}
+	}
+	db.jobsLock.Unlock()
+
+	// Jobs run without the lock held since they may start write
+	// transactions of their own.
+	ran := 0
+	for _, job := range due {
+		if db.runJobOnce(job, now) {
+			ran++
+		}
+	}
+	return ran
+}
+
+// runJobOnce runs the job unless it is throttled by admission control, and
+// returns whether it ran.
+func (db *MemDB) runJobOnce(job *backgroundJob, now time.Time) bool {
+	if db.jobLimiter != nil && !db.jobLimiter.Allow(now) {
+		db.logger.Debug("background job throttled", "job", job.name)
+		return false
+	}
+	db.logger.Debug("running background job", "job", job.name)
+	job.fn(now)
+	return true
+}
+
+// Close stops all of the background jobs of the MemDB and waits for any that
+// are running to finish. The DB can still be used afterwards, but features
+// that rely on background jobs will no longer make progress.
+func (db *MemDB) Close() {
+	db.jobsLock.Lock()
+	if db.shutdownCh != nil && !db.shutdown {
+		close(db.shutdownCh)
+	}
+	db.shutdown = true
+	db.jobs = nil
+	db.jobsLock.Unlock()
+
+	db.jobsWg.Wait()
+
+	// No goroutine ends the subscriptions in deterministic mode.
+	if db.deterministic {
+		db.subsLock.Lock()
+		for _, s := range append([]*Subscription(nil), db.subscribers...) {
+			s.stopLocked(nil)
+		}
+		db.subsLock.Unlock()
+	}
+
+	if db.wal != nil {
+		if err := db.wal.close(); err != nil {
+			db.logger.Error("failed to close write-ahead log", "error", err)
+		}
+	}
+}
+
+// ManualClock is a Clock that only moves when it is told to. It is intended
+// for tests and deterministic simulations.
+type ManualClock struct {
+	l       sync.Mutex
+	now     time.Time
+	waiters []manualWaiter
+}
+
+// manualWaiter is a pending After call on a ManualClock.
+type manualWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewManualClock returns a ManualClock set to the given time.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *ManualClock) Now() time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the clock's time once it has been
+// advanced by at least d.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	ch := make(chan time.Time, 1)
+	deadline := c.now.Add(d)
+	if !c.now.Before(deadline) {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, manualWaiter{deadline, ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing any After channels that
+// become due.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !c.now.Before(w.deadline) {
+			w.ch <- c.now
+		} else {
+			pending = append(pending, w)
+		}
+	}
+	c.waiters = pending
+}
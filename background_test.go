@@ -0,0 +1,84 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemDB_DeterministicMode(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	db, err := NewMemDB(testValidSchema(), WithClock(clock), WithDeterministicMode())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer db.Close()
+
+	var runs []time.Time
+	db.startJob("test", time.Second, func(now time.Time) {
+		runs = append(runs, now)
+	})
+
+	if n := db.Tick(); n != 0 || len(runs) != 0 {
+		t.Fatalf("should not run before due: %d %v", n, runs)
+	}
+
+	clock.Advance(time.Second)
+	if n := db.Tick(); n != 1 || len(runs) != 1 {
+		t.Fatalf("should run once: %d %v", n, runs)
+	}
+	if n := db.Tick(); n != 0 || len(runs) != 1 {
+		t.Fatalf("should not run twice: %d %v", n, runs)
+	}
+
+	clock.Advance(1500 * time.Millisecond)
+	db.Tick()
+	if len(runs) != 2 || !runs[1].Equal(time.Unix(2, 5e8)) {
+		t.Fatalf("bad: %v", runs)
+	}
+
+	db.Close()
+	clock.Advance(time.Hour)
+	if n := db.Tick(); n != 0 {
+		t.Fatalf("should not run after close: %d", n)
+	}
+}
+
+func TestMemDB_BackgroundJob(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	db, err := NewMemDB(testValidSchema(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	runCh := make(chan time.Time, 1)
+	db.startJob("test", time.Second, func(now time.Time) {
+		runCh <- now
+	})
+
+	// Wait for the job goroutine to start waiting on the clock
+	deadline := time.Now().Add(time.Second)
+	for {
+		clock.l.Lock()
+		n := len(clock.waiters)
+		clock.l.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case now := <-runCh:
+		if !now.Equal(time.Unix(1, 0)) {
+			t.Fatalf("bad: %v", now)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("job did not run")
+	}
+
+	db.Close()
+}
@@ -0,0 +1,182 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// InsertBatch inserts several objects into the given table, with the same
+// result as calling Insert for each of them in order. When all the objects
+// are new, their index entries are computed first and inserted in key order,
+// so that consecutive inserts reuse the radix nodes just written. This makes
+// loading large batches faster than inserting row by row, especially when
+// the keys come in random order.
+//
+// Batches that update existing objects, hold the same primary key twice, or
+// go into a table with a NullDistinct index are inserted row by row.
+//
+// InsertBatch 批量插入对象，先计算所有索引键并排序，再按键序写入索引。
+func (txn *Txn) InsertBatch(table string, objs []interface{}) (err error) {
+	defer txn.recoverPanic("insert", &err)
+	if err := txn.insertBatch(table, objs); err != nil {
+		return err
+	}
+	return txn.checkLimits()
+}
+
+// insertBatch does the work of InsertBatch.
+func (txn *Txn) insertBatch(table string, objs []interface{}) error {
+	if !txn.write {
+		return fmt.Errorf("cannot insert in read-only transaction")
+	}
+	if err := txn.checkWritable(table); err != nil {
+		return err
+	}
+	if isSystemTable(table) {
+		return fmt.Errorf("cannot insert in read-only system table '%s'", table)
+	}
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+
+	if err := txn.db.injectFault(FaultIndex, table); err != nil {
+		return err
+	}
+	rows, ok, err := txn.batchRows(tableSchema, objs)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		for _, obj := range objs {
+			if err := txn.insert(table, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Build each index from its entries sorted by key. Equal keys are kept
+	// in the order of the objects, so that on a unique index the last
+	// object wins, as with Insert.
+	var entries []batchEntry
+	for indexName, indexSchema := range tableSchema.Indexes {
+		entries = entries[:0]
+		for i, obj := range objs {
+			if indexName == id {
+				entries = append(entries, batchEntry{key: rows[i].key, obj: rows[i].obj, seq: i})
+				continue
+			}
+			ok, vals, err := indexValues(indexSchema, obj)
+			if err != nil {
+				return fmt.Errorf("failed to build index '%s': %v", indexName, err)
+			}
+			if !ok {
+				if indexSchema.AllowMissing || !indexSchema.covers(obj) {
+					continue
+				}
+				return fmt.Errorf("missing value for index '%s'", indexName)
+			}
+			for _, val := range vals {
+				if !indexSchema.Unique {
+					val = append(val, rows[i].key...)
+				}
+				entries = append(entries, batchEntry{key: val, obj: rows[i].obj, seq: i})
+			}
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			if c := bytes.Compare(entries[i].key, entries[j].key); c != 0 {
+				return c < 0
+			}
+			return entries[i].seq < entries[j].seq
+		})
+
+		indexTxn := txn.writableIndex(table, indexName)
+		for _, entry := range entries {
+			indexTxn.Insert(entry.key, entry.obj)
+			txn.limitBytes += len(entry.key)
+		}
+		txn.countWrite(table, indexName, len(entries), 0)
+	}
+	txn.countObjects(len(objs))
+
+	for i, obj := range objs {
+		if txn.changes != nil {
+			txn.changes = append(txn.changes, Change{
+				Table:      table,
+				After:      obj,
+				primaryKey: rows[i].key,
+			})
+		}
+		if err := txn.updateAggregates(table, nil, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchEntry is an index entry of InsertBatch, along with the position of
+// its object in the batch.
+type batchEntry struct {
+	key []byte
+	obj interface{}
+	seq int
+}
+
+// batchRows returns the primary key of each object along with the value to
+// store for it, after the checks Insert makes before writing. It returns
+// false if the batch can't be built in bulk and has to be inserted row by
+// row.
+func (txn *Txn) batchRows(tableSchema *TableSchema, objs []interface{}) ([]inlineRow, bool, error) {
+	for _, indexSchema := range tableSchema.Indexes {
+		if indexSchema.NullDistinct || indexSchema.Deferred {
+			return nil, false, nil
+		}
+	}
+
+	table := tableSchema.Name
+	idIndexer := tableSchema.Indexes[id].Indexer.(SingleIndexer)
+	idTxn := txn.readableIndex(table, id)
+	seen := make(map[string]struct{}, len(objs))
+	rows := make([]inlineRow, len(objs))
+	for i, obj := range objs {
+		if tableSchema.IDGenerator != nil {
+			if err := txn.assignID(tableSchema, obj); err != nil {
+				return nil, false, err
+			}
+		}
+		ok, idVal, err := idIndexer.FromObject(obj)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to build primary index: %v", err)
+		}
+		if !ok {
+			return nil, false, fmt.Errorf("object missing primary index")
+		}
+		if _, dup := seen[string(idVal)]; dup {
+			return nil, false, nil
+		}
+		if _, update := idTxn.Get(idVal); update {
+			return nil, false, nil
+		}
+		seen[string(idVal)] = struct{}{}
+		rows[i].key = idVal
+	}
+
+	// Objects are only externalized once the batch is known to be new, as
+	// Insert would store them again.
+	for i, obj := range objs {
+		if err := txn.checkUniqueConstraints(table, obj); err != nil {
+			return nil, false, err
+		}
+		if err := txn.checkForeignKeys(tableSchema, obj, objs); err != nil {
+			return nil, false, err
+		}
+		stored, err := txn.externalize(tableSchema, obj)
+		if err != nil {
+			return nil, false, err
+		}
+		rows[i].obj = stored
+	}
+	return rows, true, nil
+}
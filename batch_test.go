@@ -0,0 +1,109 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTxn_InsertBatch(t *testing.T) {
+	db := testDB(t)
+
+	txn := db.Txn(true)
+	txn.TrackChanges()
+	objs := []interface{}{
+		&TestObject{ID: "c", Foo: "x", Qux: []string{"1", "2"}},
+		&TestObject{ID: "a", Foo: "y", Qux: []string{"2"}},
+		&TestObject{ID: "b", Foo: "x", Qux: []string{"3"}},
+	}
+	if err := txn.InsertBatch("main", objs); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if changes := txn.Changes(); len(changes) != 3 || changes[0].After != objs[0] || changes[0].Before != nil {
+		t.Fatalf("bad: %#v", changes)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	iter, err := txn.Get("main", "foo", "x")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		ids = append(ids, raw.(*TestObject).ID)
+	}
+	if len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+		t.Fatalf("bad: %#v", ids)
+	}
+	if raw, err := txn.First("main", "qux", "2"); err != nil || raw != objs[1] {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// Updates go through Insert, so stale index entries are removed
+	txn = db.Txn(true)
+	update := []interface{}{
+		&TestObject{ID: "d", Foo: "z", Qux: []string{"4"}},
+		&TestObject{ID: "a", Foo: "z", Qux: []string{"4"}},
+	}
+	if err := txn.InsertBatch("main", update); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw, err := txn.First("main", "foo", "y"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if raw, err := txn.First("main", "foo", "z"); err != nil || raw != update[1] {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	txn.Abort()
+
+	// Nothing is written if an object is invalid
+	txn = db.Txn(true)
+	invalid := []interface{}{
+		&TestObject{ID: "e", Foo: "x"},
+		&TestObject{Foo: "x"},
+	}
+	if err := txn.InsertBatch("main", invalid); err == nil {
+		t.Fatalf("expected error")
+	}
+	if raw, err := txn.First("main", "id", "e"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	txn.Abort()
+
+	if err := db.Txn(false).InsertBatch("main", objs); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func BenchmarkTxn_InsertBatch(b *testing.B) {
+	objs := make([]interface{}, 10000)
+	for i := range objs {
+		objs[i] = &TestObject{ID: fmt.Sprintf("obj-%x", uint32(i*2654435761)), Foo: fmt.Sprintf("foo-%d", i%100), Qux: []string{"abc"}}
+	}
+
+	for _, batch := range []bool{true, false} {
+		b.Run(fmt.Sprintf("batch=%v", batch), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				db, err := NewMemDB(testValidSchema())
+				if err != nil {
+					b.Fatalf("err: %v", err)
+				}
+				txn := db.Txn(true)
+				if batch {
+					err = txn.InsertBatch("main", objs)
+				} else {
+					for _, obj := range objs {
+						if err = txn.Insert("main", obj); err != nil {
+							break
+						}
+					}
+				}
+				if err != nil {
+					b.Fatalf("err: %v", err)
+				}
+				txn.Commit()
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+// Package bench runs configurable workloads against a MemDB and reports
+// their throughput, latency and allocations, so that the performance of
+// releases and forks can be compared on the same workloads.
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	memdb "github.com/hashicorp/go-memdb"
+)
+
+// MaxIndexes is the maximum number of secondary indexes of a Workload.
+const MaxIndexes = 8
+
+// Row is the object stored in the "rows" table of the workloads. Secondary
+// index i indexes the field Fi.
+type Row struct {
+	ID                             string
+	F0, F1, F2, F3, F4, F5, F6, F7 string
+}
+
+// Workload describes the operations run by Run. Zero fields take their
+// default values.
+//
+// Workload 描述基准测试的负载：表大小、索引数量和读写比例等。
+type Workload struct {
+	// Name identifies the workload in reports, "default" by default.
+	Name string
+
+	// Rows is the number of rows inserted before the workload starts,
+	// 10000 by default. Writes update these rows, so the size of the
+	// table doesn't change.
+	Rows int
+
+	// Indexes is the number of secondary indexes, from 0 to MaxIndexes.
+	// Reads alternate between lookups by ID and lookups by a secondary
+	// index, if any.
+	Indexes int
+
+	// Cardinality is the number of distinct values of each secondary
+	// index, Rows/10 by default.
+	Cardinality int
+
+	// ReadPercent is the percentage of the operations that are read
+	// transactions, the others being write transactions. Negative means
+	// no reads; zero means 90%.
+	ReadPercent int
+
+	// WriteBatch is the number of rows updated by each write transaction,
+	// 1 by default.
+	WriteBatch int
+
+	// Workers is the number of goroutines running operations, 1 by
+	// default.
+	Workers int
+
+	// Ops is the total number of operations, 10000 by default.
+	Ops int
+
+	// Seed seeds the random choice of operations and rows.
+	Seed int64
+
+	// NewDB creates the DB from the schema of the workload, which it may
+	// modify as long as the tables keep their indexes. It uses
+	// memdb.NewMemDB by default.
+	NewDB func(schema *memdb.DBSchema) (*memdb.MemDB, error)
+}
+
+// Latency summarizes the latencies of a kind of operation.
+type Latency struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Report is the result of running a Workload. The setup of the table isn't
+// part of it.
+//
+// Report 是负载运行的结果，包括吞吐量、延迟和内存分配。
+type Report struct {
+	Workload string
+	Ops      int
+	Elapsed  time.Duration
+
+	Reads  Latency
+	Writes Latency
+
+	// Allocs and Bytes are the number and size of the heap allocations of
+	// the whole process during the run.
+	Allocs uint64
+	Bytes  uint64
+}
+
+// OpsPerSec returns the throughput of the run.
+func (r *Report) OpsPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// BenchmarkLine formats the report like a line of the output of go test
+// -bench, so reports can be compared with tools such as benchstat.
+func (r *Report) BenchmarkLine() string {
+	var nsPerOp, allocsPerOp, bytesPerOp float64
+	if r.Ops > 0 {
+		nsPerOp = float64(r.Elapsed.Nanoseconds()) / float64(r.Ops)
+		allocsPerOp = float64(r.Allocs) / float64(r.Ops)
+		bytesPerOp = float64(r.Bytes) / float64(r.Ops)
+	}
+	return fmt.Sprintf("Benchmark%s\t%d\t%.1f ns/op\t%.0f B/op\t%.0f allocs/op\t%d p99-read-ns\t%d p99-write-ns",
+		benchmarkName(r.Workload), r.Ops, nsPerOp, bytesPerOp, allocsPerOp,
+		r.Reads.P99.Nanoseconds(), r.Writes.P99.Nanoseconds())
+}
+
+// benchmarkName returns the name of a workload as a benchmark name, which
+// can't contain spaces.
+func benchmarkName(name string) string {
+	buf := []byte(name)
+	for i, c := range buf {
+		if c == ' ' || c == '\t' {
+			buf[i] = '_'
+		}
+	}
+	return string(buf)
+}
+
+// WriteReport writes a table summarizing the reports to w, one row per
+// report.
+//
+// WriteReport 将报告以表格形式写入 w 。
+func WriteReport(w io.Writer, reports ...*Report) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKLOAD\tOPS\tOPS/SEC\tREAD P50\tREAD P99\tWRITE P50\tWRITE P99\tALLOCS/OP")
+	for _, r := range reports {
+		allocs := "-"
+		if r.Ops > 0 {
+			allocs = strconv.FormatUint(r.Allocs/uint64(r.Ops), 10)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.0f\t%v\t%v\t%v\t%v\t%s\n",
+			r.Workload, r.Ops, r.OpsPerSec(), r.Reads.P50, r.Reads.P99,
+			r.Writes.P50, r.Writes.P99, allocs)
+	}
+	return tw.Flush()
+}
+
+// Schema returns the schema of a workload with the given number of
+// secondary indexes.
+func Schema(indexes int) *memdb.DBSchema {
+	table := &memdb.TableSchema{
+		Name: "rows",
+		Indexes: map[string]*memdb.IndexSchema{
+			"id": &memdb.IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &memdb.StringFieldIndex{Field: "ID"},
+			},
+		},
+	}
+	for i := 0; i < indexes; i++ {
+		name := fmt.Sprintf("f%d", i)
+		table.Indexes[name] = &memdb.IndexSchema{
+			Name:    name,
+			Indexer: &memdb.StringFieldIndex{Field: fmt.Sprintf("F%d", i)},
+		}
+	}
+	return &memdb.DBSchema{
+		Tables: map[string]*memdb.TableSchema{"rows": table},
+	}
+}
+
+// Run sets up the table of the workload and runs its operations.
+//
+// Run 初始化负载所需的表，运行其操作并返回报告。
+func Run(w Workload) (*Report, error) {
+	if w.Name == "" {
+		w.Name = "default"
+	}
+	if w.Rows == 0 {
+		w.Rows = 10000
+	}
+	if w.Cardinality == 0 {
+		w.Cardinality = w.Rows / 10
+	}
+	if w.Cardinality < 1 {
+		w.Cardinality = 1
+	}
+	if w.ReadPercent == 0 {
+		w.ReadPercent = 90
+	}
+	if w.ReadPercent < 0 {
+		w.ReadPercent = 0
+	}
+	if w.WriteBatch == 0 {
+		w.WriteBatch = 1
+	}
+	if w.Workers == 0 {
+		w.Workers = 1
+	}
+	if w.Ops == 0 {
+		w.Ops = 10000
+	}
+	switch {
+	case w.Rows < 0 || w.Ops < 0 || w.WriteBatch < 0 || w.Workers < 0:
+		return nil, fmt.Errorf("workload sizes must not be negative")
+	case w.Indexes < 0 || w.Indexes > MaxIndexes:
+		return nil, fmt.Errorf("workload must have between 0 and %d indexes", MaxIndexes)
+	case w.ReadPercent > 100:
+		return nil, fmt.Errorf("invalid read percentage %d", w.ReadPercent)
+	}
+
+	newDB := w.NewDB
+	if newDB == nil {
+		newDB = func(schema *memdb.DBSchema) (*memdb.MemDB, error) {
+			return memdb.NewMemDB(schema)
+		}
+	}
+	db, err := newDB(Schema(w.Indexes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DB: %v", err)
+	}
+
+	rng := rand.New(rand.NewSource(w.Seed))
+	txn := db.Txn(true)
+	for i := 0; i < w.Rows; i++ {
+		if err := txn.Insert("rows", w.row(rng, i)); err != nil {
+			txn.Abort()
+			return nil, fmt.Errorf("failed to insert row: %v", err)
+		}
+	}
+	txn.Commit()
+
+	var before, after runtime.MemStats
+	results := make([]*workerResult, w.Workers)
+	var wg sync.WaitGroup
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := range results {
+		// The operations are split among the workers
+		ops := w.Ops / w.Workers
+		if i < w.Ops%w.Workers {
+			ops++
+		}
+		results[i] = &workerResult{}
+		wg.Add(1)
+		go func(res *workerResult, rng *rand.Rand) {
+			defer wg.Done()
+			res.err = w.work(db, rng, ops, res)
+		}(results[i], rand.New(rand.NewSource(w.Seed+int64(i)+1)))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	var reads, writes []time.Duration
+	for _, res := range results {
+		if res.err != nil {
+			return nil, res.err
+		}
+		reads = append(reads, res.reads...)
+		writes = append(writes, res.writes...)
+	}
+	return &Report{
+		Workload: w.Name,
+		Ops:      w.Ops,
+		Elapsed:  elapsed,
+		Reads:    summarize(reads),
+		Writes:   summarize(writes),
+		Allocs:   after.Mallocs - before.Mallocs,
+		Bytes:    after.TotalAlloc - before.TotalAlloc,
+	}, nil
+}
+
+// workerResult holds the latencies of the operations of a worker.
+type workerResult struct {
+	reads  []time.Duration
+	writes []time.Duration
+	err    error
+}
+
+// row returns a row with the given ID and random indexed fields.
+func (w *Workload) row(rng *rand.Rand, id int) *Row {
+	row := &Row{ID: strconv.Itoa(id)}
+	fields := []*string{&row.F0, &row.F1, &row.F2, &row.F3, &row.F4, &row.F5, &row.F6, &row.F7}
+	for i := 0; i < w.Indexes; i++ {
+		*fields[i] = strconv.Itoa(rng.Intn(w.Cardinality))
+	}
+	return row
+}
+
+// work runs the given number of operations of the workload.
+func (w *Workload) work(db *memdb.MemDB, rng *rand.Rand, ops int, res *workerResult) error {
+	for i := 0; i < ops; i++ {
+		start := time.Now()
+		if rng.Intn(100) < w.ReadPercent {
+			if err := w.read(db, rng); err != nil {
+				return err
+			}
+			res.reads = append(res.reads, time.Since(start))
+			continue
+		}
+
+		txn := db.Txn(true)
+		for j := 0; j < w.WriteBatch; j++ {
+			if err := txn.Insert("rows", w.row(rng, rng.Intn(w.Rows))); err != nil {
+				txn.Abort()
+				return fmt.Errorf("failed to update row: %v", err)
+			}
+		}
+		txn.Commit()
+		res.writes = append(res.writes, time.Since(start))
+	}
+	return nil
+}
+
+// read looks up a row by ID, or the rows with a value of a secondary index.
+func (w *Workload) read(db *memdb.MemDB, rng *rand.Rand) error {
+	txn := db.Txn(false)
+	if w.Indexes == 0 || rng.Intn(2) == 0 {
+		_, err := txn.First("rows", "id", strconv.Itoa(rng.Intn(w.Rows)))
+		return err
+	}
+	index := fmt.Sprintf("f%d", rng.Intn(w.Indexes))
+	iter, err := txn.Get("rows", index, strconv.Itoa(rng.Intn(w.Cardinality)))
+	if err != nil {
+		return err
+	}
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+	}
+	return nil
+}
+
+// summarize returns the statistics of the latencies.
+func summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	percentile := func(p int) time.Duration {
+		return latencies[(len(latencies)-1)*p/100]
+	}
+	return Latency{
+		Count: len(latencies),
+		Mean:  total / time.Duration(len(latencies)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   latencies[len(latencies)-1],
+	}
+}
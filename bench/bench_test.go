@@ -0,0 +1,99 @@
+package bench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	memdb "github.com/hashicorp/go-memdb"
+)
+
+func TestRun(t *testing.T) {
+	report, err := Run(Workload{
+		Name:        "mixed",
+		Rows:        100,
+		Indexes:     2,
+		ReadPercent: 50,
+		WriteBatch:  3,
+		Workers:     3,
+		Ops:         200,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if report.Workload != "mixed" || report.Ops != 200 || report.Elapsed <= 0 {
+		t.Fatalf("bad: %#v", report)
+	}
+	if report.Reads.Count+report.Writes.Count != 200 || report.Reads.Count == 0 || report.Writes.Count == 0 {
+		t.Fatalf("bad: %#v", report)
+	}
+	for _, l := range []Latency{report.Reads, report.Writes} {
+		if l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.Max || l.Mean > l.Max {
+			t.Fatalf("bad: %#v", l)
+		}
+	}
+	if report.Allocs == 0 || report.OpsPerSec() <= 0 {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	// Reads only
+	report, err = Run(Workload{Rows: 10, ReadPercent: 100, Ops: 50})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if report.Workload != "default" || report.Reads.Count != 50 || report.Writes.Count != 0 {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	// Writes only, with the DB created by the caller
+	created := false
+	newDB := func(schema *memdb.DBSchema) (*memdb.MemDB, error) {
+		created = true
+		return memdb.NewMemDB(schema)
+	}
+	report, err = Run(Workload{Rows: 10, ReadPercent: -1, Ops: 50, NewDB: newDB})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !created || report.Reads.Count != 0 || report.Writes.Count != 50 {
+		t.Fatalf("bad: %#v", report)
+	}
+}
+
+func TestRun_Invalid(t *testing.T) {
+	for _, w := range []Workload{
+		Workload{Indexes: MaxIndexes + 1},
+		Workload{ReadPercent: 101},
+		Workload{Ops: -1},
+	} {
+		if _, err := Run(w); err == nil {
+			t.Fatalf("expected error: %#v", w)
+		}
+	}
+}
+
+func TestReport_Format(t *testing.T) {
+	report := &Report{
+		Workload: "read heavy",
+		Ops:      1000,
+		Elapsed:  2 * time.Millisecond,
+		Reads:    Latency{P50: time.Microsecond, P99: 3 * time.Microsecond},
+		Writes:   Latency{P50: 2 * time.Microsecond, P99: 5 * time.Microsecond},
+		Allocs:   5000,
+		Bytes:    64000,
+	}
+	expected := "Benchmarkread_heavy\t1000\t2000.0 ns/op\t64 B/op\t5 allocs/op\t3000 p99-read-ns\t5000 p99-write-ns"
+	if line := report.BenchmarkLine(); line != expected {
+		t.Fatalf("bad: %q", line)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, report); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "read heavy") || !strings.Contains(lines[1], "500000") {
+		t.Fatalf("bad: %q", buf.String())
+	}
+}
@@ -0,0 +1,537 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	// Messages sent by ServeBootstrap to a replica.
+	bootstrapBegin   = 'B'
+	bootstrapRows    = 'R'
+	bootstrapSynced  = 'S'
+	bootstrapChanges = 'I'
+	bootstrapEnd     = 'X'
+
+	// bootstrapRetained is the number of snapshots kept around so that
+	// replicas can resume an interrupted bootstrap.
+	bootstrapRetained = 4
+)
+
+// BootstrapState is the progress of a replica bootstrapping with Bootstrap.
+// Passing the same state to a later call after the connection failed resumes
+// the bootstrap where it stopped, instead of starting over.
+type BootstrapState struct {
+	// Index is the commit index of the primary the replica is being
+	// brought to, or zero before the bootstrap starts.
+	Index uint64
+
+	// Table and Key are the position reached in the snapshot: the rows of
+	// the tables sorted before Table, and the rows of Table up to Key, have
+	// been loaded. A nil Key means all of the rows of Table have.
+	Table string
+	Key   []byte
+
+	// Synced is true once the whole snapshot has been loaded. The replica
+	// is then in the state of the primary at Index, and follows the
+	// changes made after it.
+	Synced bool
+}
+
+// ServeBootstrap brings up to date a replica calling Bootstrap on the other
+// end of peerConn. It streams a consistent snapshot of every table in chunks,
+// followed by the sequences of the tables, and then the changes of every
+// commit, until writing to the replica fails or the DB is closed. Changes are sent as incremental snapshots, so commits
+// made while the replica receives a batch are folded into the next one. A
+// Codec must be given with WithCodec, and both DBs must have the same schema
+// and data version.
+//
+// The latest snapshots sent to replicas are kept in memory for a while, so
+// that a replica that lost its connection can resume from where it stopped.
+// Otherwise the replica is sent a new snapshot, replacing its rows chunk by
+// chunk.
+//
+// ServeBootstrap 向对端的副本发送一致性快照（分块、可续传），然后持续发送变更流。
+func (db *MemDB) ServeBootstrap(peerConn io.ReadWriter) error {
+	if db.codec == nil {
+		return fmt.Errorf("a codec is required to serve bootstraps")
+	}
+
+	db.jobsLock.Lock()
+	if db.shutdown {
+		db.jobsLock.Unlock()
+		return fmt.Errorf("the DB is closed")
+	}
+	if db.shutdownCh == nil {
+		db.shutdownCh = make(chan struct{})
+	}
+	shutdownCh := db.shutdownCh
+	db.jobsWg.Add(1)
+	db.jobsLock.Unlock()
+	defer db.jobsWg.Done()
+
+	r := bufio.NewReader(peerConn)
+	w := bufio.NewWriterSize(peerConn, streamChunkSize)
+
+	data, err := readBytes(r)
+	if err != nil {
+		return fmt.Errorf("failed to read bootstrap request: %v", err)
+	}
+	state, err := decodeBootstrapState(data)
+	if err != nil {
+		return err
+	}
+
+	// Resume from the snapshot the replica was given, if it is still
+	// around, or start over from a new one.
+	base := db.bootstrapBase(state.Index)
+	if base == nil {
+		base = db.retainBootstrapBase(db.Snapshot())
+		state = BootstrapState{Index: base.commitIndex}
+	}
+	var begin bytes.Buffer
+	writeUvarints(&begin, base.commitIndex, db.dataVersion)
+	if err := writeBootstrapMessage(w, bootstrapBegin, begin.Bytes()); err != nil {
+		return err
+	}
+
+	if !state.Synced {
+		if err := db.serveBootstrapSnapshot(w, base, state); err != nil {
+			return err
+		}
+		if err := writeBootstrapMessage(w, bootstrapSynced, db.bootstrapSequences(base)); err != nil {
+			return err
+		}
+		db.logger.Info("streamed bootstrap snapshot", "index", base.commitIndex)
+	}
+
+	// Send the changes of every commit from then on
+	for {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		baseTxn := base.Txn(false)
+		ws := NewWatchSet()
+		ws.Add(shutdownCh)
+		for table := range db.getSchema().Tables {
+			iter, err := baseTxn.Get(table, id)
+			if err != nil {
+				return err
+			}
+			ws.Add(iter.WatchCh())
+		}
+		ws.WatchCtx(context.Background())
+
+		select {
+		case <-shutdownCh:
+			if err := writeBootstrapMessage(w, bootstrapEnd, nil); err != nil {
+				return err
+			}
+			return w.Flush()
+		default:
+		}
+
+		next := db.retainBootstrapBase(db.Snapshot())
+		if next == base {
+			continue
+		}
+		var changes bytes.Buffer
+		if err := next.SaveIncrementalSnapshot(&changes, base); err != nil {
+			return err
+		}
+		if err := writeBootstrapMessage(w, bootstrapChanges, changes.Bytes()); err != nil {
+			return err
+		}
+		base = next
+	}
+}
+
+// serveBootstrapSnapshot sends the rows of the snapshot after the position
+// of the state. Every table is sent as a series of chunks, each covering the
+// rows after the end of the previous chunk, so the replica can drop the rows
+// it holds that are missing from them.
+func (db *MemDB) serveBootstrapSnapshot(w *bufio.Writer, base *MemDB, state BootstrapState) error {
+	tables := make([]string, 0, len(db.getSchema().Tables))
+	for table := range db.getSchema().Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	txn := base.Txn(false)
+	for _, table := range tables {
+		var from []byte
+		if table < state.Table || table == state.Table && state.Key == nil {
+			continue
+		}
+		if table == state.Table {
+			from = state.Key
+		}
+
+		var rows [][]byte
+		size := 0
+		iter := txn.readableIndex(table, id).Root().Iterator()
+		if from != nil {
+			iter.SeekLowerBound(from)
+		}
+		for key, raw, ok := iter.Next(); ok; key, raw, ok = iter.Next() {
+			if from != nil && bytes.Equal(key, from) {
+				continue
+			}
+			obj, err := txn.resolve(table, raw)
+			if err != nil {
+				return err
+			}
+			data, err := db.codec.Encode(table, obj)
+			if err != nil {
+				return fmt.Errorf("failed to encode object: %v", err)
+			}
+			rows = append(rows, data)
+			size += len(data)
+			if size < streamChunkSize {
+				continue
+			}
+
+			if err := writeBootstrapRows(w, table, from, key, rows); err != nil {
+				return err
+			}
+			from, rows, size = key, nil, 0
+		}
+		if err := writeBootstrapRows(w, table, from, nil, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBootstrapRows sends a chunk of rows of a table, covering the keys
+// after from through to. A nil from is the start of the table, and a nil to
+// its end.
+func writeBootstrapRows(w *bufio.Writer, table string, from, to []byte, rows [][]byte) error {
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(table))
+	writeBytes(&buf, from)
+	if to == nil {
+		buf.WriteByte(0)
+	} else {
+		buf.WriteByte(1)
+		writeBytes(&buf, to)
+	}
+	writeUvarints(&buf, uint64(len(rows)))
+	for _, row := range rows {
+		writeBytes(&buf, row)
+	}
+	return writeBootstrapMessage(w, bootstrapRows, buf.Bytes())
+}
+
+// bootstrapBase returns the retained snapshot at the given commit index, or
+// nil if there is none.
+func (db *MemDB) bootstrapBase(index uint64) *MemDB {
+	db.bootstrapLock.Lock()
+	defer db.bootstrapLock.Unlock()
+	for _, base := range db.bootstrapBases {
+		if base.commitIndex == index {
+			return base
+		}
+	}
+	return nil
+}
+
+// retainBootstrapBase keeps a snapshot about to be sent to a replica,
+// dropping the oldest one if too many are kept. If a snapshot at the same
+// commit index is already kept, that one is returned instead, so all the
+// replicas at an index agree on its contents.
+func (db *MemDB) retainBootstrapBase(base *MemDB) *MemDB {
+	db.bootstrapLock.Lock()
+	defer db.bootstrapLock.Unlock()
+	for _, retained := range db.bootstrapBases {
+		if retained.commitIndex == base.commitIndex {
+			return retained
+		}
+	}
+	if len(db.bootstrapBases) >= bootstrapRetained {
+		db.bootstrapBases = db.bootstrapBases[1:]
+	}
+	db.bootstrapBases = append(db.bootstrapBases, base)
+	return base
+}
+
+// Bootstrap brings the DB up to date with the primary serving ServeBootstrap
+// on the other end of peerConn, and then keeps applying its changes. It
+// returns nil once the primary is closed, or the error that interrupted the
+// bootstrap. The state records the progress made, and can be passed to a new
+// call with a new connection to resume; a zero state starts from scratch.
+//
+// The rows of each chunk of the snapshot are loaded in a write transaction
+// of their own, which also deletes the rows the DB holds in the range the
+// chunk covers, so a DB restarting a bootstrap converges to the primary. The
+// DB must not be written to by anything else while it is a replica.
+//
+// Bootstrap 从对端的主库流式拉取一致性快照，然后切换到变更流。
+func (db *MemDB) Bootstrap(peerConn io.ReadWriter, state *BootstrapState) error {
+	if db.codec == nil {
+		return fmt.Errorf("a codec is required to bootstrap")
+	}
+
+	w := bufio.NewWriter(peerConn)
+	if err := writeBytes(w, encodeBootstrapState(state)); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(peerConn, streamChunkSize)
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read bootstrap message: %v", err)
+		}
+		data, err := readBytes(r)
+		if err != nil {
+			return fmt.Errorf("failed to read bootstrap message: %v", err)
+		}
+
+		switch kind {
+		case bootstrapBegin:
+			fields, err := readUvarints(data, 2)
+			if err != nil {
+				return err
+			}
+			if fields[1] != db.dataVersion {
+				return fmt.Errorf("primary data version %d doesn't match %d", fields[1], db.dataVersion)
+			}
+			if fields[0] != state.Index {
+				*state = BootstrapState{Index: fields[0]}
+			}
+
+		case bootstrapRows:
+			if err := db.applyBootstrapRows(data, state); err != nil {
+				return err
+			}
+
+		case bootstrapSynced:
+			// Commit nothing but the sequences and the index of the
+			// snapshot, so the changes that follow apply on top of it.
+			txn := db.Txn(true)
+			if err := txn.applyBootstrapSequences(data); err != nil {
+				txn.Abort()
+				return err
+			}
+			atomic.StoreUint64(&db.commitIndex, state.Index-1)
+			if err := txn.TryCommit(); err != nil {
+				return err
+			}
+			state.Synced = true
+			db.logger.Info("bootstrapped from snapshot", "index", state.Index)
+
+		case bootstrapChanges:
+			if err := db.ApplyIncrementalSnapshot(bytes.NewReader(data)); err != nil {
+				return err
+			}
+			state.Index = atomic.LoadUint64(&db.commitIndex)
+
+		case bootstrapEnd:
+			return nil
+
+		default:
+			return fmt.Errorf("unknown bootstrap message type %q", kind)
+		}
+	}
+}
+
+// applyBootstrapRows replaces the rows of the DB in the range covered by a
+// chunk with the rows of the chunk, and records the progress in the state.
+func (db *MemDB) applyBootstrapRows(data []byte, state *BootstrapState) error {
+	r := bufio.NewReader(bytes.NewReader(data))
+	name, err := readBytes(r)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap rows: %v", err)
+	}
+	table := string(name)
+	from, err := readBytes(r)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap rows: %v", err)
+	}
+	var to []byte
+	if last, err := r.ReadByte(); err != nil {
+		return fmt.Errorf("invalid bootstrap rows: %v", err)
+	} else if last == 1 {
+		if to, err = readBytes(r); err != nil {
+			return fmt.Errorf("invalid bootstrap rows: %v", err)
+		}
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap rows: %v", err)
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+	if _, ok := txn.tableSchema(table); !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+
+	// Drop the rows in the range, then load the ones of the chunk
+	var stale [][]byte
+	iter := txn.readableIndex(table, id).Root().Iterator()
+	if len(from) > 0 {
+		iter.SeekLowerBound(from)
+	}
+	for key, _, ok := iter.Next(); ok; key, _, ok = iter.Next() {
+		if to != nil && bytes.Compare(key, to) > 0 {
+			break
+		}
+		if len(from) == 0 || !bytes.Equal(key, from) {
+			stale = append(stale, key)
+		}
+	}
+	for _, key := range stale {
+		if err := txn.deleteByKey(table, key); err != nil {
+			return err
+		}
+	}
+	for i := uint64(0); i < count; i++ {
+		row, err := readBytes(r)
+		if err != nil {
+			return fmt.Errorf("invalid bootstrap rows: %v", err)
+		}
+		obj, err := db.codec.Decode(table, row)
+		if err != nil {
+			return fmt.Errorf("failed to decode object: %v", err)
+		}
+		if err := txn.Insert(table, obj); err != nil {
+			return err
+		}
+	}
+	if err := txn.TryCommit(); err != nil {
+		return err
+	}
+
+	state.Table, state.Key = table, to
+	return nil
+}
+
+// bootstrapSequences encodes the sequences of the snapshot, which are sent
+// once its rows are.
+func (db *MemDB) bootstrapSequences(base *MemDB) []byte {
+	txn := base.Txn(false)
+	tables := make([]string, 0, len(txn.schema.Tables))
+	for table := range txn.schema.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var buf bytes.Buffer
+	for _, seq := range txn.sequenceValues(tables) {
+		writeBytes(&buf, []byte(seq.Table))
+		writeBytes(&buf, encodeSequence(seq.Value))
+	}
+	return buf.Bytes()
+}
+
+// applyBootstrapSequences sets the sequences encoded by bootstrapSequences.
+func (txn *Txn) applyBootstrapSequences(data []byte) error {
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return nil
+		}
+		table, err := readBytes(r)
+		if err != nil {
+			return fmt.Errorf("invalid bootstrap sequences: %v", err)
+		}
+		data, err := readBytes(r)
+		if err != nil {
+			return fmt.Errorf("invalid bootstrap sequences: %v", err)
+		}
+		value, err := decodeSequence(data)
+		if err != nil {
+			return err
+		}
+		if _, ok := txn.schema.Tables[string(table)]; !ok {
+			return fmt.Errorf("invalid table '%s'", table)
+		}
+		txn.setSequence(string(table), value)
+	}
+}
+
+// writeBootstrapMessage writes a message of the given kind.
+func writeBootstrapMessage(w *bufio.Writer, kind byte, data []byte) error {
+	if err := w.WriteByte(kind); err != nil {
+		return err
+	}
+	return writeBytes(w, data)
+}
+
+// encodeBootstrapState encodes the state a replica sends to the primary.
+func encodeBootstrapState(state *BootstrapState) []byte {
+	var buf bytes.Buffer
+	synced := uint64(0)
+	if state.Synced {
+		synced = 1
+	}
+	writeUvarints(&buf, state.Index, synced)
+	writeBytes(&buf, []byte(state.Table))
+	writeBytes(&buf, state.Key)
+	return buf.Bytes()
+}
+
+// decodeBootstrapState decodes data written by encodeBootstrapState.
+func decodeBootstrapState(data []byte) (BootstrapState, error) {
+	var state BootstrapState
+	r := bufio.NewReader(bytes.NewReader(data))
+	index, err := binary.ReadUvarint(r)
+	if err != nil {
+		return state, fmt.Errorf("invalid bootstrap request: %v", err)
+	}
+	synced, err := binary.ReadUvarint(r)
+	if err != nil {
+		return state, fmt.Errorf("invalid bootstrap request: %v", err)
+	}
+	table, err := readBytes(r)
+	if err != nil {
+		return state, fmt.Errorf("invalid bootstrap request: %v", err)
+	}
+	key, err := readBytes(r)
+	if err != nil {
+		return state, fmt.Errorf("invalid bootstrap request: %v", err)
+	}
+
+	state.Index = index
+	state.Synced = synced != 0
+	state.Table = string(table)
+	if len(key) > 0 {
+		state.Key = key
+	}
+	return state, nil
+}
+
+// writeUvarints appends the values as uvarints.
+func writeUvarints(buf *bytes.Buffer, vals ...uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	for _, val := range vals {
+		n := binary.PutUvarint(tmp[:], val)
+		buf.Write(tmp[:n])
+	}
+}
+
+// readUvarints reads the given number of uvarints from data.
+func readUvarints(data []byte, n int) ([]uint64, error) {
+	r := bytes.NewReader(data)
+	vals := make([]uint64, n)
+	for i := range vals {
+		val, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap message: %v", err)
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
@@ -0,0 +1,166 @@
+package memdb
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBootstrapConn counts the bytes read from a connection, and fails reads
+// once limit bytes have been read if limit is set.
+type testBootstrapConn struct {
+	net.Conn
+	read  int
+	limit int
+}
+
+func (c *testBootstrapConn) Read(p []byte) (int, error) {
+	if c.limit > 0 {
+		if c.read >= c.limit {
+			return 0, fmt.Errorf("connection lost")
+		}
+		if len(p) > c.limit-c.read {
+			p = p[:c.limit-c.read]
+		}
+	}
+	n, err := c.Conn.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestMemDB_Bootstrap(t *testing.T) {
+	primary, err := NewMemDB(testValidSchema(), WithCodec(testCodec()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := primary.Txn(true)
+	padding := strings.Repeat("x", 4096)
+	for i := 0; i < 40; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("obj%02d", i), Foo: padding, Qux: []string{"a"}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := txn.NextSequence("main"); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// The replica holds a row the primary doesn't have
+	replica, err := NewMemDB(testValidSchema(), WithCodec(testCodec()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn = replica.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "stale", Foo: "x", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	serve := func(conn net.Conn) chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- primary.ServeBootstrap(conn)
+		}()
+		return errCh
+	}
+
+	// Lose the connection halfway through the snapshot
+	a, b := net.Pipe()
+	serveCh := serve(a)
+	conn := &testBootstrapConn{Conn: b, limit: 100 * 1024}
+	state := &BootstrapState{}
+	if err := replica.Bootstrap(conn, state); err == nil {
+		t.Fatalf("expected error")
+	}
+	b.Close()
+	if err := <-serveCh; err == nil {
+		t.Fatalf("expected error")
+	}
+	if state.Index == 0 || state.Synced || state.Table != "main" || state.Key == nil {
+		t.Fatalf("bad: %#v", state)
+	}
+	index := state.Index
+
+	// Commit while the replica is disconnected, then resume
+	txn = primary.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "new", Foo: "x", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	a, b = net.Pipe()
+	serveCh = serve(a)
+	conn = &testBootstrapConn{Conn: b}
+	bootstrapCh := make(chan error, 1)
+	go func() {
+		bootstrapCh <- replica.Bootstrap(conn, state)
+	}()
+
+	waitFor := func(fn func(txn *Txn) bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !fn(replica.Txn(false)) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	count := func(txn *Txn) int {
+		iter, err := txn.Get("main", "id")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		n := 0
+		for obj := iter.Next(); obj != nil; obj = iter.Next() {
+			n++
+		}
+		return n
+	}
+	waitFor(func(txn *Txn) bool {
+		raw, err := txn.First("main", "id", "new")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return raw != nil
+	})
+	if n := count(replica.Txn(false)); n != 41 {
+		t.Fatalf("bad: %d", n)
+	}
+	if seqs := replica.Txn(false).sequenceValues([]string{"main"}); len(seqs) != 1 || seqs[0].Value != 3 {
+		t.Fatalf("bad: %#v", seqs)
+	}
+
+	// Changes keep flowing once synced
+	txn = primary.Txn(true)
+	if _, err := txn.DeleteAll("main", "id_prefix", "obj1"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	waitFor(func(txn *Txn) bool {
+		return count(txn) == 31
+	})
+
+	// Closing the primary ends the bootstrap cleanly
+	primary.Close()
+	if err := <-bootstrapCh; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := <-serveCh; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !state.Synced || state.Index != index+2 {
+		t.Fatalf("bad: %#v", state)
+	}
+	if conn.read > 128*1024 {
+		t.Fatalf("snapshot was sent again: %d bytes", conn.read)
+	}
+	raw, err := replica.Txn(false).First("main", "id", "stale")
+	if err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
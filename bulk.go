@@ -0,0 +1,107 @@
+package memdb
+
+import "fmt"
+
+// DefaultBulkChunkSize is the number of mutations ApplyBulk commits per
+// transaction when BulkOptions.ChunkSize is not set.
+const DefaultBulkChunkSize = 1000
+
+// Mutation is a single write applied by ApplyBulk.
+type Mutation struct {
+	Table string
+	Obj   interface{}
+
+	// Delete deletes Obj from the table instead of inserting it.
+	Delete bool
+}
+
+// BulkOptions controls how ApplyBulk splits its mutations.
+type BulkOptions struct {
+	// ChunkSize is the maximum number of mutations committed by each
+	// transaction.
+	ChunkSize int
+
+	// Start is the index of the first mutation to apply, used to resume
+	// from the Done count of a BulkError.
+	Start int
+
+	// Policy, if set, is used to retry a chunk that failed with a
+	// retryable error before giving up.
+	Policy *RetryPolicy
+
+	// Progress, if set, is called after each chunk is committed with the
+	// number of mutations applied so far, including those before Start,
+	// and the total number of mutations.
+	Progress func(done, total int)
+}
+
+// BulkError is returned by ApplyBulk when a chunk fails. The mutations
+// before Done were committed, and the failed chunk was not.
+type BulkError struct {
+	Done int
+	Err  error
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("bulk mutation failed after %d mutations: %v", e.Done, e.Err)
+}
+
+// ApplyBulk applies a large set of mutations over several sequential write
+// transactions of at most opts.ChunkSize mutations each. Keeping every
+// commit small bounds how long the writer lock is held and how many watch
+// channels each commit closes at once. Readers may observe the mutations
+// partly applied.
+//
+// If a chunk fails, a *BulkError is returned, and calling ApplyBulk again
+// with Start set to its Done count resumes with the failed chunk.
+//
+// ApplyBulk 将大量写操作拆分为多个小事务依次提交，出错后可以从断点继续。
+func (db *MemDB) ApplyBulk(muts []Mutation, opts BulkOptions) error {
+	size := opts.ChunkSize
+	if size <= 0 {
+		size = DefaultBulkChunkSize
+	}
+
+	for done := opts.Start; done < len(muts); {
+		end := done + size
+		if end > len(muts) {
+			end = len(muts)
+		}
+		chunk := muts[done:end]
+		fn := func(txn *Txn) error {
+			return applyMutations(txn, chunk)
+		}
+
+		var err error
+		if opts.Policy != nil {
+			err = RetryWithPolicy(db, *opts.Policy, fn)
+		} else {
+			err = runTxn(db, nil, fn)
+		}
+		if err != nil {
+			return &BulkError{Done: done, Err: err}
+		}
+
+		done = end
+		if opts.Progress != nil {
+			opts.Progress(done, len(muts))
+		}
+	}
+	return nil
+}
+
+// applyMutations applies mutations in a write transaction.
+func applyMutations(txn *Txn, muts []Mutation) error {
+	for _, m := range muts {
+		var err error
+		if m.Delete {
+			err = txn.Delete(m.Table, m.Obj)
+		} else {
+			err = txn.Insert(m.Table, m.Obj)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
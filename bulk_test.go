@@ -0,0 +1,61 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMemDB_ApplyBulk(t *testing.T) {
+	db := testDB(t)
+	var muts []Mutation
+	for i := 0; i < 10; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("%02d", i), Foo: "x", Qux: []string{"q"}}
+		muts = append(muts, Mutation{Table: "main", Obj: obj})
+	}
+
+	// An invalid mutation fails its chunk only
+	bad := muts[7]
+	muts[7] = Mutation{Table: "main", Obj: &TestObject{ID: "07"}}
+	var progress []int
+	opts := BulkOptions{
+		ChunkSize: 3,
+		Progress: func(done, total int) {
+			if total != 10 {
+				t.Fatalf("bad: %d", total)
+			}
+			progress = append(progress, done)
+		},
+	}
+	err := db.ApplyBulk(muts, opts)
+	berr, ok := err.(*BulkError)
+	if !ok || berr.Done != 6 {
+		t.Fatalf("bad: %#v", err)
+	}
+	if len(progress) != 2 || progress[1] != 6 {
+		t.Fatalf("bad: %#v", progress)
+	}
+	if raw, err := db.Txn(false).Last("main", "id"); err != nil || raw.(*TestObject).ID != "05" {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// Resume from the failed chunk
+	muts[7] = bad
+	opts.Start = berr.Done
+	if err := db.ApplyBulk(muts, opts); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(progress) != 4 || progress[3] != 10 {
+		t.Fatalf("bad: %#v", progress)
+	}
+
+	// Deletes
+	for i := range muts {
+		muts[i].Delete = true
+	}
+	if err := db.ApplyBulk(muts, BulkOptions{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw, err := db.Txn(false).First("main", "id"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
@@ -0,0 +1,139 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// CascadeSchema declares that the rows of a child table reference rows of
+// the parent table holding the schema, by storing a key of the parent. When
+// an update changes that key, the children that referenced the old key are
+// passed to Update and the returned copies are inserted in their place, all
+// within the same transaction.
+//
+// The child index must encode values the same way as the parent index, for
+// example by both being StringFieldIndex indexes.
+//
+// CascadeSchema 声明子表对父表的引用，父表的键变化时在同一事务中级联更新子表。
+type CascadeSchema struct {
+	// Index is the unique index of the parent table holding the key that
+	// children reference. It can't be the id index, since updates never
+	// change the primary key.
+	Index string
+
+	// ChildTable and ChildIndex give the index of the children holding the
+	// referenced key.
+	ChildTable string
+	ChildIndex string
+
+	// Update returns a copy of child that references the updated parent.
+	// The child object must not be modified in place.
+	Update func(child, parent interface{}) (interface{}, error)
+}
+
+// Validate is used to validate the cascade against the schema of the
+// database and of its parent table.
+func (s *CascadeSchema) Validate(schema *DBSchema, parent *TableSchema) error {
+	if s.Index == id {
+		return fmt.Errorf("cascade index can't be the id index")
+	}
+	indexSchema, ok := parent.Indexes[s.Index]
+	if !ok {
+		return fmt.Errorf("invalid index '%s'", s.Index)
+	}
+	if !indexSchema.Unique {
+		return fmt.Errorf("index '%s' must be unique", s.Index)
+	}
+	childSchema, ok := schema.Tables[s.ChildTable]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", s.ChildTable)
+	}
+	if _, ok := childSchema.Indexes[s.ChildIndex]; !ok {
+		return fmt.Errorf("invalid index '%s' for table '%s'", s.ChildIndex, s.ChildTable)
+	}
+	if s.Update == nil {
+		return fmt.Errorf("missing update function")
+	}
+	return nil
+}
+
+// cascadeUpdate rewrites the children of a parent row whose referenced key
+// changed from existing to obj.
+func (txn *Txn) cascadeUpdate(tableSchema *TableSchema, existing, obj interface{}) error {
+	for _, cascade := range tableSchema.Cascades {
+		indexSchema := tableSchema.Indexes[cascade.Index]
+		okOld, oldVals, err := indexValues(indexSchema, existing)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", cascade.Index, err)
+		}
+		okNew, newVals, err := indexValues(indexSchema, obj)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", cascade.Index, err)
+		}
+		if !okOld || (okNew && sameIndexValues(oldVals, newVals)) {
+			continue
+		}
+
+		// Collect the children before updating any of them, since the
+		// updates modify the index being read.
+		children, err := txn.lookupKeys(cascade.ChildTable, cascade.ChildIndex, oldVals)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			updated, err := cascade.Update(child, obj)
+			if err != nil {
+				return fmt.Errorf("failed to cascade update to table '%s': %v", cascade.ChildTable, err)
+			}
+			if err := txn.insert(cascade.ChildTable, updated); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookupKeys returns the rows of the table whose values for the index are
+// any of the given encoded keys. The keys of a non-unique index are matched
+// as prefixes, since the primary key is appended to them.
+func (txn *Txn) lookupKeys(table, index string, keys [][]byte) ([]interface{}, error) {
+	unique := txn.schema.Tables[table].Indexes[index].Unique
+	indexTxn := txn.readableIndex(table, index)
+
+	var rows []interface{}
+	for _, key := range keys {
+		if unique {
+			if raw, ok := indexTxn.Get(key); ok {
+				rows = append(rows, raw)
+			}
+			continue
+		}
+		iter := indexTxn.Root().Iterator()
+		iter.SeekPrefix(key)
+		for _, raw, ok := iter.Next(); ok; _, raw, ok = iter.Next() {
+			rows = append(rows, raw)
+		}
+	}
+
+	for i, raw := range rows {
+		row, err := txn.resolve(table, raw)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// sameIndexValues returns true if both sets of index values are equal.
+func sameIndexValues(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
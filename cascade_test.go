@@ -0,0 +1,135 @@
+package memdb
+
+import (
+	"testing"
+)
+
+type testTeam struct {
+	ID   string
+	Name string
+}
+
+type testMember struct {
+	ID   string
+	Team string
+}
+
+func testCascadeSchema() *DBSchema {
+	return &DBSchema{
+		Tables: map[string]*TableSchema{
+			"teams": &TableSchema{
+				Name: "teams",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"name": &IndexSchema{
+						Name:    "name",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "Name"},
+					},
+				},
+				Cascades: []*CascadeSchema{
+					&CascadeSchema{
+						Index:      "name",
+						ChildTable: "members",
+						ChildIndex: "team",
+						Update: func(child, parent interface{}) (interface{}, error) {
+							member := *child.(*testMember)
+							member.Team = parent.(*testTeam).Name
+							return &member, nil
+						},
+					},
+				},
+			},
+			"members": &TableSchema{
+				Name: "members",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"team": &IndexSchema{
+						Name:    "team",
+						Indexer: &StringFieldIndex{Field: "Team"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestCascadeSchema_Validate(t *testing.T) {
+	schema := testCascadeSchema()
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	schema.Tables["teams"].Cascades[0].Index = "id"
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, id index")
+	}
+
+	schema = testCascadeSchema()
+	schema.Tables["teams"].Cascades[0].ChildIndex = "nope"
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, invalid child index")
+	}
+
+	schema = testCascadeSchema()
+	schema.Tables["teams"].Cascades[0].Update = nil
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, missing update")
+	}
+}
+
+func TestTxn_CascadeUpdate(t *testing.T) {
+	db, err := NewMemDB(testCascadeSchema())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	inserts := []struct {
+		table string
+		obj   interface{}
+	}{
+		{"teams", &testTeam{ID: "1", Name: "red"}},
+		{"teams", &testTeam{ID: "2", Name: "redder"}},
+		{"members", &testMember{ID: "a", Team: "red"}},
+		{"members", &testMember{ID: "b", Team: "red"}},
+		{"members", &testMember{ID: "c", Team: "redder"}},
+	}
+	for _, insert := range inserts {
+		if err := txn.Insert(insert.table, insert.obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Updates that keep the key don't touch the children
+	if err := txn.Insert("teams", &testTeam{ID: "1", Name: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw, _ := txn.First("members", "id", "a"); raw != inserts[2].obj {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	if err := txn.Insert("teams", &testTeam{ID: "1", Name: "blue"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	for id, team := range map[string]string{"a": "blue", "b": "blue", "c": "redder"} {
+		raw, err := txn.First("members", "id", id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if member := raw.(*testMember); member.Team != team {
+			t.Fatalf("bad: %#v", member)
+		}
+	}
+}
@@ -1,5 +1,13 @@
 package memdb
 
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
 // Changes describes a set of mutations to memDB tables performed during a
 // transaction.
 //
@@ -21,6 +29,12 @@ type Change struct {
 	// primaryKey 存储主键索引中的原始键值，以便我们可以在同一事务中对同一对象进行多次更新，
 	// 但不向使用者公开这个实现细节。
 	primaryKey []byte
+
+	// truncated holds the primary index of a table whose objects were
+	// deleted at once, either all of them or the ones whose primary key
+	// starts with primaryKey. It stands for the deletion of each of these
+	// objects until Changes expands it into one Change per object.
+	truncated *iradix.Tree
 }
 
 // Created returns true if the mutation describes a new object being inserted.
@@ -45,3 +59,85 @@ func (m *Change) Updated() bool {
 func (m *Change) Deleted() bool {
 	return m.Before != nil && m.After == nil
 }
+
+// ChangedIndexes returns the names of the indexes of the table whose values
+// differ between Before and After, sorted. Every index with a value changes
+// when an object is created or deleted. The values of a multi-value index
+// are compared as a set. This lets consumers of Changes that only maintain
+// some projections of a table skip the changes that don't affect them.
+//
+// ChangedIndexes 返回变更前后索引值不同的索引名。
+func (m *Change) ChangedIndexes(schema *DBSchema) ([]string, error) {
+	tableSchema, ok := schema.Tables[m.Table]
+	if !ok {
+		return nil, fmt.Errorf("invalid table '%s'", m.Table)
+	}
+	var changed []string
+	for name, indexSchema := range tableSchema.Indexes {
+		diff, err := m.indexChanged(indexSchema)
+		if err != nil {
+			return nil, err
+		}
+		if diff {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// IndexChanged returns true if the values of the given index differ between
+// Before and After, as described for ChangedIndexes.
+//
+// IndexChanged 判断给定索引的值在变更前后是否不同。
+func (m *Change) IndexChanged(schema *DBSchema, index string) (bool, error) {
+	tableSchema, ok := schema.Tables[m.Table]
+	if !ok {
+		return false, fmt.Errorf("invalid table '%s'", m.Table)
+	}
+	indexSchema, ok := tableSchema.Indexes[index]
+	if !ok {
+		return false, fmt.Errorf("invalid index '%s'", index)
+	}
+	return m.indexChanged(indexSchema)
+}
+
+// indexChanged returns true if the values of the index differ between
+// Before and After.
+func (m *Change) indexChanged(indexSchema *IndexSchema) (bool, error) {
+	before, err := changeIndexValues(indexSchema, m.Before)
+	if err != nil {
+		return false, err
+	}
+	after, err := changeIndexValues(indexSchema, m.After)
+	if err != nil {
+		return false, err
+	}
+	return !sameIndexValues(before, after), nil
+}
+
+// changeIndexValues returns the sorted, distinct values of the index for an
+// object, which may be nil.
+func changeIndexValues(indexSchema *IndexSchema, obj interface{}) ([][]byte, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	ok, vals, err := indexValues(indexSchema, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build index '%s': %v", indexSchema.Name, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	sorted := append([][]byte(nil), vals...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	unique := sorted[:0]
+	for _, val := range sorted {
+		if len(unique) == 0 || !bytes.Equal(val, unique[len(unique)-1]) {
+			unique = append(unique, val)
+		}
+	}
+	return unique, nil
+}
@@ -0,0 +1,69 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChange_ChangedIndexes(t *testing.T) {
+	db := testDB(t)
+	schema := db.getSchema()
+	txn := db.Txn(true)
+	txn.TrackChanges()
+	objs := []*TestObject{
+		&TestObject{ID: "a", Foo: "x", Qux: []string{"q1", "q2"}},
+		&TestObject{ID: "b", Foo: "y", Qux: []string{"q1"}},
+	}
+	for _, obj := range objs {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(true)
+	txn.TrackChanges()
+	updates := []*TestObject{
+		// Only the order and repeats of the multi-value index change
+		&TestObject{ID: "a", Foo: "x", Qux: []string{"q2", "q1", "q2"}, Baz: "new"},
+		&TestObject{ID: "b", Foo: "z", Qux: []string{"q1", "q3"}},
+	}
+	for _, obj := range updates {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := txn.Insert("main", &TestObject{ID: "c", Foo: "c", Qux: []string{"c"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	changes := txn.Changes()
+	txn.Commit()
+
+	var got []string
+	for _, change := range changes {
+		indexes, err := change.ChangedIndexes(schema)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		got = append(got, fmt.Sprint(indexes))
+	}
+	if fmt.Sprint(got) != "[[] [foo qux] [foo id qux]]" {
+		t.Fatalf("bad: %v", got)
+	}
+
+	changed, err := changes[1].IndexChanged(schema, "foo")
+	if err != nil || !changed {
+		t.Fatalf("bad: %v %v", changed, err)
+	}
+	changed, err = changes[1].IndexChanged(schema, "id")
+	if err != nil || changed {
+		t.Fatalf("bad: %v %v", changed, err)
+	}
+	if _, err := changes[1].IndexChanged(schema, "nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+	bad := Change{Table: "nope", After: objs[0]}
+	if _, err := bad.ChangedIndexes(schema); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,29 @@
+package memdb
+
+import "time"
+
+// Clock is the source of time used by MemDB for anything time based, such
+// as the timestamps of recorded commits. The default clock uses the system
+// time, but tests and simulations can supply their own with WithClock in
+// order to control the passing of time.
+//
+// Clock 是 MemDB 的时间来源，测试中可以通过 WithClock 注入自定义实现。
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current
+	// time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the default Clock backed by the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
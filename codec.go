@@ -0,0 +1,92 @@
+package memdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Codec is used to serialize the objects of a table whenever they have to
+// leave the radix trees, for example to be written out or stored elsewhere.
+//
+// Codec 用于序列化表中的对象。
+type Codec interface {
+	// Encode serializes an object of the given table.
+	Encode(table string, obj interface{}) ([]byte, error)
+
+	// Decode deserializes data produced by Encode for the given table.
+	Decode(table string, data []byte) (interface{}, error)
+}
+
+// JSONCodec is a Codec using encoding/json.
+//
+// Types maps each table name to the type of the objects stored in it, for
+// example reflect.TypeOf(&Person{}). Decode returns values of that type.
+type JSONCodec struct {
+	Types map[string]reflect.Type
+}
+
+func (c *JSONCodec) Encode(table string, obj interface{}) ([]byte, error) {
+	return json.Marshal(obj)
+}
+
+func (c *JSONCodec) Decode(table string, data []byte) (interface{}, error) {
+	ptr, err := newCodecValue(c.Types, table)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return codecResult(c.Types[table], ptr), nil
+}
+
+// GobCodec is a Codec using encoding/gob.
+//
+// Types maps each table name to the type of the objects stored in it, for
+// example reflect.TypeOf(&Person{}). Decode returns values of that type.
+type GobCodec struct {
+	Types map[string]reflect.Type
+}
+
+func (c *GobCodec) Encode(table string, obj interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GobCodec) Decode(table string, data []byte) (interface{}, error) {
+	ptr, err := newCodecValue(c.Types, table)
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).DecodeValue(ptr); err != nil {
+		return nil, err
+	}
+	return codecResult(c.Types[table], ptr), nil
+}
+
+// newCodecValue allocates a value to decode an object of the given table
+// into. The returned value is always a pointer.
+func newCodecValue(types map[string]reflect.Type, table string) (reflect.Value, error) {
+	t, ok := types[table]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("no type registered for table '%s'", table)
+	}
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()), nil
+	}
+	return reflect.New(t), nil
+}
+
+// codecResult converts a decoded pointer back into the registered type.
+func codecResult(t reflect.Type, ptr reflect.Value) interface{} {
+	if t.Kind() == reflect.Ptr {
+		return ptr.Interface()
+	}
+	return ptr.Elem().Interface()
+}
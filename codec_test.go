@@ -0,0 +1,40 @@
+package memdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	types := map[string]reflect.Type{
+		"ptr":   reflect.TypeOf(&TestObject{}),
+		"value": reflect.TypeOf(TestObject{}),
+	}
+	codecs := map[string]Codec{
+		"json": &JSONCodec{Types: types},
+		"gob":  &GobCodec{Types: types},
+	}
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			obj := testObj()
+			for table, expected := range map[string]interface{}{"ptr": obj, "value": *obj} {
+				data, err := codec.Encode(table, expected)
+				if err != nil {
+					t.Fatalf("err: %v", err)
+				}
+				out, err := codec.Decode(table, data)
+				if err != nil {
+					t.Fatalf("err: %v", err)
+				}
+				if !reflect.DeepEqual(out, expected) {
+					t.Fatalf("bad: %#v %#v", out, expected)
+				}
+			}
+
+			if _, err := codec.Decode("nope", nil); err == nil {
+				t.Fatalf("expected error for unknown table")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package memdb
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram is a distribution of values, counted in buckets whose bounds are
+// powers of two.
+type Histogram struct {
+	Count uint64
+	Sum   uint64
+	Max   uint64
+
+	// Buckets holds the buckets that counted any value, by increasing
+	// bounds.
+	Buckets []HistogramBucket
+}
+
+// HistogramBucket counts the values of a Histogram between Min and Max,
+// inclusive.
+type HistogramBucket struct {
+	Min   uint64
+	Max   uint64
+	Count uint64
+}
+
+// CommitStats holds the distributions of the size and duration of the write
+// transactions committed since the DB was created. The CommitInfo of recent
+// commits, found in the CommitsTable, can be used to find which commits fall
+// in the largest buckets.
+//
+// CommitStats 描述提交的变更数量和耗时的分布。
+type CommitStats struct {
+	// Objects is the number of objects inserted, updated or deleted by
+	// each commit.
+	Objects Histogram
+
+	// Durations is how long each transaction held the writer lock, from
+	// its start until it was committed, in nanoseconds.
+	Durations Histogram
+}
+
+// CommitStats returns the distributions of the size and duration of the
+// commits made so far.
+func (db *MemDB) CommitStats() *CommitStats {
+	stats := &CommitStats{}
+	if h := db.commitHistograms; h != nil {
+		stats.Objects = h.objects.snapshot()
+		stats.Durations = h.durations.snapshot()
+	}
+	return stats
+}
+
+// commitHistograms are the histograms behind CommitStats.
+type commitHistograms struct {
+	objects   histogram
+	durations histogram
+}
+
+// observe records a commit.
+func (h *commitHistograms) observe(objects int, duration time.Duration) {
+	h.objects.observe(uint64(objects))
+	if duration < 0 {
+		duration = 0
+	}
+	h.durations.observe(uint64(duration))
+}
+
+// histogram is a Histogram that can be updated concurrently. A value v is
+// counted in the bucket bits.Len64(v), which holds the values with that
+// many significant bits.
+type histogram struct {
+	buckets [65]uint64
+	count   uint64
+	sum     uint64
+	max     uint64
+}
+
+// observe adds a value to the histogram.
+func (h *histogram) observe(v uint64) {
+	atomic.AddUint64(&h.buckets[bits.Len64(v)], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, v)
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if v <= max || atomic.CompareAndSwapUint64(&h.max, max, v) {
+			return
+		}
+	}
+}
+
+// snapshot returns the current state of the histogram.
+func (h *histogram) snapshot() Histogram {
+	out := Histogram{
+		Count: atomic.LoadUint64(&h.count),
+		Sum:   atomic.LoadUint64(&h.sum),
+		Max:   atomic.LoadUint64(&h.max),
+	}
+	for i := range h.buckets {
+		count := atomic.LoadUint64(&h.buckets[i])
+		if count == 0 {
+			continue
+		}
+		bucket := HistogramBucket{Count: count}
+		if i > 0 {
+			bucket.Min = 1 << uint(i-1)
+			bucket.Max = bucket.Min<<1 - 1
+		}
+		out.Buckets = append(out.Buckets, bucket)
+	}
+	return out
+}
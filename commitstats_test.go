@@ -0,0 +1,63 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemDB_CommitStats(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	db, err := NewMemDB(testValidSchema(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A small, quick commit and a large, slow one
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"q"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	txn = db.Txn(true)
+	for i := 0; i < 5; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("b%d", i), Foo: "x", Qux: []string{"q"}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	clock.Advance(3 * time.Millisecond)
+	txn.Commit()
+
+	stats := db.CommitStats()
+	expect := Histogram{
+		Count: 2,
+		Sum:   6,
+		Max:   5,
+		Buckets: []HistogramBucket{
+			{Min: 1, Max: 1, Count: 1},
+			{Min: 4, Max: 7, Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(stats.Objects, expect) {
+		t.Fatalf("bad: %#v", stats.Objects)
+	}
+	durations := stats.Durations
+	if durations.Count != 2 || durations.Max != uint64(3*time.Millisecond) || len(durations.Buckets) != 2 {
+		t.Fatalf("bad: %#v", durations)
+	}
+	if b := durations.Buckets[1]; b.Min > uint64(3*time.Millisecond) || b.Max < uint64(3*time.Millisecond) {
+		t.Fatalf("bad: %#v", b)
+	}
+
+	// The commit behind the largest values can be found
+	raw, err := db.Txn(false).Last(CommitsTable, "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	info := raw.(*CommitInfo)
+	if info.Objects != 5 || info.Duration != 3*time.Millisecond {
+		t.Fatalf("bad: %#v", info)
+	}
+}
@@ -0,0 +1,117 @@
+package memdb
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// CompressionSchema configures a table to store its objects serialized with
+// Codec and compressed with DEFLATE, rather than as they were inserted. The
+// objects are decoded again whenever they are read, which trades CPU for
+// memory, so it suits bulk tables that are rarely read. The most recently
+// decoded objects can be kept in a cache, so rows read repeatedly are only
+// decoded once; DecodeCacheStats reports how well it works.
+//
+// Index values are still computed from the full object when it is inserted.
+// Reads return a decoded copy of the object, which may be shared with other
+// readers while it is in the cache, so it must not be modified. Reads that
+// fail to decode an object return an error, or panic when they happen
+// while advancing a ResultIterator.
+//
+// CompressionSchema 将表中的对象序列化并压缩存储，读取时解码，以 CPU 换取内存。
+type CompressionSchema struct {
+	// Codec is used to encode and decode the objects of the table.
+	Codec Codec
+
+	// Level is the DEFLATE compression level, from flate.BestSpeed to
+	// flate.BestCompression. Zero means flate.DefaultCompression.
+	Level int
+
+	// CacheSize and CacheBytes bound the number of decoded objects kept in
+	// the cache, and the total size of their serialized form. Zero means no
+	// limit, and objects are decoded on every read if both are zero.
+	CacheSize  int
+	CacheBytes int
+
+	writers sync.Pool
+	cache   decodeCache
+}
+
+// Validate is used to validate the compression schema.
+func (s *CompressionSchema) Validate() error {
+	if s.Codec == nil {
+		return fmt.Errorf("missing compression codec")
+	}
+	if s.Level != 0 && (s.Level < flate.BestSpeed || s.Level > flate.BestCompression) {
+		return fmt.Errorf("invalid compression level %d", s.Level)
+	}
+	if s.CacheSize < 0 || s.CacheBytes < 0 {
+		return fmt.Errorf("compression cache size must not be negative")
+	}
+	return nil
+}
+
+// compressedRow is stored in the radix trees in place of the objects of
+// tables with a CompressionSchema.
+type compressedRow struct {
+	data []byte
+}
+
+// compress returns the compressed form of an object of the table.
+func (s *CompressionSchema) compress(table string, obj interface{}) (*compressedRow, error) {
+	data, err := s.Codec.Encode(table, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode object: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, _ := s.writers.Get().(*flate.Writer)
+	if w == nil {
+		level := s.Level
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		if w, err = flate.NewWriter(&buf, level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(&buf)
+	}
+	defer s.writers.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress object: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress object: %v", err)
+	}
+
+	// The buffer usually has spare capacity, which isn't kept
+	return &compressedRow{data: append([]byte(nil), buf.Bytes()...)}, nil
+}
+
+// decompress returns the object stored as the given row, from the cache if
+// it was decoded recently.
+func (s *CompressionSchema) decompress(table string, row *compressedRow) (interface{}, error) {
+	limits := s.cacheLimits()
+	if obj, ok := s.cache.get(row, limits); ok {
+		return obj, nil
+	}
+	data, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(row.data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress object: %v", err)
+	}
+	obj, err := s.Codec.Decode(table, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode object: %v", err)
+	}
+	s.cache.put(row, obj, len(data), limits)
+	return obj, nil
+}
+
+// cacheLimits returns the limits of the decode cache.
+func (s *CompressionSchema) cacheLimits() cacheLimits {
+	return cacheLimits{objects: s.CacheSize, bytes: s.CacheBytes}
+}
@@ -0,0 +1,189 @@
+package memdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testCompressedDB(t *testing.T, cacheSize int) *MemDB {
+	schema := testValidSchema()
+	schema.Tables["main"].Compression = &CompressionSchema{
+		Codec: &JSONCodec{
+			Types: map[string]reflect.Type{"main": reflect.TypeOf(&TestObject{})},
+		},
+		CacheSize: cacheSize,
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return db
+}
+
+func TestTxn_Compression(t *testing.T) {
+	db := testCompressedDB(t, 0)
+	payload := strings.Repeat("abcdefgh", 1000)
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b", "c"} {
+		obj := &TestObject{ID: id, Foo: id, Baz: payload, Qux: []string{id}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// The trees hold the compressed rows
+	txn = db.Txn(false)
+	raw, ok := txn.readableIndex("main", "id").Get([]byte("a\x00"))
+	if !ok {
+		t.Fatalf("missing row")
+	}
+	row, ok := raw.(*compressedRow)
+	if !ok || len(row.data) >= len(payload)/10 {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// Reads return the decoded objects
+	obj, err := txn.First("main", "id", "b")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := obj.(*TestObject); got.ID != "b" || got.Baz != payload {
+		t.Fatalf("bad: %#v", got)
+	}
+	iter, err := txn.Get("main", "qux", "c")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := iter.Next().(*TestObject); got.ID != "c" || got.Baz != payload {
+		t.Fatalf("bad: %#v", got)
+	}
+	again, err := txn.First("main", "id", "b")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if again == obj {
+		t.Fatalf("objects should be decoded on every read")
+	}
+
+	// Updates and deletes find the stored objects
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "z", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Delete("main", &TestObject{ID: "b"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	txn = db.Txn(false)
+	if obj, err := txn.First("main", "foo", "a"); err != nil || obj != nil {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+	if obj, err := txn.First("main", "foo", "z"); err != nil || obj.(*TestObject).ID != "a" {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+	if n, err := txn.Len("main"); err != nil || n != 2 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+}
+
+func TestTxn_Compression_Cache(t *testing.T) {
+	db := testCompressedDB(t, 1)
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: id, Qux: []string{id}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Repeated reads share the cached object, until it is evicted
+	txn = db.Txn(false)
+	first, err := txn.First("main", "id", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if obj, err := txn.First("main", "id", "a"); err != nil || obj != first {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+	if _, err := txn.First("main", "id", "b"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if obj, err := txn.First("main", "id", "a"); err != nil || obj == first {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+}
+
+func TestMemDB_DecodeCacheStats(t *testing.T) {
+	db := testCompressedDB(t, 2)
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: id, Qux: []string{id}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// The least recently used object is evicted
+	txn = db.Txn(false)
+	read := func(id string) interface{} {
+		t.Helper()
+		obj, err := txn.First("main", "id", id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return obj
+	}
+	a := read("a")
+	read("b")
+	if read("a") != a {
+		t.Fatalf("should be cached")
+	}
+	read("c")
+	if read("a") != a {
+		t.Fatalf("should be cached")
+	}
+	stats := db.DecodeCacheStats()
+	if len(stats) != 1 {
+		t.Fatalf("bad: %#v", stats)
+	}
+	s := stats[0]
+	if s.Table != "main" || s.Hits != 2 || s.Misses != 3 || s.Evictions != 1 || s.Objects != 2 || s.Bytes == 0 {
+		t.Fatalf("bad: %#v", s)
+	}
+
+	// The size of the objects is bounded too
+	compression := db.getSchema().Tables["main"].Compression
+	compression.CacheSize, compression.CacheBytes = 0, s.Bytes/2+1
+	read("b")
+	s = db.DecodeCacheStats()[0]
+	if s.Objects != 1 || s.Bytes > compression.CacheBytes || s.Evictions != 3 {
+		t.Fatalf("bad: %#v", s)
+	}
+
+	// Tables without a cache aren't reported
+	if stats := testCompressedDB(t, 0).DecodeCacheStats(); len(stats) != 0 {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
+
+func TestCompressionSchema_Validate(t *testing.T) {
+	codec := &JSONCodec{}
+	for _, s := range []*CompressionSchema{
+		&CompressionSchema{},
+		&CompressionSchema{Codec: codec, Level: 10},
+		&CompressionSchema{Codec: codec, CacheSize: -1},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Fatalf("expected error: %#v", s)
+		}
+	}
+
+	schema := testValidSchema()
+	schema.Tables["main"].Inline = true
+	schema.Tables["main"].Compression = &CompressionSchema{Codec: codec}
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
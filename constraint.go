@@ -0,0 +1,170 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// UniqueConstraintSchema declares that the values of a unique index in one
+// table may not appear in the matching unique index of any other table in
+// the constraint. For example a "hostname" may be required to be unique
+// across tables of physical and virtual nodes.
+//
+// The constraint is checked by Txn.Insert, which refuses objects that would
+// violate it. Since there is only a single writer, the check can't race with
+// other writes. A deferred constraint is checked when the transaction
+// commits instead.
+//
+// UniqueConstraintSchema 声明索引值在多个表之间必须唯一。
+type UniqueConstraintSchema struct {
+	// Name identifies the constraint in errors.
+	Name string
+
+	// Indexes maps each table of the constraint to the name of its index
+	// holding the values. The indexes must be unique.
+	Indexes map[string]string
+
+	// Deferred makes the constraint checked against the final state of the
+	// transaction when it commits, so a value can move from a table to
+	// another. TryCommit then fails if the constraint is violated.
+	Deferred bool
+}
+
+// Validate is used to validate the constraint against the schema of the
+// database.
+func (s *UniqueConstraintSchema) Validate(schema *DBSchema) error {
+	if s.Name == "" {
+		return fmt.Errorf("missing constraint name")
+	}
+	if len(s.Indexes) < 2 {
+		return fmt.Errorf("constraint must span at least two tables")
+	}
+	for table, index := range s.Indexes {
+		tableSchema, ok := schema.Tables[table]
+		if !ok {
+			return fmt.Errorf("invalid table '%s'", table)
+		}
+		indexSchema, ok := tableSchema.Indexes[index]
+		if !ok {
+			return fmt.Errorf("invalid index '%s' for table '%s'", index, table)
+		}
+		if !indexSchema.Unique {
+			return fmt.Errorf("index '%s' for table '%s' must be unique", index, table)
+		}
+	}
+	return nil
+}
+
+// checkUniqueConstraints returns an error if inserting obj into the table
+// would violate one of the unique constraints of the schema.
+func (txn *Txn) checkUniqueConstraints(table string, obj interface{}) error {
+	for _, constraint := range txn.schema.UniqueConstraints {
+		if _, ok := constraint.Indexes[table]; !ok {
+			continue
+		}
+		if constraint.Deferred {
+			if err := txn.deferObjectOf(txn.schema.Tables[table], obj); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := txn.checkUniqueConstraint(constraint, table, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUniqueConstraint returns an error if the values obj has for the index
+// of the table in the constraint are held by another table.
+func (txn *Txn) checkUniqueConstraint(constraint *UniqueConstraintSchema, table string, obj interface{}) error {
+	index := constraint.Indexes[table]
+	ok, vals, err := indexValues(txn.schema.Tables[table].Indexes[index], obj)
+	if err != nil {
+		return fmt.Errorf("failed to build index '%s': %v", index, err)
+	}
+	if !ok {
+		return nil
+	}
+
+	// Check the other tables in a stable order so errors are
+	// deterministic.
+	others := make([]string, 0, len(constraint.Indexes)-1)
+	for other := range constraint.Indexes {
+		if other != table {
+			others = append(others, other)
+		}
+	}
+	sort.Strings(others)
+
+	for _, other := range others {
+		indexTxn := txn.readableIndex(other, constraint.Indexes[other])
+		for _, val := range vals {
+			if _, exists := indexTxn.Get(val); exists {
+				txn.db.logger.Warn("unique constraint violated",
+					"constraint", constraint.Name, "table", table, "existing_table", other)
+				return fmt.Errorf("unique constraint '%s' violated: value already exists in table '%s'",
+					constraint.Name, other)
+			}
+		}
+	}
+	return nil
+}
+
+// checkUniqueIndexes returns an error if inserting obj into the table would
+// give one of its NullDistinct indexes a value held by another object.
+// Deferred indexes are checked at commit instead.
+func (txn *Txn) checkUniqueIndexes(tableSchema *TableSchema, obj interface{}, idVal []byte) error {
+	for name, indexSchema := range tableSchema.Indexes {
+		if !indexSchema.NullDistinct || indexSchema.Deferred {
+			continue
+		}
+
+		ok, vals, err := indexValues(indexSchema, obj)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		indexTxn := txn.readableIndex(tableSchema.Name, name)
+		for _, val := range vals {
+			raw, exists := indexTxn.Get(val)
+			if !exists {
+				continue
+			}
+			existing, err := txn.resolve(tableSchema.Name, raw)
+			if err != nil {
+				return err
+			}
+			_, existingID, err := tableSchema.Indexes[id].Indexer.(SingleIndexer).FromObject(existing)
+			if err != nil {
+				return fmt.Errorf("failed to build primary index: %v", err)
+			}
+			if !bytes.Equal(existingID, idVal) {
+				txn.db.logger.Warn("unique index violated", "table", tableSchema.Name, "index", name)
+				return fmt.Errorf("unique index '%s' violated: value already exists", name)
+			}
+		}
+	}
+	return nil
+}
+
+// indexValues returns the values an object has for the given index. Objects
+// failing the condition of a partial index have none.
+func indexValues(indexSchema *IndexSchema, obj interface{}) (bool, [][]byte, error) {
+	if !indexSchema.covers(obj) {
+		return false, nil, nil
+	}
+	switch indexer := indexSchema.Indexer.(type) {
+	case SingleIndexer:
+		ok, val, err := indexer.FromObject(obj)
+		return ok, [][]byte{val}, err
+	case MultiIndexer:
+		return indexer.FromObject(obj)
+	}
+	return false, nil, fmt.Errorf("indexer for '%s' is neither a SingleIndexer nor a MultiIndexer",
+		indexSchema.Name)
+}
@@ -0,0 +1,190 @@
+package memdb
+
+import (
+	"strings"
+	"testing"
+)
+
+type testNode struct {
+	ID       string
+	Hostname string
+}
+
+func testConstraintSchema() *DBSchema {
+	table := func(name string) *TableSchema {
+		return &TableSchema{
+			Name: name,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &StringFieldIndex{Field: "ID"},
+				},
+				"hostname": &IndexSchema{
+					Name:    "hostname",
+					Unique:  true,
+					Indexer: &StringFieldIndex{Field: "Hostname", Lowercase: true},
+				},
+			},
+		}
+	}
+	return &DBSchema{
+		Tables: map[string]*TableSchema{
+			"physical": table("physical"),
+			"virtual":  table("virtual"),
+		},
+		UniqueConstraints: []*UniqueConstraintSchema{
+			&UniqueConstraintSchema{
+				Name: "hostname",
+				Indexes: map[string]string{
+					"physical": "hostname",
+					"virtual":  "hostname",
+				},
+			},
+		},
+	}
+}
+
+func TestUniqueConstraintSchema_Validate(t *testing.T) {
+	schema := testConstraintSchema()
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	schema.UniqueConstraints[0].Indexes["virtual"] = "nope"
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, invalid index")
+	}
+
+	schema = testConstraintSchema()
+	schema.Tables["virtual"].Indexes["hostname"].Unique = false
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, index not unique")
+	}
+
+	schema = testConstraintSchema()
+	delete(schema.UniqueConstraints[0].Indexes, "virtual")
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, single table")
+	}
+}
+
+func TestTxn_UniqueConstraint(t *testing.T) {
+	db, err := NewMemDB(testConstraintSchema())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+	if err := txn.Insert("physical", &testNode{ID: "1", Hostname: "web"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Updates within the same table are fine
+	if err := txn.Insert("physical", &testNode{ID: "1", Hostname: "WEB"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	err = txn.Insert("virtual", &testNode{ID: "1", Hostname: "Web"})
+	if err == nil || !strings.Contains(err.Error(), "'physical'") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Once freed, the value can be used by the other table
+	if err := txn.Insert("physical", &testNode{ID: "1", Hostname: "db"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("virtual", &testNode{ID: "1", Hostname: "web"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestTxn_Insert_NullDistinct(t *testing.T) {
+	schema := testConstraintSchema()
+	schema.UniqueConstraints = nil
+	schema.Tables["physical"].Indexes["hostname"].NullDistinct = true
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, node := range []*testNode{
+		&testNode{ID: "1", Hostname: "a"},
+		&testNode{ID: "2"},
+		&testNode{ID: "3"},
+		&testNode{ID: "1", Hostname: "A"},
+	} {
+		if err := txn.Insert("physical", node); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Another object can't take the value, not even when changing case
+	err = txn.Insert("physical", &testNode{ID: "2", Hostname: "a"})
+	if err == nil || !strings.Contains(err.Error(), "unique index 'hostname' violated") {
+		t.Fatalf("bad: %v", err)
+	}
+	if raw, _ := txn.First("physical", "id", "2"); raw.(*testNode).Hostname != "" {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// Objects without a value aren't indexed
+	iter, err := txn.Get("physical", "hostname_prefix", "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw := iter.Next(); raw.(*testNode).ID != "1" || iter.Next() != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// The other table still lets later writes win
+	if err := txn.Insert("virtual", &testNode{ID: "1", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("virtual", &testNode{ID: "2", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Abort()
+
+	schema.Tables["physical"].Indexes["hostname"].Unique = false
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
+
+func TestCaseInsensitiveUniqueIndex(t *testing.T) {
+	schema := testConstraintSchema()
+	schema.UniqueConstraints = nil
+	schema.Tables["physical"].Indexes["hostname"] = CaseInsensitiveUniqueIndex("hostname", "Hostname")
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	if err := txn.Insert("physical", &testNode{ID: "1", Hostname: "Web-K"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("physical", &testNode{ID: "2", Hostname: "WEB-k"}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Lookups ignore case, and return the original value
+	raw, err := txn.First("physical", "hostname", "web-K")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw.(*testNode).Hostname != "Web-K" {
+		t.Fatalf("bad: %#v", raw)
+	}
+	raw, err = txn.First("physical", "hostname_prefix", "WEB")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw == nil || raw.(*testNode).ID != "1" {
+		t.Fatalf("bad: %#v", raw)
+	}
+	txn.Abort()
+}
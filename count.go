@@ -0,0 +1,40 @@
+package memdb
+
+import "fmt"
+
+// Count returns the number of index entries matching the given constraints
+// of an index, with the same arguments as Get. It walks the matching part
+// of the index without resolving the objects, and returns the size of the
+// whole index in constant time if no arguments are given.
+//
+// On a multi-value index, an object is counted once for each of its values
+// that matches.
+//
+// Count 统计索引中满足条件的条目数，无需遍历 ResultIterator 。
+func (txn *Txn) Count(table, index string, args ...interface{}) (int, error) {
+	indexSchema, val, err := txn.getIndexValue(table, index, args...)
+	if err != nil {
+		return 0, err
+	}
+	txn.trackPrefixRead(table, index, val)
+
+	indexTxn := txn.readableIndex(table, indexSchema.Name)
+	if len(val) == 0 {
+		return indexTxn.CommitOnly().Len(), nil
+	}
+	count := 0
+	indexTxn.Root().WalkPrefix(val, func(k []byte, v interface{}) bool {
+		count++
+		return false
+	})
+	return count, nil
+}
+
+// Len returns the number of objects in the table, in constant time.
+func (txn *Txn) Len(table string) (int, error) {
+	if _, ok := txn.tableSchema(table); !ok {
+		return 0, fmt.Errorf("invalid table '%s'", table)
+	}
+	txn.trackRead(table, id, nil, nil)
+	return txn.readableIndex(table, id).CommitOnly().Len(), nil
+}
@@ -0,0 +1,64 @@
+package memdb
+
+import "testing"
+
+func TestTxn_Count(t *testing.T) {
+	db := testDB(t)
+
+	txn := db.Txn(true)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "a", Foo: "x", Qux: []string{"1", "2"}},
+		&TestObject{ID: "b", Foo: "x", Qux: []string{"2"}},
+		&TestObject{ID: "c", Foo: "xy", Qux: []string{"3"}},
+	} {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(true)
+	defer txn.Abort()
+	cases := []struct {
+		index string
+		args  []interface{}
+		count int
+	}{
+		{"id", nil, 3},
+		{"id", []interface{}{"b"}, 1},
+		{"id", []interface{}{"d"}, 0},
+		{"foo", []interface{}{"x"}, 2},
+		{"foo_prefix", []interface{}{"x"}, 3},
+		{"qux", nil, 4},
+		{"qux", []interface{}{"2"}, 2},
+	}
+	for _, c := range cases {
+		count, err := txn.Count("main", c.index, c.args...)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if count != c.count {
+			t.Fatalf("bad: %s %v: %d", c.index, c.args, count)
+		}
+	}
+	if _, err := txn.Count("main", "nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Uncommitted writes are counted
+	if err := txn.Delete("main", &TestObject{ID: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if count, err := txn.Count("main", "foo", "x"); err != nil || count != 1 {
+		t.Fatalf("bad: %d %v", count, err)
+	}
+	if n, err := txn.Len("main"); err != nil || n != 2 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if n, err := db.Txn(false).Len("main"); err != nil || n != 3 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if _, err := txn.Len("nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
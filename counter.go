@@ -0,0 +1,87 @@
+package memdb
+
+import (
+	"fmt"
+	"math"
+)
+
+// Counter is an object of a counter table.
+type Counter struct {
+	Key   string
+	Value int64
+}
+
+// CounterTableSchema returns the schema for a counter table with the given
+// name, to be added to the DBSchema of the MemDB. A counter table holds
+// int64 values by key, which are changed with Txn.Increment and read with
+// Txn.CounterValue. Counters are regular *Counter objects, so their updates
+// show up in the changes of the transaction and in subscriptions.
+//
+// CounterTableSchema 返回计数器表的模式，计数器通过 Txn.Increment 原子地增减。
+func CounterTableSchema(name string) *TableSchema {
+	return &TableSchema{
+		Name: name,
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &StringFieldIndex{Field: "Key"},
+			},
+		},
+		Counter: true,
+	}
+}
+
+// validateCounter checks that a table marked as a counter table was built
+// by CounterTableSchema.
+func (s *TableSchema) validateCounter() error {
+	idSchema, ok := s.Indexes[id]
+	if !ok || len(s.Indexes) != 1 {
+		return fmt.Errorf("counter table must only have the id index")
+	}
+	if indexer, ok := idSchema.Indexer.(*StringFieldIndex); !ok || indexer.Field != "Key" {
+		return fmt.Errorf("counter table must be created with CounterTableSchema")
+	}
+	if s.Singleton || s.Set || s.LargeObjects != nil || s.Compression != nil {
+		return fmt.Errorf("counter table can't be a singleton, a set or store large or compressed objects")
+	}
+	return nil
+}
+
+// Increment adds delta, which may be negative, to the counter with the
+// given key and returns its new value. A counter that doesn't exist starts
+// at zero. The counter is read and written within the transaction, so
+// concurrent increments are never lost. An increment that would overflow
+// an int64 returns an error and leaves the counter unchanged.
+func (txn *Txn) Increment(table, key string, delta int64) (int64, error) {
+	value, err := txn.CounterValue(table, key)
+	if err != nil {
+		return 0, err
+	}
+	if (delta > 0 && value > math.MaxInt64-delta) ||
+		(delta < 0 && value < math.MinInt64-delta) {
+		return 0, fmt.Errorf("counter '%s' of table '%s' would overflow", key, table)
+	}
+	value += delta
+	if err := txn.Insert(table, &Counter{Key: key, Value: value}); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// CounterValue returns the value of the counter with the given key, or zero
+// if it doesn't exist.
+func (txn *Txn) CounterValue(table, key string) (int64, error) {
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return 0, fmt.Errorf("invalid table '%s'", table)
+	}
+	if !tableSchema.Counter {
+		return 0, fmt.Errorf("table '%s' is not a counter table", table)
+	}
+	raw, err := txn.First(table, id, key)
+	if err != nil || raw == nil {
+		return 0, err
+	}
+	return raw.(*Counter).Value, nil
+}
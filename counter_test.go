@@ -0,0 +1,55 @@
+package memdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTxn_Increment(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["hits"] = CounterTableSchema("hits")
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	txn.TrackChanges()
+	for _, delta := range []int64{5, 3, -2} {
+		if _, err := txn.Increment("hits", "home", delta); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if value, err := txn.Increment("hits", "about", 1); err != nil || value != 1 {
+		t.Fatalf("bad: %d %v", value, err)
+	}
+	if changes := txn.Changes(); len(changes) != 2 || !changes[0].Created() {
+		t.Fatalf("bad: %#v", changes)
+	}
+	txn.Commit()
+
+	txn = db.Txn(true)
+	if value, err := txn.CounterValue("hits", "home"); err != nil || value != 6 {
+		t.Fatalf("bad: %d %v", value, err)
+	}
+	if value, err := txn.CounterValue("hits", "nope"); err != nil || value != 0 {
+		t.Fatalf("bad: %d %v", value, err)
+	}
+
+	// Overflows leave the counter as it was
+	if _, err := txn.Increment("hits", "home", math.MaxInt64); err == nil {
+		t.Fatalf("expected error")
+	}
+	if value, err := txn.CounterValue("hits", "home"); err != nil || value != 6 {
+		t.Fatalf("bad: %d %v", value, err)
+	}
+	if _, err := txn.Increment("main", "home", 1); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	schema.Tables["hits"].Indexes["id"].Indexer = &StringFieldIndex{Field: "Name"}
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
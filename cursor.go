@@ -0,0 +1,234 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// cursorVersion is the version of the encoding of cursors.
+const cursorVersion = 1
+
+// Flags of encoded cursors.
+const (
+	cursorReverse = 1 << iota
+	cursorHasKey
+	cursorAfter
+)
+
+// CursorIterator is a ResultIterator whose position can be saved with
+// Cursor and resumed with Txn.GetFromCursor. The iterators returned by Get,
+// GetReverse, LowerBound, ReverseLowerBound and GetByPKPrefix implement it,
+// and so do the FilterIterator, LimitIterator and OffsetIterator wrapping
+// them.
+//
+// CursorIterator 是可以保存位置并在之后的事务中恢复迭代的 ResultIterator 。
+type CursorIterator interface {
+	ResultIterator
+
+	// Cursor returns an opaque token for the position of the iterator,
+	// right after the last result returned by Next, or at the start of the
+	// iteration if Next wasn't called yet.
+	Cursor() []byte
+}
+
+// cursorPos is the position of an iterator over an index, which is what a
+// cursor encodes.
+type cursorPos struct {
+	table string
+	index string
+
+	// prefix, if set, is the prefix of the keys iterated over.
+	prefix []byte
+
+	// key is the last key returned, or the bound iteration starts from,
+	// which is excluded if after is set. A nil key starts reverse
+	// iteration from the end.
+	key     []byte
+	after   bool
+	reverse bool
+}
+
+// advance moves the position past the key just returned.
+func (p *cursorPos) advance(key []byte) {
+	p.key, p.after = key, true
+}
+
+// matches returns true if the key is within the keys iterated over.
+func (p *cursorPos) matches(key []byte) bool {
+	return p.prefix == nil || bytes.HasPrefix(key, p.prefix)
+}
+
+// Cursor encodes the position.
+func (p *cursorPos) Cursor() []byte {
+	if p.table == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(cursorVersion)
+	var flags byte
+	if p.reverse {
+		flags |= cursorReverse
+	}
+	if p.key != nil {
+		flags |= cursorHasKey
+	}
+	if p.after {
+		flags |= cursorAfter
+	}
+	buf.WriteByte(flags)
+	writeBytes(&buf, []byte(p.table))
+	writeBytes(&buf, []byte(p.index))
+	writeBytes(&buf, p.prefix)
+	writeBytes(&buf, p.key)
+	return buf.Bytes()
+}
+
+// decodeCursor decodes a position encoded by Cursor.
+func decodeCursor(cursor []byte) (*cursorPos, error) {
+	r := bufio.NewReader(bytes.NewReader(cursor))
+	if version, err := r.ReadByte(); err != nil || version != cursorVersion {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var fields [4][]byte
+	for i := range fields {
+		if fields[i], err = readBytes(r); err != nil {
+			return nil, fmt.Errorf("invalid cursor: %v", err)
+		}
+	}
+	pos := &cursorPos{
+		table:   string(fields[0]),
+		index:   string(fields[1]),
+		prefix:  fields[2],
+		reverse: flags&cursorReverse != 0,
+		after:   flags&cursorAfter != 0,
+	}
+	if len(pos.prefix) == 0 {
+		pos.prefix = nil
+	}
+	if flags&cursorHasKey != 0 {
+		pos.key = fields[3]
+	}
+	return pos, nil
+}
+
+// GetFromCursor returns an iterator resuming the iteration whose position
+// was saved with CursorIterator.Cursor, possibly in another transaction. It
+// returns the results of the original query following the last one
+// returned before the cursor was taken, in the same order, as they are in
+// this transaction. The table and index must be the ones of the original
+// query.
+//
+// GetFromCursor 从游标保存的位置继续迭代。
+func (txn *Txn) GetFromCursor(table, index string, cursor []byte) (CursorIterator, error) {
+	pos, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	index = strings.TrimSuffix(index, "_prefix")
+	if pos.table != table || pos.index != index {
+		return nil, fmt.Errorf("cursor is for index '%s' of table '%s'", pos.index, pos.table)
+	}
+	if _, _, err := txn.getIndexValue(table, index); err != nil {
+		return nil, err
+	}
+	txn.trackPrefixRead(table, index, pos.prefix)
+
+	indexTxn := txn.readableIndex(table, index)
+	watchCh := indexTxn.Root().Iterator().SeekPrefixWatch(pos.prefix)
+	if txn.iterateLive(table) {
+		return txn.newLiveIterator(*pos, watchCh), nil
+	}
+	return &cursorIterator{
+		pos:     *pos,
+		next:    seekCursor(indexTxn.Root(), pos),
+		watchCh: watchCh,
+		resolve: txn.resolver(table),
+	}, nil
+}
+
+// seekCursor returns a function returning the entries of the index from the
+// given position on.
+func seekCursor(root *iradix.Node, pos *cursorPos) func() ([]byte, interface{}, bool) {
+	var next func() ([]byte, interface{}, bool)
+	if !pos.reverse {
+		iter := root.Iterator()
+		iter.SeekLowerBound(pos.key)
+		next = iter.Next
+	} else {
+		iter := root.ReverseIterator()
+		if pos.key != nil {
+			iter.SeekReverseLowerBound(pos.key)
+		}
+		next = iter.Previous
+	}
+	if !pos.after || pos.key == nil {
+		return next
+	}
+
+	skip := pos.key
+	return func() ([]byte, interface{}, bool) {
+		key, value, ok := next()
+		if skip != nil && ok && bytes.Equal(key, skip) {
+			key, value, ok = next()
+		}
+		skip = nil
+		return key, value, ok
+	}
+}
+
+// cursorIterator is the iterator returned by GetFromCursor.
+type cursorIterator struct {
+	pos  cursorPos
+	next func() ([]byte, interface{}, bool)
+	done bool
+
+	watchCh <-chan struct{}
+	resolve func(interface{}) (interface{}, error)
+	err     error
+}
+
+func (c *cursorIterator) WatchCh() <-chan struct{} {
+	return c.watchCh
+}
+
+func (c *cursorIterator) Next() interface{} {
+	if c.done {
+		return nil
+	}
+	key, value, ok := c.next()
+	if !ok || !c.pos.matches(key) {
+		c.done = true
+		return nil
+	}
+	c.pos.advance(key)
+	if c.resolve != nil {
+		obj, err := c.resolve(value)
+		if err != nil {
+			c.done, c.err = true, err
+			return nil
+		}
+		return obj
+	}
+	return value
+}
+
+func (c *cursorIterator) Cursor() []byte {
+	return c.pos.Cursor()
+}
+
+// iteratorCursor returns the cursor of an iterator, or nil if it doesn't
+// implement CursorIterator.
+func iteratorCursor(iter ResultIterator) []byte {
+	if c, ok := iter.(CursorIterator); ok {
+		return c.Cursor()
+	}
+	return nil
+}
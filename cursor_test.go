@@ -0,0 +1,105 @@
+package memdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTxn_GetFromCursor(t *testing.T) {
+	db := testDB(t)
+	insert := func(ids ...string) {
+		txn := db.Txn(true)
+		for _, id := range ids {
+			if err := txn.Insert("main", &TestObject{ID: id, Foo: "x", Qux: []string{"1"}}); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		txn.Insert("main", &TestObject{ID: "z" + ids[0], Foo: "y", Qux: []string{"1"}})
+		txn.Commit()
+	}
+	insert("a", "c", "e", "g")
+
+	// Reads pages of two results, resuming each in a new transaction
+	pages := func(first func(txn *Txn) (ResultIterator, error), between func()) []string {
+		var pages []string
+		var cursor []byte
+		for len(pages) < 10 {
+			txn := db.Txn(false)
+			var iter ResultIterator
+			var err error
+			if cursor == nil {
+				iter, err = first(txn)
+			} else {
+				iter, err = txn.GetFromCursor("main", "foo", cursor)
+			}
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			page := NewLimitIterator(iter, 2)
+			var ids []string
+			for raw := page.Next(); raw != nil; raw = page.Next() {
+				ids = append(ids, raw.(*TestObject).ID)
+			}
+			if len(ids) == 0 {
+				return pages
+			}
+			pages = append(pages, strings.Join(ids, ""))
+			cursor = page.Cursor()
+			if between != nil {
+				between()
+				between = nil
+			}
+		}
+		t.Fatalf("too many pages: %v", pages)
+		return nil
+	}
+
+	forward := pages(func(txn *Txn) (ResultIterator, error) {
+		return txn.Get("main", "foo", "x")
+	}, func() { insert("b", "d", "f") })
+	if strings.Join(forward, " ") != "ac de fg" {
+		t.Fatalf("bad: %v", forward)
+	}
+
+	reverse := pages(func(txn *Txn) (ResultIterator, error) {
+		return txn.GetReverse("main", "foo", "x")
+	}, nil)
+	if strings.Join(reverse, " ") != "gf ed cb a" {
+		t.Fatalf("bad: %v", reverse)
+	}
+
+	lower := pages(func(txn *Txn) (ResultIterator, error) {
+		return txn.LowerBound("main", "foo", "x")
+	}, nil)
+	if strings.Join(lower, " ") != "ab cd ef gza zb" {
+		t.Fatalf("bad: %v", lower)
+	}
+
+	// A cursor taken before any result starts over
+	iter, err := db.Txn(false).Get("main", "foo", "y")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resumed, err := db.Txn(false).GetFromCursor("main", "foo", iter.(CursorIterator).Cursor())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw := resumed.Next(); raw == nil || raw.(*TestObject).ID != "za" {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if raw := resumed.Next(); raw == nil || raw.(*TestObject).ID != "zb" {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if raw := resumed.Next(); raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// Cursors only resume the query they were taken from
+	cursor := resumed.Cursor()
+	if _, err := db.Txn(false).GetFromCursor("main", "id", cursor); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := db.Txn(false).GetFromCursor("main", "foo", []byte("nope")); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,141 @@
+package memdb
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+)
+
+// DecodeCacheStats reports the use of the cache of decoded objects of a
+// table with a CompressionSchema or a LargeObjectSchema.
+type DecodeCacheStats struct {
+	Table string
+
+	// Hits and Misses count the reads that found the decoded object in the
+	// cache and those that had to decode it. Evictions counts the objects
+	// dropped to make room for others.
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// Objects is the number of objects in the cache, and Bytes the total
+	// size of their encoded form.
+	Objects int
+	Bytes   int
+}
+
+// DecodeCacheStats returns the statistics of the decode caches of the
+// tables of the schema, sorted by table. Tables whose cache is disabled are
+// left out. The caches belong to the schema, so DBs sharing a schema share
+// them.
+//
+// DecodeCacheStats 返回各表解码缓存的命中、未命中和淘汰统计。
+func (db *MemDB) DecodeCacheStats() []DecodeCacheStats {
+	var stats []DecodeCacheStats
+	for table, tableSchema := range db.getSchema().Tables {
+		var cache *decodeCache
+		var limits cacheLimits
+		switch {
+		case tableSchema.Compression != nil:
+			cache, limits = &tableSchema.Compression.cache, tableSchema.Compression.cacheLimits()
+		case tableSchema.LargeObjects != nil:
+			cache, limits = &tableSchema.LargeObjects.cache, tableSchema.LargeObjects.cacheLimits()
+		}
+		if cache == nil || !limits.enabled() {
+			continue
+		}
+		s := cache.stats()
+		s.Table = table
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Table < stats[j].Table
+	})
+	return stats
+}
+
+// cacheLimits bounds the size of a decode cache. A zero field means no
+// limit, and the cache is disabled if both are zero.
+type cacheLimits struct {
+	objects int
+	bytes   int
+}
+
+// enabled returns true if objects may be cached.
+func (l cacheLimits) enabled() bool {
+	return l.objects > 0 || l.bytes > 0
+}
+
+// decodeCache is an LRU cache of the objects decoded from stored values,
+// keyed by the stored value. Stored values are never modified, so entries
+// don't need to be invalidated.
+type decodeCache struct {
+	lock    sync.Mutex
+	entries map[interface{}]*list.Element
+	lru     list.List
+	bytes   int
+
+	hits, misses, evictions uint64
+}
+
+// decodeEntry is an object of a decodeCache, along with the size of its
+// encoded form.
+type decodeEntry struct {
+	key  interface{}
+	obj  interface{}
+	size int
+}
+
+// get returns the object decoded from a stored value, if it is cached.
+func (c *decodeCache) get(key interface{}, limits cacheLimits) (interface{}, bool) {
+	if !limits.enabled() {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.hits++
+		return elem.Value.(*decodeEntry).obj, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// put caches the object decoded from a stored value, whose encoded form is
+// size bytes long, evicting the least recently used objects as needed.
+func (c *decodeCache) put(key, obj interface{}, size int, limits cacheLimits) {
+	if !limits.enabled() || (limits.bytes > 0 && size > limits.bytes) {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[interface{}]*list.Element)
+	}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&decodeEntry{key: key, obj: obj, size: size})
+	c.bytes += size
+	for (limits.objects > 0 && c.lru.Len() > limits.objects) ||
+		(limits.bytes > 0 && c.bytes > limits.bytes) {
+		entry := c.lru.Remove(c.lru.Back()).(*decodeEntry)
+		delete(c.entries, entry.key)
+		c.bytes -= entry.size
+		c.evictions++
+	}
+}
+
+// stats returns the statistics of the cache.
+func (c *decodeCache) stats() DecodeCacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return DecodeCacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Objects:   c.lru.Len(),
+		Bytes:     c.bytes,
+	}
+}
@@ -0,0 +1,223 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// deferredChecks holds what a write transaction must check at commit for
+// the constraints declared as deferred.
+type deferredChecks struct {
+	// objects are the objects whose deferred constraints are checked, by
+	// table and primary key. Objects that no longer exist are skipped.
+	objects map[deferredObject]struct{}
+
+	// keys are the parent keys that were removed while referenced by a
+	// deferred foreign key. They must not be referenced anymore, unless a
+	// parent holds them again.
+	keys []deferredKey
+}
+
+// deferredObject identifies an object by table and primary key.
+type deferredObject struct {
+	table string
+	key   string
+}
+
+// deferredKey is a parent key removed under a deferred foreign key.
+type deferredKey struct {
+	ref foreignKeyRef
+	key []byte
+}
+
+// deferObject schedules the check of the deferred constraints of the object
+// with the given primary key.
+func (txn *Txn) deferObject(table string, key []byte) {
+	if txn.deferred == nil {
+		txn.deferred = &deferredChecks{}
+	}
+	if txn.deferred.objects == nil {
+		txn.deferred.objects = make(map[deferredObject]struct{})
+	}
+	txn.deferred.objects[deferredObject{table, string(key)}] = struct{}{}
+}
+
+// deferObjectOf is like deferObject, but takes the object.
+func (txn *Txn) deferObjectOf(tableSchema *TableSchema, obj interface{}) error {
+	ok, key, err := tableSchema.Indexes[id].Indexer.(SingleIndexer).FromObject(obj)
+	if err != nil {
+		return fmt.Errorf("failed to build primary index: %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("object missing primary index")
+	}
+	txn.deferObject(tableSchema.Name, key)
+	return nil
+}
+
+// deferKeys schedules the check that the parent keys removed under a
+// deferred foreign key are no longer referenced.
+func (txn *Txn) deferKeys(ref foreignKeyRef, keys [][]byte) {
+	if txn.deferred == nil {
+		txn.deferred = &deferredChecks{}
+	}
+	for _, key := range keys {
+		txn.deferred.keys = append(txn.deferred.keys, deferredKey{ref, key})
+	}
+}
+
+// entryOwner returns the primary key of the object an entry of a unique
+// index points to, or nil if there is no entry.
+func (txn *Txn) entryOwner(table string, indexTxn *iradix.Txn, val []byte) ([]byte, error) {
+	raw, ok := indexTxn.Get(val)
+	if !ok {
+		return nil, nil
+	}
+	obj, err := txn.resolve(table, raw)
+	if err != nil {
+		return nil, err
+	}
+	_, key, err := txn.schema.Tables[table].Indexes[id].Indexer.(SingleIndexer).FromObject(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build primary index: %v", err)
+	}
+	return key, nil
+}
+
+// ownsEntry returns true if the entry of the index at val may be removed
+// for the object with the given primary key. Another object may have taken
+// over the entry of a deferred unique index, which must then be left to
+// it.
+func (txn *Txn) ownsEntry(table string, indexSchema *IndexSchema, indexTxn *iradix.Txn, val, key []byte) (bool, error) {
+	if !indexSchema.Deferred {
+		return true, nil
+	}
+	owner, err := txn.entryOwner(table, indexTxn, val)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(owner, key), nil
+}
+
+// deferDisplaced schedules the check of the objects whose entries of a
+// deferred unique index are about to be taken over by the object with the
+// given primary key.
+func (txn *Txn) deferDisplaced(table string, indexTxn *iradix.Txn, vals [][]byte, key []byte) error {
+	for _, val := range vals {
+		owner, err := txn.entryOwner(table, indexTxn, val)
+		if err != nil {
+			return err
+		}
+		if owner != nil && !bytes.Equal(owner, key) {
+			txn.deferObject(table, owner)
+		}
+	}
+	return nil
+}
+
+// checkDeferred checks the deferred constraints against the final state of
+// the transaction. Entries of deferred unique indexes that were removed by
+// an object that had taken them over are restored for the objects still
+// holding the value.
+func (txn *Txn) checkDeferred() error {
+	if txn.deferred == nil {
+		return nil
+	}
+
+	// Check the objects in a stable order so errors are deterministic
+	objects := make([]deferredObject, 0, len(txn.deferred.objects))
+	for object := range txn.deferred.objects {
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].table != objects[j].table {
+			return objects[i].table < objects[j].table
+		}
+		return objects[i].key < objects[j].key
+	})
+	for _, object := range objects {
+		if err := txn.checkDeferredObject(object); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range txn.deferred.keys {
+		if _, ok := txn.readableIndex(k.ref.fk.ParentTable, k.ref.fk.parentIndex()).Get(k.key); ok {
+			continue
+		}
+		children, err := txn.lookupKeys(k.ref.table, k.ref.fk.Index, [][]byte{k.key})
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return fmt.Errorf("foreign key '%s' of table '%s' violated: object is still referenced",
+				k.ref.fk.Index, k.ref.table)
+		}
+	}
+	return nil
+}
+
+// checkDeferredObject checks the deferred constraints of an object.
+func (txn *Txn) checkDeferredObject(object deferredObject) error {
+	tableSchema, ok := txn.schema.Tables[object.table]
+	if !ok {
+		return nil
+	}
+	raw, ok := txn.readableIndex(object.table, id).Get([]byte(object.key))
+	if !ok {
+		return nil
+	}
+	obj, err := txn.resolve(object.table, raw)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(tableSchema.Indexes))
+	for name, indexSchema := range tableSchema.Indexes {
+		if indexSchema.Deferred {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ok, vals, err := indexValues(tableSchema.Indexes[name], obj)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", name, err)
+		}
+		if !ok {
+			continue
+		}
+		for _, val := range vals {
+			owner, err := txn.entryOwner(object.table, txn.readableIndex(object.table, name), val)
+			if err != nil {
+				return err
+			}
+			switch {
+			case owner == nil:
+				txn.writableIndex(object.table, name).Insert(val, raw)
+				txn.countWrite(object.table, name, 1, 0)
+			case string(owner) != object.key:
+				return fmt.Errorf("unique index '%s' violated: value already exists", name)
+			}
+		}
+	}
+
+	for _, constraint := range txn.schema.UniqueConstraints {
+		if _, ok := constraint.Indexes[object.table]; ok && constraint.Deferred {
+			if err := txn.checkUniqueConstraint(constraint, object.table, obj); err != nil {
+				return err
+			}
+		}
+	}
+	for _, fk := range tableSchema.ForeignKeys {
+		if fk.Deferred || txn.deferReferences {
+			if err := txn.checkForeignKey(tableSchema, fk, obj, []interface{}{obj}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
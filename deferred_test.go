@@ -0,0 +1,216 @@
+package memdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTxn_DeferredUniqueIndex(t *testing.T) {
+	schema := testConstraintSchema()
+	schema.UniqueConstraints = nil
+	schema.Tables["physical"].Indexes["hostname"].NullDistinct = true
+	schema.Tables["physical"].Indexes["hostname"].Deferred = true
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, node := range []*testNode{
+		&testNode{ID: "1", Hostname: "a"},
+		&testNode{ID: "2", Hostname: "b"},
+		&testNode{ID: "3"},
+	} {
+		if err := txn.Insert("physical", node); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Two objects can swap their values
+	txn = db.Txn(true)
+	if err := txn.Insert("physical", &testNode{ID: "1", Hostname: "b"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("physical", &testNode{ID: "2", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn = db.Txn(false)
+	for _, expect := range []struct{ hostname, id string }{{"a", "2"}, {"b", "1"}} {
+		raw, err := txn.First("physical", "hostname", expect.hostname)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if raw == nil || raw.(*testNode).ID != expect.id {
+			t.Fatalf("bad: %#v", raw)
+		}
+	}
+
+	// A value still shared at commit fails it
+	txn = db.Txn(true)
+	if err := txn.Insert("physical", &testNode{ID: "3", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err = txn.TryCommit()
+	if err == nil || !strings.Contains(err.Error(), "unique index 'hostname' violated") {
+		t.Fatalf("bad: %v", err)
+	}
+	txn = db.Txn(false)
+	if raw, _ := txn.First("physical", "id", "3"); raw.(*testNode).Hostname != "" {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// Commit discards the changes and panics with the error, while Retry
+	// returns it
+	txn = db.Txn(true)
+	if err := txn.Insert("physical", &testNode{ID: "3", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	func() {
+		defer func() {
+			r := recover()
+			if err, ok := r.(error); !ok || !strings.Contains(err.Error(), "unique index 'hostname' violated") {
+				t.Fatalf("bad: %#v", r)
+			}
+		}()
+		txn.Commit()
+	}()
+	err = Retry(db, func(txn *Txn) error {
+		return txn.Insert("physical", &testNode{ID: "3", Hostname: "a"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "unique index 'hostname' violated") {
+		t.Fatalf("bad: %v", err)
+	}
+	txn = db.Txn(false)
+	if raw, _ := txn.First("physical", "id", "3"); raw.(*testNode).Hostname != "" {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// The object displaced gets its entry back if the other one is deleted
+	txn = db.Txn(true)
+	if err := txn.Insert("physical", &testNode{ID: "3", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Delete("physical", &testNode{ID: "3", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn = db.Txn(false)
+	raw, err := txn.First("physical", "hostname", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw == nil || raw.(*testNode).ID != "2" {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	schema.Tables["physical"].Indexes["hostname"].Unique = false
+	schema.Tables["physical"].Indexes["hostname"].NullDistinct = false
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
+
+func TestTxn_DeferredUniqueConstraint(t *testing.T) {
+	schema := testConstraintSchema()
+	schema.UniqueConstraints[0].Deferred = true
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	if err := txn.Insert("physical", &testNode{ID: "1", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// The value can move to the other table
+	txn = db.Txn(true)
+	if err := txn.Insert("virtual", &testNode{ID: "1", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Delete("physical", &testNode{ID: "1", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn = db.Txn(true)
+	if err := txn.Insert("physical", &testNode{ID: "2", Hostname: "A"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err = txn.TryCommit()
+	if err == nil || !strings.Contains(err.Error(), "unique constraint 'hostname' violated") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestTxn_DeferredForeignKey(t *testing.T) {
+	schema := testForeignKeySchema(Restrict)
+	schema.Tables["members"].ForeignKeys[0].Deferred = true
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A child can be inserted before its parent
+	txn := db.Txn(true)
+	if err := txn.Insert("members", &testMember{ID: "a", Team: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("teams", &testTeam{ID: "1", Name: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn = db.Txn(true)
+	if err := txn.Insert("members", &testMember{ID: "b", Team: "blue"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// A referenced parent can be deleted if it's replaced
+	txn = db.Txn(true)
+	if err := txn.Delete("teams", &testTeam{ID: "1", Name: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("teams", &testTeam{ID: "2", Name: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Or if its children are moved away
+	txn = db.Txn(true)
+	if err := txn.Insert("teams", &testTeam{ID: "2", Name: "blue"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("members", &testMember{ID: "a", Team: "blue"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn = db.Txn(true)
+	if err := txn.Delete("teams", &testTeam{ID: "2", Name: "blue"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err = txn.TryCommit()
+	if err == nil || !strings.Contains(err.Error(), "still referenced") {
+		t.Fatalf("bad: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package memdb
+
+import "fmt"
+
+// WithStrictDeprecation makes queries against deprecated indexes fail with an
+// error instead of logging a warning, to flush out the last users of an
+// index before it is dropped.
+func WithStrictDeprecation() Option {
+	return func(db *MemDB) {
+		db.strictDeprecation = true
+	}
+}
+
+// queryDeprecated reports a query against a deprecated index. It returns an
+// error in strict mode.
+func (txn *Txn) queryDeprecated(table, index string) error {
+	if txn.db.strictDeprecation {
+		return fmt.Errorf("index '%s' of table '%s' is deprecated", index, table)
+	}
+	txn.db.logger.Warn("query on deprecated index", "table", table, "index", index)
+	return nil
+}
+
+// DropIndex removes a deprecated index from the schema and frees its radix
+// tree, which stops the index from being maintained on writes. The index
+// must have been marked as Deprecated first, for instance with ReloadSchema,
+// and must not be used by the rest of the schema, such as by a cascade or an
+// aggregate. Transactions started before the drop can still query the index.
+//
+// DropIndex 删除已废弃的索引并回收其内存。
+func (db *MemDB) DropIndex(table, index string) error {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+	indexSchema, ok := tableSchema.Indexes[index]
+	if !ok {
+		return fmt.Errorf("invalid index '%s'", index)
+	}
+	if !indexSchema.Deprecated {
+		return fmt.Errorf("index '%s' must be deprecated before it is dropped", index)
+	}
+
+	// Copy the parts of the schema that change, as transactions may still
+	// be using the current one.
+	newTable := *tableSchema
+	newTable.Indexes = make(map[string]*IndexSchema, len(tableSchema.Indexes)-1)
+	for name, indexSchema := range tableSchema.Indexes {
+		if name != index {
+			newTable.Indexes[name] = indexSchema
+		}
+	}
+	schema := *txn.schema
+	schema.Tables = make(map[string]*TableSchema, len(txn.schema.Tables))
+	for name, tableSchema := range txn.schema.Tables {
+		schema.Tables[name] = tableSchema
+	}
+	schema.Tables[table] = &newTable
+	if err := schema.Validate(); err != nil {
+		return fmt.Errorf("index '%s' can't be dropped: %v", index, err)
+	}
+
+	// The trees of inline tables are generated per index as needed, so
+	// there is nothing to remove for them.
+	if !tableSchema.Inline {
+		txn.rootTxn.Delete(indexPath(table, index))
+	}
+	txn.schema = &schema
+	if err := txn.TryCommit(); err != nil {
+		return err
+	}
+	db.logger.Info("dropped index", "table", table, "index", index)
+	return nil
+}
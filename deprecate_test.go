@@ -0,0 +1,83 @@
+package memdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemDB_DropIndex(t *testing.T) {
+	logger := &testLogger{}
+	db, err := NewMemDB(testValidSchema(), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// Only deprecated indexes can be dropped
+	if err := db.DropIndex("main", "foo"); err == nil {
+		t.Fatalf("expected error")
+	}
+	schema := testValidSchema()
+	schema.Tables["main"].Indexes["foo"].Deprecated = true
+	if err := db.ReloadSchema(schema); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Queries still work, but log a warning
+	logger.lines = nil
+	before := db.Txn(false)
+	if raw, err := before.First("main", "foo", "x"); err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], "WARN query on deprecated index") {
+		t.Fatalf("bad: %#v", logger.lines)
+	}
+
+	if err := db.DropIndex("main", "foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := db.Txn(false).First("main", "foo", "x"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, ok := db.getRoot().Get(indexPath("main", "foo")); ok {
+		t.Fatalf("index tree should be freed")
+	}
+
+	// Writes no longer maintain the index, and older transactions can
+	// still read it
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "b", Foo: "x", Qux: []string{"2"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if raw, err := before.First("main", "foo", "x"); err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// The id index can't be deprecated
+	schema = testValidSchema()
+	schema.Tables["main"].Indexes["id"].Deprecated = true
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestMemDB_StrictDeprecation(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["main"].Indexes["qux"].Deprecated = true
+	db, err := NewMemDB(schema, WithStrictDeprecation())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(false)
+	if _, err := txn.Get("main", "qux", "1"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := txn.Get("main", "id"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
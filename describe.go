@@ -0,0 +1,295 @@
+package memdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SchemaDescription is a machine-readable description of a DBSchema, as
+// returned by DBSchema.Describe. It only holds plain data, so it can be
+// marshaled to JSON for documentation generators and admin tools. Tables,
+// indexes and the other items are sorted by name.
+//
+// SchemaDescription 是模式的可序列化描述，可用于生成文档或在管理界面中展示。
+type SchemaDescription struct {
+	Tables            []*TableDescription            `json:"tables"`
+	UniqueConstraints []*UniqueConstraintDescription `json:"unique_constraints,omitempty"`
+	Relations         []*RelationDescription         `json:"relations,omitempty"`
+	Aggregates        []*AggregateDescription        `json:"aggregates,omitempty"`
+}
+
+// TableDescription describes a table of the schema.
+type TableDescription struct {
+	Name         string                   `json:"name"`
+	Indexes      []*IndexDescription      `json:"indexes"`
+	Cascades     []*CascadeDescription    `json:"cascades,omitempty"`
+	ForeignKeys  []*ForeignKeyDescription `json:"foreign_keys,omitempty"`
+	Inline       bool                     `json:"inline,omitempty"`
+	Singleton    bool                     `json:"singleton,omitempty"`
+	Set          bool                     `json:"set,omitempty"`
+	Counter      bool                     `json:"counter,omitempty"`
+	Log          bool                     `json:"log,omitempty"`
+	LargeObjects bool                     `json:"large_objects,omitempty"`
+	Compressed   bool                     `json:"compressed,omitempty"`
+	TTLField     string                   `json:"ttl_field,omitempty"`
+}
+
+// IndexDescription describes an index of a table.
+type IndexDescription struct {
+	Name         string              `json:"name"`
+	Unique       bool                `json:"unique,omitempty"`
+	NullDistinct bool                `json:"null_distinct,omitempty"`
+	AllowMissing bool                `json:"allow_missing,omitempty"`
+	Deprecated   bool                `json:"deprecated,omitempty"`
+	Indexer      *IndexerDescription `json:"indexer"`
+}
+
+// IndexerDescription describes the Indexer of an index. Kind is the name of
+// the indexer's type, such as "StringFieldIndex", and Field is the field it
+// reads, if any. Indexers wrapping other indexers, such as a CompoundIndex,
+// list them in Indexers.
+type IndexerDescription struct {
+	Kind     string                `json:"kind"`
+	Field    string                `json:"field,omitempty"`
+	Multi    bool                  `json:"multi,omitempty"`
+	Prefix   bool                  `json:"prefix,omitempty"`
+	Indexers []*IndexerDescription `json:"indexers,omitempty"`
+}
+
+// CascadeDescription describes a cascade from a parent table to a child.
+type CascadeDescription struct {
+	Index      string `json:"index"`
+	ChildTable string `json:"child_table"`
+	ChildIndex string `json:"child_index"`
+}
+
+// ForeignKeyDescription describes a foreign key of a table. OnDelete is
+// "restrict", "cascade" or "set null".
+type ForeignKeyDescription struct {
+	Index       string `json:"index"`
+	ParentTable string `json:"parent_table"`
+	ParentIndex string `json:"parent_index"`
+	OnDelete    string `json:"on_delete"`
+}
+
+// UniqueConstraintDescription describes a unique constraint spanning tables.
+type UniqueConstraintDescription struct {
+	Name    string            `json:"name"`
+	Indexes map[string]string `json:"indexes"`
+}
+
+// RelationDescription describes a relation between tables.
+type RelationDescription struct {
+	Name      string `json:"name"`
+	From      string `json:"from"`
+	FromIndex string `json:"from_index"`
+	To        string `json:"to"`
+	ToIndex   string `json:"to_index"`
+}
+
+// AggregateDescription describes an aggregate maintained over a table.
+type AggregateDescription struct {
+	Name  string `json:"name"`
+	Table string `json:"table"`
+	Index string `json:"index,omitempty"`
+	Op    string `json:"op"`
+	Field string `json:"field,omitempty"`
+}
+
+// Describe returns a description of the schema. The schema is expected to
+// be valid.
+func (s *DBSchema) Describe() *SchemaDescription {
+	desc := &SchemaDescription{}
+
+	tables := make([]string, 0, len(s.Tables))
+	for name := range s.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	for _, name := range tables {
+		desc.Tables = append(desc.Tables, s.Tables[name].describe())
+	}
+
+	for _, constraint := range s.UniqueConstraints {
+		indexes := make(map[string]string, len(constraint.Indexes))
+		for table, index := range constraint.Indexes {
+			indexes[table] = index
+		}
+		desc.UniqueConstraints = append(desc.UniqueConstraints, &UniqueConstraintDescription{
+			Name:    constraint.Name,
+			Indexes: indexes,
+		})
+	}
+	sort.Slice(desc.UniqueConstraints, func(i, j int) bool {
+		return desc.UniqueConstraints[i].Name < desc.UniqueConstraints[j].Name
+	})
+
+	for _, relation := range s.Relations {
+		desc.Relations = append(desc.Relations, &RelationDescription{
+			Name:      relation.Name,
+			From:      relation.From,
+			FromIndex: relation.FromIndex,
+			To:        relation.To,
+			ToIndex:   relation.ToIndex,
+		})
+	}
+	sort.Slice(desc.Relations, func(i, j int) bool {
+		return desc.Relations[i].Name < desc.Relations[j].Name
+	})
+
+	for _, aggregate := range s.Aggregates {
+		op := "count"
+		if aggregate.Op == AggregateMax {
+			op = "max"
+		}
+		desc.Aggregates = append(desc.Aggregates, &AggregateDescription{
+			Name:  aggregate.Name,
+			Table: aggregate.Table,
+			Index: aggregate.Index,
+			Op:    op,
+			Field: aggregate.Field,
+		})
+	}
+	sort.Slice(desc.Aggregates, func(i, j int) bool {
+		return desc.Aggregates[i].Name < desc.Aggregates[j].Name
+	})
+
+	return desc
+}
+
+// describe returns a description of the table.
+func (s *TableSchema) describe() *TableDescription {
+	desc := &TableDescription{
+		Name:         s.Name,
+		Inline:       s.Inline,
+		Singleton:    s.Singleton,
+		Set:          s.Set,
+		Counter:      s.Counter,
+		Log:          s.Log != nil,
+		LargeObjects: s.LargeObjects != nil,
+		Compressed:   s.Compression != nil,
+	}
+	if s.TTL != nil {
+		desc.TTLField = s.TTL.Field
+	}
+
+	indexes := make([]string, 0, len(s.Indexes))
+	for name := range s.Indexes {
+		indexes = append(indexes, name)
+	}
+	sort.Strings(indexes)
+	for _, name := range indexes {
+		index := s.Indexes[name]
+		desc.Indexes = append(desc.Indexes, &IndexDescription{
+			Name:         index.Name,
+			Unique:       index.Unique,
+			NullDistinct: index.NullDistinct,
+			AllowMissing: index.AllowMissing,
+			Deprecated:   index.Deprecated,
+			Indexer:      describeIndexer(index.Indexer),
+		})
+	}
+
+	for _, cascade := range s.Cascades {
+		desc.Cascades = append(desc.Cascades, &CascadeDescription{
+			Index:      cascade.Index,
+			ChildTable: cascade.ChildTable,
+			ChildIndex: cascade.ChildIndex,
+		})
+	}
+	for _, fk := range s.ForeignKeys {
+		desc.ForeignKeys = append(desc.ForeignKeys, &ForeignKeyDescription{
+			Index:       fk.Index,
+			ParentTable: fk.ParentTable,
+			ParentIndex: fk.parentIndex(),
+			OnDelete:    fk.OnDelete.String(),
+		})
+	}
+	return desc
+}
+
+// describeIndexer returns a description of the indexer. Since indexers can
+// be user defined, their Field and wrapped indexers are found by reflection.
+func describeIndexer(indexer Indexer) *IndexerDescription {
+	desc := &IndexerDescription{Kind: "Indexer"}
+	if indexer == nil {
+		return desc
+	}
+	_, desc.Multi = indexer.(MultiIndexer)
+	_, desc.Prefix = indexer.(PrefixIndexer)
+
+	v := reflect.Indirect(reflect.ValueOf(indexer))
+	if name := v.Type().Name(); name != "" {
+		desc.Kind = name
+	}
+	if v.Kind() != reflect.Struct {
+		return desc
+	}
+
+	if field := v.FieldByName("Field"); field.IsValid() && field.Kind() == reflect.String {
+		desc.Field = field.String()
+	}
+	indexerType := reflect.TypeOf((*Indexer)(nil)).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		switch field := v.Field(i); {
+		case field.Type() == indexerType && !field.IsNil():
+			desc.Indexers = append(desc.Indexers, describeIndexer(field.Interface().(Indexer)))
+		case field.Kind() == reflect.Slice && field.Type().Elem() == indexerType:
+			for j := 0; j < field.Len(); j++ {
+				sub, _ := field.Index(j).Interface().(Indexer)
+				desc.Indexers = append(desc.Indexers, describeIndexer(sub))
+			}
+		}
+	}
+	return desc
+}
+
+// JSON returns the description encoded as indented JSON.
+func (d *SchemaDescription) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// Mermaid returns the description as a Mermaid entity relationship diagram.
+// Each table is an entity listing its indexes, with the indexer kind as the
+// type and the field as a comment. Unique indexes are marked PK for the id
+// index and UK otherwise. Cascades and relations are drawn as edges between
+// the tables.
+//
+// Mermaid 以 Mermaid ER 图的形式输出模式描述。
+func (d *SchemaDescription) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("erDiagram\n")
+	for _, table := range d.Tables {
+		fmt.Fprintf(&b, "    %s {\n", table.Name)
+		for _, index := range table.Indexes {
+			fmt.Fprintf(&b, "        %s %s", index.Indexer.Kind, index.Name)
+			switch {
+			case index.Name == id:
+				b.WriteString(" PK")
+			case index.Unique:
+				b.WriteString(" UK")
+			}
+			if index.Indexer.Field != "" {
+				fmt.Fprintf(&b, " %q", index.Indexer.Field)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("    }\n")
+	}
+	for _, table := range d.Tables {
+		for _, cascade := range table.Cascades {
+			fmt.Fprintf(&b, "    %s ||--o{ %s : %q\n", table.Name, cascade.ChildTable,
+				cascade.Index+" to "+cascade.ChildIndex)
+		}
+	}
+	for _, relation := range d.Relations {
+		fmt.Fprintf(&b, "    %s }o--o{ %s : %q\n", relation.From, relation.To, relation.Name)
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package memdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDBSchema_Describe(t *testing.T) {
+	schema := testCascadeSchema()
+	schema.Tables["members"].Indexes["team_id"] = &IndexSchema{
+		Name:   "team_id",
+		Unique: true,
+		Indexer: &CompoundIndex{
+			Indexes: []Indexer{
+				&StringFieldIndex{Field: "Team"},
+				&StringFieldIndex{Field: "ID"},
+			},
+		},
+	}
+	schema.Relations = map[string]*RelationSchema{
+		"team": &RelationSchema{Name: "team", From: "members", FromIndex: "team", To: "teams", ToIndex: "name"},
+	}
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	desc := schema.Describe()
+	if len(desc.Tables) != 2 || desc.Tables[0].Name != "members" || desc.Tables[1].Name != "teams" {
+		t.Fatalf("bad: %#v", desc.Tables)
+	}
+	expect := &IndexerDescription{
+		Kind:   "CompoundIndex",
+		Prefix: true,
+		Indexers: []*IndexerDescription{
+			&IndexerDescription{Kind: "StringFieldIndex", Field: "Team", Prefix: true},
+			&IndexerDescription{Kind: "StringFieldIndex", Field: "ID", Prefix: true},
+		},
+	}
+	if index := desc.Tables[0].Indexes[2]; index.Name != "team_id" || !index.Unique || !reflect.DeepEqual(index.Indexer, expect) {
+		t.Fatalf("bad: %#v", index)
+	}
+	if cascades := desc.Tables[1].Cascades; len(cascades) != 1 || cascades[0].ChildTable != "members" {
+		t.Fatalf("bad: %#v", cascades)
+	}
+
+	// The JSON form round trips
+	data, err := desc.JSON()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var decoded SchemaDescription
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, desc) {
+		t.Fatalf("bad: %s", data)
+	}
+
+	mermaid := `erDiagram
+    members {
+        StringFieldIndex id PK "ID"
+        StringFieldIndex team "Team"
+        CompoundIndex team_id UK
+    }
+    teams {
+        StringFieldIndex id PK "ID"
+        StringFieldIndex name UK "Name"
+    }
+    teams ||--o{ members : "name to team"
+    members }o--o{ teams : "team"
+`
+	if out := desc.Mermaid(); out != mermaid {
+		t.Fatalf("bad: %s", out)
+	}
+}
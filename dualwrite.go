@@ -0,0 +1,423 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// DualWriteFunc returns the object to store in the new table of a DualWrite
+// for an object of the old table. It must not modify obj, and must return
+// equal objects for equal inputs, as Verify compares its results with the
+// rows of the new table.
+type DualWriteFunc func(obj interface{}) (interface{}, error)
+
+// DualWrite moves the rows of a table to a new one, such as a table with
+// restructured indexes, while both stay in use. Once it is started, every
+// commit that writes to the old table writes the rows it inserted, updated
+// or deleted to the new table as well, converted by its DualWriteFunc, in
+// the same commit. Backfill copies the rows written before, in batches, and
+// Cutover checks that both tables hold the same rows before retiring the
+// old table. A typical migration adds the new table with ReloadSchema,
+// starts the dual write, backfills until done, moves the readers and
+// writers to the new table, cuts over, and finally drops the old table with
+// ApplySchemaChange.
+//
+// Both tables must be in the schema for as long as the dual write is in
+// progress. Commits that the new table rejects, for instance because of a
+// unique index, fail with the error. Transactions started with TxnTables
+// lock the new table along with the old one.
+//
+// DualWrite 在迁移期间将旧表的写入同步写入新表，并支持回填与校验后的切换。
+type DualWrite struct {
+	db   *MemDB
+	from string
+	to   string
+	fn   DualWriteFunc
+
+	// cursor is where the next Backfill resumes. lock serializes the
+	// calls to Backfill.
+	cursor []byte
+	lock   sync.Mutex
+}
+
+// dualWriteState holds the dual writes in progress by old table, and the
+// tables retired by a cutover along with the table that replaced them. It
+// is replaced rather than modified, so transactions can keep the one they
+// started with.
+type dualWriteState struct {
+	active  map[string]*DualWrite
+	retired map[string]string
+}
+
+// mirrors returns true if any dual write is in progress.
+func (s *dualWriteState) mirrors() bool {
+	return s != nil && len(s.active) > 0
+}
+
+// mirrorTo returns the table the writes to the given table are copied to,
+// if any.
+func (s *dualWriteState) mirrorTo(table string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	d, ok := s.active[table]
+	if !ok {
+		return "", false
+	}
+	return d.to, true
+}
+
+// retiredTo returns the table that replaced the given table at a cutover,
+// if it was retired.
+func (s *dualWriteState) retiredTo(table string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	to, ok := s.retired[table]
+	return to, ok
+}
+
+// getDualWrites returns the current dual writes, or nil if there were
+// never any.
+func (db *MemDB) getDualWrites() *dualWriteState {
+	db.dualWriteLock.Lock()
+	defer db.dualWriteLock.Unlock()
+	return db.dualWrites
+}
+
+// updateDualWrites replaces the dual writes with a copy modified by fn. It
+// must be called with the writer lock held, so no write transaction runs
+// with the previous state.
+func (db *MemDB) updateDualWrites(fn func(s *dualWriteState)) {
+	db.dualWriteLock.Lock()
+	defer db.dualWriteLock.Unlock()
+	s := &dualWriteState{
+		active:  make(map[string]*DualWrite),
+		retired: make(map[string]string),
+	}
+	if db.dualWrites != nil {
+		for table, d := range db.dualWrites.active {
+			s.active[table] = d
+		}
+		for table, to := range db.dualWrites.retired {
+			s.retired[table] = to
+		}
+	}
+	fn(s)
+	db.dualWrites = s
+}
+
+// forgetRetired lets the tables retired by a cutover be written again once
+// the schema no longer has them, so they can be added back.
+func (db *MemDB) forgetRetired(schema *DBSchema) {
+	s := db.getDualWrites()
+	if s == nil {
+		return
+	}
+	var dropped []string
+	for table := range s.retired {
+		if _, ok := schema.Tables[table]; !ok {
+			dropped = append(dropped, table)
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	db.writer.Lock()
+	defer db.writer.Unlock()
+	db.updateDualWrites(func(s *dualWriteState) {
+		for _, table := range dropped {
+			delete(s.retired, table)
+		}
+	})
+}
+
+// StartDualWrite starts copying the writes to the table from to the table
+// to, converted by fn. It waits for the write transactions in progress,
+// which don't dual-write, to end. A table can only be the old or the new
+// table of a single dual write at once.
+//
+// StartDualWrite 开始将对 from 表的写入同步写入 to 表。
+func (db *MemDB) StartDualWrite(from, to string, fn DualWriteFunc) (*DualWrite, error) {
+	if fn == nil {
+		return nil, fmt.Errorf("missing dual write function")
+	}
+	if from == to {
+		return nil, fmt.Errorf("cannot dual-write table '%s' to itself", from)
+	}
+	db.writer.Lock()
+	defer db.writer.Unlock()
+
+	schema := db.getSchema()
+	s := db.getDualWrites()
+	for _, table := range []string{from, to} {
+		if isSystemTable(table) {
+			return nil, fmt.Errorf("cannot dual-write system table '%s'", table)
+		}
+		if _, ok := schema.Tables[table]; !ok {
+			return nil, fmt.Errorf("invalid table '%s'", table)
+		}
+		if replaced, ok := s.retiredTo(table); ok {
+			return nil, fmt.Errorf("table '%s' was migrated to '%s'", table, replaced)
+		}
+		if _, ok := s.mirrorTo(table); ok {
+			return nil, fmt.Errorf("table '%s' is already dual-written", table)
+		}
+		if s != nil {
+			for _, d := range s.active {
+				if d.to == table {
+					return nil, fmt.Errorf("table '%s' is already dual-written to", table)
+				}
+			}
+		}
+	}
+
+	d := &DualWrite{db: db, from: from, to: to, fn: fn}
+	db.updateDualWrites(func(s *dualWriteState) {
+		s.active[from] = d
+	})
+	db.logger.Info("started dual write", "from", from, "to", to)
+	return d, nil
+}
+
+// checkActive returns an error unless the dual write is in progress for the
+// transaction.
+func (d *DualWrite) checkActive(txn *Txn) error {
+	if s := txn.dualWrites; s == nil || s.active[d.from] != d {
+		return fmt.Errorf("dual write from table '%s' to '%s' is not in progress", d.from, d.to)
+	}
+	return nil
+}
+
+// Backfill copies up to batch rows of the old table to the new one, in a
+// write transaction of its own, and returns true once all of them were
+// copied. Each call resumes after the rows copied by the previous one, and
+// the rows written meanwhile are dual-written, so the tables match once it
+// returns true. Rows that the new table already holds as they are converted
+// aren't written again.
+func (d *DualWrite) Backfill(batch int) (bool, error) {
+	if batch <= 0 {
+		return false, fmt.Errorf("invalid batch size %d", batch)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	if err := d.checkActive(txn); err != nil {
+		return false, err
+	}
+
+	var iter CursorIterator
+	if d.cursor == nil {
+		all, err := txn.Get(d.from, id)
+		if err != nil {
+			return false, err
+		}
+		iter = all.(CursorIterator)
+	} else {
+		var err error
+		if iter, err = txn.GetFromCursor(d.from, id, d.cursor); err != nil {
+			return false, err
+		}
+	}
+
+	done := false
+	for i := 0; i < batch && !done; i++ {
+		obj := iter.Next()
+		if obj == nil {
+			if err := IteratorErr(iter); err != nil {
+				return false, err
+			}
+			done = true
+			break
+		}
+		if err := d.copyRow(txn, obj); err != nil {
+			return false, err
+		}
+	}
+	cursor := iter.Cursor()
+	if !done {
+		done = iter.Next() == nil
+	}
+	if err := txn.TryCommit(); err != nil {
+		return false, err
+	}
+	d.cursor = cursor
+	return done, nil
+}
+
+// copyRow writes the converted copy of a row of the old table to the new
+// one, unless it is already there.
+func (d *DualWrite) copyRow(txn *Txn, obj interface{}) error {
+	converted, existing, err := d.lookup(txn, obj)
+	if err != nil {
+		return err
+	}
+	if existing != nil && reflect.DeepEqual(existing, converted) {
+		return nil
+	}
+	return txn.insert(d.to, converted)
+}
+
+// lookup converts a row of the old table, and returns the row of the new
+// table with the same primary key, if any.
+func (d *DualWrite) lookup(txn *Txn, obj interface{}) (converted, existing interface{}, err error) {
+	converted, key, err := d.convert(txn, obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, ok := txn.readableIndex(d.to, id).Get(key)
+	if !ok {
+		return converted, nil, nil
+	}
+	existing, err = txn.resolve(d.to, raw)
+	return converted, existing, err
+}
+
+// convert returns the copy of a row of the old table for the new one, along
+// with its primary key.
+func (d *DualWrite) convert(txn *Txn, obj interface{}) (interface{}, []byte, error) {
+	converted, err := d.fn(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	if converted == nil {
+		return nil, nil, fmt.Errorf("dual write function returned no object")
+	}
+	indexer := txn.schema.Tables[d.to].Indexes[id].Indexer.(SingleIndexer)
+	ok, key, err := indexer.FromObject(converted)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build primary index: %v", err)
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("object missing primary index")
+	}
+	return converted, key, nil
+}
+
+// mirror writes to the new table the change of a row of the old one.
+func (d *DualWrite) mirror(txn *Txn, change Change) error {
+	var oldKey []byte
+	if change.Before != nil {
+		var err error
+		if _, oldKey, err = d.convert(txn, change.Before); err != nil {
+			return err
+		}
+	}
+	if change.After == nil {
+		return txn.deleteByKey(d.to, oldKey)
+	}
+
+	converted, key, err := d.convert(txn, change.After)
+	if err != nil {
+		return err
+	}
+	if oldKey != nil && string(oldKey) != string(key) {
+		if err := txn.deleteByKey(d.to, oldKey); err != nil {
+			return err
+		}
+	}
+	return txn.insert(d.to, converted)
+}
+
+// mirrorDualWrites copies to the new tables of the dual writes in progress
+// the changes the transaction made to their old tables, as part of its
+// commit. It also refuses the changes to retired tables made by
+// transactions that started before their cutover.
+func (txn *Txn) mirrorDualWrites() error {
+	s := txn.dualWrites
+	if s == nil || txn.changes == nil {
+		return nil
+	}
+	for _, change := range txn.Changes() {
+		if to, ok := s.retiredTo(change.Table); ok {
+			return fmt.Errorf("table '%s' was migrated to '%s'", change.Table, to)
+		}
+		d, ok := s.active[change.Table]
+		if !ok {
+			continue
+		}
+		if err := d.mirror(txn, change); err != nil {
+			return fmt.Errorf("failed to dual-write to table '%s': %v", d.to, err)
+		}
+	}
+	return nil
+}
+
+// Verify checks that the new table holds the converted copy of every row of
+// the old table and nothing else, as of the latest commit. It returns an
+// error describing the first difference found.
+func (d *DualWrite) Verify() error {
+	return d.verify(d.db.Txn(false))
+}
+
+// verify does the work of Verify within the given transaction.
+func (d *DualWrite) verify(txn *Txn) error {
+	iter, err := txn.Get(d.from, id)
+	if err != nil {
+		return err
+	}
+	rows := 0
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		converted, existing, err := d.lookup(txn, obj)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return fmt.Errorf("table '%s' is missing a row of table '%s': %#v", d.to, d.from, converted)
+		}
+		if !reflect.DeepEqual(existing, converted) {
+			return fmt.Errorf("row of table '%s' doesn't match table '%s': %#v", d.to, d.from, existing)
+		}
+		rows++
+	}
+	if err := IteratorErr(iter); err != nil {
+		return err
+	}
+	size, err := txn.Len(d.to)
+	if err != nil {
+		return err
+	}
+	if size != rows {
+		return fmt.Errorf("table '%s' has %d rows, not the %d of table '%s'", d.to, size, rows, d.from)
+	}
+	return nil
+}
+
+// Cutover verifies the tables as Verify does, with the writer lock held so
+// no commit runs meanwhile, and ends the dual write if they match. The old
+// table is then retired: writes to it fail, and so do the commits of
+// transactions that wrote to it before, until it is dropped from the
+// schema. Readers and writers should have moved to the new table first.
+func (d *DualWrite) Cutover() error {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	if err := d.checkActive(txn); err != nil {
+		return err
+	}
+	if err := d.verify(txn); err != nil {
+		d.db.logger.Warn("dual write cutover failed", "from", d.from, "to", d.to, "error", err)
+		return err
+	}
+	d.db.updateDualWrites(func(s *dualWriteState) {
+		delete(s.active, d.from)
+		s.retired[d.from] = d.to
+	})
+	d.db.logger.Info("cut over dual write", "from", d.from, "to", d.to)
+	return nil
+}
+
+// Stop ends the dual write without a cutover, leaving both tables as they
+// are. It does nothing if the dual write already ended.
+func (d *DualWrite) Stop() {
+	d.db.writer.Lock()
+	defer d.db.writer.Unlock()
+	if s := d.db.getDualWrites(); s == nil || s.active[d.from] != d {
+		return
+	}
+	d.db.updateDualWrites(func(s *dualWriteState) {
+		delete(s.active, d.from)
+	})
+}
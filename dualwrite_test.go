@@ -0,0 +1,176 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDualWrite(t *testing.T) {
+	db := testDB(t)
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: id, Qux: []string{id}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// The new table indexes the objects by Baz instead
+	schema := testValidSchema()
+	schema.Tables["main2"] = &TableSchema{
+		Name: "main2",
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &StringFieldIndex{Field: "ID"},
+			},
+			"baz": &IndexSchema{
+				Name:    "baz",
+				Indexer: &StringFieldIndex{Field: "Baz"},
+			},
+		},
+	}
+	if err := db.ReloadSchema(schema); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	convert := func(obj interface{}) (interface{}, error) {
+		copy := *obj.(*TestObject)
+		copy.Baz = "v2-" + copy.Foo
+		return &copy, nil
+	}
+
+	if _, err := db.StartDualWrite("main", "main", convert); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := db.StartDualWrite("main", "nope", convert); err == nil {
+		t.Fatalf("expected error")
+	}
+	d, err := db.StartDualWrite("main", "main2", convert)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := db.StartDualWrite("main", "main2", convert); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Writes to the old table are copied to the new one
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn.DeleteAll("main", "id", "b"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", &TestObject{ID: "d", Foo: "d", Qux: []string{"d"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	check := func(ids ...string) {
+		t.Helper()
+		txn := db.Txn(false)
+		iter, err := txn.Get("main2", "id")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var got []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			obj := raw.(*TestObject)
+			got = append(got, obj.ID+"="+obj.Baz)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Fatalf("bad: %v", got)
+		}
+	}
+	check("a=v2-x", "d=v2-d")
+
+	// The tables don't match until the backfill is done
+	if err := d.Verify(); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := d.Cutover(); err == nil {
+		t.Fatalf("expected error")
+	}
+	batches := 0
+	for done := false; !done; batches++ {
+		if done, err = d.Backfill(1); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if batches != 3 {
+		t.Fatalf("bad: %d", batches)
+	}
+	check("a=v2-x", "c=v2-c", "d=v2-d")
+	if err := d.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Transactions locking the old table lock the new one too
+	txn, err = db.TxnTables("main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", &TestObject{ID: "e", Foo: "e", Qux: []string{"e"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	check("a=v2-x", "c=v2-c", "d=v2-d", "e=v2-e")
+
+	// Rows changed behind the dual write are caught by the cutover
+	txn = db.Txn(true)
+	if err := txn.Insert("main2", &TestObject{ID: "c", Baz: "stale"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if err := d.Cutover(); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := d.Backfill(10); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn = db.Txn(true)
+	if err := txn.Insert("main2", &TestObject{ID: "c", Baz: "v2-c", Foo: "c", Qux: []string{"c"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// A transaction writing to the old table before the cutover can't
+	// commit after it
+	late := db.TxnOptimistic()
+	if err := late.Insert("main", &TestObject{ID: "f", Foo: "f", Qux: []string{"f"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := d.Cutover(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := late.TryCommit(); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "g", Foo: "g", Qux: []string{"g"}}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+	if _, err := d.Backfill(10); err == nil {
+		t.Fatalf("expected error")
+	}
+	check("a=v2-x", "c=v2-c", "d=v2-d", "e=v2-e")
+
+	// The old table can be written again once it was dropped and added
+	// back
+	delete(schema.Tables, "main")
+	if err := db.ApplySchemaChange(schema); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	schema.Tables["main"] = testValidSchema().Tables["main"]
+	if err := db.ReloadSchema(schema); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "g", Foo: "g", Qux: []string{"g"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+}
@@ -0,0 +1,311 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// exportMagic starts every table export.
+const exportMagic = "MEMDBEX1"
+
+// ExportTable writes the table in a compact read-only format that can be
+// opened with OpenTableExport, typically by another process that maps the
+// file into memory to share a large reference dataset. Rows are encoded
+// with the codec and laid out next to a sorted array of the keys of every
+// index, so the export can be queried in place: opening it only reads a
+// small directory, and rows are only decoded if the reader asks for it.
+//
+// The export holds big-endian integers and byte strings:
+//
+//	magic "MEMDBEX1"
+//	table name length, table name
+//	row count R, index count I
+//	R+1 row offsets into the row data
+//	I times: index name length, index name, entry count E,
+//	         E+1 key offsets into the keys, E row numbers, keys
+//	row data
+//
+// ExportTable 将表导出为紧凑的只读格式，另一个进程可以通过 mmap 直接查询而无需反序列化。
+func (txn *Txn) ExportTable(table string, codec Codec, w io.Writer) error {
+	tableSchema, ok := txn.tableSchema(table)
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+	idIndexer := tableSchema.Indexes[id].Indexer.(SingleIndexer)
+
+	txn.trackRead(table, id, nil, nil)
+
+	// Number the rows in primary key order
+	rowOf := make(map[string]uint64)
+	var rowData bytes.Buffer
+	rowOffsets := []uint64{0}
+	iter := txn.readableIndex(table, id).Root().Iterator()
+	for key, raw, ok := iter.Next(); ok; key, raw, ok = iter.Next() {
+		obj, err := txn.resolve(table, raw)
+		if err != nil {
+			return err
+		}
+		data, err := codec.Encode(table, obj)
+		if err != nil {
+			return fmt.Errorf("failed to encode object: %v", err)
+		}
+		rowOf[string(key)] = uint64(len(rowOffsets) - 1)
+		rowData.Write(data)
+		rowOffsets = append(rowOffsets, uint64(rowData.Len()))
+	}
+
+	buf := bufio.NewWriterSize(w, streamChunkSize)
+	out := &exportWriter{w: buf}
+	out.bytes([]byte(exportMagic))
+	out.string(table)
+	out.uint(uint64(len(rowOffsets) - 1))
+	out.uint(uint64(len(tableSchema.Indexes)))
+	for _, offset := range rowOffsets {
+		out.uint(offset)
+	}
+
+	indexes := make([]string, 0, len(tableSchema.Indexes))
+	for name := range tableSchema.Indexes {
+		indexes = append(indexes, name)
+	}
+	sort.Strings(indexes)
+	for _, name := range indexes {
+		var keys bytes.Buffer
+		keyOffsets := []uint64{0}
+		var rows []uint64
+		iter := txn.readableIndex(table, name).Root().Iterator()
+		for key, raw, ok := iter.Next(); ok; key, raw, ok = iter.Next() {
+			obj, err := txn.resolve(table, raw)
+			if err != nil {
+				return err
+			}
+			_, idVal, err := idIndexer.FromObject(obj)
+			if err != nil {
+				return fmt.Errorf("failed to build primary index: %v", err)
+			}
+			keys.Write(key)
+			keyOffsets = append(keyOffsets, uint64(keys.Len()))
+			rows = append(rows, rowOf[string(idVal)])
+		}
+
+		out.string(name)
+		out.uint(uint64(len(rows)))
+		for _, offset := range keyOffsets {
+			out.uint(offset)
+		}
+		for _, row := range rows {
+			out.uint(row)
+		}
+		out.bytes(keys.Bytes())
+	}
+	out.bytes(rowData.Bytes())
+
+	if out.err != nil {
+		return out.err
+	}
+	return buf.Flush()
+}
+
+// exportWriter writes the fields of an export, keeping the first error.
+type exportWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *exportWriter) bytes(data []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(data)
+	}
+}
+
+func (e *exportWriter) uint(val uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], val)
+	e.bytes(buf[:])
+}
+
+func (e *exportWriter) string(val string) {
+	e.uint(uint64(len(val)))
+	e.bytes([]byte(val))
+}
+
+// TableExport is a table export written by Txn.ExportTable, opened for
+// queries. It never copies the underlying data, so it can be backed by a
+// memory mapped file, and it is safe for concurrent use.
+type TableExport struct {
+	schema     *TableSchema
+	rows       int
+	rowOffsets []byte
+	rowData    []byte
+	indexes    map[string]*exportIndex
+}
+
+// exportIndex is an index of a table export.
+type exportIndex struct {
+	entries    int
+	keyOffsets []byte
+	rows       []byte
+	keys       []byte
+}
+
+// OpenTableExport opens a table export held in data, such as a file mapped
+// into memory with syscall.Mmap. The schema of the table is needed to turn
+// query arguments into index keys, so it must match the one the export was
+// written with. The data must not be modified while the export is in use.
+func OpenTableExport(data []byte, schema *TableSchema) (*TableExport, error) {
+	r := &exportReader{data: data}
+	if magic := r.bytes(len(exportMagic)); string(magic) != exportMagic {
+		return nil, fmt.Errorf("not a table export")
+	}
+	if table := r.string(); r.err == nil && table != schema.Name {
+		return nil, fmt.Errorf("export of table '%s' can't be opened as table '%s'", table, schema.Name)
+	}
+
+	e := &TableExport{
+		schema:  schema,
+		rows:    r.count(),
+		indexes: make(map[string]*exportIndex),
+	}
+	numIndexes := r.count()
+	e.rowOffsets = r.bytes(8 * (e.rows + 1))
+	for i := 0; i < numIndexes && r.err == nil; i++ {
+		name := r.string()
+		index := &exportIndex{entries: r.count()}
+		index.keyOffsets = r.bytes(8 * (index.entries + 1))
+		index.rows = r.bytes(8 * index.entries)
+		if r.err != nil {
+			break
+		}
+		index.keys = r.bytes(int(exportUint(index.keyOffsets, index.entries)))
+		e.indexes[name] = index
+	}
+	if r.err == nil && e.rows > 0 {
+		e.rowData = r.bytes(int(exportUint(e.rowOffsets, e.rows)))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return e, nil
+}
+
+// exportReader reads the fields of an export, keeping the first error.
+type exportReader struct {
+	data []byte
+	err  error
+}
+
+func (r *exportReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = fmt.Errorf("table export is truncated")
+		return nil
+	}
+	out := r.data[:n:n]
+	r.data = r.data[n:]
+	return out
+}
+
+func (r *exportReader) uint() uint64 {
+	buf := r.bytes(8)
+	if buf == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
+
+// count reads a number of items, each taking at least a byte.
+func (r *exportReader) count() int {
+	n := r.uint()
+	if r.err == nil && n > uint64(len(r.data)) {
+		r.err = fmt.Errorf("table export is truncated")
+		return 0
+	}
+	return int(n)
+}
+
+func (r *exportReader) string() string {
+	return string(r.bytes(r.count()))
+}
+
+// exportUint returns the i-th integer of an array of the export.
+func exportUint(array []byte, i int) uint64 {
+	return binary.BigEndian.Uint64(array[8*i:])
+}
+
+// Len returns the number of rows in the export.
+func (e *TableExport) Len() int {
+	return e.rows
+}
+
+// Row returns the encoded row with the given number. Rows are numbered
+// from zero in primary key order, and i must be less than Len.
+func (e *TableExport) Row(i int) []byte {
+	start, end := exportUint(e.rowOffsets, i), exportUint(e.rowOffsets, i+1)
+	if start > end || end > uint64(len(e.rowData)) {
+		return nil
+	}
+	return e.rowData[start:end:end]
+}
+
+// First returns the first encoded row matching the arguments on the index,
+// or nil if there is none. The index and arguments work as for Txn.First.
+func (e *TableExport) First(index string, args ...interface{}) ([]byte, error) {
+	iter, err := e.Get(index, args...)
+	if err != nil {
+		return nil, err
+	}
+	return iter.Next(), nil
+}
+
+// Get returns an iterator over the encoded rows matching the arguments on
+// the index. The index and arguments work as for Txn.Get.
+func (e *TableExport) Get(index string, args ...interface{}) (*ExportIterator, error) {
+	indexSchema, val, err := indexValue(e.schema, index, args...)
+	if err != nil {
+		return nil, err
+	}
+	exported, ok := e.indexes[indexSchema.Name]
+	if !ok {
+		return nil, fmt.Errorf("index '%s' is missing from the export", indexSchema.Name)
+	}
+	start := sort.Search(exported.entries, func(i int) bool {
+		return bytes.Compare(exported.key(i), val) >= 0
+	})
+	return &ExportIterator{export: e, index: exported, prefix: val, next: start}, nil
+}
+
+// key returns the i-th key of the index.
+func (x *exportIndex) key(i int) []byte {
+	start, end := exportUint(x.keyOffsets, i), exportUint(x.keyOffsets, i+1)
+	if start > end || end > uint64(len(x.keys)) {
+		return nil
+	}
+	return x.keys[start:end:end]
+}
+
+// ExportIterator iterates over the rows of a TableExport matching a query.
+type ExportIterator struct {
+	export *TableExport
+	index  *exportIndex
+	prefix []byte
+	next   int
+}
+
+// Next returns the next encoded row, or nil once there are no more.
+func (it *ExportIterator) Next() []byte {
+	if it.next >= it.index.entries || !bytes.HasPrefix(it.index.key(it.next), it.prefix) {
+		return nil
+	}
+	row := exportUint(it.index.rows, it.next)
+	it.next++
+	if row >= uint64(it.export.rows) {
+		return nil
+	}
+	return it.export.Row(int(row))
+}
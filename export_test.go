@@ -0,0 +1,113 @@
+package memdb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTxn_ExportTable(t *testing.T) {
+	db := testDB(t)
+	codec := &JSONCodec{
+		Types: map[string]reflect.Type{"main": reflect.TypeOf(&TestObject{})},
+	}
+
+	txn := db.Txn(true)
+	objs := []*TestObject{
+		&TestObject{ID: "a", Foo: "x", Qux: []string{"1", "2"}},
+		&TestObject{ID: "ab", Foo: "y", Qux: []string{"2"}},
+		&TestObject{ID: "b", Foo: "x", Qux: []string{"3"}},
+	}
+	for _, obj := range objs {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	var buf bytes.Buffer
+	txn = db.Txn(false)
+	if err := txn.ExportTable("main", codec, &buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.ExportTable("nope", codec, &buf); err == nil {
+		t.Fatalf("expected error for invalid table")
+	}
+
+	schema := db.getSchema().Tables["main"]
+	export, err := OpenTableExport(buf.Bytes(), schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if export.Len() != 3 {
+		t.Fatalf("bad: %d", export.Len())
+	}
+	decode := func(data []byte) *TestObject {
+		if data == nil {
+			return nil
+		}
+		obj, err := codec.Decode("main", data)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return obj.(*TestObject)
+	}
+	for i, obj := range objs {
+		if out := decode(export.Row(i)); !reflect.DeepEqual(out, obj) {
+			t.Fatalf("bad: %d: %#v", i, out)
+		}
+	}
+
+	// Exact lookups match the whole value, prefix ones don't
+	raw, err := export.First("id", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out := decode(raw); out == nil || out.ID != "a" {
+		t.Fatalf("bad: %#v", out)
+	}
+	if raw, err := export.First("id", "c"); err != nil || raw != nil {
+		t.Fatalf("bad: %q %v", raw, err)
+	}
+	ids := func(index string, args ...interface{}) []string {
+		iter, err := export.Get(index, args...)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var out []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			out = append(out, decode(raw).ID)
+		}
+		return out
+	}
+	if out := ids("id", "a"); !reflect.DeepEqual(out, []string{"a"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids("id_prefix", "a"); !reflect.DeepEqual(out, []string{"a", "ab"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids("foo", "x"); !reflect.DeepEqual(out, []string{"a", "b"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids("qux", "2"); !reflect.DeepEqual(out, []string{"a", "ab"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids("id"); len(out) != 3 {
+		t.Fatalf("bad: %#v", out)
+	}
+	if _, err := export.Get("nope", "a"); err == nil {
+		t.Fatalf("expected error for invalid index")
+	}
+
+	// Corrupted exports are rejected
+	data := buf.Bytes()
+	for _, bad := range [][]byte{nil, data[:len(data)-1], append([]byte("MEMDBEX0"), data[8:]...)} {
+		if _, err := OpenTableExport(bad, schema); err == nil {
+			t.Fatalf("expected error for corrupted export")
+		}
+	}
+	other := &TableSchema{Name: "other", Indexes: schema.Indexes}
+	if _, err := OpenTableExport(data, other); err == nil {
+		t.Fatalf("expected error for mismatched table")
+	}
+}
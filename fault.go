@@ -0,0 +1,49 @@
+package memdb
+
+// FaultPoint is a place where faults can be injected with
+// WithFaultInjection.
+type FaultPoint string
+
+const (
+	// FaultCommit is the start of the commit of every write transaction,
+	// including those the DB runs itself. An error aborts the transaction,
+	// as described for TryCommit.
+	FaultCommit FaultPoint = "commit"
+
+	// FaultIndex is the computation of the index values of an object by
+	// Insert, InsertBatch and Delete, before anything is written. An error
+	// is returned by the write, and leaves the transaction as it was.
+	FaultIndex FaultPoint = "index"
+
+	// FaultSnapshot is the save of each table to a snapshot, and the start
+	// of the restore of a snapshot, with an empty table. An error makes the
+	// save or restore fail.
+	FaultSnapshot FaultPoint = "snapshot"
+)
+
+// FaultFunc is called when the DB reaches a FaultPoint for the given table,
+// or an empty table at the start of commits and restores. It can sleep to
+// add latency, and return an error to make the operation fail. It is called
+// from the goroutine running the operation, sometimes with the writer lock
+// held.
+type FaultFunc func(point FaultPoint, table string) error
+
+// WithFaultInjection registers a function injecting faults at every
+// FaultPoint, so that tests can check how an application behaves when the
+// DB is slow or fails. It is meant for tests only.
+//
+// WithFaultInjection 注册故障注入函数，用于在测试中模拟延迟或错误。
+func WithFaultInjection(fn FaultFunc) Option {
+	return func(db *MemDB) {
+		db.faultFn = fn
+	}
+}
+
+// injectFault calls the function registered with WithFaultInjection, if
+// any.
+func (db *MemDB) injectFault(point FaultPoint, table string) error {
+	if db.faultFn == nil {
+		return nil
+	}
+	return db.faultFn(point, table)
+}
@@ -0,0 +1,93 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemDB_FaultInjection(t *testing.T) {
+	var fail FaultPoint
+	var seen []string
+	fn := func(point FaultPoint, table string) error {
+		seen = append(seen, string(point)+":"+table)
+		if point == fail {
+			return fmt.Errorf("injected")
+		}
+		return nil
+	}
+	db, _ := testSnapshotDB(t, WithCodec(testCodec()), WithFaultInjection(fn))
+	seen = nil
+
+	// Failing index extraction leaves the transaction usable
+	fail = FaultIndex
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "c", Foo: "c", Qux: []string{"c"}}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := txn.Delete("main", &TestObject{ID: "a"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	fail = ""
+	if err := txn.Insert("main", &TestObject{ID: "d", Foo: "d", Qux: []string{"d"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Failing commits abort the transaction
+	fail = FaultCommit
+	if err := txn.TryCommit(); err == nil {
+		t.Fatalf("expected error")
+	}
+	fail = ""
+	if obj, err := db.Txn(false).First("main", "id", "d"); err != nil || obj != nil {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+
+	// Failing snapshots fail the save and restore
+	fail = FaultSnapshot
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(&buf); err == nil {
+		t.Fatalf("expected error")
+	}
+	fail = ""
+	if err := db.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fail = FaultSnapshot
+	if _, err := RestoreSnapshot(bytes.NewReader(buf.Bytes()), testValidSchema(),
+		WithCodec(testCodec()), WithFaultInjection(fn)); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	expected := []string{
+		"index:main", "index:main", "index:main", "commit:",
+		"snapshot:main", "snapshot:main", "snapshot:",
+	}
+	if fmt.Sprint(seen) != fmt.Sprint(expected) {
+		t.Fatalf("bad: %v", seen)
+	}
+}
+
+func TestMemDB_FaultInjection_Latency(t *testing.T) {
+	delay := 20 * time.Millisecond
+	db, err := NewMemDB(testValidSchema(), WithFaultInjection(func(point FaultPoint, table string) error {
+		if point == FaultCommit {
+			time.Sleep(delay)
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	start := time.Now()
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "a", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("bad: %v", elapsed)
+	}
+}
@@ -1,5 +1,7 @@
 package memdb
 
+import "context"
+
 // FilterFunc is a function that takes the results of an iterator and returns
 // whether the result should be filtered out.
 //
@@ -40,6 +42,18 @@ func (f *FilterIterator) WatchCh() <-chan struct{} {
 	return f.iter.WatchCh()
 }
 
+// WatchCtx waits for the results of the wrapped iterator to change. See the
+// WatchCtx function.
+func (f *FilterIterator) WatchCtx(ctx context.Context) error {
+	return WatchCtx(ctx, f.iter.WatchCh())
+}
+
+// Cursor returns the cursor of the wrapped iterator, or nil if it isn't a
+// CursorIterator.
+func (f *FilterIterator) Cursor() []byte {
+	return iteratorCursor(f.iter)
+}
+
 // Next returns the next non-filtered result from the wrapped iterator.
 func (f *FilterIterator) Next() interface{} {
 	for {
@@ -47,5 +61,108 @@ func (f *FilterIterator) Next() interface{} {
 		if value := f.iter.Next(); value == nil || !f.filter(value) {
 			return value
 		}
+		discardResult(f.iter)
+	}
+}
+
+// LimitIterator is used to wrap a ResultIterator and return at most a given
+// number of its results.
+//
+// LimitIterator 用于封装 ResultIterator 并限制返回的结果数量。
+type LimitIterator struct {
+	// limit is the number of results still to be returned.
+	limit int
+
+	// iter is the iterator that is being wrapped.
+	iter ResultIterator
+}
+
+// NewLimitIterator wraps a ResultIterator so that it returns at most n
+// results. Combined with NewOffsetIterator, it returns a page of results:
+//
+//	page := NewLimitIterator(NewOffsetIterator(iter, pageNum*pageSize), pageSize)
+func NewLimitIterator(iter ResultIterator, n int) *LimitIterator {
+	return &LimitIterator{
+		limit: n,
+		iter:  iter,
+	}
+}
+
+// WatchCh returns the watch channel of the wrapped iterator, which is also
+// closed by changes to results past the limit.
+func (l *LimitIterator) WatchCh() <-chan struct{} {
+	return l.iter.WatchCh()
+}
+
+// WatchCtx waits for the results of the wrapped iterator to change. See the
+// WatchCtx function.
+func (l *LimitIterator) WatchCtx(ctx context.Context) error {
+	return WatchCtx(ctx, l.iter.WatchCh())
+}
+
+// Cursor returns the cursor of the wrapped iterator, or nil if it isn't a
+// CursorIterator.
+func (l *LimitIterator) Cursor() []byte {
+	return iteratorCursor(l.iter)
+}
+
+// Next returns the next result from the wrapped iterator, or nil once the
+// limit is reached.
+func (l *LimitIterator) Next() interface{} {
+	if l.limit <= 0 {
+		return nil
+	}
+	l.limit--
+	return l.iter.Next()
+}
+
+// OffsetIterator is used to wrap a ResultIterator and skip its first
+// results.
+//
+// OffsetIterator 用于封装 ResultIterator 并跳过前若干个结果。
+type OffsetIterator struct {
+	// skip is the number of results still to be skipped.
+	skip int
+
+	// iter is the iterator that is being wrapped.
+	iter ResultIterator
+}
+
+// NewOffsetIterator wraps a ResultIterator so that its first skip results
+// are discarded. They are read on the first call to Next.
+func NewOffsetIterator(iter ResultIterator, skip int) *OffsetIterator {
+	return &OffsetIterator{
+		skip: skip,
+		iter: iter,
+	}
+}
+
+// WatchCh returns the watch channel of the wrapped iterator, which is also
+// closed by changes to the skipped results.
+func (o *OffsetIterator) WatchCh() <-chan struct{} {
+	return o.iter.WatchCh()
+}
+
+// WatchCtx waits for the results of the wrapped iterator to change. See the
+// WatchCtx function.
+func (o *OffsetIterator) WatchCtx(ctx context.Context) error {
+	return WatchCtx(ctx, o.iter.WatchCh())
+}
+
+// Cursor returns the cursor of the wrapped iterator, or nil if it isn't a
+// CursorIterator.
+func (o *OffsetIterator) Cursor() []byte {
+	return iteratorCursor(o.iter)
+}
+
+// Next returns the next result from the wrapped iterator past the offset.
+func (o *OffsetIterator) Next() interface{} {
+	for ; o.skip > 0; o.skip-- {
+		if o.iter.Next() == nil {
+			o.skip = 0
+			return nil
+		}
+		discardResult(o.iter)
 	}
+	return o.iter.Next()
 }
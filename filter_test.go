@@ -120,3 +120,50 @@ func TestFilterIterator(t *testing.T) {
 	// Check the results in a new txn
 	checkResult(txn)
 }
+
+func TestLimitOffsetIterator(t *testing.T) {
+	var _ ResultIterator = &LimitIterator{}
+	var _ ResultIterator = &OffsetIterator{}
+
+	db := testDB(t)
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: "x", Qux: []string{"1"}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	page := func(skip, n int) string {
+		result, err := db.Txn(false).Get("main", "id")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		iter := NewLimitIterator(NewOffsetIterator(result, skip), n)
+		if iter.WatchCh() != result.WatchCh() {
+			t.Fatalf("bad watch channel")
+		}
+		ids := ""
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			ids += raw.(*TestObject).ID
+		}
+		return ids
+	}
+	cases := []struct {
+		skip, n int
+		ids     string
+	}{
+		{0, 2, "ab"},
+		{2, 2, "cd"},
+		{4, 2, "e"},
+		{5, 2, ""},
+		{9, 2, ""},
+		{0, 0, ""},
+		{1, 10, "bcde"},
+	}
+	for _, c := range cases {
+		if ids := page(c.skip, c.n); ids != c.ids {
+			t.Fatalf("bad: %d %d: %s", c.skip, c.n, ids)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ForeignKeyAction is what happens to the rows referencing a parent row
+// when the parent is deleted.
+type ForeignKeyAction int
+
+const (
+	// Restrict makes the delete of a parent that is still referenced fail.
+	Restrict ForeignKeyAction = iota
+
+	// Cascade deletes the rows referencing the parent along with it.
+	Cascade
+
+	// SetNull replaces the rows referencing the parent with the copies
+	// returned by the SetNull function of the ForeignKeySchema.
+	SetNull
+)
+
+func (a ForeignKeyAction) String() string {
+	switch a {
+	case Restrict:
+		return "restrict"
+	case Cascade:
+		return "cascade"
+	case SetNull:
+		return "set null"
+	default:
+		return fmt.Sprintf("ForeignKeyAction(%d)", int(a))
+	}
+}
+
+// ForeignKeySchema declares that the rows of the table holding the schema
+// reference rows of a parent table, and has the transactions enforce it:
+// inserting a row whose reference matches no parent fails, as does changing
+// the referenced key of a parent that is still referenced, unless a
+// CascadeSchema of the parent rewrites the references. What happens when a
+// referenced parent is deleted is set by OnDelete. Rows without a value for
+// Index reference nothing.
+//
+// Index must encode values the same way as the referenced index of the
+// parent, for example by both being StringFieldIndex indexes.
+//
+// ForeignKeySchema 声明外键约束，在插入和删除时保证引用完整性。
+type ForeignKeySchema struct {
+	// Index is the index of the table holding the referenced keys.
+	Index string
+
+	// ParentTable and ParentIndex give the unique index of the referenced
+	// rows. ParentIndex defaults to the id index.
+	ParentTable string
+	ParentIndex string
+
+	// OnDelete is the action taken when a referenced parent is deleted.
+	OnDelete ForeignKeyAction
+
+	// SetNull returns a copy of child without the reference, for the
+	// SetNull action. The child object must not be modified in place.
+	SetNull func(child interface{}) (interface{}, error)
+
+	// Deferred makes the references checked against the final state of the
+	// transaction when it commits, rather than on each write. A child may
+	// then be inserted before its parent, and a parent still referenced may
+	// be deleted or change its key with OnDelete set to Restrict, as long as
+	// the references are fixed before the commit. TryCommit fails if they
+	// aren't. The Cascade and SetNull actions still apply immediately.
+	Deferred bool
+}
+
+// parentIndex returns the name of the referenced index.
+func (s *ForeignKeySchema) parentIndex() string {
+	if s.ParentIndex == "" {
+		return id
+	}
+	return s.ParentIndex
+}
+
+// Validate is used to validate the foreign key against the schema of the
+// database and of the table holding it.
+func (s *ForeignKeySchema) Validate(schema *DBSchema, table *TableSchema) error {
+	if _, ok := table.Indexes[s.Index]; !ok {
+		return fmt.Errorf("invalid index '%s'", s.Index)
+	}
+	parent, ok := schema.Tables[s.ParentTable]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", s.ParentTable)
+	}
+	indexSchema, ok := parent.Indexes[s.parentIndex()]
+	if !ok {
+		return fmt.Errorf("invalid index '%s' for table '%s'", s.parentIndex(), s.ParentTable)
+	}
+	if !indexSchema.Unique {
+		return fmt.Errorf("index '%s' for table '%s' must be unique", s.parentIndex(), s.ParentTable)
+	}
+	switch s.OnDelete {
+	case Restrict, Cascade:
+	case SetNull:
+		if s.SetNull == nil {
+			return fmt.Errorf("missing set null function")
+		}
+	default:
+		return fmt.Errorf("invalid delete action %d", s.OnDelete)
+	}
+	return nil
+}
+
+// foreignKeyRef is a foreign key of a child table.
+type foreignKeyRef struct {
+	table string
+	fk    *ForeignKeySchema
+}
+
+// referencesTo returns the foreign keys referencing the given table.
+func (txn *Txn) referencesTo(table string) []foreignKeyRef {
+	var refs []foreignKeyRef
+	for name, tableSchema := range txn.schema.Tables {
+		for _, fk := range tableSchema.ForeignKeys {
+			if fk.ParentTable == table {
+				refs = append(refs, foreignKeyRef{name, fk})
+			}
+		}
+	}
+	return refs
+}
+
+// checkForeignKeys returns an error if obj, about to be written to the
+// table along with the objects written, references a parent that doesn't
+// exist. It's called before obj is written, so that a failed write leaves
+// the transaction unchanged. Deferred foreign keys are checked at commit
+// instead.
+func (txn *Txn) checkForeignKeys(tableSchema *TableSchema, obj interface{}, written []interface{}) error {
+	for _, fk := range tableSchema.ForeignKeys {
+		if fk.Deferred || txn.deferReferences {
+			if err := txn.deferObjectOf(tableSchema, obj); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := txn.checkForeignKey(tableSchema, fk, obj, written); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkForeignKey returns an error if obj, an object of the table, references
+// a parent that doesn't exist through the foreign key. For a foreign key
+// referencing its own table, the objects written along with obj count as
+// parents, and the version of obj they replace doesn't.
+func (txn *Txn) checkForeignKey(tableSchema *TableSchema, fk *ForeignKeySchema, obj interface{}, written []interface{}) error {
+	ok, vals, err := indexValues(tableSchema.Indexes[fk.Index], obj)
+	if err != nil {
+		return fmt.Errorf("failed to build index '%s': %v", fk.Index, err)
+	}
+	if !ok {
+		return nil
+	}
+
+	self := fk.ParentTable == tableSchema.Name
+	pending := make(map[string]struct{})
+	var objID []byte
+	if self {
+		parentSchema := tableSchema.Indexes[fk.parentIndex()]
+		for _, w := range written {
+			ok, keys, err := indexValues(parentSchema, w)
+			if err != nil {
+				return fmt.Errorf("failed to build index '%s': %v", parentSchema.Name, err)
+			}
+			if ok {
+				for _, key := range keys {
+					pending[string(key)] = struct{}{}
+				}
+			}
+		}
+		if _, objID, err = tableSchema.Indexes[id].Indexer.(SingleIndexer).FromObject(obj); err != nil {
+			return fmt.Errorf("failed to build primary index: %v", err)
+		}
+	}
+
+	parentTxn := txn.readableIndex(fk.ParentTable, fk.parentIndex())
+	for _, val := range vals {
+		if _, ok := pending[string(val)]; ok {
+			continue
+		}
+		raw, ok := parentTxn.Get(val)
+		if ok && self {
+			owner, err := txn.resolve(fk.ParentTable, raw)
+			if err != nil {
+				return err
+			}
+			_, ownerID, err := tableSchema.Indexes[id].Indexer.(SingleIndexer).FromObject(owner)
+			if err != nil {
+				return fmt.Errorf("failed to build primary index: %v", err)
+			}
+			ok = !bytes.Equal(ownerID, objID)
+		}
+		if !ok {
+			return fmt.Errorf("foreign key '%s' violated: no matching object in table '%s'",
+				fk.Index, fk.ParentTable)
+		}
+	}
+	return nil
+}
+
+// checkKeptReferences returns an error if updating a parent row from
+// existing to obj would remove a key that rows still reference. It's called
+// before the row is written; the references rewritten by the cascades of
+// the table, and those of the row itself, are left out. The removed keys of
+// deferred foreign keys are checked at commit instead.
+func (txn *Txn) checkKeptReferences(tableSchema *TableSchema, existing, obj interface{}, idVal []byte) error {
+	for _, ref := range txn.referencesTo(tableSchema.Name) {
+		if cascadesTo(tableSchema, ref) {
+			continue
+		}
+		removed, err := removedKeys(tableSchema.Indexes[ref.fk.parentIndex()], existing, obj)
+		if err != nil {
+			return err
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		if ref.fk.Deferred || txn.deferReferences {
+			txn.deferKeys(ref, removed)
+			continue
+		}
+		children, err := txn.lookupKeys(ref.table, ref.fk.Index, removed)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if ref.table == tableSchema.Name {
+				_, childID, err := tableSchema.Indexes[id].Indexer.(SingleIndexer).FromObject(child)
+				if err != nil {
+					return fmt.Errorf("failed to build primary index: %v", err)
+				}
+				if bytes.Equal(childID, idVal) {
+					continue
+				}
+			}
+			return fmt.Errorf("foreign key '%s' of table '%s' violated: changed key is still referenced",
+				ref.fk.Index, ref.table)
+		}
+	}
+	return nil
+}
+
+// cascadesTo returns true if a cascade of the table rewrites the references
+// of the foreign key when the referenced key changes.
+func cascadesTo(tableSchema *TableSchema, ref foreignKeyRef) bool {
+	for _, cascade := range tableSchema.Cascades {
+		if cascade.Index == ref.fk.parentIndex() && cascade.ChildTable == ref.table &&
+			cascade.ChildIndex == ref.fk.Index {
+			return true
+		}
+	}
+	return false
+}
+
+// removedKeys returns the values of the index that existing has and obj
+// doesn't. obj may be nil for a delete.
+func removedKeys(indexSchema *IndexSchema, existing, obj interface{}) ([][]byte, error) {
+	ok, oldVals, err := indexValues(indexSchema, existing)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if obj == nil {
+		return oldVals, nil
+	}
+	ok, newVals, err := indexValues(indexSchema, obj)
+	if err != nil || !ok {
+		return oldVals, err
+	}
+	keep := make(map[string]struct{}, len(newVals))
+	for _, val := range newVals {
+		keep[string(val)] = struct{}{}
+	}
+	var removed [][]byte
+	for _, val := range oldVals {
+		if _, ok := keep[string(val)]; !ok {
+			removed = append(removed, val)
+		}
+	}
+	return removed, nil
+}
+
+// deleteReferences applies the delete actions of the foreign keys
+// referencing a parent row that was deleted.
+func (txn *Txn) deleteReferences(tableSchema *TableSchema, existing interface{}) error {
+	for _, ref := range txn.referencesTo(tableSchema.Name) {
+		keys, err := removedKeys(tableSchema.Indexes[ref.fk.parentIndex()], existing, nil)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if ref.fk.OnDelete == Restrict && (ref.fk.Deferred || txn.deferReferences) {
+			txn.deferKeys(ref, keys)
+			continue
+		}
+		children, err := txn.lookupKeys(ref.table, ref.fk.Index, keys)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			switch ref.fk.OnDelete {
+			case Cascade:
+				err = txn.delete(ref.table, child)
+			case SetNull:
+				var updated interface{}
+				if updated, err = ref.fk.SetNull(child); err != nil {
+					return fmt.Errorf("failed to clear reference in table '%s': %v", ref.table, err)
+				}
+				err = txn.insert(ref.table, updated)
+			default:
+				err = fmt.Errorf("foreign key '%s' of table '%s' violated: object is still referenced",
+					ref.fk.Index, ref.table)
+			}
+			// A row deleted by an earlier cascade is already gone
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+	}
+	return nil
+}
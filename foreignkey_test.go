@@ -0,0 +1,278 @@
+package memdb
+
+import (
+	"testing"
+)
+
+func testForeignKeySchema(action ForeignKeyAction) *DBSchema {
+	schema := testCascadeSchema()
+	schema.Tables["teams"].Cascades = nil
+	schema.Tables["members"].Indexes["team"].AllowMissing = true
+	schema.Tables["members"].ForeignKeys = []*ForeignKeySchema{
+		&ForeignKeySchema{
+			Index:       "team",
+			ParentTable: "teams",
+			ParentIndex: "name",
+			OnDelete:    action,
+			SetNull: func(child interface{}) (interface{}, error) {
+				member := *child.(*testMember)
+				member.Team = ""
+				return &member, nil
+			},
+		},
+	}
+	return schema
+}
+
+func testForeignKeyDB(t *testing.T, action ForeignKeyAction) *MemDB {
+	db, err := NewMemDB(testForeignKeySchema(action))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	inserts := []struct {
+		table string
+		obj   interface{}
+	}{
+		{"teams", &testTeam{ID: "1", Name: "red"}},
+		{"teams", &testTeam{ID: "2", Name: "blue"}},
+		{"members", &testMember{ID: "a", Team: "red"}},
+		{"members", &testMember{ID: "b", Team: "red"}},
+		{"members", &testMember{ID: "c", Team: "blue"}},
+	}
+	for _, insert := range inserts {
+		if err := txn.Insert(insert.table, insert.obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	return db
+}
+
+func TestForeignKeySchema_Validate(t *testing.T) {
+	schema := testForeignKeySchema(Restrict)
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	schema.Tables["members"].ForeignKeys[0].ParentTable = "nope"
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, invalid parent table")
+	}
+
+	schema = testForeignKeySchema(Restrict)
+	schema.Tables["members"].ForeignKeys[0].Index = "nope"
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, invalid index")
+	}
+
+	schema = testForeignKeySchema(Restrict)
+	schema.Tables["teams"].Indexes["name"].Unique = false
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, parent index not unique")
+	}
+
+	schema = testForeignKeySchema(SetNull)
+	schema.Tables["members"].ForeignKeys[0].SetNull = nil
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, missing set null")
+	}
+}
+
+func TestTxn_ForeignKeyInsert(t *testing.T) {
+	db := testForeignKeyDB(t, Restrict)
+
+	txn := db.Txn(true)
+	if err := txn.Insert("members", &testMember{ID: "d", Team: "green"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	// A parent inserted earlier in the transaction can be referenced
+	txn = db.Txn(true)
+	if err := txn.Insert("teams", &testTeam{ID: "3", Name: "green"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("members", &testMember{ID: "d", Team: "green"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Renaming a referenced parent is refused
+	if err := txn.Insert("teams", &testTeam{ID: "1", Name: "orange"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+}
+
+func TestTxn_ForeignKeyRestrict(t *testing.T) {
+	db := testForeignKeyDB(t, Restrict)
+
+	txn := db.Txn(true)
+	if err := txn.Delete("teams", &testTeam{ID: "1"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	txn = db.Txn(true)
+	if _, err := txn.DeleteAll("teams", "id"); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	// Once the children are gone the parent can be deleted
+	txn = db.Txn(true)
+	if _, err := txn.DeleteAll("members", "team", "red"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Delete("teams", &testTeam{ID: "1"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+}
+
+func TestTxn_ForeignKeyCascade(t *testing.T) {
+	db := testForeignKeyDB(t, Cascade)
+
+	txn := db.Txn(true)
+	if err := txn.Delete("teams", &testTeam{ID: "1"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	for id, want := range map[string]bool{"a": false, "b": false, "c": true} {
+		raw, err := txn.First("members", "id", id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if (raw != nil) != want {
+			t.Fatalf("bad: %s %#v", id, raw)
+		}
+	}
+
+	// Truncating the parent deletes every child
+	txn = db.Txn(true)
+	if _, err := txn.DeletePrefix("teams", "id_prefix", ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if raw, err := db.Txn(false).First("members", "id"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
+
+func TestTxn_ForeignKeySetNull(t *testing.T) {
+	db := testForeignKeyDB(t, SetNull)
+
+	txn := db.Txn(true)
+	if err := txn.Delete("teams", &testTeam{ID: "1"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	raw, err := txn.First("members", "id", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if member := raw.(*testMember); member.Team != "" {
+		t.Fatalf("bad: %#v", member)
+	}
+	raw, err = txn.First("members", "id", "c")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if member := raw.(*testMember); member.Team != "blue" {
+		t.Fatalf("bad: %#v", member)
+	}
+}
+
+func TestTxn_ForeignKeyViolationWritesNothing(t *testing.T) {
+	db := testForeignKeyDB(t, Restrict)
+
+	txn := db.Txn(true)
+	if err := txn.Insert("members", &testMember{ID: "d", Team: "green"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := txn.Insert("teams", &testTeam{ID: "1", Name: "orange"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := txn.InsertBatch("members", []interface{}{&testMember{ID: "e", Team: "green"}}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	for _, id := range []string{"d", "e"} {
+		raw, err := txn.First("members", "id", id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if raw != nil {
+			t.Fatalf("bad: %#v", raw)
+		}
+	}
+	raw, err := txn.First("teams", "id", "1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw.(*testTeam).Name != "red" {
+		t.Fatalf("bad: %#v", raw)
+	}
+}
+
+type testTreeNode struct {
+	ID     string
+	Parent string
+}
+
+func TestTxn_ForeignKeySelfReference(t *testing.T) {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"nodes": &TableSchema{
+				Name: "nodes",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"parent": &IndexSchema{
+						Name:         "parent",
+						AllowMissing: true,
+						Indexer:      &StringFieldIndex{Field: "Parent"},
+					},
+				},
+				ForeignKeys: []*ForeignKeySchema{
+					&ForeignKeySchema{Index: "parent", ParentTable: "nodes"},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A batch may list children before their parents
+	txn := db.Txn(true)
+	err = txn.InsertBatch("nodes", []interface{}{
+		&testTreeNode{ID: "b", Parent: "a"},
+		&testTreeNode{ID: "a", Parent: "a"},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A row may reference itself
+	if err := txn.Insert("nodes", &testTreeNode{ID: "c", Parent: "c"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("nodes", &testTreeNode{ID: "d", Parent: "e"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if raw, _ := txn.First("nodes", "id", "d"); raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+	txn.Commit()
+}
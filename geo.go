@@ -0,0 +1,372 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// geohashLen is the length of the geohashes stored in a GeoIndexer, which
+// locate points within a few centimeters.
+const geohashLen = 12
+
+// geohashMaxCells bounds the number of geohash cells scanned by a query. The
+// more cells, the closer they fit the area of the query.
+const geohashMaxCells = 32
+
+// earthRadius is the mean radius of the Earth, in meters.
+const earthRadius = 6371008.8
+
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GeoIndexer is used to extract the latitude and longitude fields of an
+// object using reflection, and builds an index on the geohash of the point
+// they denote. Nearby points share a prefix of their geohash, so
+// Txn.GetWithinRadius and Txn.GetInBoundingBox only scan the cells of the
+// index around the area of the query. The fields must be floats, in
+// degrees.
+//
+// Get takes a latitude and a longitude, and finds the objects at that very
+// point; a prefix lookup takes a geohash and finds the objects in its cell.
+//
+// GeoIndexer 使用 geohash 为经纬度字段建立索引，支持按半径和矩形范围查询。
+type GeoIndexer struct {
+	LatField string
+	LonField string
+}
+
+func (g *GeoIndexer) FromObject(obj interface{}) (bool, []byte, error) {
+	lat, lon, err := g.point(obj)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, geohash(lat, lon, geohashLen), nil
+}
+
+func (g *GeoIndexer) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("must provide a latitude and a longitude")
+	}
+	var coords [2]float64
+	for i, arg := range args {
+		v := reflect.ValueOf(arg)
+		if k := v.Kind(); k != reflect.Float32 && k != reflect.Float64 {
+			return nil, fmt.Errorf("argument must be a float: %#v", arg)
+		}
+		coords[i] = v.Float()
+	}
+	if err := checkPoint(coords[0], coords[1]); err != nil {
+		return nil, err
+	}
+	return geohash(coords[0], coords[1], geohashLen), nil
+}
+
+func (g *GeoIndexer) PrefixFromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a geohash: %#v", args[0])
+	}
+	for i := 0; i < len(arg); i++ {
+		if c := arg[i]; c >= 0x80 || geohashValue[c] < 0 {
+			return nil, fmt.Errorf("invalid geohash %q", arg)
+		}
+	}
+	return []byte(arg), nil
+}
+
+// Validate checks the settings of the index.
+func (g *GeoIndexer) Validate() error {
+	if g.LatField == "" || g.LonField == "" {
+		return fmt.Errorf("missing latitude or longitude field")
+	}
+	return nil
+}
+
+// point returns the latitude and longitude of an object.
+func (g *GeoIndexer) point(obj interface{}) (float64, float64, error) {
+	v := reflect.ValueOf(obj)
+	v = reflect.Indirect(v) // Dereference the pointer if any
+
+	var coords [2]float64
+	for i, field := range []string{g.LatField, g.LonField} {
+		fv := v.FieldByName(field)
+		if !fv.IsValid() {
+			return 0, 0, fmt.Errorf("field '%s' for %#v is invalid", field, obj)
+		}
+		if k := fv.Kind(); k != reflect.Float32 && k != reflect.Float64 {
+			return 0, 0, fmt.Errorf("field %q is of type %v; want a float", field, k)
+		}
+		coords[i] = fv.Float()
+	}
+	if err := checkPoint(coords[0], coords[1]); err != nil {
+		return 0, 0, err
+	}
+	return coords[0], coords[1], nil
+}
+
+// checkPoint checks that the coordinates are valid.
+func checkPoint(lat, lon float64) error {
+	if !(lat >= -90 && lat <= 90) || !(lon >= -180 && lon <= 180) {
+		return fmt.Errorf("invalid coordinates (%v, %v)", lat, lon)
+	}
+	return nil
+}
+
+// geohashValue maps the characters of geohashes to their value, or -1.
+var geohashValue = func() (values [128]int8) {
+	for i := range values {
+		values[i] = -1
+	}
+	for i := 0; i < len(geohashBase32); i++ {
+		values[geohashBase32[i]] = int8(i)
+	}
+	return values
+}()
+
+// geohashBits returns the number of bits of the latitude and longitude in a
+// geohash of the given length.
+func geohashBits(length int) (latBits, lonBits uint) {
+	bits := uint(5 * length)
+	return bits / 2, (bits + 1) / 2
+}
+
+// geohash returns the geohash of the given length of a point.
+func geohash(lat, lon float64, length int) []byte {
+	latBits, lonBits := geohashBits(length)
+	return geohashCell(cellIndex(lat, -90, 180, latBits), cellIndex(lon, -180, 360, lonBits), length)
+}
+
+// cellIndex returns the index of the cell holding a coordinate, among the
+// 2^bits cells dividing the span starting at min.
+func cellIndex(coord, min, span float64, bits uint) uint64 {
+	cells := uint64(1) << bits
+	i := uint64((coord - min) / span * float64(cells))
+	if i >= cells {
+		i = cells - 1
+	}
+	return i
+}
+
+// geohashCell returns the geohash of the given length of a cell, whose
+// longitude bits are interleaved with its latitude bits, starting with the
+// longitude.
+func geohashCell(latIndex, lonIndex uint64, length int) []byte {
+	latBits, lonBits := geohashBits(length)
+	buf := make([]byte, length)
+	for i := 0; i < 5*length; i++ {
+		var bit uint64
+		if i%2 == 0 {
+			lonBits--
+			bit = lonIndex >> lonBits & 1
+		} else {
+			latBits--
+			bit = latIndex >> latBits & 1
+		}
+		buf[i/5] |= byte(bit << uint(4-i%5))
+	}
+	for i, c := range buf {
+		buf[i] = geohashBase32[c]
+	}
+	return buf
+}
+
+// geohashCover returns the geohash cells covering a bounding box that
+// doesn't cross the antimeridian, using the longest geohashes that need at
+// most maxCells cells.
+func geohashCover(minLat, minLon, maxLat, maxLon float64, maxCells int) [][]byte {
+	cover := [][]byte{nil}
+	for length := 1; length <= geohashLen; length++ {
+		latBits, lonBits := geohashBits(length)
+		minLatIndex, maxLatIndex := cellIndex(minLat, -90, 180, latBits), cellIndex(maxLat, -90, 180, latBits)
+		minLonIndex, maxLonIndex := cellIndex(minLon, -180, 360, lonBits), cellIndex(maxLon, -180, 360, lonBits)
+		if (maxLatIndex-minLatIndex+1)*(maxLonIndex-minLonIndex+1) > uint64(maxCells) {
+			break
+		}
+		cover = cover[:0]
+		for lat := minLatIndex; lat <= maxLatIndex; lat++ {
+			for lon := minLonIndex; lon <= maxLonIndex; lon++ {
+				cover = append(cover, geohashCell(lat, lon, length))
+			}
+		}
+	}
+	return cover
+}
+
+// distance returns the great-circle distance between two points, in meters.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// geoIndex returns the GeoIndexer of an index.
+func (txn *Txn) geoIndex(table, index string) (*GeoIndexer, error) {
+	tableSchema, ok := txn.tableSchema(table)
+	if !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+	indexSchema, ok := tableSchema.Indexes[index]
+	if !ok {
+		return nil, fmt.Errorf("invalid index '%s'", index)
+	}
+	geo, ok := indexSchema.Indexer.(*GeoIndexer)
+	if !ok {
+		return nil, fmt.Errorf("index '%s' is not a geo index", index)
+	}
+	return geo, nil
+}
+
+// geoScan returns the objects of the geohash cells covering the bounding
+// boxes, along with their coordinates.
+func (txn *Txn) geoScan(table, index string, geo *GeoIndexer, boxes ...[4]float64) ([]geoPoint, error) {
+	// Scan the cells in order, so the objects are in the order of the index
+	var cells [][]byte
+	for _, box := range boxes {
+		cells = append(cells, geohashCover(box[0], box[1], box[2], box[3], geohashMaxCells)...)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		return bytes.Compare(cells[i], cells[j]) < 0
+	})
+
+	indexTxn := txn.readableIndex(table, index)
+	var points []geoPoint
+	for _, cell := range cells {
+		iter := indexTxn.Root().Iterator()
+		iter.SeekPrefix(cell)
+		for _, raw, ok := iter.Next(); ok; _, raw, ok = iter.Next() {
+			obj, err := txn.resolve(table, raw)
+			if err != nil {
+				return nil, err
+			}
+			lat, lon, err := geo.point(obj)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, geoPoint{obj: obj, lat: lat, lon: lon})
+		}
+	}
+	return points, nil
+}
+
+// geoPoint is an object found by a geospatial query.
+type geoPoint struct {
+	obj      interface{}
+	lat, lon float64
+	distance float64
+}
+
+// geoIterator returns an iterator over the objects, watching the index.
+func (txn *Txn) geoIterator(table, index string, points []geoPoint) ResultIterator {
+	rows := make([]interface{}, len(points))
+	for i, p := range points {
+		rows[i] = p.obj
+	}
+	txn.trackRead(table, index, nil, nil)
+	ws := NewWatchSet()
+	watchCh, _, _ := txn.readableIndex(table, index).Root().GetWatch(nil)
+	ws.Add(watchCh)
+	return &traverseIterator{rows: rows, ws: ws}
+}
+
+// GetInBoundingBox returns the objects whose point is within a bounding box,
+// using an index built with a GeoIndexer. The box crosses the antimeridian
+// if minLon is greater than maxLon. The objects are returned in the order
+// of the index. The watch channel of the iterator is closed when the index
+// changes.
+//
+// GetInBoundingBox 返回位于矩形范围内的对象。
+func (txn *Txn) GetInBoundingBox(table, index string, minLat, minLon, maxLat, maxLon float64) (ResultIterator, error) {
+	geo, err := txn.geoIndex(table, index)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPoint(minLat, minLon); err != nil {
+		return nil, err
+	}
+	if err := checkPoint(maxLat, maxLon); err != nil {
+		return nil, err
+	}
+	if minLat > maxLat {
+		return nil, fmt.Errorf("minimum latitude %v is greater than maximum latitude %v", minLat, maxLat)
+	}
+
+	boxes := [][4]float64{{minLat, minLon, maxLat, maxLon}}
+	if minLon > maxLon {
+		boxes = [][4]float64{{minLat, minLon, maxLat, 180}, {minLat, -180, maxLat, maxLon}}
+	}
+	points, err := txn.geoScan(table, index, geo, boxes...)
+	if err != nil {
+		return nil, err
+	}
+	inBox := points[:0]
+	for _, p := range points {
+		inLon := p.lon >= minLon && p.lon <= maxLon
+		if minLon > maxLon {
+			inLon = p.lon >= minLon || p.lon <= maxLon
+		}
+		if inLon && p.lat >= minLat && p.lat <= maxLat {
+			inBox = append(inBox, p)
+		}
+	}
+	return txn.geoIterator(table, index, inBox), nil
+}
+
+// GetWithinRadius returns the objects whose point is within the given
+// distance in meters of a point, using an index built with a GeoIndexer.
+// Distances are computed on a sphere, which is accurate to about half a
+// percent. The objects are returned nearest first. The watch channel of the
+// iterator is closed when the index changes.
+//
+// GetWithinRadius 返回距离给定点不超过指定米数的对象，按距离由近到远排序。
+func (txn *Txn) GetWithinRadius(table, index string, lat, lon, meters float64) (ResultIterator, error) {
+	geo, err := txn.geoIndex(table, index)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPoint(lat, lon); err != nil {
+		return nil, err
+	}
+	if !(meters >= 0) {
+		return nil, fmt.Errorf("invalid radius %v", meters)
+	}
+
+	// Scan the bounding box of the circle, which spans every longitude if
+	// it reaches a pole
+	dLat := meters / earthRadius * 180 / math.Pi
+	minLat, maxLat := math.Max(lat-dLat, -90), math.Min(lat+dLat, 90)
+	boxes := [][4]float64{{minLat, -180, maxLat, 180}}
+	if minLat > -90 && maxLat < 90 {
+		dLon := dLat / math.Cos(lat*math.Pi/180)
+		switch {
+		case dLon >= 180:
+		case lon-dLon < -180:
+			boxes = [][4]float64{{minLat, -180, maxLat, lon + dLon}, {minLat, lon - dLon + 360, maxLat, 180}}
+		case lon+dLon > 180:
+			boxes = [][4]float64{{minLat, lon - dLon, maxLat, 180}, {minLat, -180, maxLat, lon + dLon - 360}}
+		default:
+			boxes = [][4]float64{{minLat, lon - dLon, maxLat, lon + dLon}}
+		}
+	}
+	points, err := txn.geoScan(table, index, geo, boxes...)
+	if err != nil {
+		return nil, err
+	}
+
+	within := points[:0]
+	for _, p := range points {
+		if p.distance = distance(lat, lon, p.lat, p.lon); p.distance <= meters {
+			within = append(within, p)
+		}
+	}
+	sort.SliceStable(within, func(i, j int) bool {
+		return within[i].distance < within[j].distance
+	})
+	return txn.geoIterator(table, index, within), nil
+}
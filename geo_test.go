@@ -0,0 +1,224 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testGeoPlace struct {
+	Name string
+	Lat  float64
+	Lon  float64
+}
+
+func testGeoDB(t *testing.T) *MemDB {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"places": &TableSchema{
+				Name: "places",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "Name"},
+					},
+					"location": &IndexSchema{
+						Name:    "location",
+						Indexer: &GeoIndexer{LatField: "Lat", LonField: "Lon"},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for _, place := range []*testGeoPlace{
+		&testGeoPlace{Name: "paris", Lat: 48.8566, Lon: 2.3522},
+		&testGeoPlace{Name: "london", Lat: 51.5074, Lon: -0.1278},
+		&testGeoPlace{Name: "berlin", Lat: 52.52, Lon: 13.405},
+		&testGeoPlace{Name: "suva", Lat: -18.1416, Lon: 178.4419},
+		&testGeoPlace{Name: "apia", Lat: -13.8333, Lon: -171.75},
+		&testGeoPlace{Name: "pole-a", Lat: 89.9, Lon: 0},
+		&testGeoPlace{Name: "pole-b", Lat: 89.9, Lon: 180},
+	} {
+		if err := txn.Insert("places", place); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	return db
+}
+
+func placeNames(t *testing.T, iter ResultIterator, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var names []string
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		names = append(names, obj.(*testGeoPlace).Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestGeoIndexer(t *testing.T) {
+	indexer := &GeoIndexer{LatField: "Lat", LonField: "Lon"}
+	ok, val, err := indexer.FromObject(&testGeoPlace{Lat: 57.64911, Lon: 10.40744})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || !strings.HasPrefix(string(val), "u4pruydqqvj") || len(val) != 12 {
+		t.Fatalf("bad: %q", val)
+	}
+	args, err := indexer.FromArgs(57.64911, 10.40744)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(args) != string(val) {
+		t.Fatalf("bad: %q", args)
+	}
+
+	for _, bad := range []*testGeoPlace{
+		&testGeoPlace{Lat: 91},
+		&testGeoPlace{Lon: -180.5},
+	} {
+		if _, _, err := indexer.FromObject(bad); err == nil {
+			t.Fatalf("expected error: %#v", bad)
+		}
+	}
+	if _, _, err := (&GeoIndexer{LatField: "Name", LonField: "Lon"}).FromObject(&testGeoPlace{}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := indexer.FromArgs(1.0); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := indexer.FromArgs(1, 2); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := indexer.PrefixFromArgs("u4pa"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := (&IndexSchema{Name: "geo", Indexer: &GeoIndexer{LatField: "Lat"}}).Validate(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestTxn_GetWithinRadius(t *testing.T) {
+	db := testGeoDB(t)
+	txn := db.Txn(false)
+
+	cases := []struct {
+		lat, lon, meters float64
+		expected         string
+	}{
+		{48.8566, 2.3522, 0, "paris"},
+		{48.8566, 2.3522, 300000, "paris"},
+		{48.8566, 2.3522, 400000, "paris,london"},
+		{52.52, 13.405, 1000000, "berlin,paris,london"},
+		// Across the antimeridian and the pole
+		{-18.1416, 178.4419, 1200000, "suva,apia"},
+		{-13.8333, -171.75, 1200000, "apia,suva"},
+		{89.9, 0, 30000, "pole-a,pole-b"},
+		{0, 0, 1000, ""},
+	}
+	for _, c := range cases {
+		iter, err := txn.GetWithinRadius("places", "location", c.lat, c.lon, c.meters)
+		if got := placeNames(t, iter, err); got != c.expected {
+			t.Fatalf("%#v: bad: %s", c, got)
+		}
+	}
+
+	if _, err := txn.GetWithinRadius("places", "location", 0, 0, -1); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := txn.GetWithinRadius("places", "location", 100, 0, 1); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := txn.GetWithinRadius("places", "id", 0, 0, 1); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestTxn_GetInBoundingBox(t *testing.T) {
+	db := testGeoDB(t)
+	txn := db.Txn(false)
+
+	// Europe
+	iter, err := txn.GetInBoundingBox("places", "location", 45, -5, 55, 15)
+	if got := placeNames(t, iter, err); got != "london,paris,berlin" {
+		t.Fatalf("bad: %s", got)
+	}
+	iter, err = txn.GetInBoundingBox("places", "location", 50, -5, 55, 10)
+	if got := placeNames(t, iter, err); got != "london" {
+		t.Fatalf("bad: %s", got)
+	}
+
+	// The Pacific, across the antimeridian
+	iter, err = txn.GetInBoundingBox("places", "location", -20, 170, -10, -170)
+	if got := placeNames(t, iter, err); got != "apia,suva" {
+		t.Fatalf("bad: %s", got)
+	}
+
+	// Everything
+	iter, err = txn.GetInBoundingBox("places", "location", -90, -180, 90, 180)
+	if got := placeNames(t, iter, err); len(strings.Split(got, ",")) != 7 {
+		t.Fatalf("bad: %s", got)
+	}
+
+	if _, err := txn.GetInBoundingBox("places", "location", 10, 0, 0, 10); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Prefix lookups find the objects of a cell
+	iter, err = txn.Get("places", "location_prefix", "u0")
+	if got := placeNames(t, iter, err); got != "paris" {
+		t.Fatalf("bad: %s", got)
+	}
+}
+
+func TestTxn_GetWithinRadius_Many(t *testing.T) {
+	db := testGeoDB(t)
+	txn := db.Txn(true)
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 100; j++ {
+			place := &testGeoPlace{Name: fmt.Sprintf("grid-%d-%d", i, j), Lat: 40 + float64(i)/100, Lon: float64(j) / 100}
+			if err := txn.Insert("places", place); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+
+	// The results match a scan of the table
+	lat, lon, meters := 40.5, 0.5, 20000.0
+	iter, err := txn.GetWithinRadius("places", "location", lat, lon, meters)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	found, last := 0, 0.0
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		place := obj.(*testGeoPlace)
+		d := distance(lat, lon, place.Lat, place.Lon)
+		if d > meters || d < last {
+			t.Fatalf("bad: %#v %v", place, d)
+		}
+		found, last = found+1, d
+	}
+	all, err := txn.Get("places", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := 0
+	for obj := all.Next(); obj != nil; obj = all.Next() {
+		place := obj.(*testGeoPlace)
+		if distance(lat, lon, place.Lat, place.Lon) <= meters {
+			expected++
+		}
+	}
+	if found != expected || found == 0 {
+		t.Fatalf("bad: %d %d", found, expected)
+	}
+}
@@ -3,6 +3,6 @@ module github.com/hashicorp/go-memdb
 go 1.12
 
 require (
-	github.com/hashicorp/go-immutable-radix v1.3.0
+	github.com/hashicorp/go-immutable-radix v1.3.1
 	github.com/hashicorp/golang-lru v0.5.4 // indirect
 )
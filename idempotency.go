@@ -0,0 +1,117 @@
+package memdb
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// IdempotencyRecord is the row type of the IdempotencyTable. It records the
+// commit made for an idempotency key and the result that was given with it.
+type IdempotencyRecord struct {
+	Key    string
+	Commit uint64
+	Time   time.Time
+	Result interface{}
+}
+
+// WithIdempotencyRetention sets how long idempotency keys are remembered.
+// A background job deletes older records every retention period. By
+// default records are kept for as long as the MemDB exists.
+//
+// 设置幂等键的保留时长，后台任务会定期删除过期的记录。
+func WithIdempotencyRetention(retention time.Duration) Option {
+	return func(db *MemDB) {
+		db.idempotencyRetention = retention
+	}
+}
+
+// CommitIdempotent commits the transaction on behalf of the given key, and
+// records result along with it in the IdempotencyTable. If the key was
+// already committed, the changes made by this transaction are discarded
+// instead, and the record of the original commit is returned with true.
+// This allows callers that retry their writes to apply them only once.
+//
+// If the key can't be recorded or the commit fails, the transaction is
+// aborted and the error returned.
+//
+// The check is made under the writer lock, so a caller may also look the
+// key up with First on the IdempotencyTable before doing any work, and rely
+// on CommitIdempotent to settle any race with a concurrent retry. Optimistic
+// transactions don't hold the writer lock until their commit, so they are
+// aborted with an error.
+//
+// This is a noop returning nil for read transactions.
+func (txn *Txn) CommitIdempotent(key string, result interface{}) (*IdempotencyRecord, bool, error) {
+	if !txn.write || txn.rootTxn == nil {
+		return nil, false, nil
+	}
+	if txn.optimistic {
+		txn.Abort()
+		return nil, false, fmt.Errorf("cannot commit idempotently in optimistic transaction")
+	}
+	if err := txn.checkWritable(IdempotencyTable); err != nil {
+		txn.Abort()
+		return nil, false, err
+	}
+
+	_, val, err := txn.getIndexValue(IdempotencyTable, id, key)
+	if err != nil {
+		txn.Abort()
+		return nil, false, err
+	}
+	indexTxn := txn.writableIndex(IdempotencyTable, id)
+	if existing, ok := indexTxn.Get(val); ok {
+		txn.Abort()
+		return existing.(*IdempotencyRecord), true, nil
+	}
+
+	// The writer lock is held, so the next commit index is known.
+	record := &IdempotencyRecord{
+		Key:    key,
+		Commit: atomic.LoadUint64(&txn.db.commitIndex) + 1,
+		Time:   txn.db.clock.Now(),
+		Result: result,
+	}
+	indexTxn.Insert(val, record)
+	if err := txn.TryCommit(); err != nil {
+		return nil, false, err
+	}
+	return record, false, nil
+}
+
+// startIdempotencyReaper starts the background job that deletes expired
+// idempotency records.
+func (db *MemDB) startIdempotencyReaper() {
+	db.startJob("idempotency reaper", db.idempotencyRetention, func(now time.Time) {
+		db.reapIdempotencyKeys(now.Add(-db.idempotencyRetention))
+	})
+}
+
+// reapIdempotencyKeys deletes the idempotency records committed before the
+// given time. It returns the number of records deleted.
+func (db *MemDB) reapIdempotencyKeys(before time.Time) int {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	indexTxn := txn.writableIndex(IdempotencyTable, id)
+	var expired [][]byte
+	iter := indexTxn.Root().Iterator()
+	for key, raw, ok := iter.Next(); ok; key, raw, ok = iter.Next() {
+		if raw.(*IdempotencyRecord).Time.Before(before) {
+			expired = append(expired, key)
+		}
+	}
+	if len(expired) == 0 {
+		return 0
+	}
+
+	for _, key := range expired {
+		indexTxn.Delete(key)
+	}
+	if err := txn.TryCommit(); err != nil {
+		db.logger.Warn("failed to delete expired idempotency keys", "error", err)
+		return 0
+	}
+	return len(expired)
+}
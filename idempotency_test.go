@@ -0,0 +1,96 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTxn_CommitIdempotent(t *testing.T) {
+	db := testDB(t)
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", testObj()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	record, dup, err := txn.CommitIdempotent("req-1", "ok")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if dup || record.Commit != 1 || record.Result != "ok" {
+		t.Fatalf("bad: %#v %v", record, dup)
+	}
+
+	// A retry with the same key is discarded
+	obj2 := testObj()
+	obj2.ID = "other"
+	txn = db.Txn(true)
+	if err := txn.Insert("main", obj2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	again, dup, err := txn.CommitIdempotent("req-1", "retry")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !dup || again != record {
+		t.Fatalf("bad: %#v %v", again, dup)
+	}
+
+	txn = db.Txn(false)
+	if raw, err := txn.First("main", "id", "other"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	raw, err := txn.First(IdempotencyTable, "id", "req-1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw != record {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// The table is read-only
+	wtxn := db.Txn(true)
+	defer wtxn.Abort()
+	if err := wtxn.Delete(IdempotencyTable, record); err == nil {
+		t.Fatalf("expected error")
+	}
+	wtxn.Abort()
+
+	// Transactions that can't write the table get an error
+	ttxn, err := db.TxnTables("main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, _, err := ttxn.CommitIdempotent("req-2", nil); err == nil {
+		t.Fatalf("expected error")
+	}
+	if raw, _ := db.Txn(false).First(IdempotencyTable, "id", "req-2"); raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+}
+
+func TestMemDB_IdempotencyRetention(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	db, err := NewMemDB(testValidSchema(), WithClock(clock), WithDeterministicMode(),
+		WithIdempotencyRetention(time.Minute))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer db.Close()
+
+	db.Txn(true).CommitIdempotent("old", nil)
+	clock.Advance(30 * time.Second)
+	db.Txn(true).CommitIdempotent("new", nil)
+
+	clock.Advance(45 * time.Second)
+	if n := db.Tick(); n != 1 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	txn := db.Txn(false)
+	if raw, _ := txn.First(IdempotencyTable, "id", "old"); raw != nil {
+		t.Fatalf("should be reaped: %#v", raw)
+	}
+	if raw, _ := txn.First(IdempotencyTable, "id", "new"); raw == nil {
+		t.Fatalf("should be kept")
+	}
+}
@@ -0,0 +1,269 @@
+package memdb
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// IDGenerator generates the primary keys of the objects of a table. When a
+// table has an IDGenerator, objects inserted with a zero value in their ID
+// field are assigned a new key before they are indexed. Objects of such a
+// table must be pointers to structs, since the key is set in place.
+//
+// IDGenerator 为表生成主键，插入主键为零值的对象时自动赋值。
+type IDGenerator interface {
+	// NextID returns a new key for an object of the table, as seen by the
+	// inserting transaction. The key must be assignable to the ID field,
+	// or be an integer when the field is an integer of another type.
+	NextID(txn *Txn, table string) (interface{}, error)
+}
+
+// idFieldName returns the name of the field holding the primary key of the
+// table's objects: IDField, or else the Field of the id index's indexer.
+func (s *TableSchema) idFieldName() string {
+	if s.IDField != "" {
+		return s.IDField
+	}
+	if idSchema, ok := s.Indexes[id]; ok && idSchema != nil {
+		return describeIndexer(idSchema.Indexer).Field
+	}
+	return ""
+}
+
+// validateIDGenerator checks that the ID field of a table with an
+// IDGenerator is known, and that the id index is in numeric order for the
+// generators deriving keys from the largest one.
+func (s *TableSchema) validateIDGenerator() error {
+	if s.idFieldName() == "" {
+		return fmt.Errorf("id generator requires an IDField, since the id index has no Field")
+	}
+	switch s.IDGenerator.(type) {
+	case *SequentialGenerator, *SequenceGenerator:
+		if idSchema, ok := s.Indexes[id]; ok && idSchema != nil && !isNumericIndexer(idSchema.Indexer) {
+			return fmt.Errorf("id generator requires an id index in numeric order, such as a UintFieldIndex")
+		}
+	}
+	return nil
+}
+
+// isNumericIndexer returns true for the integer indexers whose keys sort in
+// numeric order.
+func isNumericIndexer(indexer Indexer) bool {
+	switch indexer.(type) {
+	case *UintFieldIndex, *IntFieldIndexer:
+		return true
+	}
+	return false
+}
+
+// idField returns the settable field of obj holding its primary key.
+func (s *TableSchema) idField(obj interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("object must be a pointer to a struct to be assigned an id: %#v", obj)
+	}
+	name := s.idFieldName()
+	field := v.Elem().FieldByName(name)
+	if !field.IsValid() || !field.CanSet() {
+		return reflect.Value{}, fmt.Errorf("field '%s' for %#v is invalid", name, obj)
+	}
+	return field, nil
+}
+
+// assignID sets the primary key of obj from the table's IDGenerator if its
+// ID field has the zero value.
+func (txn *Txn) assignID(tableSchema *TableSchema, obj interface{}) error {
+	field, err := tableSchema.idField(obj)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+		return nil
+	}
+
+	raw, err := tableSchema.IDGenerator.NextID(txn, tableSchema.Name)
+	if err != nil {
+		return fmt.Errorf("failed to generate id: %v", err)
+	}
+	val := reflect.ValueOf(raw)
+	switch {
+	case val.IsValid() && val.Type().AssignableTo(field.Type()):
+		field.Set(val)
+	case isIntegerKind(val.Kind()) && isIntegerKind(field.Kind()):
+		field.Set(val.Convert(field.Type()))
+	default:
+		return fmt.Errorf("generated id %#v can't be assigned to field '%s'", raw, tableSchema.idFieldName())
+	}
+	return nil
+}
+
+// isIntegerKind returns true for the signed and unsigned integer kinds.
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// InsertID is like Insert, but also returns the primary key of the object.
+// For tables with an IDGenerator, this is the key that was assigned if the
+// object had none.
+func (txn *Txn) InsertID(table string, obj interface{}) (key interface{}, err error) {
+	defer txn.recoverPanic("insert", &err)
+	if err := txn.insert(table, obj); err != nil {
+		return nil, err
+	}
+	if err := txn.checkLimits(); err != nil {
+		return nil, err
+	}
+
+	tableSchema := txn.schema.Tables[table]
+	field, err := tableSchema.idField(obj)
+	if err != nil {
+		return nil, err
+	}
+	return field.Interface(), nil
+}
+
+// UUIDv7Generator generates time ordered UUIDs, version 7 of RFC 9562, as
+// strings suitable for a StringFieldIndex or UUIDFieldIndex. The timestamp
+// is taken from the Clock of the MemDB.
+type UUIDv7Generator struct {
+	// Rand is the source of the random bits. If nil, crypto/rand is used.
+	Rand io.Reader
+}
+
+func (g *UUIDv7Generator) NextID(txn *Txn, table string) (interface{}, error) {
+	var buf [16]byte
+	random := g.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+	if _, err := io.ReadFull(random, buf[6:]); err != nil {
+		return nil, err
+	}
+
+	ms := uint64(txn.db.clock.Now().UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		buf[i] = byte(ms >> uint(40-8*i))
+	}
+	buf[6] = buf[6]&0x0f | 0x70
+	buf[8] = buf[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%12x",
+		buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:16]), nil
+}
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+)
+
+// snowflakeEpoch is the default epoch of a SnowflakeGenerator.
+var snowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator generates time ordered uint64 keys made of the
+// milliseconds since Epoch, the Node and a sequence number, as 41, 10 and 12
+// bits, so that several MemDBs can generate keys that never collide. When
+// the sequence runs out within a millisecond, or the clock goes backwards,
+// the timestamp of the previous key is carried forward rather than waiting.
+// A SnowflakeGenerator is safe for concurrent use, and may be shared by
+// tables.
+type SnowflakeGenerator struct {
+	// Node identifies the generator, and must be less than 1024.
+	Node uint16
+
+	// Epoch is the time the timestamps are counted from. If zero, the
+	// start of 2020 is used.
+	Epoch time.Time
+
+	lock     sync.Mutex
+	last     int64
+	sequence uint64
+}
+
+func (g *SnowflakeGenerator) NextID(txn *Txn, table string) (interface{}, error) {
+	if g.Node >= 1<<snowflakeNodeBits {
+		return nil, fmt.Errorf("snowflake node %d out of range", g.Node)
+	}
+	epoch := g.Epoch
+	if epoch.IsZero() {
+		epoch = snowflakeEpoch
+	}
+	ms := int64(txn.db.clock.Now().Sub(epoch) / time.Millisecond)
+	if ms < 0 {
+		return nil, fmt.Errorf("clock is before the snowflake epoch")
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	switch {
+	case ms > g.last:
+		g.last = ms
+		g.sequence = 0
+	case g.sequence+1 < 1<<snowflakeSequenceBits:
+		g.sequence++
+	default:
+		g.last++
+		g.sequence = 0
+	}
+
+	return uint64(g.last)<<(snowflakeNodeBits+snowflakeSequenceBits) |
+		uint64(g.Node)<<snowflakeSequenceBits | g.sequence, nil
+}
+
+// SequentialGenerator generates integer keys one above the largest key of
+// the table, as seen by the inserting transaction, starting at Start, or at
+// 1 if Start is zero. The id index must order keys numerically, so it must
+// be a UintFieldIndex or an IntFieldIndexer. Since the keys are derived from the table's content,
+// they are never lost to aborted transactions, but the key of a deleted
+// last object may be handed out again.
+type SequentialGenerator struct {
+	Start uint64
+}
+
+func (g *SequentialGenerator) NextID(txn *Txn, table string) (interface{}, error) {
+	next := g.Start
+	if next == 0 {
+		next = 1
+	}
+
+	max, err := txn.lastIntegerID(table)
+	if err != nil {
+		return nil, err
+	}
+	if max >= next {
+		next = max + 1
+	}
+	return next, nil
+}
+
+// lastIntegerID returns the integer primary key of the last object of the
+// table, or zero if it is empty or the key is negative.
+func (txn *Txn) lastIntegerID(table string) (uint64, error) {
+	last, err := txn.Last(table, id)
+	if err != nil || last == nil {
+		return 0, err
+	}
+	field, err := txn.schema.Tables[table].idField(last)
+	if err != nil {
+		return 0, err
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.Int() > 0 {
+			return uint64(field.Int()), nil
+		}
+		return 0, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return field.Uint(), nil
+	default:
+		return 0, fmt.Errorf("sequential id requires an integer field, got %s", field.Type())
+	}
+}
@@ -0,0 +1,170 @@
+package memdb
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type testTicket struct {
+	ID    uint64
+	Title string
+}
+
+func testIDGenDB(t *testing.T, gen IDGenerator, indexer Indexer, opts ...Option) *MemDB {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"tickets": &TableSchema{
+				Name: "tickets",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: indexer,
+					},
+				},
+				IDGenerator: gen,
+			},
+		},
+	}
+	db, err := NewMemDB(schema, opts...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return db
+}
+
+func TestTxn_InsertID_Sequential(t *testing.T) {
+	db := testIDGenDB(t, &SequentialGenerator{}, &UintFieldIndex{Field: "ID"})
+
+	txn := db.Txn(true)
+	for i := uint64(1); i <= 3; i++ {
+		key, err := txn.InsertID("tickets", &testTicket{Title: "x"})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if key != i {
+			t.Fatalf("bad: %#v", key)
+		}
+	}
+
+	// Objects that have a key keep it, and later keys follow it
+	ticket := &testTicket{ID: 10}
+	if err := txn.Insert("tickets", ticket); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ticket = &testTicket{}
+	if err := txn.Insert("tickets", ticket); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ticket.ID != 11 {
+		t.Fatalf("bad: %#v", ticket)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	if raw, err := txn.First("tickets", "id", uint64(11)); err != nil || raw != ticket {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
+
+func TestTxn_InsertID_Snowflake(t *testing.T) {
+	clock := NewManualClock(snowflakeEpoch.Add(time.Second))
+	gen := &SnowflakeGenerator{Node: 3}
+	db := testIDGenDB(t, gen, &UintFieldIndex{Field: "ID"}, WithClock(clock))
+
+	txn := db.Txn(true)
+	var keys []uint64
+	for i := 0; i < 2; i++ {
+		key, err := txn.InsertID("tickets", &testTicket{})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		keys = append(keys, key.(uint64))
+	}
+	base := uint64(1000)<<22 | 3<<12
+	if keys[0] != base || keys[1] != base+1 {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// An exhausted sequence borrows the next millisecond
+	gen.sequence = 1<<12 - 1
+	key, err := txn.InsertID("tickets", &testTicket{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if key != uint64(1001)<<22|3<<12 {
+		t.Fatalf("bad: %#v", key)
+	}
+
+	if _, err := (&SnowflakeGenerator{Node: 1024}).NextID(txn, "tickets"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestTxn_InsertID_UUIDv7(t *testing.T) {
+	type doc struct {
+		UUID string
+	}
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	gen := &UUIDv7Generator{Rand: bytes.NewReader(bytes.Repeat([]byte{0xff}, 10))}
+	db := testIDGenDB(t, gen, &UUIDFieldIndex{Field: "UUID"}, WithClock(clock))
+
+	txn := db.Txn(true)
+	key, err := txn.InsertID("tickets", &doc{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if key != "018bcfe5-6800-7fff-bfff-ffffffffffff" {
+		t.Fatalf("bad: %#v", key)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(key.(string)) {
+		t.Fatalf("bad: %#v", key)
+	}
+
+	// The random source is exhausted
+	if err := txn.Insert("tickets", &doc{}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Objects must be pointers for the key to be assigned
+	if err := txn.Insert("tickets", doc{}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestTableSchema_Validate_IDGenerator(t *testing.T) {
+	s := &TableSchema{
+		Name: "main",
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &ConditionalIndex{Conditional: func(interface{}) (bool, error) { return true, nil }},
+			},
+		},
+		IDGenerator: &UUIDv7Generator{},
+	}
+	if err := s.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+	s.IDField = "ID"
+	if err := s.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Keys derived from the largest one need a numeric order
+	s.IDGenerator = &SequentialGenerator{}
+	if err := s.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+	s.Indexes["id"].Indexer = &IntFieldIndex{Field: "ID"}
+	if err := s.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+	s.Indexes["id"].Indexer = &IntFieldIndexer{Field: "ID"}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
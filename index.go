@@ -1,13 +1,20 @@
 package memdb
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/bits"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // Indexer is an interface used for defining indexes. Indexes are used
@@ -73,6 +80,24 @@ type PrefixIndexer interface {
 	PrefixFromArgs(args ...interface{}) ([]byte, error)
 }
 
+// IndexValidator is an optional interface on top of an Indexer that checks
+// its settings when the schema is validated. Indexers wrapping other
+// indexers validate them as well.
+//
+// IndexValidator 是可选接口，在校验 schema 时检查索引器的配置。
+type IndexValidator interface {
+	// Validate returns an error if the indexer is misconfigured.
+	Validate() error
+}
+
+// validateIndexer validates the indexer if it implements IndexValidator.
+func validateIndexer(indexer Indexer) error {
+	if v, ok := indexer.(IndexValidator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
 // StringFieldIndex is used to extract a field from an object
 // using reflection and builds an index on that field.
 type StringFieldIndex struct {
@@ -158,6 +183,110 @@ func (s *StringFieldIndex) PrefixFromArgs(args ...interface{}) ([]byte, error) {
 	return val, nil
 }
 
+// EscapedStringFieldIndex is like StringFieldIndex, but escapes the null
+// bytes of values so that they can hold arbitrary bytes. StringFieldIndex
+// ends values with a null byte, so a value containing one can collide with
+// another value, or show up in the exact matches of its own prefix. Here
+// each null byte is encoded as 0x00 0xff and values end with 0x00 0x01,
+// which keeps keys in the order of the values.
+//
+// This is the recommended string indexer for the sub-indexes of a
+// CompoundIndex, where a stray null byte would also shift the following
+// parts of the key.
+//
+// EscapedStringFieldIndex 对值中的空字节进行转义，任意字节的值都不会冲突。
+type EscapedStringFieldIndex struct {
+	Field     string
+	Lowercase bool
+
+	// FoldCase makes values that are equal under Unicode case folding, as
+	// compared by strings.EqualFold, share the same key. This goes further
+	// than Lowercase, which misses letters such as the long s "ſ". The
+	// objects keep the case they were inserted with.
+	FoldCase bool
+}
+
+func (s *EscapedStringFieldIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	v := reflect.ValueOf(obj)
+	v = reflect.Indirect(v) // Dereference the pointer if any
+
+	fv := v.FieldByName(s.Field)
+	isPtr := fv.Kind() == reflect.Ptr
+	fv = reflect.Indirect(fv)
+	if !isPtr && !fv.IsValid() {
+		return false, nil,
+			fmt.Errorf("field '%s' for %#v is invalid %v ", s.Field, obj, isPtr)
+	}
+	if isPtr && !fv.IsValid() {
+		return false, nil, nil
+	}
+
+	val := fv.String()
+	if val == "" {
+		return false, nil, nil
+	}
+	return true, s.encode(val, true), nil
+}
+
+func (s *EscapedStringFieldIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	return s.encode(arg, true), nil
+}
+
+func (s *EscapedStringFieldIndex) PrefixFromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	return s.encode(arg, false), nil
+}
+
+// encode escapes the null bytes of the value, and adds the terminator if
+// asked to.
+func (s *EscapedStringFieldIndex) encode(val string, terminate bool) []byte {
+	if s.Lowercase {
+		val = strings.ToLower(val)
+	}
+	if s.FoldCase {
+		val = foldCase(val)
+	}
+	out := make([]byte, 0, len(val)+2)
+	for i := 0; i < len(val); i++ {
+		out = append(out, val[i])
+		if val[i] == 0x00 {
+			out = append(out, 0xff)
+		}
+	}
+	if terminate {
+		out = append(out, 0x00, 0x01)
+	}
+	return out
+}
+
+// foldCase maps every rune of the string to the smallest rune it is equal
+// to under simple case folding, so that strings that are equal under
+// strings.EqualFold map to the same string.
+func foldCase(val string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, val)
+}
+
 // StringSliceFieldIndex builds an index from a field on an object that is a
 // string slice ([]string). Each value within the string slice can be used for
 // lookup.
@@ -325,7 +454,13 @@ func (s *StringMapFieldIndex) FromArgs(args ...interface{}) ([]byte, error) {
 }
 
 // IntFieldIndex is used to extract an int field from an object using
-// reflection and builds an index on that field.
+// reflection and builds an index on that field. Its values are varint
+// encoded, so they don't sort in numeric order; IntFieldIndexer should be
+// used for range queries.
+//
+// Deprecated: Use IntFieldIndexer, which follows the naming of the other
+// order-preserving indexers and supports range queries. IntFieldIndex is
+// kept so existing schemas keep building.
 type IntFieldIndex struct {
 	Field string
 }
@@ -397,6 +532,137 @@ func IsIntType(k reflect.Kind) (size int, okay bool) {
 	}
 }
 
+// IntFieldIndexer is used to extract an int field from an object using
+// reflection and builds an index on that field. Unlike IntFieldIndex, the
+// values are encoded as fixed size big endian integers with the sign bit
+// flipped, so the index is in numeric order, negative values first, and
+// LowerBound can be used for range queries. Values of every int type are
+// encoded alike, so arguments don't need to match the type of the field.
+//
+// IntFieldIndexer 为有符号整数字段建立按数值排序的索引，支持范围查询。
+type IntFieldIndexer struct {
+	Field string
+}
+
+func (i *IntFieldIndexer) FromObject(obj interface{}) (bool, []byte, error) {
+	v := reflect.ValueOf(obj)
+	v = reflect.Indirect(v) // Dereference the pointer if any
+
+	fv := v.FieldByName(i.Field)
+	if !fv.IsValid() {
+		return false, nil,
+			fmt.Errorf("field '%s' for %#v is invalid", i.Field, obj)
+	}
+
+	k := fv.Kind()
+	if _, ok := IsIntType(k); !ok {
+		return false, nil, fmt.Errorf("field %q is of type %v; want an int", i.Field, k)
+	}
+	return true, encodeInt(fv.Int()), nil
+}
+
+func (i *IntFieldIndexer) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+
+	v := reflect.ValueOf(args[0])
+	if !v.IsValid() {
+		return nil, fmt.Errorf("%#v is invalid", args[0])
+	}
+
+	k := v.Kind()
+	if _, ok := IsIntType(k); !ok {
+		return nil, fmt.Errorf("arg is of type %v; want an int", k)
+	}
+	return encodeInt(v.Int()), nil
+}
+
+// encodeInt returns the index value of an int, whose bytes sort in the
+// order of the ints.
+func encodeInt(val int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(val)^(1<<63))
+	return buf
+}
+
+// FloatFieldIndexer is used to extract a float field from an object using
+// reflection and builds an index on that field. The values are encoded so
+// that the index is in numeric order, from negative infinity to positive
+// infinity, and LowerBound can be used for range queries. Negative zero is
+// indexed as zero, and NaN can't be indexed. Int arguments are accepted as
+// well as floats.
+//
+// FloatFieldIndexer 为浮点数字段建立按数值排序的索引，支持范围查询。
+type FloatFieldIndexer struct {
+	Field string
+}
+
+func (f *FloatFieldIndexer) FromObject(obj interface{}) (bool, []byte, error) {
+	v := reflect.ValueOf(obj)
+	v = reflect.Indirect(v) // Dereference the pointer if any
+
+	fv := v.FieldByName(f.Field)
+	if !fv.IsValid() {
+		return false, nil,
+			fmt.Errorf("field '%s' for %#v is invalid", f.Field, obj)
+	}
+
+	k := fv.Kind()
+	if k != reflect.Float32 && k != reflect.Float64 {
+		return false, nil, fmt.Errorf("field %q is of type %v; want a float", f.Field, k)
+	}
+	val := fv.Float()
+	if math.IsNaN(val) {
+		return false, nil, fmt.Errorf("field %q is NaN", f.Field)
+	}
+	return true, encodeFloat(val), nil
+}
+
+func (f *FloatFieldIndexer) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+
+	v := reflect.ValueOf(args[0])
+	if !v.IsValid() {
+		return nil, fmt.Errorf("%#v is invalid", args[0])
+	}
+
+	var val float64
+	k := v.Kind()
+	if k == reflect.Float32 || k == reflect.Float64 {
+		val = v.Float()
+	} else if _, ok := IsIntType(k); ok {
+		val = float64(v.Int())
+	} else {
+		return nil, fmt.Errorf("arg is of type %v; want a float", k)
+	}
+	if math.IsNaN(val) {
+		return nil, fmt.Errorf("arg is NaN")
+	}
+	return encodeFloat(val), nil
+}
+
+// encodeFloat returns the index value of a float, whose bytes sort in the
+// order of the floats: the sign bit is flipped for positive floats, and
+// every bit for negative ones.
+func encodeFloat(val float64) []byte {
+	// Negative zero is indexed as zero
+	if val == 0 {
+		val = 0
+	}
+	u := math.Float64bits(val)
+	if u&(1<<63) != 0 {
+		u = ^u
+	} else {
+		u |= 1 << 63
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, u)
+	return buf
+}
+
 // UintFieldIndex is used to extract a uint field from an object using
 // reflection and builds an index on that field.
 type UintFieldIndex struct {
@@ -501,6 +767,14 @@ func (i *BoolFieldIndex) FromObject(obj interface{}) (bool, []byte, error) {
 			fmt.Errorf("field '%s' for %#v is invalid", i.Field, obj)
 	}
 
+	// A nil *bool has no value
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return false, nil, nil
+		}
+		fv = fv.Elem()
+	}
+
 	// Check the type
 	k := fv.Kind()
 	if k != reflect.Bool {
@@ -520,6 +794,150 @@ func (i *BoolFieldIndex) FromArgs(args ...interface{}) ([]byte, error) {
 	return fromBoolArgs(args)
 }
 
+// TimeFieldIndexer is used to extract a time.Time or *time.Time field from
+// an object using reflection and builds an index on that field. Times are
+// encoded with nanosecond precision, as the instant they denote whatever
+// their location, so the index is in chronological order and LowerBound can
+// be used to scan a window of time. A zero time or a nil pointer is treated
+// as missing.
+//
+// TimeFieldIndexer 为 time.Time 字段建立按时间先后排序的索引，支持范围查询。
+type TimeFieldIndexer struct {
+	Field string
+}
+
+func (t *TimeFieldIndexer) FromObject(obj interface{}) (bool, []byte, error) {
+	v := reflect.ValueOf(obj)
+	v = reflect.Indirect(v) // Dereference the pointer if any
+
+	fv := v.FieldByName(t.Field)
+	if !fv.IsValid() {
+		return false, nil,
+			fmt.Errorf("field '%s' for %#v is invalid", t.Field, obj)
+	}
+
+	// A nil *time.Time has no value
+	if fv.Kind() == reflect.Ptr && fv.Type().Elem() == timeType {
+		if fv.IsNil() {
+			return false, nil, nil
+		}
+		fv = fv.Elem()
+	}
+	if fv.Type() != timeType {
+		return false, nil, fmt.Errorf("field %q is of type %v; want a time.Time", t.Field, fv.Type())
+	}
+
+	val := fv.Interface().(time.Time)
+	if val.IsZero() {
+		return false, nil, nil
+	}
+	return true, encodeTime(val), nil
+}
+
+func (t *TimeFieldIndexer) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	switch arg := args[0].(type) {
+	case time.Time:
+		return encodeTime(arg), nil
+	case *time.Time:
+		if arg != nil {
+			return encodeTime(*arg), nil
+		}
+	}
+	return nil, fmt.Errorf("argument must be a time.Time: %#v", args[0])
+}
+
+// encodeTime returns the index value of a time: the seconds since the Unix
+// epoch, with the sign bit flipped so negative values sort first, followed
+// by the nanoseconds.
+func encodeTime(val time.Time) []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint64(buf, uint64(val.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(buf[8:], uint32(val.Nanosecond()))
+	return buf
+}
+
+// EnumFieldIndex is used to extract a string field from an object using
+// reflection and builds an index on that field, for fields restricted to a
+// set of Values such as a status. Inserting an object whose value isn't one
+// of the Values fails. Values are encoded as their position in Values, so
+// the index is ordered as the Values are declared. An empty value is
+// treated as missing, unless "" is one of the Values. The field may be of
+// a named string type.
+//
+// EnumFieldIndex 为取值限定在 Values 中的字段建立索引，插入时校验取值。
+type EnumFieldIndex struct {
+	Field  string
+	Values []string
+}
+
+func (e *EnumFieldIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	v := reflect.ValueOf(obj)
+	v = reflect.Indirect(v) // Dereference the pointer if any
+
+	fv := v.FieldByName(e.Field)
+	if !fv.IsValid() {
+		return false, nil,
+			fmt.Errorf("field '%s' for %#v is invalid", e.Field, obj)
+	}
+	if fv.Kind() != reflect.String {
+		return false, nil, fmt.Errorf("field %q is of type %v; want a string", e.Field, fv.Kind())
+	}
+
+	val := fv.String()
+	buf, err := e.encode(val)
+	if err != nil {
+		if val == "" {
+			return false, nil, nil
+		}
+		return false, nil, fmt.Errorf("field %q: %v", e.Field, err)
+	}
+	return true, buf, nil
+}
+
+func (e *EnumFieldIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	v := reflect.ValueOf(args[0])
+	if !v.IsValid() || v.Kind() != reflect.String {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	return e.encode(v.String())
+}
+
+// encode returns the position of the value in Values.
+func (e *EnumFieldIndex) encode(val string) ([]byte, error) {
+	for i, allowed := range e.Values {
+		if val == allowed {
+			buf := make([]byte, 2)
+			binary.BigEndian.PutUint16(buf, uint16(i))
+			return buf, nil
+		}
+	}
+	return nil, fmt.Errorf("value %q is not one of %q", val, e.Values)
+}
+
+// Validate checks that the Values can be encoded and are distinct.
+func (e *EnumFieldIndex) Validate() error {
+	if len(e.Values) == 0 {
+		return fmt.Errorf("enum index must have values")
+	}
+	if len(e.Values) > math.MaxUint16+1 {
+		return fmt.Errorf("enum index has too many values")
+	}
+	seen := make(map[string]bool, len(e.Values))
+	for _, val := range e.Values {
+		if seen[val] {
+			return fmt.Errorf("duplicate enum value %q", val)
+		}
+		seen[val] = true
+	}
+	return nil
+}
+
 // UUIDFieldIndex is used to extract a field from an object
 // using reflection and builds an index on that field by treating
 // it as a UUID. This is an optimization to using a StringFieldIndex
@@ -671,6 +1089,59 @@ func (c *ConditionalIndex) FromArgs(args ...interface{}) ([]byte, error) {
 	return fromBoolArgs(args)
 }
 
+// FuncIndexer builds a single-value index from user functions, so that an
+// index can be defined by closures, as when a schema is built at runtime,
+// rather than by a named type implementing SingleIndexer. FromObjectFn and
+// FromArgsFn have the meaning of the methods of the interface, and must
+// encode values the same way.
+//
+// 函数索引，由闭包定义单值索引
+type FuncIndexer struct {
+	FromObjectFn func(obj interface{}) (bool, []byte, error)
+	FromArgsFn   func(args ...interface{}) ([]byte, error)
+}
+
+func (f *FuncIndexer) FromObject(obj interface{}) (bool, []byte, error) {
+	return f.FromObjectFn(obj)
+}
+
+func (f *FuncIndexer) FromArgs(args ...interface{}) ([]byte, error) {
+	return f.FromArgsFn(args...)
+}
+
+// Validate checks that the functions are set.
+func (f *FuncIndexer) Validate() error {
+	if f.FromObjectFn == nil || f.FromArgsFn == nil {
+		return fmt.Errorf("missing index functions")
+	}
+	return nil
+}
+
+// FuncMultiIndexer is like FuncIndexer, but builds a multi-value index: the
+// FromObjectFn function may return any number of values for an object.
+//
+// 函数索引，由闭包定义多值索引
+type FuncMultiIndexer struct {
+	FromObjectFn func(obj interface{}) (bool, [][]byte, error)
+	FromArgsFn   func(args ...interface{}) ([]byte, error)
+}
+
+func (f *FuncMultiIndexer) FromObject(obj interface{}) (bool, [][]byte, error) {
+	return f.FromObjectFn(obj)
+}
+
+func (f *FuncMultiIndexer) FromArgs(args ...interface{}) ([]byte, error) {
+	return f.FromArgsFn(args...)
+}
+
+// Validate checks that the functions are set.
+func (f *FuncMultiIndexer) Validate() error {
+	if f.FromObjectFn == nil || f.FromArgsFn == nil {
+		return fmt.Errorf("missing index functions")
+	}
+	return nil
+}
+
 // fromBoolArgs is a helper that expects only a single boolean argument and
 // returns a single length byte array containing either a one or zero depending
 // on whether the passed input is true or false respectively.
@@ -693,10 +1164,378 @@ func fromBoolArgs(args []interface{}) ([]byte, error) {
 	return []byte{0}, nil
 }
 
+// HMACIndex wraps a SingleIndexer and replaces the values it produces with
+// their HMAC-SHA256 under Key. Exact lookups keep working since the arguments
+// go through the same transformation, but the raw field values don't appear
+// as plaintext in the keys of the index. Prefix scans and range queries are
+// not supported since the hashed keys have no order.
+//
+// Only the index keys are hashed. The objects themselves are stored as they
+// were inserted, so memory dumps, snapshots and exports still hold the
+// plaintext values.
+//
+// HMACIndex 对索引值做 HMAC ，仍可进行等值查询，但索引键中不会出现明文。
+type HMACIndex struct {
+	Indexer Indexer
+	Key     []byte
+}
+
+func (h *HMACIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	indexer, ok := h.Indexer.(SingleIndexer)
+	if !ok {
+		return false, nil, fmt.Errorf("wrapped indexer must be a SingleIndexer")
+	}
+
+	ok, val, err := indexer.FromObject(obj)
+	if err != nil || !ok {
+		return ok, nil, err
+	}
+	return true, h.sum(val), nil
+}
+
+func (h *HMACIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	val, err := h.Indexer.FromArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	return h.sum(val), nil
+}
+
+// Validate checks that the wrapped indexer is a valid SingleIndexer.
+func (h *HMACIndex) Validate() error {
+	if _, ok := h.Indexer.(SingleIndexer); !ok {
+		return fmt.Errorf("wrapped indexer must be a SingleIndexer")
+	}
+	return validateIndexer(h.Indexer)
+}
+
+// sum returns the keyed hash of the given index value.
+func (h *HMACIndex) sum(val []byte) []byte {
+	mac := hmac.New(sha256.New, h.Key)
+	mac.Write(val)
+	return mac.Sum(nil)
+}
+
+// FieldPathIndexer indexes a field nested in the objects, such as the City
+// of the Address of a customer, by following the dotted Path to it. Each
+// element of the path names a field as with reflect.Value.FieldByName, so
+// fields promoted from embedded structs can be named directly, and the
+// pointers and interfaces along the way are followed. An object is missing
+// from the index if a pointer on the path is nil.
+//
+// If Indexer is nil, the field at the end of the path must be a string,
+// indexed as with a StringFieldIndex. Otherwise the path leads to a nested
+// struct which the SingleIndexer Indexer indexes, so any field indexer can
+// be used for nested fields, as in
+//
+//	&FieldPathIndexer{Path: "Address", Indexer: &IntFieldIndexer{Field: "Zip"}}
+//
+// FieldPathIndexer 按点分隔的路径（如 "Address.City"）为嵌套字段建立索引。
+type FieldPathIndexer struct {
+	Path    string
+	Indexer Indexer
+}
+
+func (f *FieldPathIndexer) FromObject(obj interface{}) (bool, []byte, error) {
+	var single SingleIndexer
+	if f.Indexer != nil {
+		var ok bool
+		if single, ok = f.Indexer.(SingleIndexer); !ok {
+			return false, nil, fmt.Errorf("wrapped indexer must be a SingleIndexer")
+		}
+	}
+
+	v := reflect.ValueOf(obj)
+	for _, name := range strings.Split(f.Path, ".") {
+		if v = derefField(v); !v.IsValid() {
+			return false, nil, nil
+		}
+		if v.Kind() != reflect.Struct {
+			return false, nil, fmt.Errorf("field path '%s' for %#v goes through a %v at '%s'", f.Path, obj, v.Kind(), name)
+		}
+		field, ok := v.Type().FieldByName(name)
+		if !ok {
+			return false, nil, fmt.Errorf("field '%s' of path '%s' for %#v is invalid", name, f.Path, obj)
+		}
+
+		// Promoted fields are reached through the embedded structs, which
+		// may be nil pointers
+		for i, index := range field.Index {
+			if i > 0 {
+				if v = derefField(v); !v.IsValid() {
+					return false, nil, nil
+				}
+			}
+			v = v.Field(index)
+		}
+	}
+	if v = derefField(v); !v.IsValid() {
+		return false, nil, nil
+	}
+
+	if single == nil {
+		if v.Kind() != reflect.String {
+			return false, nil, fmt.Errorf("field path '%s' for %#v leads to a %v; want a string", f.Path, obj, v.Kind())
+		}
+		val := v.String()
+		if val == "" {
+			return false, nil, nil
+		}
+		return true, []byte(val + "\x00"), nil
+	}
+	if !v.CanInterface() {
+		return false, nil, fmt.Errorf("field path '%s' for %#v goes through an unexported field", f.Path, obj)
+	}
+	return single.FromObject(v.Interface())
+}
+
+func (f *FieldPathIndexer) FromArgs(args ...interface{}) ([]byte, error) {
+	return f.indexer().FromArgs(args...)
+}
+
+func (f *FieldPathIndexer) PrefixFromArgs(args ...interface{}) ([]byte, error) {
+	prefix, ok := f.indexer().(PrefixIndexer)
+	if !ok {
+		return nil, fmt.Errorf("wrapped indexer doesn't support prefix queries")
+	}
+	return prefix.PrefixFromArgs(args...)
+}
+
+// derefField follows the pointers and interfaces holding a value, and
+// returns the zero Value if one of them is nil.
+func derefField(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// indexer returns the indexer of the value the path leads to. String
+// fields are encoded as by a StringFieldIndex.
+func (f *FieldPathIndexer) indexer() Indexer {
+	if f.Indexer != nil {
+		return f.Indexer
+	}
+	return &StringFieldIndex{}
+}
+
+// Validate checks that the path names fields, and the wrapped indexer if
+// any.
+func (f *FieldPathIndexer) Validate() error {
+	for _, name := range strings.Split(f.Path, ".") {
+		if name == "" {
+			return fmt.Errorf("invalid field path '%s'", f.Path)
+		}
+	}
+	if f.Indexer == nil {
+		return nil
+	}
+	return validateIndexer(f.Indexer)
+}
+
+// JSONPathIndexer indexes a value of semi-structured objects, which are
+// either decoded JSON documents of type map[string]interface{} or raw JSON
+// of type []byte or json.RawMessage. Path selects the value with a subset of
+// JSONPath: an optional leading "$", then member names, each after a dot or
+// quoted in brackets, and array elements by index, as in
+// "$.address.city", "$.tags[0]" or "$['first name']".
+//
+// Strings, numbers and booleans can be indexed, and are looked up with
+// arguments of the matching Go type: a string, any integer or float type,
+// or a bool. Values of different JSON types never match each other, and
+// numbers are compared and ordered as float64 values, as encoding/json
+// decodes them. A document where the path leads nowhere, or
+// to null, is missing from the index. Prefix queries match strings.
+//
+// JSONPathIndexer 使用 JSONPath 风格的表达式为 map 或 JSON 文档中的值建立索引。
+type JSONPathIndexer struct {
+	Path string
+}
+
+// Type tags of the values of a JSONPathIndexer, which keep the values of
+// different types apart.
+const (
+	jsonBool   byte = 1
+	jsonNumber byte = 2
+	jsonString byte = 3
+)
+
+func (j *JSONPathIndexer) FromObject(obj interface{}) (bool, []byte, error) {
+	path, err := parseJSONPath(j.Path)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var doc interface{}
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		doc = v
+	case json.RawMessage:
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return false, nil, fmt.Errorf("invalid JSON document: %v", err)
+		}
+	case []byte:
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return false, nil, fmt.Errorf("invalid JSON document: %v", err)
+		}
+	default:
+		return false, nil, fmt.Errorf("object %#v is not a JSON document", obj)
+	}
+
+	for _, step := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			if step.name == nil {
+				return false, nil, nil
+			}
+			doc = node[*step.name]
+		case []interface{}:
+			if step.name != nil || step.index >= len(node) {
+				return false, nil, nil
+			}
+			doc = node[step.index]
+		default:
+			return false, nil, nil
+		}
+	}
+
+	switch v := doc.(type) {
+	case nil:
+		return false, nil, nil
+	case map[string]interface{}, []interface{}:
+		return false, nil, fmt.Errorf("path '%s' leads to a %T; want a string, a number or a bool", j.Path, v)
+	}
+	val, err := encodeJSONValue(doc)
+	return err == nil, val, err
+}
+
+func (j *JSONPathIndexer) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	return encodeJSONValue(args[0])
+}
+
+func (j *JSONPathIndexer) PrefixFromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	return append([]byte{jsonString}, arg...), nil
+}
+
+// Validate checks that the path can be parsed.
+func (j *JSONPathIndexer) Validate() error {
+	_, err := parseJSONPath(j.Path)
+	return err
+}
+
+// encodeJSONValue returns the index value of a JSON string, number or bool,
+// or of an argument of the matching Go type.
+func encodeJSONValue(val interface{}) ([]byte, error) {
+	v := reflect.ValueOf(val)
+	switch {
+	case v.Kind() == reflect.String:
+		return append(append([]byte{jsonString}, v.String()...), 0), nil
+	case v.Kind() == reflect.Bool:
+		if v.Bool() {
+			return []byte{jsonBool, 1}, nil
+		}
+		return []byte{jsonBool, 0}, nil
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		return encodeJSONNumber(v.Float()), nil
+	}
+	if _, ok := IsIntType(v.Kind()); ok {
+		return encodeJSONNumber(float64(v.Int())), nil
+	}
+	if _, ok := IsUintType(v.Kind()); ok {
+		return encodeJSONNumber(float64(v.Uint())), nil
+	}
+	return nil, fmt.Errorf("argument must be a string, a number or a bool: %#v", val)
+}
+
+// encodeJSONNumber returns the index value of a number, whose bytes sort in
+// the order of the numbers.
+func encodeJSONNumber(f float64) []byte {
+	return append([]byte{jsonNumber}, encodeFloat(f)...)
+}
+
+// jsonPathStep is a step of a JSON path: a member name, or an array index if
+// name is nil.
+type jsonPathStep struct {
+	name  *string
+	index int
+}
+
+// parseJSONPath parses the path of a JSONPathIndexer.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	invalid := func() ([]jsonPathStep, error) {
+		return nil, fmt.Errorf("invalid JSON path '%s'", path)
+	}
+
+	rest := strings.TrimPrefix(path, "$")
+	var steps []jsonPathStep
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return invalid()
+			}
+			steps = append(steps, jsonPathStep{name: &name})
+			rest = rest[end+1:]
+
+		case strings.HasPrefix(rest, "['") || strings.HasPrefix(rest, "[\""):
+			quote := rest[1]
+			end := strings.IndexByte(rest[2:], quote)
+			if end < 0 || !strings.HasPrefix(rest[end+3:], "]") {
+				return invalid()
+			}
+			name := rest[2 : end+2]
+			steps = append(steps, jsonPathStep{name: &name})
+			rest = rest[end+4:]
+
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return invalid()
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return invalid()
+			}
+			steps = append(steps, jsonPathStep{index: index})
+			rest = rest[end+1:]
+
+		case len(steps) == 0 && !strings.HasPrefix(path, "$"):
+			// A path may start with a bare member name
+			rest = "." + rest
+
+		default:
+			return invalid()
+		}
+	}
+	if len(steps) == 0 {
+		return invalid()
+	}
+	return steps, nil
+}
+
 // CompoundIndex is used to build an index using multiple sub-indexes
 // Prefix based iteration is supported as long as the appropriate prefix
 // of indexers support it. All sub-indexers are only assumed to expect
-// a single argument.
+// a single argument. String parts should use an EscapedStringFieldIndex
+// unless their values can't contain null bytes.
 type CompoundIndex struct {
 	Indexes []Indexer
 
@@ -705,8 +1544,49 @@ type CompoundIndex struct {
 	// indexed which might be useful for an index scan. Otherwise,
 	// the CompoundIndex requires all indexers to be satisfied.
 	AllowMissing bool
+
+	// Nullable, if set, holds for each sub-index whether a missing value is
+	// encoded as an explicit null instead, so the following sub-indexes are
+	// still indexed. The values of a nullable sub-index are tagged: nulls
+	// sort before any value, so a scan of the index returns the objects
+	// missing the field first. A nil argument to FromArgs and
+	// PrefixFromArgs looks up the null of a nullable sub-index. Sub-indexes
+	// past the end of Nullable aren't nullable.
+	//
+	// 每个子索引是否将缺失值编码为 null
+	Nullable []bool
+}
+
+// nullable returns true if missing values of the sub-index are encoded as
+// null.
+func (c *CompoundIndex) nullable(i int) bool {
+	return i < len(c.Nullable) && c.Nullable[i]
+}
+
+// Validate checks the settings of the index and its sub-indexes.
+func (c *CompoundIndex) Validate() error {
+	if len(c.Nullable) > len(c.Indexes) {
+		return fmt.Errorf("more nullable flags than sub-indexes")
+	}
+	return validateSubIndexes(c.Indexes)
 }
 
+// validateSubIndexes validates the sub-indexes of a compound index.
+func validateSubIndexes(indexes []Indexer) error {
+	for i, idx := range indexes {
+		if err := validateIndexer(idx); err != nil {
+			return fmt.Errorf("sub-index %d error: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Tags of the values of nullable sub-indexes.
+const (
+	compoundNull  byte = 0x00
+	compoundValue byte = 0x01
+)
+
 func (c *CompoundIndex) FromObject(raw interface{}) (bool, []byte, error) {
 	var out []byte
 	for i, idxRaw := range c.Indexes {
@@ -718,6 +1598,15 @@ func (c *CompoundIndex) FromObject(raw interface{}) (bool, []byte, error) {
 		if err != nil {
 			return false, nil, fmt.Errorf("sub-index %d error: %v", i, err)
 		}
+		if c.nullable(i) {
+			if ok {
+				out = append(out, compoundValue)
+				out = append(out, val...)
+			} else {
+				out = append(out, compoundNull)
+			}
+			continue
+		}
 		if !ok {
 			if c.AllowMissing {
 				break
@@ -736,15 +1625,34 @@ func (c *CompoundIndex) FromArgs(args ...interface{}) ([]byte, error) {
 	}
 	var out []byte
 	for i, arg := range args {
-		val, err := c.Indexes[i].FromArgs(arg)
+		val, err := c.partFromArgs(i, arg)
 		if err != nil {
-			return nil, fmt.Errorf("sub-index %d error: %v", i, err)
+			return nil, err
 		}
 		out = append(out, val...)
 	}
 	return out, nil
 }
 
+// partFromArgs encodes the argument of a sub-index, tagged if it's nullable.
+func (c *CompoundIndex) partFromArgs(i int, arg interface{}) ([]byte, error) {
+	if !c.nullable(i) {
+		val, err := c.Indexes[i].FromArgs(arg)
+		if err != nil {
+			return nil, fmt.Errorf("sub-index %d error: %v", i, err)
+		}
+		return val, nil
+	}
+	if arg == nil {
+		return []byte{compoundNull}, nil
+	}
+	val, err := c.Indexes[i].FromArgs(arg)
+	if err != nil {
+		return nil, fmt.Errorf("sub-index %d error: %v", i, err)
+	}
+	return append([]byte{compoundValue}, val...), nil
+}
+
 func (c *CompoundIndex) PrefixFromArgs(args ...interface{}) ([]byte, error) {
 	if len(args) > len(c.Indexes) {
 		return nil, fmt.Errorf("more arguments than index fields")
@@ -752,12 +1660,17 @@ func (c *CompoundIndex) PrefixFromArgs(args ...interface{}) ([]byte, error) {
 	var out []byte
 	for i, arg := range args {
 		if i+1 < len(args) {
-			val, err := c.Indexes[i].FromArgs(arg)
+			val, err := c.partFromArgs(i, arg)
 			if err != nil {
-				return nil, fmt.Errorf("sub-index %d error: %v", i, err)
+				return nil, err
 			}
 			out = append(out, val...)
+		} else if c.nullable(i) && arg == nil {
+			out = append(out, compoundNull)
 		} else {
+			if c.nullable(i) {
+				out = append(out, compoundValue)
+			}
 			prefixIndexer, ok := c.Indexes[i].(PrefixIndexer)
 			if !ok {
 				return nil, fmt.Errorf("sub-index %d does not support prefix scanning", i)
@@ -812,6 +1725,11 @@ type CompoundMultiIndex struct {
 	AllowMissing bool
 }
 
+// Validate checks the sub-indexes.
+func (c *CompoundMultiIndex) Validate() error {
+	return validateSubIndexes(c.Indexes)
+}
+
 func (c *CompoundMultiIndex) FromObject(raw interface{}) (bool, [][]byte, error) {
 	// At each entry, builder is storing the results from the next index
 	builder := make([][][]byte, 0, len(c.Indexes))
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 type TestObject struct {
@@ -198,6 +201,89 @@ func TestStringFieldIndex_PrefixFromArgs(t *testing.T) {
 	}
 }
 
+func TestEscapedStringFieldIndex(t *testing.T) {
+	obj := testObj()
+	obj.Foo = "a\x00b"
+	indexer := EscapedStringFieldIndex{Field: "Foo"}
+
+	ok, val, err := indexer.FromObject(obj)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || string(val) != "a\x00\xffb\x00\x01" {
+		t.Fatalf("bad: %q", val)
+	}
+
+	ok, _, err = (&EscapedStringFieldIndex{Field: "Empty"}).FromObject(obj)
+	if err != nil || ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	if _, _, err := (&EscapedStringFieldIndex{Field: "NA"}).FromObject(obj); err == nil {
+		t.Fatalf("should get error")
+	}
+
+	// No encoded value is a prefix of another one, and the order of the
+	// values is kept
+	values := []string{"a", "a\x00", "a\x00\x00", "a\x01", "ab"}
+	var prev []byte
+	for _, value := range values {
+		val, err := indexer.FromArgs(value)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if prev != nil && (bytes.Compare(prev, val) >= 0 || bytes.HasPrefix(val, prev)) {
+			t.Fatalf("bad: %q %q", prev, val)
+		}
+		prev = val
+	}
+
+	prefix, err := indexer.PrefixFromArgs("a\x00")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(prefix) != "a\x00\xff" {
+		t.Fatalf("bad: %q", prefix)
+	}
+	if _, err := indexer.FromArgs(1); err == nil {
+		t.Fatalf("should get error")
+	}
+
+	// Compound keys can't be confused by null bytes in their parts
+	compound := &CompoundIndex{
+		Indexes: []Indexer{
+			&EscapedStringFieldIndex{Field: "Foo"},
+			&EscapedStringFieldIndex{Field: "Baz"},
+		},
+	}
+	a, _ := compound.FromArgs("a\x00", "b")
+	b, _ := compound.FromArgs("a", "\x00b")
+	if bytes.Equal(a, b) {
+		t.Fatalf("bad: %q", a)
+	}
+}
+
+func TestEscapedStringFieldIndex_FoldCase(t *testing.T) {
+	indexer := EscapedStringFieldIndex{Field: "Foo", FoldCase: true}
+	a, err := indexer.FromArgs("Straſse")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b, err := indexer.FromArgs("STRASSE")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatalf("bad: %q %q", a, b)
+	}
+
+	lower := EscapedStringFieldIndex{Field: "Foo", Lowercase: true}
+	a, _ = lower.FromArgs("Straſse")
+	b, _ = lower.FromArgs("STRASSE")
+	if bytes.Equal(a, b) {
+		t.Fatalf("bad: %q %q", a, b)
+	}
+}
+
 func TestStringSliceFieldIndex_FromObject(t *testing.T) {
 	obj := testObj()
 
@@ -950,6 +1036,162 @@ func compareEncoded(t *testing.T, l interface{}, r interface{}, expected int) {
 	}
 }
 
+func TestIntFieldIndexer(t *testing.T) {
+	type row struct {
+		Int   int
+		Int8  int8
+		Float float64
+	}
+	indexer := &IntFieldIndexer{Field: "Int"}
+
+	// Keys sort in numeric order, whatever the int type
+	var prev []byte
+	for _, arg := range []interface{}{math.MinInt64, int8(-100), int32(-1), 0, int16(1), 300, int64(math.MaxInt64)} {
+		key, err := indexer.FromArgs(arg)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			t.Fatalf("bad order at %v: %v %v", arg, prev, key)
+		}
+		prev = key
+	}
+
+	ok, val, err := (&IntFieldIndexer{Field: "Int8"}).FromObject(&row{Int8: -3})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected, err := indexer.FromArgs(-3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || !bytes.Equal(val, expected) {
+		t.Fatalf("bad: %v %v", ok, val)
+	}
+
+	for _, field := range []string{"Float", "Nope"} {
+		if _, _, err := (&IntFieldIndexer{Field: field}).FromObject(&row{}); err == nil {
+			t.Fatalf("should get error: %s", field)
+		}
+	}
+	if _, err := indexer.FromArgs(uint(1)); err == nil {
+		t.Fatalf("should get error")
+	}
+}
+
+func TestFloatFieldIndexer(t *testing.T) {
+	type row struct {
+		Float   float64
+		Float32 float32
+		Int     int
+	}
+	indexer := &FloatFieldIndexer{Field: "Float"}
+
+	// Keys sort in numeric order
+	var prev []byte
+	for _, arg := range []interface{}{math.Inf(-1), -math.MaxFloat64, -2, -1.5, -math.SmallestNonzeroFloat64, 0.0, math.SmallestNonzeroFloat64, float32(0.5), 1, math.MaxFloat64, math.Inf(1)} {
+		key, err := indexer.FromArgs(arg)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			t.Fatalf("bad order at %v: %v %v", arg, prev, key)
+		}
+		prev = key
+	}
+
+	// Negative zero and ints match the floats
+	zero, err := indexer.FromArgs(0.0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ok, val, err := indexer.FromObject(&row{Float: math.Copysign(0, -1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || !bytes.Equal(val, zero) {
+		t.Fatalf("bad: %v %v", ok, val)
+	}
+	ok, val, err = (&FloatFieldIndexer{Field: "Float32"}).FromObject(&row{Float32: 2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	two, err := indexer.FromArgs(2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || !bytes.Equal(val, two) {
+		t.Fatalf("bad: %v %v", ok, val)
+	}
+
+	if _, _, err := indexer.FromObject(&row{Float: math.NaN()}); err == nil {
+		t.Fatalf("should get error")
+	}
+	if _, err := indexer.FromArgs(math.NaN()); err == nil {
+		t.Fatalf("should get error")
+	}
+	if _, _, err := (&FloatFieldIndexer{Field: "Int"}).FromObject(&row{}); err == nil {
+		t.Fatalf("should get error")
+	}
+	if _, err := indexer.FromArgs("1"); err == nil {
+		t.Fatalf("should get error")
+	}
+}
+
+func TestFloatFieldIndexer_Range(t *testing.T) {
+	type reading struct {
+		ID    string
+		Value float64
+	}
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"readings": &TableSchema{
+				Name: "readings",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"value": &IndexSchema{
+						Name:    "value",
+						Indexer: &FloatFieldIndexer{Field: "Value"},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for id, value := range map[string]float64{"a": -10, "b": -0.5, "c": 0, "d": 2.5, "e": 100} {
+		if err := txn.Insert("readings", &reading{ID: id, Value: value}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Scan the readings from -1 up to 10
+	txn = db.Txn(false)
+	iter, err := txn.LowerBound("readings", "value", -1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var got []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		obj := raw.(*reading)
+		if obj.Value >= 10 {
+			break
+		}
+		got = append(got, obj.ID)
+	}
+	if strings.Join(got, ",") != "b,c,d" {
+		t.Fatalf("bad: %v", got)
+	}
+}
+
 func TestBoolFieldIndex_FromObject(t *testing.T) {
 	obj := testObj()
 	indexer := BoolFieldIndex{Field: "Bool"}
@@ -989,6 +1231,197 @@ func TestBoolFieldIndex_FromObject(t *testing.T) {
 	if err == nil {
 		t.Fatalf("should get error")
 	}
+
+	// Pointers are dereferenced, and nil ones are missing
+	indexer = BoolFieldIndex{Field: "Bam"}
+	obj.Bam = nil
+	ok, val, err = indexer.FromObject(obj)
+	if err != nil || ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	obj.Bam = &obj.Bool
+	ok, val, err = indexer.FromObject(obj)
+	if err != nil || !ok || len(val) != 1 || val[0] != 1 {
+		t.Fatalf("bad: %v %v %v", ok, val, err)
+	}
+}
+
+func TestEnumFieldIndex(t *testing.T) {
+	type status string
+	type job struct {
+		Status status
+	}
+	indexer := &EnumFieldIndex{Field: "Status", Values: []string{"pending", "running", "done"}}
+
+	ok, val, err := indexer.FromObject(&job{Status: "running"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || !bytes.Equal(val, []byte{0, 1}) {
+		t.Fatalf("bad: %v %v", ok, val)
+	}
+
+	ok, _, err = indexer.FromObject(&job{})
+	if err != nil || ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	if _, _, err := indexer.FromObject(&job{Status: "lost"}); err == nil {
+		t.Fatalf("should get error")
+	}
+
+	val, err = indexer.FromArgs(status("done"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(val, []byte{0, 2}) {
+		t.Fatalf("bad: %v", val)
+	}
+	if _, err := indexer.FromArgs("lost"); err == nil {
+		t.Fatalf("should get error")
+	}
+	if _, err := indexer.FromArgs(1); err == nil {
+		t.Fatalf("should get error")
+	}
+
+	for _, bad := range []*EnumFieldIndex{
+		&EnumFieldIndex{Field: "Status"},
+		&EnumFieldIndex{Field: "Status", Values: []string{"a", "a"}},
+	} {
+		if err := (&IndexSchema{Name: "status", Indexer: bad}).Validate(); err == nil {
+			t.Fatalf("should not validate: %#v", bad)
+		}
+	}
+}
+
+func TestTimeFieldIndexer(t *testing.T) {
+	type event struct {
+		At     time.Time
+		Ends   *time.Time
+		Name   string
+		Number int
+	}
+	indexer := &TimeFieldIndexer{Field: "At"}
+
+	// The location doesn't matter, only the instant
+	at := time.Date(2020, 5, 1, 12, 0, 0, 5, time.UTC)
+	ok, val, err := indexer.FromObject(&event{At: at.In(time.FixedZone("x", 3600))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok {
+		t.Fatalf("should be ok")
+	}
+	expected, err := indexer.FromArgs(at)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(val, expected) {
+		t.Fatalf("bad: %v %v", val, expected)
+	}
+
+	// Keys sort chronologically, including before the epoch
+	times := []time.Time{
+		time.Date(1, 1, 1, 0, 0, 0, 1, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 999999999, time.UTC),
+		time.Unix(0, 0),
+		time.Unix(0, 1),
+		at,
+		time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	var prev []byte
+	for _, tm := range times {
+		key, err := indexer.FromArgs(&tm)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			t.Fatalf("bad order at %v: %v %v", tm, prev, key)
+		}
+		prev = key
+	}
+
+	// Zero times and nil pointers are missing
+	if ok, _, err := indexer.FromObject(&event{}); err != nil || ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	ptr := &TimeFieldIndexer{Field: "Ends"}
+	if ok, _, err := ptr.FromObject(&event{}); err != nil || ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	if ok, val, err := ptr.FromObject(&event{Ends: &at}); err != nil || !ok || !bytes.Equal(val, expected) {
+		t.Fatalf("bad: %v %v %v", ok, val, err)
+	}
+
+	for _, field := range []string{"Name", "Number", "Nope"} {
+		if _, _, err := (&TimeFieldIndexer{Field: field}).FromObject(&event{}); err == nil {
+			t.Fatalf("should get error: %s", field)
+		}
+	}
+	if _, err := indexer.FromArgs("2020-05-01"); err == nil {
+		t.Fatalf("should get error")
+	}
+	if _, err := indexer.FromArgs((*time.Time)(nil)); err == nil {
+		t.Fatalf("should get error")
+	}
+}
+
+func TestTimeFieldIndexer_Range(t *testing.T) {
+	type event struct {
+		ID string
+		At time.Time
+	}
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"events": &TableSchema{
+				Name: "events",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"at": &IndexSchema{
+						Name:    "at",
+						Indexer: &TimeFieldIndexer{Field: "At"},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	txn := db.Txn(true)
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		at := base.Add(time.Duration(i) * time.Hour)
+		if err := txn.Insert("events", &event{ID: id, At: at}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Scan the events from 01:00 to 03:00, given in another time zone
+	txn = db.Txn(false)
+	zone := time.FixedZone("x", -5*3600)
+	start, end := base.Add(time.Hour).In(zone), base.Add(3*time.Hour)
+	iter, err := txn.LowerBound("events", "at", start)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var got []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		obj := raw.(*event)
+		if !obj.At.Before(end) {
+			break
+		}
+		got = append(got, obj.ID)
+	}
+	if strings.Join(got, ",") != "b,c" {
+		t.Fatalf("bad: %v", got)
+	}
 }
 
 func TestBoolFieldIndex_FromArgs(t *testing.T) {
@@ -1189,6 +1622,104 @@ func TestConditionalIndex_FromArgs(t *testing.T) {
 	}
 }
 
+func TestFuncIndexer(t *testing.T) {
+	// Index the length of Foo, and the first letter of each Qux
+	length := &FuncIndexer{
+		FromObjectFn: func(obj interface{}) (bool, []byte, error) {
+			return true, encodeInt(int64(len(obj.(*TestObject).Foo))), nil
+		},
+		FromArgsFn: func(args ...interface{}) ([]byte, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("must provide only a single argument")
+			}
+			return encodeInt(int64(args[0].(int))), nil
+		},
+	}
+	initials := &FuncMultiIndexer{
+		FromObjectFn: func(obj interface{}) (bool, [][]byte, error) {
+			var vals [][]byte
+			for _, s := range obj.(*TestObject).Qux {
+				if s != "" {
+					vals = append(vals, []byte{s[0]})
+				}
+			}
+			return len(vals) != 0, vals, nil
+		},
+		FromArgsFn: func(args ...interface{}) ([]byte, error) {
+			return []byte(args[0].(string)), nil
+		},
+	}
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"main": &TableSchema{
+				Name: "main",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"length": &IndexSchema{
+						Name:    "length",
+						Indexer: length,
+					},
+					"initials": &IndexSchema{
+						Name:         "initials",
+						AllowMissing: true,
+						Indexer:      initials,
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "1", Foo: "abc", Qux: []string{"xy", "z"}},
+		&TestObject{ID: "2", Foo: "ab", Qux: []string{"yz"}},
+		&TestObject{ID: "3", Foo: "de"},
+	} {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	raw, err := txn.First("main", "length", 3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw == nil || raw.(*TestObject).ID != "1" {
+		t.Fatalf("bad: %#v", raw)
+	}
+	iter, err := txn.Get("main", "initials", "y")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		ids = append(ids, raw.(*TestObject).ID)
+	}
+	if strings.Join(ids, ",") != "2" {
+		t.Fatalf("bad: %v", ids)
+	}
+
+	schema.Tables["main"].Indexes["length"].Indexer = &FuncIndexer{}
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+	schema.Tables["main"].Indexes["length"].Indexer = length
+	initials.FromArgsFn = nil
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
+
 func TestCompoundIndex_FromObject(t *testing.T) {
 	obj := testObj()
 	indexer := &CompoundIndex{
@@ -1314,3 +1845,425 @@ func TestCompoundIndex_PrefixFromArgs(t *testing.T) {
 		t.Fatalf("expected an error when passing too many arguments")
 	}
 }
+
+func TestCompoundIndex_Nullable(t *testing.T) {
+	indexer := &CompoundIndex{
+		Indexes: []Indexer{
+			&StringFieldIndex{Field: "Foo"},
+			&StringFieldIndex{Field: "Baz"},
+		},
+		Nullable: []bool{true},
+	}
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"main": &TableSchema{
+				Name: "main",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"compound": &IndexSchema{
+						Name:    "compound",
+						Indexer: indexer,
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "1", Foo: "a", Baz: "x"},
+		&TestObject{ID: "2", Baz: "y"},
+		&TestObject{ID: "3", Baz: "x"},
+	} {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// A missing Baz still aborts indexing
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "4", Foo: "a"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	txn = db.Txn(false)
+	ids := func(index string, args ...interface{}) string {
+		iter, err := txn.Get("main", index, args...)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var ids []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			ids = append(ids, raw.(*TestObject).ID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// Nulls sort first
+	if got := ids("compound"); got != "3,2,1" {
+		t.Fatalf("bad: %s", got)
+	}
+	if got := ids("compound", nil, "x"); got != "3" {
+		t.Fatalf("bad: %s", got)
+	}
+	if got := ids("compound_prefix", nil); got != "3,2" {
+		t.Fatalf("bad: %s", got)
+	}
+	if got := ids("compound_prefix", "a"); got != "1" {
+		t.Fatalf("bad: %s", got)
+	}
+
+	indexer.Nullable = []bool{true, false, true}
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
+
+type testAddress struct {
+	City string
+	Zip  int
+}
+
+type testMeta struct {
+	Owner string
+}
+
+type testCustomer struct {
+	*testMeta
+	ID      string
+	Address *testAddress
+	Billing interface{}
+}
+
+func TestFieldPathIndexer_FromObject(t *testing.T) {
+	obj := &testCustomer{
+		testMeta: &testMeta{Owner: "ops"},
+		ID:       "a",
+		Address:  &testAddress{City: "Paris", Zip: 75001},
+		Billing:  testAddress{City: "Lyon"},
+	}
+	for _, tc := range []struct {
+		indexer *FieldPathIndexer
+		val     string
+	}{
+		{&FieldPathIndexer{Path: "Address.City"}, "Paris\x00"},
+		{&FieldPathIndexer{Path: "Billing.City"}, "Lyon\x00"},
+		{&FieldPathIndexer{Path: "Owner"}, "ops\x00"},
+		{&FieldPathIndexer{Path: "Address", Indexer: &IntFieldIndex{Field: "Zip"}}, string(mustArgs(t, &IntFieldIndex{}, 75001))},
+	} {
+		ok, val, err := tc.indexer.FromObject(obj)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !ok || string(val) != tc.val {
+			t.Fatalf("bad: %s: %v %q", tc.indexer.Path, ok, val)
+		}
+	}
+
+	// Nil pointers on the path, including embedded ones, leave the
+	// object out of the index
+	obj.Address, obj.testMeta = nil, nil
+	for _, path := range []string{"Address.City", "Owner"} {
+		ok, _, err := (&FieldPathIndexer{Path: path}).FromObject(obj)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if ok {
+			t.Fatalf("should not be ok: %s", path)
+		}
+	}
+
+	// Paths that don't lead to a field fail
+	for _, path := range []string{"ID.City", "Address.Nope", "Address"} {
+		obj.Address = &testAddress{}
+		if _, _, err := (&FieldPathIndexer{Path: path}).FromObject(obj); err == nil {
+			t.Fatalf("expected error: %s", path)
+		}
+	}
+	if err := (&FieldPathIndexer{Path: "Address..City"}).Validate(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestFieldPathIndexer_FromArgs(t *testing.T) {
+	indexer := &FieldPathIndexer{Path: "Address.City"}
+	val, err := indexer.FromArgs("Paris")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(val) != "Paris\x00" {
+		t.Fatalf("bad: %q", val)
+	}
+	val, err = indexer.PrefixFromArgs("Par")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(val) != "Par" {
+		t.Fatalf("bad: %q", val)
+	}
+
+	// Prefixes need a wrapped PrefixIndexer
+	indexer = &FieldPathIndexer{Path: "Address", Indexer: &IntFieldIndex{Field: "Zip"}}
+	if _, err := indexer.PrefixFromArgs(1); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestJSONPathIndexer_FromObject(t *testing.T) {
+	raw := []byte(`{"name": "a", "address": {"city": "Paris", "zip": 75001},
+		"tags": ["x", "y"], "active": true, "first name": "Ann", "note": null}`)
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, tc := range []struct {
+		path string
+		arg  interface{}
+	}{
+		{"$.name", "a"},
+		{"address.city", "Paris"},
+		{"$.address.zip", 75001},
+		{"$.address['zip']", 75001.0},
+		{"$.tags[1]", "y"},
+		{"$.active", true},
+		{"$['first name']", "Ann"},
+		{`$["first name"]`, "Ann"},
+	} {
+		indexer := &JSONPathIndexer{Path: tc.path}
+		expected, err := indexer.FromArgs(tc.arg)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for _, obj := range []interface{}{doc, raw, json.RawMessage(raw)} {
+			ok, val, err := indexer.FromObject(obj)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if !ok || !bytes.Equal(val, expected) {
+				t.Fatalf("bad: %s: %v %q", tc.path, ok, val)
+			}
+		}
+	}
+
+	// Paths leading nowhere leave the document out of the index
+	for _, path := range []string{"$.nope", "$.note", "$.tags[2]", "$.name.first", "$.tags.x"} {
+		ok, _, err := (&JSONPathIndexer{Path: path}).FromObject(doc)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if ok {
+			t.Fatalf("should not be ok: %s", path)
+		}
+	}
+
+	// Objects, arrays, invalid documents and paths fail
+	for _, tc := range []struct {
+		path string
+		obj  interface{}
+	}{
+		{"$.address", doc},
+		{"$.tags", doc},
+		{"$.name", []byte("{")},
+		{"$.name", "not a document"},
+		{"$..name", doc},
+		{"$.tags[x]", doc},
+		{"$['name", doc},
+		{"$", doc},
+	} {
+		if _, _, err := (&JSONPathIndexer{Path: tc.path}).FromObject(tc.obj); err == nil {
+			t.Fatalf("expected error: %s %#v", tc.path, tc.obj)
+		}
+	}
+}
+
+func TestJSONPathIndexer_FromArgs(t *testing.T) {
+	indexer := &JSONPathIndexer{Path: "$.n"}
+
+	// Values of different types don't match
+	s, _ := indexer.FromArgs("1")
+	n, _ := indexer.FromArgs(1)
+	b, _ := indexer.FromArgs(true)
+	if bytes.Equal(s, n) || bytes.Equal(n, b) {
+		t.Fatalf("bad: %q %q %q", s, n, b)
+	}
+	if _, err := indexer.FromArgs([]string{"a"}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Numbers of any type are ordered by value
+	var prev []byte
+	for _, arg := range []interface{}{-1e10, int8(-3), -0.5, 0, uint(1), 2.5, int64(1 << 40)} {
+		val, err := indexer.FromArgs(arg)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if prev != nil && bytes.Compare(prev, val) >= 0 {
+			t.Fatalf("bad order at %v", arg)
+		}
+		prev = val
+	}
+	zero, _ := indexer.FromArgs(0)
+	negZero, _ := indexer.FromArgs(math.Copysign(0, -1))
+	if !bytes.Equal(zero, negZero) {
+		t.Fatalf("bad: %q %q", zero, negZero)
+	}
+
+	// Prefixes match strings
+	prefix, err := indexer.PrefixFromArgs("ab")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	val, _ := indexer.FromArgs("abc")
+	if !bytes.HasPrefix(val, prefix) {
+		t.Fatalf("bad: %q %q", prefix, val)
+	}
+}
+
+func TestJSONPathIndexer_Table(t *testing.T) {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"docs": &TableSchema{
+				Name: "docs",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &JSONPathIndexer{Path: "$.id"},
+					},
+					"city": &IndexSchema{
+						Name:         "city",
+						AllowMissing: true,
+						Indexer:      &JSONPathIndexer{Path: "$.address.city"},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for _, doc := range []string{
+		`{"id": "a", "address": {"city": "Paris"}}`,
+		`{"id": "b", "address": {"city": "Lyon"}}`,
+		`{"id": "c"}`,
+	} {
+		if err := txn.Insert("docs", []byte(doc)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	raw, err := txn.First("docs", "city", "Lyon")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(raw.([]byte)) != `{"id": "b", "address": {"city": "Lyon"}}` {
+		t.Fatalf("bad: %s", raw)
+	}
+	if n, err := txn.Count("docs", "city"); err != nil || n != 2 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+
+	schema.Tables["docs"].Indexes["city"].Indexer = &JSONPathIndexer{Path: "$.address..city"}
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// mustArgs returns the index value of the given arguments.
+func mustArgs(t *testing.T, indexer Indexer, args ...interface{}) []byte {
+	t.Helper()
+	val, err := indexer.FromArgs(args...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return val
+}
+
+func TestHMACIndex_FromObject(t *testing.T) {
+	obj := testObj()
+	indexer := &HMACIndex{
+		Indexer: &StringFieldIndex{Field: "Foo"},
+		Key:     []byte("secret"),
+	}
+
+	ok, val, err := indexer.FromObject(obj)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok {
+		t.Fatalf("should be ok")
+	}
+	if len(val) != 32 || bytes.Contains(val, []byte(obj.Foo)) {
+		t.Fatalf("bad: %v", val)
+	}
+
+	// A different key produces a different value
+	other := &HMACIndex{Indexer: indexer.Indexer, Key: []byte("other")}
+	_, val2, err := other.FromObject(obj)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if bytes.Equal(val, val2) {
+		t.Fatalf("values should differ")
+	}
+
+	// Missing values are passed through
+	obj.Foo = ""
+	ok, val, err = indexer.FromObject(obj)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ok || val != nil {
+		t.Fatalf("should not be ok: %v", val)
+	}
+
+	// Only single indexers can be wrapped, which the schema checks
+	indexer.Indexer = &StringSliceFieldIndex{Field: "Qux"}
+	if _, _, err := indexer.FromObject(obj); err == nil {
+		t.Fatalf("should get err")
+	}
+	schema := &IndexSchema{Name: "foo", Indexer: indexer}
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
+
+func TestHMACIndex_FromArgs(t *testing.T) {
+	obj := testObj()
+	indexer := &HMACIndex{
+		Indexer: &StringFieldIndex{Field: "Foo"},
+		Key:     []byte("secret"),
+	}
+
+	_, expected, err := indexer.FromObject(obj)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	val, err := indexer.FromArgs(obj.Foo)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(val, expected) {
+		t.Fatalf("bad: %v %v", val, expected)
+	}
+
+	if _, err := indexer.FromArgs(42); err == nil {
+		t.Fatalf("should get err")
+	}
+}
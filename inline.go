@@ -0,0 +1,206 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// inlineRow is a row of an inline table, along with its primary key.
+type inlineRow struct {
+	key []byte
+	obj interface{}
+}
+
+// inlineTable is the committed state of a table whose schema sets Inline:
+// its rows, sorted by primary key, stored as a single value in the radix
+// root instead of one radix tree per index. It is never modified once
+// stored. The index trees that reads and writes work against are generated
+// from the rows when first needed, and shared by every transaction that
+// sees this version of the table.
+//
+// inlineTable 是小表的已提交状态：按主键排序的行切片，索引树按需生成。
+type inlineTable struct {
+	rows []inlineRow
+
+	lock  sync.Mutex
+	trees map[string]*iradix.Tree
+
+	// stale is set once a commit to the primary DB has replaced this
+	// version, at which point changed is closed and so are the watch
+	// channels of the generated trees.
+	stale   bool
+	changed chan struct{}
+}
+
+// newInlineTable returns a version of an inline table holding the given
+// rows, which must be sorted by primary key.
+func newInlineTable(rows []inlineRow) *inlineTable {
+	return &inlineTable{
+		rows:    rows,
+		changed: make(chan struct{}),
+	}
+}
+
+// inlinePath returns the path from the root to the rows of an inline table.
+func inlinePath(table string) []byte {
+	return []byte(table + ".")
+}
+
+// tree returns the radix tree of the given index, generating it from the
+// rows the first time.
+func (t *inlineTable) tree(tableSchema *TableSchema, index string) *iradix.Tree {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if tree, ok := t.trees[index]; ok {
+		return tree
+	}
+
+	indexSchema := tableSchema.Indexes[index]
+	indexTxn := iradix.New().Txn()
+	for _, row := range t.rows {
+		ok, vals, err := indexValues(indexSchema, row.obj)
+		if err != nil {
+			panic(fmt.Errorf("failed to build index '%s' for %s: %v", index, tableSchema.Name, err))
+		}
+		if !ok {
+			continue
+		}
+		for _, val := range vals {
+			if !indexSchema.Unique {
+				val = append(val, row.key...)
+			}
+			indexTxn.Insert(val, row.obj)
+		}
+	}
+	tree := indexTxn.CommitOnly()
+
+	// Anyone watching a version that was already replaced must wake up
+	// right away.
+	if t.stale {
+		closeWatches(tree)
+	}
+	if t.trees == nil {
+		t.trees = make(map[string]*iradix.Tree)
+	}
+	t.trees[index] = tree
+	return tree
+}
+
+// invalidate marks the version as replaced, firing the watches on it.
+func (t *inlineTable) invalidate() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.stale {
+		return
+	}
+	t.stale = true
+	close(t.changed)
+	for _, tree := range t.trees {
+		closeWatches(tree)
+	}
+}
+
+// closeWatches closes the watch channels of every node of the tree, which
+// itself is left as it is. Deleting everything in a transaction that tracks
+// mutations collects all of the channels for Notify.
+func closeWatches(tree *iradix.Tree) {
+	indexTxn := tree.Txn()
+	indexTxn.TrackMutate(true)
+	indexTxn.DeletePrefix(nil)
+	indexTxn.Notify()
+}
+
+// isInlineTable returns true if the table's rows are stored inline.
+func (txn *Txn) isInlineTable(table string) bool {
+	tableSchema, ok := txn.schema.Tables[table]
+	return ok && tableSchema.Inline
+}
+
+// inlineTable returns the version of an inline table that the transaction
+// started from.
+func (txn *Txn) inlineTable(table string) *inlineTable {
+	raw, _ := txn.rootTxn.Get(inlinePath(table))
+	return raw.(*inlineTable)
+}
+
+// commitInline stores the rows of the inline tables modified by the
+// transaction into the given root, and returns the versions they replace.
+// Writes to inline tables go through the generated index trees like any
+// other, and only the primary index is turned back into rows.
+func (txn *Txn) commitInline(root *iradix.Txn) []*inlineTable {
+	var replaced []*inlineTable
+	for key, subTxn := range txn.modified {
+		if key.Index != id || !txn.isInlineTable(key.Table) {
+			continue
+		}
+
+		tree := subTxn.CommitOnly()
+		rows := make([]inlineRow, 0, tree.Len())
+		iter := tree.Root().Iterator()
+		for k, obj, ok := iter.Next(); ok; k, obj, ok = iter.Next() {
+			rows = append(rows, inlineRow{key: k, obj: obj})
+		}
+
+		replaced = append(replaced, txn.inlineTable(key.Table))
+		root.Insert(inlinePath(key.Table), newInlineTable(rows))
+	}
+	return replaced
+}
+
+// getInline serves a scan of the primary index of an inline table straight
+// from its sorted rows, without generating the index tree. It returns nil
+// if the scan can't be served this way, because the transaction modified
+// the table.
+func (txn *Txn) getInline(table string, val []byte) ResultIterator {
+	if _, ok := txn.modified[tableIndex{table, id}]; ok {
+		return nil
+	}
+
+	t := txn.inlineTable(table)
+	start := sort.Search(len(t.rows), func(i int) bool {
+		return bytes.Compare(t.rows[i].key, val) >= 0
+	})
+	end := start
+	for end < len(t.rows) && bytes.HasPrefix(t.rows[end].key, val) {
+		end++
+	}
+	return &inlineIterator{
+		rows:    t.rows[start:end],
+		pos:     cursorPos{table: table, index: id, prefix: val, key: val},
+		watchCh: t.changed,
+	}
+}
+
+// inlineIterator is a ResultIterator over rows of an inline table.
+type inlineIterator struct {
+	rows    []inlineRow
+	pos     cursorPos
+	watchCh <-chan struct{}
+}
+
+// WatchCh returns a channel that is closed when the table is changed.
+func (i *inlineIterator) WatchCh() <-chan struct{} {
+	return i.watchCh
+}
+
+// Next returns the next row.
+func (i *inlineIterator) Next() interface{} {
+	if len(i.rows) == 0 {
+		return nil
+	}
+	obj := i.rows[0].obj
+	i.pos.advance(i.rows[0].key)
+	i.rows = i.rows[1:]
+	return obj
+}
+
+// Cursor returns the position of the iterator.
+func (i *inlineIterator) Cursor() []byte {
+	return i.pos.Cursor()
+}
@@ -0,0 +1,122 @@
+package memdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testInlineDB(t *testing.T) *MemDB {
+	schema := testValidSchema()
+	schema.Tables["main"].Inline = true
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return db
+}
+
+func TestMemDB_InlineTable(t *testing.T) {
+	db := testInlineDB(t)
+
+	txn := db.Txn(true)
+	for _, id := range []string{"c", "a", "b"} {
+		obj := &TestObject{ID: id, Foo: "abc", Qux: []string{id}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Reads see the writes before commit
+	raw, err := txn.First("main", "qux", "b")
+	if err != nil || raw == nil || raw.(*TestObject).ID != "b" {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	txn.Commit()
+
+	ids := func(txn *Txn, index string, args ...interface{}) []string {
+		iter, err := txn.Get("main", index, args...)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var out []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			out = append(out, raw.(*TestObject).ID)
+		}
+		return out
+	}
+
+	txn = db.Txn(false)
+	if out := ids(txn, "id_prefix", ""); !reflect.DeepEqual(out, []string{"a", "b", "c"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids(txn, "id", "b"); !reflect.DeepEqual(out, []string{"b"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids(txn, "foo", "abc"); !reflect.DeepEqual(out, []string{"a", "b", "c"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// Watches on both the rows and the generated indexes fire on change
+	scan, err := txn.Get("main", "id_prefix", "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	quxWatch, _, err := txn.FirstWatch("main", "qux", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	snap := db.Snapshot()
+
+	wtxn := db.Txn(true)
+	if err := wtxn.Delete("main", &TestObject{ID: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	wtxn.Commit()
+
+	for _, ch := range []<-chan struct{}{scan.WatchCh(), quxWatch} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("should fire")
+		}
+	}
+
+	// Reading an old version still works, and still sees the old rows
+	if out := ids(txn, "qux", "a"); !reflect.DeepEqual(out, []string{"a"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids(db.Txn(false), "id_prefix", ""); !reflect.DeepEqual(out, []string{"b", "c"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// Snapshots are isolated from the DB
+	stxn := snap.Txn(true)
+	if _, err := stxn.DeleteAll("main", "id_prefix", ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	stxn.Commit()
+	if out := ids(snap.Txn(false), "foo", "abc"); len(out) != 0 {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids(db.Txn(false), "foo", "abc"); !reflect.DeepEqual(out, []string{"b", "c"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestTableSchema_Validate_Inline(t *testing.T) {
+	table := testValidSchema().Tables["main"]
+	table.Inline = true
+	if err := table.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	table.LargeObjects = &LargeObjectSchema{
+		Threshold: 10,
+		Codec:     &JSONCodec{},
+		Store:     &testBlobStore{},
+	}
+	if err := table.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
@@ -0,0 +1,104 @@
+package memdb
+
+import iradix "github.com/hashicorp/go-immutable-radix"
+
+// IterationMode defines what the iterators of a write transaction return
+// when the transaction writes to the index they iterate over.
+//
+// IterationMode 定义写事务在迭代过程中写入时迭代器的行为。
+type IterationMode int
+
+const (
+	// IterateSnapshot iterators return the objects of the index as it was
+	// when they were created, whatever is written afterwards. This is the
+	// default.
+	IterateSnapshot IterationMode = iota
+
+	// IterateLive iterators return the objects of the index as it is at
+	// each call to Next: objects deleted before the iterator reaches them
+	// are skipped, and objects inserted past its position are returned.
+	// Each call to Next seeks from the root of the index, which makes it
+	// slower than with IterateSnapshot.
+	IterateLive
+)
+
+// SetIterationMode sets the mode of the iterators that Get, GetReverse,
+// LowerBound and ReverseLowerBound return from now on. Iterators already
+// created keep their mode. It has no effect on read-only transactions,
+// which never see any writes.
+func (txn *Txn) SetIterationMode(mode IterationMode) {
+	txn.iterMode = mode
+}
+
+// IterationMode returns the mode of the iterators of the transaction.
+func (txn *Txn) IterationMode() IterationMode {
+	return txn.iterMode
+}
+
+// iterateLive returns true if the iterators over the table must be live.
+func (txn *Txn) iterateLive(table string) bool {
+	return txn.write && txn.iterMode == IterateLive &&
+		(!isSystemTable(table) || isStoredSystemTable(table))
+}
+
+// currentIndex returns the root of the given index with the writes made so
+// far by the transaction. Unlike readableIndex, it doesn't clone the index
+// transaction, so the node must not be read once the index is written again.
+func (txn *Txn) currentIndex(table, index string) *iradix.Node {
+	if subTxn, ok := txn.modified[tableIndex{table, index}]; ok {
+		return subTxn.Root()
+	}
+	return txn.indexTree(table, index).Root()
+}
+
+// liveIterator is the ResultIterator of transactions using IterateLive. It
+// seeks past the last key returned on every call to Next.
+type liveIterator struct {
+	txn  *Txn
+	pos  cursorPos
+	done bool
+
+	watchCh <-chan struct{}
+	resolve func(interface{}) (interface{}, error)
+	err     error
+}
+
+// newLiveIterator returns a live iterator starting from the given position.
+func (txn *Txn) newLiveIterator(pos cursorPos, watchCh <-chan struct{}) *liveIterator {
+	return &liveIterator{
+		txn:     txn,
+		pos:     pos,
+		watchCh: watchCh,
+		resolve: txn.resolver(pos.table),
+	}
+}
+
+func (i *liveIterator) WatchCh() <-chan struct{} {
+	return i.watchCh
+}
+
+func (i *liveIterator) Next() interface{} {
+	if i.done {
+		return nil
+	}
+	root := i.txn.currentIndex(i.pos.table, i.pos.index)
+	key, value, ok := seekCursor(root, &i.pos)()
+	if !ok || !i.pos.matches(key) {
+		i.done = true
+		return nil
+	}
+	i.pos.advance(key)
+	if i.resolve != nil {
+		obj, err := i.resolve(value)
+		if err != nil {
+			i.done, i.err = true, err
+			return nil
+		}
+		return obj
+	}
+	return value
+}
+
+func (i *liveIterator) Cursor() []byte {
+	return i.pos.Cursor()
+}
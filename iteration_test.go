@@ -0,0 +1,126 @@
+package memdb
+
+import (
+	"strings"
+	"testing"
+)
+
+// iterateIDs inserts objects "b", "d" and "f", then collects the IDs
+// returned by the iterator while deleting "d" after the first result and
+// inserting "c" and "e".
+func iterateIDs(t *testing.T, mode IterationMode, get func(txn *Txn) (ResultIterator, error)) []string {
+	t.Helper()
+	db := testDB(t)
+	txn := db.Txn(true)
+	defer txn.Abort()
+	txn.SetIterationMode(mode)
+	objs := make(map[string]*TestObject)
+	for _, id := range []string{"b", "d", "f"} {
+		objs[id] = &TestObject{ID: id, Foo: "x", Qux: []string{"1"}}
+		if err := txn.Insert("main", objs[id]); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	iter, err := get(txn)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		ids = append(ids, raw.(*TestObject).ID)
+		if len(ids) > 1 {
+			continue
+		}
+		if err := txn.Delete("main", objs["d"]); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for _, id := range []string{"c", "e"} {
+			if err := txn.Insert("main", &TestObject{ID: id, Foo: "x", Qux: []string{"1"}}); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+	return ids
+}
+
+func TestTxn_IterationMode(t *testing.T) {
+	cases := []struct {
+		name     string
+		get      func(txn *Txn) (ResultIterator, error)
+		snapshot string
+		live     string
+	}{
+		{
+			"get",
+			func(txn *Txn) (ResultIterator, error) { return txn.Get("main", "foo", "x") },
+			"bdf", "bcef",
+		},
+		{
+			"get prefix",
+			func(txn *Txn) (ResultIterator, error) { return txn.Get("main", "id_prefix", "") },
+			"bdf", "bcef",
+		},
+		{
+			"get reverse",
+			func(txn *Txn) (ResultIterator, error) { return txn.GetReverse("main", "foo", "x") },
+			"fdb", "fecb",
+		},
+		{
+			"lower bound",
+			func(txn *Txn) (ResultIterator, error) { return txn.LowerBound("main", "id", "a") },
+			"bdf", "bcef",
+		},
+		{
+			"reverse lower bound",
+			func(txn *Txn) (ResultIterator, error) { return txn.ReverseLowerBound("main", "id", "g") },
+			"fdb", "fecb",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if ids := strings.Join(iterateIDs(t, IterateSnapshot, c.get), ""); ids != c.snapshot {
+				t.Fatalf("bad: %s", ids)
+			}
+			if ids := strings.Join(iterateIDs(t, IterateLive, c.get), ""); ids != c.live {
+				t.Fatalf("bad: %s", ids)
+			}
+		})
+	}
+
+	// Live iterators can delete the object they just returned
+	db := testDB(t)
+	txn := db.Txn(true)
+	txn.SetIterationMode(IterateLive)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: "x", Qux: []string{"1"}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	iter, err := txn.Get("main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	deleted := 0
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		if err := txn.Delete("main", raw); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		deleted++
+	}
+	if raw, err := txn.First("main", "id"); err != nil || raw != nil || deleted != 3 {
+		t.Fatalf("bad: %#v %v %d", raw, err, deleted)
+	}
+	txn.Abort()
+
+	// Read-only transactions ignore the mode
+	txn = db.Txn(false)
+	txn.SetIterationMode(IterateLive)
+	iter, err = txn.Get("main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, ok := iter.(*radixIterator); !ok {
+		t.Fatalf("bad: %#v", iter)
+	}
+}
@@ -0,0 +1,259 @@
+package memdb
+
+import (
+	"fmt"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// BlobStore stores the serialized form of large objects outside of MemDB.
+// It is used by tables that declare a LargeObjectSchema. Implementations
+// must be safe for concurrent use.
+type BlobStore interface {
+	// Put stores the data and returns a reference that can be used to
+	// retrieve it with Get.
+	Put(table string, data []byte) (string, error)
+
+	// Get returns the data stored under the given reference.
+	Get(ref string) ([]byte, error)
+
+	// Delete removes the data stored under the given reference.
+	Delete(ref string) error
+}
+
+// LargeObjectSchema configures a table to keep objects whose encoded size
+// exceeds Threshold bytes in a BlobStore. The radix trees then only hold a
+// small reference, and the object is fetched and decoded transparently when
+// it is read, so huge payloads are kept out of the MVCC snapshots.
+//
+// Index values are still computed from the full object when it is inserted.
+// A blob is deleted from the store once the transaction deleting or
+// replacing its object commits, or once the transaction storing it is
+// aborted, so older snapshots and read transactions may find it gone. Reads
+// that fail to fetch or decode a blob return an error; iterators end early
+// instead, and report the error through IteratorErr.
+//
+// LargeObjectSchema 将超过阈值的大对象存储在外部 BlobStore 中，读取时透明加载。
+type LargeObjectSchema struct {
+	// Threshold is the encoded size in bytes above which objects are
+	// stored in the BlobStore.
+	Threshold int
+
+	// Codec is used to encode and decode the objects of the table.
+	Codec Codec
+
+	// Store holds the encoded objects.
+	Store BlobStore
+
+	// CacheSize and CacheBytes bound the number of objects fetched from the
+	// store that are kept decoded, and the total size of their encoded
+	// form. Zero means no limit, and objects are fetched on every read if
+	// both are zero. Cached objects are shared by their readers, so they
+	// must not be modified. See DecodeCacheStats.
+	CacheSize  int
+	CacheBytes int
+
+	cache decodeCache
+}
+
+// Validate is used to validate the large object schema.
+func (s *LargeObjectSchema) Validate() error {
+	if s.Threshold < 0 {
+		return fmt.Errorf("large object threshold must not be negative")
+	}
+	if s.Codec == nil {
+		return fmt.Errorf("missing large object codec")
+	}
+	if s.Store == nil {
+		return fmt.Errorf("missing large object store")
+	}
+	if s.CacheSize < 0 || s.CacheBytes < 0 {
+		return fmt.Errorf("large object cache size must not be negative")
+	}
+	return nil
+}
+
+// blobRef is stored in the radix trees in place of an externalized object.
+type blobRef struct {
+	ref string
+}
+
+// blobWrites holds the blobs a write transaction stored, which are deleted if
+// it is aborted, and the blobs of the objects it deleted or replaced, which
+// are deleted once it commits.
+type blobWrites struct {
+	stored   []storedBlob
+	released []storedBlob
+}
+
+// storedBlob is a blob of a BlobStore.
+type storedBlob struct {
+	store BlobStore
+	ref   string
+}
+
+// externalize returns the value to store in the indexes for the given
+// object, which is either the object itself, a reference to its blob, or its
+// compressed form.
+func (txn *Txn) externalize(tableSchema *TableSchema, obj interface{}) (interface{}, error) {
+	if c := tableSchema.Compression; c != nil {
+		return c.compress(tableSchema.Name, obj)
+	}
+	lo := tableSchema.LargeObjects
+	if lo == nil {
+		return obj, nil
+	}
+
+	data, err := lo.Codec.Encode(tableSchema.Name, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode large object: %v", err)
+	}
+	if len(data) <= lo.Threshold {
+		return obj, nil
+	}
+
+	ref, err := lo.Store.Put(tableSchema.Name, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store large object: %v", err)
+	}
+
+	// The blob is deleted if the transaction is aborted
+	if txn.blobs == nil {
+		txn.blobs = &blobWrites{}
+	}
+	txn.blobs.stored = append(txn.blobs.stored, storedBlob{lo.Store, ref})
+	return &blobRef{ref: ref}, nil
+}
+
+// releaseBlob schedules the deletion of the blob of a value removed from the
+// indexes of the table, if it has one, for when the transaction commits.
+func (txn *Txn) releaseBlob(tableSchema *TableSchema, raw interface{}) {
+	blob, ok := raw.(*blobRef)
+	if !ok || tableSchema.LargeObjects == nil {
+		return
+	}
+	if txn.blobs == nil {
+		txn.blobs = &blobWrites{}
+	}
+	txn.blobs.released = append(txn.blobs.released, storedBlob{tableSchema.LargeObjects.Store, blob.ref})
+}
+
+// releaseBlobs schedules the deletion of the blobs of every value of the
+// given primary index of the table, which was emptied.
+func (txn *Txn) releaseBlobs(tableSchema *TableSchema, tree *iradix.Tree) {
+	if tableSchema.LargeObjects == nil {
+		return
+	}
+	iter := tree.Root().Iterator()
+	for _, raw, ok := iter.Next(); ok; _, raw, ok = iter.Next() {
+		txn.releaseBlob(tableSchema, raw)
+	}
+}
+
+// deleteBlobs deletes the blobs the transaction released if it committed, or
+// the ones it stored otherwise.
+func (txn *Txn) deleteBlobs(committed bool) {
+	if txn.blobs == nil {
+		return
+	}
+	blobs := txn.blobs.stored
+	if committed {
+		blobs = txn.blobs.released
+	}
+	txn.blobs = nil
+	txn.db.deleteBlobs(blobs)
+}
+
+// deleteBlobs deletes the given blobs from their store. Blobs that can't be
+// deleted are only logged.
+func (db *MemDB) deleteBlobs(blobs []storedBlob) {
+	for _, blob := range blobs {
+		if err := blob.store.Delete(blob.ref); err != nil {
+			db.logger.Warn("failed to delete large object", "ref", blob.ref, "error", err)
+		}
+	}
+}
+
+// resolve returns the object for a value read from the indexes of the given
+// table, fetching it from the BlobStore if it was externalized, or
+// decompressing it.
+func (txn *Txn) resolve(table string, raw interface{}) (interface{}, error) {
+	if row, ok := raw.(*compressedRow); ok {
+		tableSchema, ok := txn.tableSchema(table)
+		if !ok || tableSchema.Compression == nil {
+			return nil, fmt.Errorf("invalid compressed object in table '%s'", table)
+		}
+		return tableSchema.Compression.decompress(table, row)
+	}
+
+	blob, ok := raw.(*blobRef)
+	if !ok {
+		return raw, nil
+	}
+
+	tableSchema, ok := txn.tableSchema(table)
+	if !ok || tableSchema.LargeObjects == nil {
+		return nil, fmt.Errorf("invalid large object reference in table '%s'", table)
+	}
+	lo := tableSchema.LargeObjects
+	limits := lo.cacheLimits()
+	if obj, ok := lo.cache.get(blob, limits); ok {
+		return obj, nil
+	}
+
+	data, err := lo.Store.Get(blob.ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch large object: %v", err)
+	}
+	obj, err := lo.Codec.Decode(table, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode large object: %v", err)
+	}
+	lo.cache.put(blob, obj, len(data), limits)
+	return obj, nil
+}
+
+// cacheLimits returns the limits of the decode cache.
+func (s *LargeObjectSchema) cacheLimits() cacheLimits {
+	return cacheLimits{objects: s.CacheSize, bytes: s.CacheBytes}
+}
+
+// resolver returns a function resolving the values of iterators over the
+// given table, or nil if the table never externalizes objects.
+func (txn *Txn) resolver(table string) func(interface{}) (interface{}, error) {
+	tableSchema, ok := txn.tableSchema(table)
+	if !ok || (tableSchema.LargeObjects == nil && tableSchema.Compression == nil) {
+		return nil
+	}
+	return func(raw interface{}) (interface{}, error) {
+		return txn.resolve(table, raw)
+	}
+}
+
+// ErrIterator is a ResultIterator whose iteration can end early because of
+// an error, such as a large object that can't be fetched or decoded. The
+// iterators returned by Get and the other queries implement it, and so do
+// the FilterIterator, LimitIterator and OffsetIterator wrapping them.
+type ErrIterator interface {
+	ResultIterator
+
+	// Err returns the error that made Next return nil, if any.
+	Err() error
+}
+
+// IteratorErr returns the error that ended the iteration of the iterator, or
+// nil if it ended normally or doesn't implement ErrIterator.
+func IteratorErr(iter ResultIterator) error {
+	if it, ok := iter.(ErrIterator); ok {
+		return it.Err()
+	}
+	return nil
+}
+
+func (r *radixIterator) Err() error        { return r.opts.Err() }
+func (r *radixReverseIterator) Err() error { return r.opts.Err() }
+func (c *cursorIterator) Err() error       { return c.err }
+func (i *liveIterator) Err() error         { return i.err }
+func (f *FilterIterator) Err() error       { return IteratorErr(f.iter) }
+func (l *LimitIterator) Err() error        { return IteratorErr(l.iter) }
+func (o *OffsetIterator) Err() error       { return IteratorErr(o.iter) }
@@ -0,0 +1,335 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testBlobStore struct {
+	l     sync.Mutex
+	next  int
+	blobs map[string][]byte
+}
+
+func (s *testBlobStore) Put(table string, data []byte) (string, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.blobs == nil {
+		s.blobs = make(map[string][]byte)
+	}
+	s.next++
+	ref := fmt.Sprintf("%s/%d", table, s.next)
+	s.blobs[ref] = data
+	return ref, nil
+}
+
+func (s *testBlobStore) Get(ref string) ([]byte, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	data, ok := s.blobs[ref]
+	if !ok {
+		return nil, fmt.Errorf("blob %q not found", ref)
+	}
+	return data, nil
+}
+
+func (s *testBlobStore) Delete(ref string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.blobs, ref)
+	return nil
+}
+
+func TestTxn_LargeObjects(t *testing.T) {
+	store := &testBlobStore{}
+	schema := testValidSchema()
+	schema.Tables["main"].LargeObjects = &LargeObjectSchema{
+		Threshold: 600,
+		Codec: &JSONCodec{
+			Types: map[string]reflect.Type{"main": reflect.TypeOf(&TestObject{})},
+		},
+		Store: store,
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	small := &TestObject{ID: "small", Foo: "abc", Qux: []string{"a"}}
+	large := &TestObject{ID: "large", Foo: "abc", Qux: []string{"a"}, Baz: strings.Repeat("x", 1000)}
+
+	txn := db.Txn(true)
+	txn.TrackChanges()
+	if err := txn.Insert("main", small); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", large); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Only the large object is kept out of the tree
+	if raw, _ := txn.readableIndex("main", "id").Get([]byte("large\x00")); reflect.TypeOf(raw) != reflect.TypeOf(&blobRef{}) {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if raw, _ := txn.readableIndex("main", "id").Get([]byte("small\x00")); raw != small {
+		t.Fatalf("bad: %#v", raw)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	raw, err := txn.First("main", "id", "large")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(raw, large) {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	iter, err := txn.Get("main", "foo", "abc")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		ids = append(ids, raw.(*TestObject).ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"large", "small"}) {
+		t.Fatalf("bad: %#v", ids)
+	}
+
+	// Updating the large object sees the previous version
+	large2 := &TestObject{ID: "large", Foo: "xyz", Qux: large.Qux, Baz: large.Baz}
+	txn = db.Txn(true)
+	txn.TrackChanges()
+	if err := txn.Insert("main", large2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	changes := txn.Changes()
+	if len(changes) != 1 || !reflect.DeepEqual(changes[0].Before, large) {
+		t.Fatalf("bad: %#v", changes)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	raw, err = txn.First("main", "foo", "abc")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw.(*TestObject).ID != "small" {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// Fetch errors are returned
+	store.l.Lock()
+	store.blobs = nil
+	store.l.Unlock()
+	if _, err := txn.First("main", "id", "large"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestTxn_LargeObjects_Cache(t *testing.T) {
+	store := &testBlobStore{}
+	schema := testValidSchema()
+	schema.Tables["main"].LargeObjects = &LargeObjectSchema{
+		Codec: &JSONCodec{
+			Types: map[string]reflect.Type{"main": reflect.TypeOf(&TestObject{})},
+		},
+		Store:     store,
+		CacheSize: 1,
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "abc", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// Cached objects are read without the store
+	txn = db.Txn(false)
+	first, err := txn.First("main", "id", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	store.l.Lock()
+	store.blobs = nil
+	store.l.Unlock()
+	if obj, err := txn.First("main", "id", "a"); err != nil || obj != first {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+	if s := db.DecodeCacheStats(); len(s) != 1 || s[0].Hits != 1 || s[0].Misses != 1 {
+		t.Fatalf("bad: %#v", s)
+	}
+}
+
+func TestTableSchema_Validate_LargeObjects(t *testing.T) {
+	table := testValidSchema().Tables["main"]
+	table.LargeObjects = &LargeObjectSchema{Threshold: 10}
+	if err := table.Validate(); err == nil {
+		t.Fatalf("should not validate, no codec or store")
+	}
+
+	table.LargeObjects.Codec = &GobCodec{}
+	table.LargeObjects.Store = &testBlobStore{}
+	if err := table.Validate(); err != nil {
+		t.Fatalf("should validate: %v", err)
+	}
+}
+
+func TestTxn_LargeObjects_Errors(t *testing.T) {
+	store := &testBlobStore{}
+	schema := testValidSchema()
+	schema.Tables["main"].LargeObjects = &LargeObjectSchema{
+		Codec: &JSONCodec{
+			Types: map[string]reflect.Type{"main": reflect.TypeOf(&TestObject{})},
+		},
+		Store: store,
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: "abc", Qux: []string{"a"}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	store.l.Lock()
+	store.blobs = nil
+	store.l.Unlock()
+
+	// Iterators end early and report the error
+	txn = db.Txn(false)
+	iter, err := txn.Get("main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if obj := iter.Next(); obj != nil {
+		t.Fatalf("bad: %#v", obj)
+	}
+	if err := IteratorErr(iter); err == nil {
+		t.Fatalf("expected error")
+	}
+	iter, err = txn.Get("main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	limited := NewLimitIterator(NewFilterIterator(iter, func(interface{}) bool { return false }), 1)
+	if obj := limited.Next(); obj != nil || limited.Err() == nil {
+		t.Fatalf("bad: %#v %v", obj, limited.Err())
+	}
+
+	// So do writes reading the objects
+	txn = db.Txn(true)
+	if _, err := txn.DeleteAll("main", "foo", "abc"); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	txn = db.Txn(true)
+	txn.TrackChanges()
+	if _, err := txn.DeleteAll("main", "id_prefix", ""); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+}
+
+func TestTxn_LargeObjects_Release(t *testing.T) {
+	store := &testBlobStore{}
+	schema := testValidSchema()
+	schema.Tables["main"].LargeObjects = &LargeObjectSchema{
+		Codec: &JSONCodec{
+			Types: map[string]reflect.Type{"main": reflect.TypeOf(&TestObject{})},
+		},
+		Store: store,
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	blobs := func() int {
+		store.l.Lock()
+		defer store.l.Unlock()
+		return len(store.blobs)
+	}
+
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: "abc", Qux: []string{"a"}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	if n := blobs(); n != 3 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Aborting deletes the blobs stored by the transaction
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "d", Foo: "abc", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Abort()
+	if n := blobs(); n != 3 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Overwritten and deleted objects lose their blob on commit only
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "xyz", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Delete("main", &TestObject{ID: "b"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := blobs(); n != 4 {
+		t.Fatalf("bad: %d", n)
+	}
+	txn.Commit()
+	if n := blobs(); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Rolling back to a savepoint keeps the blobs released before it, and
+	// deletes the ones stored after it
+	txn = db.Txn(true)
+	if err := txn.Delete("main", &TestObject{ID: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sp, err := txn.Savepoint()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", &TestObject{ID: "c", Foo: "xyz", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.RollbackTo(sp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := blobs(); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+	txn.Commit()
+	if n := blobs(); n != 1 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Emptying the table releases every blob
+	txn = db.Txn(true)
+	if _, err := txn.DeleteAll("main", "id_prefix", ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if n := blobs(); n != 0 {
+		t.Fatalf("bad: %d", n)
+	}
+}
@@ -0,0 +1,147 @@
+package memdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lease is an object of a lease table. A lease gives its holder a key for a
+// limited time, and must be renewed before it expires to keep it.
+type Lease struct {
+	Key    string
+	Holder string
+	TTL    time.Duration
+
+	// Expires is when the lease expires unless it is renewed. It is zero
+	// once a lease kept by a table created with keepExpired expired.
+	Expires time.Time
+	Expired bool
+}
+
+// LeaseTableSchema returns the schema for a lease table with the given
+// name, to be added to the DBSchema of the MemDB. Leases are granted with
+// Txn.Grant, kept alive with Txn.Renew and given up with Txn.Revoke. The
+// leases that aren't renewed in time are deleted by the background job of
+// TTLSchema, or marked as Expired if keepExpired is true, so consumers
+// learn about expirations from the watch channels of the leases, or from
+// the changes of the commit when subscribed with MemDB.Subscribe. The job
+// runs at the interval set with WithTTLInterval, which bounds how late
+// expirations are noticed.
+//
+// LeaseTableSchema 返回租约表的模式，未及时续约的租约会被自动删除或标记为过期。
+func LeaseTableSchema(name string, keepExpired bool) *TableSchema {
+	ttl := &TTLSchema{Field: "Expires"}
+	if keepExpired {
+		ttl.Expire = expireLease
+	}
+	return &TableSchema{
+		Name: name,
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &StringFieldIndex{Field: "Key"},
+			},
+			"holder": &IndexSchema{
+				Name:    "holder",
+				Indexer: &StringFieldIndex{Field: "Holder"},
+			},
+		},
+		TTL: ttl,
+	}
+}
+
+// expireLease marks an expired lease.
+func expireLease(obj interface{}) (interface{}, error) {
+	lease := *obj.(*Lease)
+	lease.Expires = time.Time{}
+	lease.Expired = true
+	return &lease, nil
+}
+
+// Grant gives the lease on a key to the holder for the given TTL. It fails
+// if the key is leased by another holder and the lease hasn't expired yet.
+// Granting a lease to its current holder renews it with the new TTL.
+func (txn *Txn) Grant(table, key, holder string, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lease TTL must be positive")
+	}
+	current, err := txn.GetLease(table, key)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.Holder != holder {
+		return nil, fmt.Errorf("key '%s' of table '%s' is leased by '%s'", key, table, current.Holder)
+	}
+
+	lease := &Lease{
+		Key:     key,
+		Holder:  holder,
+		TTL:     ttl,
+		Expires: txn.db.clock.Now().Add(ttl),
+	}
+	if err := txn.Insert(table, lease); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Renew extends the lease of the holder on a key by its TTL, counting from
+// now. It fails if the holder doesn't hold a lease on the key that is still
+// valid.
+func (txn *Txn) Renew(table, key, holder string) (*Lease, error) {
+	current, err := txn.GetLease(table, key)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.Holder != holder {
+		return nil, fmt.Errorf("no valid lease on key '%s' of table '%s' for '%s'", key, table, holder)
+	}
+	return txn.Grant(table, key, holder, current.TTL)
+}
+
+// Revoke deletes the lease of the holder on a key. It returns ErrNotFound
+// if the holder doesn't hold a lease on the key.
+func (txn *Txn) Revoke(table, key, holder string) error {
+	raw, err := txn.First(table, id, key)
+	if err != nil {
+		return err
+	}
+	lease, err := leaseObject(table, raw)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Holder != holder {
+		return ErrNotFound
+	}
+	return txn.Delete(table, lease)
+}
+
+// GetLease returns the lease on a key, or nil if there is none or it has
+// expired, even if the expiration wasn't processed yet.
+func (txn *Txn) GetLease(table, key string) (*Lease, error) {
+	raw, err := txn.First(table, id, key)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := leaseObject(table, raw)
+	if err != nil || lease == nil {
+		return nil, err
+	}
+	if lease.Expired || !txn.db.clock.Now().Before(lease.Expires) {
+		return nil, nil
+	}
+	return lease, nil
+}
+
+// leaseObject asserts that an object of the table is a lease.
+func leaseObject(table string, raw interface{}) (*Lease, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	lease, ok := raw.(*Lease)
+	if !ok {
+		return nil, fmt.Errorf("table '%s' is not a lease table", table)
+	}
+	return lease, nil
+}
@@ -0,0 +1,87 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTxn_Lease(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	schema := testValidSchema()
+	schema.Tables["members"] = LeaseTableSchema("members", false)
+	schema.Tables["locks"] = LeaseTableSchema("locks", true)
+	db, err := NewMemDB(schema, WithClock(clock), WithDeterministicMode())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	if _, err := txn.Grant("members", "node1", "node1", 10*time.Second); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn.Grant("locks", "leader", "node1", 10*time.Second); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn.Grant("locks", "leader", "node2", 10*time.Second); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Commit()
+
+	watchCh, _, err := db.Txn(false).FirstWatch("members", "id", "node1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Renewing keeps the lock alive past its first expiration
+	clock.Advance(8 * time.Second)
+	txn = db.Txn(true)
+	if _, err := txn.Renew("locks", "leader", "node1"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn.Renew("locks", "leader", "node2"); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Commit()
+
+	clock.Advance(4 * time.Second)
+	if num, err := db.ReapExpired(clock.Now()); err != nil || num != 1 {
+		t.Fatalf("bad: %d %v", num, err)
+	}
+	select {
+	case <-watchCh:
+	default:
+		t.Fatalf("should be notified")
+	}
+
+	txn = db.Txn(false)
+	if raw, err := txn.First("members", "id", "node1"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if lease, err := txn.GetLease("locks", "leader"); err != nil || lease == nil {
+		t.Fatalf("bad: %#v %v", lease, err)
+	}
+
+	// Expired locks are kept, marked, and can be taken over
+	clock.Advance(10 * time.Second)
+	if num, err := db.ReapExpired(clock.Now()); err != nil || num != 1 {
+		t.Fatalf("bad: %d %v", num, err)
+	}
+	txn = db.Txn(true)
+	raw, err := txn.First("locks", "id", "leader")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if lease := raw.(*Lease); !lease.Expired || !lease.Expires.IsZero() {
+		t.Fatalf("bad: %#v", lease)
+	}
+	if _, err := txn.Grant("locks", "leader", "node2", 10*time.Second); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Revoke("locks", "leader", "node1"); err != ErrNotFound {
+		t.Fatalf("bad: %v", err)
+	}
+	if err := txn.Revoke("locks", "leader", "node2"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+}
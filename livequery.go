@@ -0,0 +1,307 @@
+package memdb
+
+import (
+	"fmt"
+	"sync"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// LiveQueryFunc runs the query of a LiveQuery against a read transaction.
+// The watch channel of the returned iterator must fire when the results may
+// have changed, as it does for the iterators returned by Txn.Get.
+type LiveQueryFunc func(txn *Txn) (ResultIterator, error)
+
+// DeltaKind is the kind of change to the result set of a LiveQuery.
+type DeltaKind int
+
+const (
+	// DeltaAdded is an object that entered the result set.
+	DeltaAdded DeltaKind = iota
+
+	// DeltaUpdated is an object of the result set that was replaced by a
+	// new version.
+	DeltaUpdated
+
+	// DeltaRemoved is an object that left the result set.
+	DeltaRemoved
+)
+
+// Delta is a change to the result set of a LiveQuery. Before is nil for
+// added objects and After is nil for removed ones.
+type Delta struct {
+	Kind   DeltaKind
+	Before interface{}
+	After  interface{}
+}
+
+// LiveQuery keeps the result set of a query up to date as commits occur, and
+// delivers the changes to it as deltas, so consumers don't need to run the
+// query again and compare results themselves every time a watch fires.
+// Objects are matched across runs of the query by their primary key, and
+// reported as updated when the row stored for them was replaced, including
+// in tables with a CompressionSchema or LargeObjectSchema.
+//
+// LiveQuery 随着提交不断更新查询结果集，并以增量的形式投递变化。
+type LiveQuery struct {
+	db    *MemDB
+	table string
+	query LiveQueryFunc
+
+	// l guards the fields below. Refreshes are serialized by it.
+	l       sync.Mutex
+	keys    []string
+	results map[string]interface{}
+	stored  map[string]interface{}
+	watchCh <-chan struct{}
+	watcher watchCloser
+	err     error
+
+	stopCh  chan struct{}
+	stopped bool
+
+	// id numbers the query in the WatchersTable once it's started.
+	id uint64
+}
+
+// NewLiveQuery runs the query against the given table, whose objects it must
+// return, and returns a LiveQuery holding its results. The results are
+// brought up to date on calls to Refresh, or as commits occur once Start is
+// called.
+func NewLiveQuery(db *MemDB, table string, query LiveQueryFunc) (*LiveQuery, error) {
+	if _, ok := db.getSchema().Tables[table]; !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+	q := &LiveQuery{
+		db:      db,
+		table:   table,
+		query:   query,
+		results: make(map[string]interface{}),
+		stored:  make(map[string]interface{}),
+		stopCh:  make(chan struct{}),
+	}
+	if _, err := q.Refresh(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Results returns the current result set, in the order the query returned
+// the objects.
+func (q *LiveQuery) Results() []interface{} {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	out := make([]interface{}, len(q.keys))
+	for i, key := range q.keys {
+		out[i] = q.results[key]
+	}
+	return out
+}
+
+// Refresh runs the query again and returns the deltas from the previous
+// results: the added and updated objects in the order the query returned
+// them, followed by the removed ones.
+func (q *LiveQuery) Refresh() ([]Delta, error) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	txn := q.db.Txn(false)
+	iter, err := q.query(txn)
+	if err != nil {
+		return nil, err
+	}
+	tableSchema := txn.schema.Tables[q.table]
+	idIndexer := tableSchema.Indexes[id].Indexer.(SingleIndexer)
+
+	// Tables that don't store objects as inserted return a new copy of an
+	// object when it's decoded again, so the stored rows are compared
+	// instead.
+	var idTxn *iradix.Txn
+	if tableSchema.Compression != nil || tableSchema.LargeObjects != nil {
+		idTxn = txn.readableIndex(q.table, id)
+	}
+
+	var deltas []Delta
+	keys := make([]string, 0, len(q.keys))
+	results := make(map[string]interface{}, len(q.results))
+	stored := make(map[string]interface{}, len(q.stored))
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		ok, idVal, err := idIndexer.FromObject(obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build primary index: %v", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("object missing primary index")
+		}
+		key := string(idVal)
+		if _, dup := results[key]; dup {
+			continue
+		}
+		keys = append(keys, key)
+		results[key] = obj
+		stored[key] = obj
+		if idTxn != nil {
+			if raw, ok := idTxn.Get(idVal); ok {
+				stored[key] = raw
+			}
+		}
+
+		before, existed := q.results[key]
+		switch {
+		case !existed:
+			deltas = append(deltas, Delta{Kind: DeltaAdded, After: obj})
+		case !sameObject(q.stored[key], stored[key]):
+			deltas = append(deltas, Delta{Kind: DeltaUpdated, Before: before, After: obj})
+		}
+	}
+	if err := IteratorErr(iter); err != nil {
+		return nil, err
+	}
+	for _, key := range q.keys {
+		if _, ok := results[key]; !ok {
+			deltas = append(deltas, Delta{Kind: DeltaRemoved, Before: q.results[key]})
+		}
+	}
+
+	q.keys = keys
+	q.results = results
+	q.stored = stored
+	q.closeWatcher()
+	q.watchCh = iter.WatchCh()
+	q.watcher, _ = iter.(watchCloser)
+	return deltas, nil
+}
+
+// watchCloser is implemented by iterators whose watch channel is backed by
+// a goroutine, which Close stops.
+type watchCloser interface {
+	Close()
+}
+
+// closeWatcher stops the goroutine behind the current watch channel, if
+// any. q.l must be held.
+func (q *LiveQuery) closeWatcher() {
+	if q.watcher != nil {
+		q.watcher.Close()
+		q.watcher = nil
+	}
+}
+
+// Start delivers the deltas of every change to the result set on the
+// returned channel, one batch per refresh, until Stop is called or the
+// MemDB is closed. The channel is then closed. Commits that happen while a
+// batch waits to be received are folded into the next batch. If the query
+// fails the channel is closed and the error is returned by Err. Start must
+// only be called once.
+//
+// Start needs a goroutine, so in deterministic mode it returns a closed
+// channel and Err reports why; call Refresh instead.
+func (q *LiveQuery) Start() <-chan []Delta {
+	ch := make(chan []Delta)
+
+	db := q.db
+	db.jobsLock.Lock()
+	defer db.jobsLock.Unlock()
+	if db.deterministic {
+		q.l.Lock()
+		q.err = fmt.Errorf("live queries can't be started in deterministic mode")
+		q.l.Unlock()
+		close(ch)
+		return ch
+	}
+	if db.shutdown {
+		close(ch)
+		return ch
+	}
+	if db.shutdownCh == nil {
+		db.shutdownCh = make(chan struct{})
+	}
+	db.subsLock.Lock()
+	db.watchers++
+	q.id = db.watchers
+	db.liveQueries = append(db.liveQueries, q)
+	db.subsLock.Unlock()
+
+	db.jobsWg.Add(1)
+	go q.run(ch, db.shutdownCh)
+	return ch
+}
+
+// run refreshes the query every time its results may have changed.
+func (q *LiveQuery) run(ch chan []Delta, shutdownCh chan struct{}) {
+	defer q.db.jobsWg.Done()
+	defer close(ch)
+	defer q.db.removeLiveQuery(q)
+
+	for {
+		q.l.Lock()
+		watchCh := q.watchCh
+		q.l.Unlock()
+
+		select {
+		case <-watchCh:
+		case <-q.stopCh:
+			return
+		case <-shutdownCh:
+			return
+		}
+
+		// Stop closes the watch channel too.
+		select {
+		case <-q.stopCh:
+			return
+		default:
+		}
+
+		deltas, err := q.Refresh()
+		if err != nil {
+			q.l.Lock()
+			q.err = err
+			q.l.Unlock()
+			q.db.logger.Error("live query failed", "table", q.table, "error", err)
+			return
+		}
+		if len(deltas) == 0 {
+			continue
+		}
+
+		select {
+		case ch <- deltas:
+		case <-q.stopCh:
+			return
+		case <-shutdownCh:
+			return
+		}
+	}
+}
+
+// removeLiveQuery removes a live query from the WatchersTable.
+func (db *MemDB) removeLiveQuery(q *LiveQuery) {
+	db.subsLock.Lock()
+	defer db.subsLock.Unlock()
+	for i, other := range db.liveQueries {
+		if other == q {
+			db.liveQueries = append(db.liveQueries[:i], db.liveQueries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Stop stops the delivery of deltas started by Start.
+func (q *LiveQuery) Stop() {
+	q.l.Lock()
+	defer q.l.Unlock()
+	if !q.stopped {
+		close(q.stopCh)
+		q.stopped = true
+		q.closeWatcher()
+	}
+}
+
+// Err returns the error that stopped the delivery of deltas, if any.
+func (q *LiveQuery) Err() error {
+	q.l.Lock()
+	defer q.l.Unlock()
+	return q.err
+}
@@ -0,0 +1,190 @@
+package memdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveQuery(t *testing.T) {
+	db := testDB(t)
+	insert := func(objs ...*TestObject) {
+		txn := db.Txn(true)
+		for _, obj := range objs {
+			if err := txn.Insert("main", obj); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		txn.Commit()
+	}
+	a := &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}
+	insert(a, &TestObject{ID: "b", Foo: "y", Qux: []string{"2"}})
+
+	q, err := NewLiveQuery(db, "main", func(txn *Txn) (ResultIterator, error) {
+		return txn.Get("main", "foo", "x")
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if results := q.Results(); len(results) != 1 || results[0] != a {
+		t.Fatalf("bad: %#v", results)
+	}
+
+	ch := q.Start()
+	next := func() []Delta {
+		select {
+		case deltas, ok := <-ch:
+			if !ok {
+				t.Fatalf("closed: %v", q.Err())
+			}
+			return deltas
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+		return nil
+	}
+
+	// An object moving into the results, and another one updated
+	a2 := &TestObject{ID: "a", Foo: "x", Qux: []string{"3"}}
+	b := &TestObject{ID: "b", Foo: "x", Qux: []string{"2"}}
+	insert(a2, b)
+	deltas := next()
+	if len(deltas) != 2 ||
+		deltas[0].Kind != DeltaUpdated || deltas[0].Before != a || deltas[0].After != a2 ||
+		deltas[1].Kind != DeltaAdded || deltas[1].After != b {
+		t.Fatalf("bad: %#v", deltas)
+	}
+
+	// An object leaving the results
+	txn := db.Txn(true)
+	if err := txn.Delete("main", a2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	deltas = next()
+	if len(deltas) != 1 || deltas[0].Kind != DeltaRemoved || deltas[0].Before != a2 {
+		t.Fatalf("bad: %#v", deltas)
+	}
+	if results := q.Results(); len(results) != 1 || results[0] != b {
+		t.Fatalf("bad: %#v", results)
+	}
+
+	q.Stop()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("should be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	if err := q.Err(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestLiveQuery_Refresh(t *testing.T) {
+	db := testDB(t)
+	q, err := NewLiveQuery(db, "main", func(txn *Txn) (ResultIterator, error) {
+		return txn.Get("main", "id")
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Changes outside of the results don't produce deltas
+	deltas, err := q.Refresh()
+	if err != nil || len(deltas) != 0 {
+		t.Fatalf("bad: %#v %v", deltas, err)
+	}
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	deltas, err = q.Refresh()
+	if err != nil || len(deltas) != 1 || deltas[0].Kind != DeltaAdded {
+		t.Fatalf("bad: %#v %v", deltas, err)
+	}
+
+	// Delivery stops when the DB is closed
+	ch := q.Start()
+	db.Close()
+	if _, ok := <-ch; ok {
+		t.Fatalf("should be closed")
+	}
+
+	if _, err := NewLiveQuery(db, "nope", nil); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestLiveQuery_Compression(t *testing.T) {
+	db := testCompressedDB(t, 0)
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: id, Qux: []string{id}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	q, err := NewLiveQuery(db, "main", func(txn *Txn) (ResultIterator, error) {
+		return txn.Get("main", "id")
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Rows decoded again aren't reported as updated
+	deltas, err := q.Refresh()
+	if err != nil || len(deltas) != 0 {
+		t.Fatalf("bad: %#v %v", deltas, err)
+	}
+
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "b", Foo: "x", Qux: []string{"b"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	deltas, err = q.Refresh()
+	if err != nil || len(deltas) != 1 || deltas[0].Kind != DeltaUpdated {
+		t.Fatalf("bad: %#v %v", deltas, err)
+	}
+	if after := deltas[0].After.(*TestObject); after.ID != "b" || after.Foo != "x" {
+		t.Fatalf("bad: %#v", after)
+	}
+}
+
+func TestLiveQuery_DeterministicMode(t *testing.T) {
+	db, err := NewMemDB(testValidSchema(), WithDeterministicMode())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	q, err := NewLiveQuery(db, "main", func(txn *Txn) (ResultIterator, error) {
+		return txn.Get("main", "id")
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Start refuses to run a goroutine
+	if _, ok := <-q.Start(); ok {
+		t.Fatalf("should be closed")
+	}
+	if err := q.Err(); err == nil || !strings.Contains(err.Error(), "deterministic mode") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Refresh still works
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	deltas, err := q.Refresh()
+	if err != nil || len(deltas) != 1 || deltas[0].Kind != DeltaAdded {
+		t.Fatalf("bad: %#v %v", deltas, err)
+	}
+}
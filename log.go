@@ -0,0 +1,30 @@
+package memdb
+
+// Logger is the structured logger used by a MemDB. Messages are followed by
+// alternating keys and values. The method set matches both *slog.Logger and
+// hclog.Logger, so either can be passed to WithLogger as is.
+//
+// Logger 是结构化日志接口，*slog.Logger 和 hclog.Logger 均满足该接口。
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// WithLogger sets the logger of the MemDB. The MemDB logs schema validation
+// failures, constraint violations, background job activity and snapshot
+// restores. By default nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(db *MemDB) {
+		db.logger = logger
+	}
+}
+
+// nopLogger is the Logger used when none is configured.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
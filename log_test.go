@@ -0,0 +1,102 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger records the messages logged at or above Info, along with the
+// debug messages if verbose is set.
+type testLogger struct {
+	l       sync.Mutex
+	verbose bool
+	lines   []string
+}
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	line := level + " " + msg
+	for i := 0; i+1 < len(args); i += 2 {
+		line += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+	l.lines = append(l.lines, line)
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) {
+	if l.verbose {
+		l.log("DEBUG", msg, args)
+	}
+}
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+
+func TestMemDB_Logger(t *testing.T) {
+	logger := &testLogger{}
+	if _, err := NewMemDB(&DBSchema{}, WithLogger(logger)); err == nil {
+		t.Fatalf("expected error")
+	}
+	if len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], "ERROR invalid schema") {
+		t.Fatalf("bad: %#v", logger.lines)
+	}
+
+	// Constraint violations
+	logger = &testLogger{}
+	db, err := NewMemDB(testConstraintSchema(), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	if err := txn.Insert("physical", &testNode{ID: "1", Hostname: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("virtual", &testNode{ID: "1", Hostname: "a"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+	expect := []string{"WARN unique constraint violated constraint=hostname table=virtual existing_table=physical"}
+	if !reflect.DeepEqual(logger.lines, expect) {
+		t.Fatalf("bad: %#v", logger.lines)
+	}
+
+	// Background jobs
+	logger = &testLogger{verbose: true}
+	clock := NewManualClock(time.Unix(0, 0))
+	db, err = NewMemDB(testValidSchema(), WithLogger(logger), WithClock(clock),
+		WithDeterministicMode(), WithIdempotencyRetention(time.Minute))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	clock.Advance(time.Minute)
+	db.Tick()
+	expect = []string{"DEBUG running background job job=idempotency reaper"}
+	if !reflect.DeepEqual(logger.lines, expect) {
+		t.Fatalf("bad: %#v", logger.lines)
+	}
+
+	// Snapshot restores
+	logger = &testLogger{}
+	db, _ = testSnapshotDB(t, WithCodec(testCodec()))
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	data := buf.Bytes()
+	if _, err := RestoreSnapshot(bytes.NewReader(data), testValidSchema(), WithCodec(testCodec()), WithLogger(logger)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := RestoreSnapshot(bytes.NewReader(data[:len(data)-1]), testValidSchema(), WithCodec(testCodec()), WithLogger(logger)); err == nil {
+		t.Fatalf("expected error")
+	}
+	if len(logger.lines) != 2 ||
+		!strings.HasPrefix(logger.lines[0], "INFO restored snapshot") ||
+		!strings.HasPrefix(logger.lines[1], "ERROR failed to restore snapshot") {
+		t.Fatalf("bad: %#v", logger.lines)
+	}
+}
@@ -0,0 +1,209 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LogEntry is an object of a log table.
+type LogEntry struct {
+	// Offset is the position of the entry in the log. Offsets start at 1,
+	// only grow and are never reused by the same MemDB, though there may
+	// be gaps left by aborted transactions.
+	Offset uint64
+	Time   time.Time
+	Value  interface{}
+}
+
+// LogRetention bounds what a log table keeps. Entries beyond the limits are
+// removed, oldest first. A zero field means no limit.
+type LogRetention struct {
+	// MaxEntries is the maximum number of entries kept.
+	MaxEntries int
+
+	// MaxAge is how long entries are kept after they are appended.
+	MaxAge time.Duration
+}
+
+// LogTableSchema returns the schema for a log table with the given name and
+// retention, to be added to the DBSchema of the MemDB. A log table is an
+// append-only event log: entries are added with Txn.Append, read in order
+// with Txn.ReadFrom, and can't be updated. The retention is enforced by
+// Append and Txn.TrimLog, in the same transaction, so entries only disappear
+// when a write transaction commits.
+//
+// LogTableSchema 返回只追加日志表的模式，条目按偏移量顺序读取，并按保留策略裁剪。
+func LogTableSchema(name string, retention LogRetention) *TableSchema {
+	return &TableSchema{
+		Name: name,
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &UintFieldIndex{Field: "Offset"},
+			},
+		},
+		Log: &retention,
+	}
+}
+
+// validateLog checks that a log table was built by LogTableSchema.
+func (s *TableSchema) validateLog() error {
+	idSchema, ok := s.Indexes[id]
+	if !ok || len(s.Indexes) != 1 {
+		return fmt.Errorf("log table must only have the id index")
+	}
+	if indexer, ok := idSchema.Indexer.(*UintFieldIndex); !ok || indexer.Field != "Offset" {
+		return fmt.Errorf("log table must be created with LogTableSchema")
+	}
+	if s.Inline || s.Singleton || s.Set || s.Counter || s.LargeObjects != nil || s.Compression != nil {
+		return fmt.Errorf("log table can't be inline, a singleton, a set, a counter or store large or compressed objects")
+	}
+	if s.Log.MaxEntries < 0 || s.Log.MaxAge < 0 {
+		return fmt.Errorf("log retention can't be negative")
+	}
+	return nil
+}
+
+// Append adds a value at the end of a log table and returns the offset of
+// the new entry. The retention of the table is then enforced.
+func (txn *Txn) Append(table string, value interface{}) (uint64, error) {
+	if _, err := txn.logSchema(table); err != nil {
+		return 0, err
+	}
+	last, err := txn.Last(table, id)
+	if err != nil {
+		return 0, err
+	}
+	var offset uint64
+	if last != nil {
+		offset = last.(*LogEntry).Offset
+	}
+
+	// The last offset is remembered for when retention empties the log
+	offset = txn.db.nextTableID(table, offset)
+	entry := &LogEntry{
+		Offset: offset,
+		Time:   txn.db.clock.Now(),
+		Value:  value,
+	}
+	if err := txn.Insert(table, entry); err != nil {
+		return 0, err
+	}
+	if _, err := txn.TrimLog(table); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// TrimLog removes the entries of a log table that are beyond its retention,
+// and returns how many were removed. Append trims the log already, so this
+// is only needed to expire entries by age in a log that isn't written to.
+func (txn *Txn) TrimLog(table string) (int, error) {
+	retention, err := txn.logSchema(table)
+	if err != nil {
+		return 0, err
+	}
+	over := 0
+	if retention.MaxEntries > 0 {
+		size := txn.readableIndex(table, id).CommitOnly().Len()
+		if size > retention.MaxEntries {
+			over = size - retention.MaxEntries
+		}
+	}
+	var cutoff time.Time
+	if retention.MaxAge > 0 {
+		cutoff = txn.db.clock.Now().Add(-retention.MaxAge)
+	} else if over == 0 {
+		return 0, nil
+	}
+
+	iter, err := txn.Get(table, id)
+	if err != nil {
+		return 0, err
+	}
+	var expired []*LogEntry
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		entry := raw.(*LogEntry)
+		if len(expired) >= over && (cutoff.IsZero() || !entry.Time.Before(cutoff)) {
+			break
+		}
+		expired = append(expired, entry)
+	}
+	for _, entry := range expired {
+		if err := txn.Delete(table, entry); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
+// LogIterator iterates over the entries of a log table in offset order.
+type LogIterator struct {
+	iter ResultIterator
+}
+
+// WatchCh returns a channel that is closed when the entries change,
+// including when new ones are appended.
+func (i *LogIterator) WatchCh() <-chan struct{} {
+	return i.iter.WatchCh()
+}
+
+// WatchCtx waits for the entries to change, or for the context to be done.
+// Consumers tailing a log call it once they reached the end.
+func (i *LogIterator) WatchCtx(ctx context.Context) error {
+	return WatchCtx(ctx, i.iter.WatchCh())
+}
+
+// Next returns the next entry, or nil once there are no more.
+func (i *LogIterator) Next() *LogEntry {
+	raw := i.iter.Next()
+	if raw == nil {
+		return nil
+	}
+	return raw.(*LogEntry)
+}
+
+// ReadFrom returns an iterator over the entries of a log table starting at
+// the given offset. If that entry was trimmed, iteration starts at the
+// oldest entry kept, whose offset tells the caller what was missed.
+func (txn *Txn) ReadFrom(table string, offset uint64) (*LogIterator, error) {
+	if _, err := txn.logSchema(table); err != nil {
+		return nil, err
+	}
+	iter, err := txn.LowerBound(table, id, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &LogIterator{iter: iter}, nil
+}
+
+// logSchema returns the retention of a log table, or an error if the table
+// isn't a log table.
+func (txn *Txn) logSchema(table string) (*LogRetention, error) {
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+	if tableSchema.Log == nil {
+		return nil, fmt.Errorf("table '%s' is not a log table", table)
+	}
+	return tableSchema.Log, nil
+}
+
+// nextTableID returns the ID following the last one of a log or queue table,
+// or the last one handed out if that is larger. IDs handed out by aborted
+// transactions are skipped, which is fine since they only need to grow.
+func (db *MemDB) nextTableID(table string, last uint64) uint64 {
+	db.tableIDsLock.Lock()
+	defer db.tableIDsLock.Unlock()
+	if db.tableIDs == nil {
+		db.tableIDs = make(map[string]uint64)
+	}
+	if last < db.tableIDs[table] {
+		last = db.tableIDs[table]
+	}
+	db.tableIDs[table] = last + 1
+	return last + 1
+}
@@ -0,0 +1,71 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTxn_LogTable(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	schema := testValidSchema()
+	schema.Tables["events"] = LogTableSchema("events", LogRetention{MaxEntries: 3, MaxAge: time.Hour})
+	db, err := NewMemDB(schema, WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for i := 1; i <= 4; i++ {
+		offset, err := txn.Append("events", i)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if offset != uint64(i) {
+			t.Fatalf("bad: %d", offset)
+		}
+	}
+	txn.Commit()
+
+	// The oldest entry went over the count limit
+	readOffsets := func(offset uint64) []uint64 {
+		iter, err := db.Txn(false).ReadFrom("events", offset)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var offsets []uint64
+		for entry := iter.Next(); entry != nil; entry = iter.Next() {
+			offsets = append(offsets, entry.Offset)
+		}
+		return offsets
+	}
+	if offsets := readOffsets(0); len(offsets) != 3 || offsets[0] != 2 {
+		t.Fatalf("bad: %#v", offsets)
+	}
+	if offsets := readOffsets(4); len(offsets) != 1 || offsets[0] != 4 {
+		t.Fatalf("bad: %#v", offsets)
+	}
+
+	// Entries can't be updated
+	txn = db.Txn(true)
+	if err := txn.Insert("events", &LogEntry{Offset: 4}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	// Old entries expire, and offsets keep growing once the log is empty
+	clock.Advance(2 * time.Hour)
+	txn = db.Txn(true)
+	if num, err := txn.TrimLog("events"); err != nil || num != 3 {
+		t.Fatalf("bad: %d %v", num, err)
+	}
+	if offset, err := txn.Append("events", 5); err != nil || offset != 5 {
+		t.Fatalf("bad: %d %v", offset, err)
+	}
+	if _, err := txn.Append("main", 6); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Commit()
+	if offsets := readOffsets(0); len(offsets) != 1 || offsets[0] != 5 {
+		t.Fatalf("bad: %#v", offsets)
+	}
+}
@@ -3,8 +3,10 @@
 package memdb
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/hashicorp/go-immutable-radix"
@@ -36,36 +38,202 @@ import (
 // 即使是已从 MemDB 中删除的对象，修改这些对象仍然是不安全的，因为可能有旧的数据库快照正在被其他 goroutine 读取。
 
 type MemDB struct {
-	schema  *DBSchema
-	root    unsafe.Pointer // *iradix.Tree underneath
+	// commitIndex is incremented for every committed write transaction. It
+	// is accessed atomically and kept first for 64-bit alignment.
+	commitIndex uint64
+
+	// rootSeq is odd while a commit is swapping the root and advancing the
+	// commit index, so that ReadView can load both consistently.
+	rootSeq uint64
+
+	// root is kept apart from the fields written by commits, so that
+	// loading it doesn't contend with them.
+	_    [cacheLinePad]byte
+	root unsafe.Pointer // *iradix.Tree underneath
+	_    [cacheLinePad]byte
+
 	primary bool
 
-	// There can only be a single writer at once
-	writer sync.Mutex
+	// commits holds the []*CommitInfo for the most recent commits.
+	commits atomic.Value
+
+	// clock is the source of time for the DB.
+	clock Clock
+
+	// codec serializes objects for snapshots. dataVersion is recorded in
+	// snapshots, and migrations upgrade rows from older data versions.
+	codec       Codec
+	dataVersion uint64
+	migrations  map[uint64]Migration
+
+	// writeStatsFn is called with the index writes of every commit.
+	writeStatsFn WriteStatsFunc
+
+	// deterministic disables background goroutines; jobs are only run by
+	// explicit calls to Tick.
+	deterministic bool
+
+	// jobLimiter, if set, is used for admission control of job runs.
+	jobLimiter *TokenBucket
+
+	// jobs holds the registered background jobs in deterministic mode.
+	// The shutdown fields are used to stop the job goroutines otherwise.
+	jobs       []*backgroundJob
+	jobsLock   sync.Mutex
+	jobsWg     sync.WaitGroup
+	shutdown   bool
+	shutdownCh chan struct{}
+
+	// idempotencyRetention is how long idempotency records are kept, or
+	// zero to keep them forever.
+	idempotencyRetention time.Duration
+
+	// ttlInterval is how often expired objects are deleted by the job
+	// started once with ttlReaper.
+	ttlInterval time.Duration
+	ttlReaper   sync.Once
+
+	// outboxIDs holds the last event ID handed out per outbox table, so IDs
+	// are not reused once the dispatcher has emptied an outbox.
+	outboxIDs  map[string]uint64
+	outboxLock sync.Mutex
+
+	// tableIDs holds the last ID handed out per log and queue table, so IDs
+	// are not reused once a table was emptied.
+	tableIDs     map[string]uint64
+	tableIDsLock sync.Mutex
+
+	// repanic makes transactions panic again after recovering from a panic.
+	repanic bool
+
+	// strictDeprecation makes queries against deprecated indexes fail.
+	strictDeprecation bool
+
+	// indexUsage counts the reads and writes of every index, if enabled.
+	indexUsage *indexUsage
+
+	// txnLimits bounds the size of write transactions.
+	txnLimits TxnLimits
+
+	// walOptions configures the write-ahead log, which is wal once opened.
+	walOptions *WALOptions
+	wal        *wal
+
+	// commitHistograms records the size and duration of commits.
+	commitHistograms *commitHistograms
+
+	// abortFn is called for each aborted transaction, which are counted in
+	// aborts.
+	abortFn AbortFunc
+	aborts  abortCounts
+
+	// conflictFn is called for each conflicting optimistic transaction.
+	conflictFn ConflictFunc
+
+	// faultFn injects faults for tests.
+	faultFn FaultFunc
+
+	// logger receives the structured logs of the MemDB.
+	logger Logger
+
+	// alarms are the thresholds checked after each commit.
+	alarms []*Alarm
+
+	// bootstrapBases holds the latest snapshots streamed to replicas by
+	// ServeBootstrap, so interrupted bootstraps can resume from them.
+	bootstrapBases []*MemDB
+	bootstrapLock  sync.Mutex
+
+	// pinned holds the snapshots kept with PinSnapshot, by name.
+	pinned  map[string]*MemDB
+	pinLock sync.Mutex
+
+	// subscribers receive the changes of every commit, and liveQueries
+	// are the live queries started with Start. watchers numbers both of
+	// them for the WatchersTable. They are guarded by subsLock.
+	subscribers []*Subscription
+	liveQueries []*LiveQuery
+	watchers    uint64
+	subsLock    sync.Mutex
+
+	// dualWrites holds the dual writes started with StartDualWrite and the
+	// tables retired by their cutover. It is replaced with the writer lock
+	// held, and never modified.
+	dualWrites    *dualWriteState
+	dualWriteLock sync.Mutex
+
+	// There can only be a single writer at once, unless writers lock
+	// tables with TxnTables, which share the writer lock and take the
+	// locks of tableLocks instead. Their commits are serialized by
+	// commitLock.
+	writer         writerLock
+	tableLocks     map[string]*sync.Mutex
+	tableLocksLock sync.Mutex
+	commitLock     sync.Mutex
 }
 
-// NewMemDB creates a new MemDB with the given schema.
-func NewMemDB(schema *DBSchema) (*MemDB, error) {
+// NewMemDB creates a new MemDB with the given schema. Optional behavior can
+// be configured by passing any number of Options.
+func NewMemDB(schema *DBSchema, opts ...Option) (*MemDB, error) {
+	// Create the MemDB
+	db := &MemDB{
+		primary: true,
+		clock:   systemClock{},
+		logger:  nopLogger{},
+
+		commitHistograms: &commitHistograms{},
+	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	db.setRoot(iradix.New())
+
 	// Validate the schema
 	if err := schema.Validate(); err != nil {
+		db.logger.Error("invalid schema", "error", err)
 		return nil, err
 	}
-
-	// Create the MemDB
-	db := &MemDB{
-		schema:  schema,
-		root:    unsafe.Pointer(iradix.New()),
-		primary: true,
+	for _, alarm := range db.alarms {
+		if err := alarm.validate(schema); err != nil {
+			err = fmt.Errorf("alarm on table '%s': %v", alarm.Table, err)
+			db.logger.Error("invalid alarm", "error", err)
+			return nil, err
+		}
 	}
 
 	// Init MemDB
-	if err := db.initialize(); err != nil {
+	if err := db.initialize(schema); err != nil {
 		return nil, err
 	}
+	if db.walOptions != nil {
+		if err := db.openWAL(); err != nil {
+			db.logger.Error("failed to open write-ahead log", "error", err)
+			return nil, err
+		}
+	}
+	if db.idempotencyRetention > 0 {
+		db.startIdempotencyReaper()
+	}
+	if schema.hasTTL() {
+		db.startTTLReaper()
+	}
 
 	return db, nil
 }
 
+// getSchema returns the current schema of the DB. The schema is stored in
+// the root tree, so it always matches the index trees found there, even as
+// it changes with ReloadSchema or DropIndex.
+func (db *MemDB) getSchema() *DBSchema {
+	return rootSchema(db.getRoot())
+}
+
+// rootSchema returns the schema stored in the given root tree.
+func rootSchema(root *iradix.Tree) *DBSchema {
+	raw, _ := root.Get(schemaPath)
+	return raw.(*DBSchema)
+}
+
 // getRoot is used to do an atomic load of the root pointer
 func (db *MemDB) getRoot() *iradix.Tree {
 	root := (*iradix.Tree)(atomic.LoadPointer(&db.root))
@@ -74,16 +242,31 @@ func (db *MemDB) getRoot() *iradix.Tree {
 
 // Txn is used to start a new transaction in either read or write mode.
 // There can only be a single concurrent writer, but any number of readers.
+// Writers that only touch some tables can use TxnTables to run alongside
+// each other.
 func (db *MemDB) Txn(write bool) *Txn {
 	// 写事务加锁
 	if write {
 		db.writer.Lock()
 	}
 	// 创建事务对象
+	root := db.getRoot()
 	txn := &Txn{
 		db:      db,
 		write:   write,
-		rootTxn: db.getRoot().Txn(),
+		schema:  rootSchema(root),
+		rootTxn: root.Txn(),
+	}
+
+	if write {
+		txn.started = db.clock.Now()
+		txn.dualWrites = db.getDualWrites()
+	}
+
+	// The write-ahead log, the subscriptions and the dual writes are made of
+	// the changes of each commit
+	if write && (db.wal != nil || db.hasSubscribers() || txn.dualWrites.mirrors()) {
+		txn.TrackChanges()
 	}
 	return txn
 }
@@ -95,10 +278,16 @@ func (db *MemDB) Txn(write bool) *Txn {
 // the Snapshot will not deep copy those values. Therefore, it is still unsafe
 // to modify any inserted values in either DB.
 func (db *MemDB) Snapshot() *MemDB {
+	root, index := db.loadRoot()
 	clone := &MemDB{
-		schema:  db.schema,
-		root:    unsafe.Pointer(db.getRoot()),
-		primary: false,
+		commitIndex: index,
+		root:        unsafe.Pointer(root),
+		primary:     false,
+		clock:       db.clock,
+		codec:       db.codec,
+		dataVersion: db.dataVersion,
+		migrations:  db.migrations,
+		logger:      db.logger,
 	}
 	return clone
 }
@@ -108,20 +297,40 @@ func (db *MemDB) Snapshot() *MemDB {
 //
 // initialize 用于设置创建后使用的数据库。
 // 在分配一个 MemDB 之后，这个函数只能调用一次。
-func (db *MemDB) initialize() error {
+func (db *MemDB) initialize(schema *DBSchema) error {
 	root := db.getRoot()
+	root, _, _ = root.Insert(schemaPath, schema)
 	// 为每个 table.index 创建一个索引 radix tree 结构，类似于 mysql 的每个索引是一个 btree 。
-	for tableName, tableSchema := range db.schema.Tables {
+	for tableName, tableSchema := range schema.Tables {
+		// Inline tables keep their rows in a single value instead.
+		if tableSchema.Inline {
+			root, _, _ = root.Insert(inlinePath(tableName), newInlineTable(nil))
+			continue
+		}
 		for iName := range tableSchema.Indexes {
 			// 每次 root.Insert 创建一个副本
 			root, _, _ = root.Insert(indexPath(tableName, iName), iradix.New())
 		}
 	}
+	// System tables that hold state of their own are stored like any other.
+	for tableName, tableSchema := range systemSchema.Tables {
+		if !isStoredSystemTable(tableName) {
+			continue
+		}
+		for iName := range tableSchema.Indexes {
+			root, _, _ = root.Insert(indexPath(tableName, iName), iradix.New())
+		}
+	}
+	root, _, _ = root.Insert(indexPath(AggregatesTable, id), initAggregates(schema))
 	// 覆盖 db.root
-	db.root = unsafe.Pointer(root)
+	db.setRoot(root)
 	return nil
 }
 
+// schemaPath is the path from the root to the schema. Table names can't be
+// empty, so it never clashes with the path of an index.
+var schemaPath = []byte("\x00schema")
+
 // indexPath returns the path from the root to the given table index
 //
 // 表名.索引
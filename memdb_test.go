@@ -79,3 +79,45 @@ func TestMemDB_Snapshot(t *testing.T) {
 		t.Fatalf("should exist")
 	}
 }
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fixedClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.now.Add(d)
+	return ch
+}
+
+func TestMemDB_WithClock(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	db, err := NewMemDB(testValidSchema(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", testObj()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	raw, err := txn.Last(CommitsTable, "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if commit := raw.(*CommitInfo); !commit.Time.Equal(clock.now) {
+		t.Fatalf("bad: %v", commit.Time)
+	}
+
+	// Snapshots share the clock
+	if snap := db.Snapshot(); snap.clock != Clock(clock) {
+		t.Fatalf("bad: %#v", snap.clock)
+	}
+}
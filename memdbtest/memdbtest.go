@@ -0,0 +1,429 @@
+// Package memdbtest checks the transactional guarantees of a MemDB by
+// running randomized concurrent workloads against a model of the expected
+// state. It can be used to validate forks and new features, such as
+// options or table settings that change how objects are stored.
+package memdbtest
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+
+	memdb "github.com/hashicorp/go-memdb"
+)
+
+// Row is the object stored in the "rows" table of the Schema.
+type Row struct {
+	Key   string
+	Group string
+	Value int
+}
+
+// Version is the object stored in the "version" table of the Schema. Every
+// committed write transaction increments it, which orders the commits.
+type Version struct {
+	ID    string
+	Value uint64
+}
+
+// versionID is the ID of the only Version.
+const versionID = "version"
+
+// groups are the values of Row.Group.
+var groups = []string{"red", "green", "blue"}
+
+// Schema returns the schema used by Check. Rows are indexed by Key, Group
+// and Value, so that reads can compare the indexes with each other.
+//
+// Schema 返回 Check 使用的数据库模式。
+func Schema() *memdb.DBSchema {
+	return &memdb.DBSchema{
+		Tables: map[string]*memdb.TableSchema{
+			"rows": &memdb.TableSchema{
+				Name: "rows",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "Key"},
+					},
+					"group": &memdb.IndexSchema{
+						Name:    "group",
+						Indexer: &memdb.StringFieldIndex{Field: "Group"},
+					},
+					"value": &memdb.IndexSchema{
+						Name:    "value",
+						Indexer: &memdb.IntFieldIndexer{Field: "Value"},
+					},
+				},
+			},
+			"version": &memdb.TableSchema{
+				Name: "version",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "ID"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Config configures the workload run by Check. Zero fields take their
+// default values.
+type Config struct {
+	// Seed seeds the random operations. Goroutines are scheduled at
+	// random too, so a seed doesn't reproduce a run exactly, but it
+	// reproduces the operations of each goroutine.
+	Seed int64
+
+	// Workers is the number of goroutines running transactions, 4 by
+	// default.
+	Workers int
+
+	// Txns is the number of transactions run by each worker, 200 by
+	// default. About half of them are write transactions, a fifth of
+	// which are aborted.
+	Txns int
+
+	// Keys is the number of distinct keys written, 16 by default. Fewer
+	// keys make the transactions write the same rows more often.
+	Keys int
+
+	// NewDB creates the DB under test from the Schema, which it may modify
+	// as long as the tables keep their indexes. It is memdb.NewMemDB by
+	// default.
+	NewDB func(schema *memdb.DBSchema) (*memdb.MemDB, error)
+}
+
+// Check runs the workload described by config and returns an error
+// describing the first violated invariant, if any. The invariants are:
+//
+//   - Writes within a write transaction are visible to its own reads.
+//   - Write transactions are serialized, and aborted ones have no effect.
+//   - Read transactions see the state left by exactly one committed write
+//     transaction, and keep seeing it while other transactions commit.
+//   - The secondary indexes hold the same rows as the primary index, in
+//     index order.
+//
+// Check 运行随机并发负载，并根据模型校验事务的隔离性和索引一致性。
+func Check(config Config) error {
+	if config.Workers == 0 {
+		config.Workers = 4
+	}
+	if config.Txns == 0 {
+		config.Txns = 200
+	}
+	if config.Keys == 0 {
+		config.Keys = 16
+	}
+	newDB := config.NewDB
+	if newDB == nil {
+		newDB = func(schema *memdb.DBSchema) (*memdb.MemDB, error) {
+			return memdb.NewMemDB(schema)
+		}
+	}
+	db, err := newDB(Schema())
+	if err != nil {
+		return fmt.Errorf("failed to create DB: %v", err)
+	}
+
+	h := &harness{
+		db:      db,
+		keys:    config.Keys,
+		commits: make(map[uint64][]op),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < config.Workers; i++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for j := 0; j < config.Txns && h.err() == nil; j++ {
+				var err error
+				if rng.Intn(2) == 0 {
+					err = h.write(rng)
+				} else {
+					err = h.read(rng)
+				}
+				if err != nil {
+					h.fail(err)
+				}
+			}
+		}(rand.New(rand.NewSource(config.Seed + int64(i))))
+	}
+	wg.Wait()
+	if err := h.err(); err != nil {
+		return fmt.Errorf("seed %d: %v", config.Seed, err)
+	}
+
+	// The final state is observed like any other
+	if err := h.read(rand.New(rand.NewSource(config.Seed))); err != nil {
+		return fmt.Errorf("seed %d: %v", config.Seed, err)
+	}
+	if err := h.verify(); err != nil {
+		return fmt.Errorf("seed %d: %v", config.Seed, err)
+	}
+	return nil
+}
+
+// op is a write of a committed transaction. A nil row is a delete, which
+// found the row if found is true.
+type op struct {
+	key   string
+	row   *Row
+	found bool
+}
+
+// observation is the state seen by a read transaction.
+type observation struct {
+	version uint64
+	rows    map[string]Row
+}
+
+// harness holds the model built from the committed transactions. The
+// transactions are only checked against the model once every worker is
+// done, as a commit may be recorded after a reader saw it.
+type harness struct {
+	db   *memdb.MemDB
+	keys int
+
+	lock         sync.Mutex
+	commits      map[uint64][]op
+	observations []observation
+	firstErr     error
+}
+
+// fail records the first error of the workers.
+func (h *harness) fail(err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.firstErr == nil {
+		h.firstErr = err
+	}
+}
+
+// err returns the first error of the workers.
+func (h *harness) err() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.firstErr
+}
+
+// version returns the version of the state seen by the transaction.
+func version(txn *memdb.Txn) (uint64, error) {
+	raw, err := txn.First("version", "id", versionID)
+	if err != nil || raw == nil {
+		return 0, err
+	}
+	return raw.(*Version).Value, nil
+}
+
+// write runs a write transaction doing a few random inserts and deletes,
+// and records its writes if it commits.
+func (h *harness) write(rng *rand.Rand) error {
+	txn := h.db.Txn(true)
+	defer txn.Abort()
+	v, err := version(txn)
+	if err != nil {
+		return err
+	}
+
+	// local holds the rows written so far, nil for deleted ones
+	local := make(map[string]*Row)
+	var ops []op
+	for i := 1 + rng.Intn(4); i > 0; i-- {
+		key := fmt.Sprintf("key-%03d", rng.Intn(h.keys))
+		if rng.Intn(3) == 0 {
+			err := txn.Delete("rows", &Row{Key: key})
+			if err != nil && err != memdb.ErrNotFound {
+				return fmt.Errorf("failed to delete %s: %v", key, err)
+			}
+			found := err == nil
+			if row, ok := local[key]; ok && found != (row != nil) {
+				return fmt.Errorf("delete of %s found it: %v, after writing %#v", key, found, row)
+			}
+			local[key] = nil
+			ops = append(ops, op{key: key, found: found})
+		} else {
+			row := &Row{Key: key, Group: groups[rng.Intn(len(groups))], Value: rng.Intn(100) - 50}
+			if err := txn.Insert("rows", row); err != nil {
+				return fmt.Errorf("failed to insert %s: %v", key, err)
+			}
+			local[key] = row
+			ops = append(ops, op{key: key, row: row})
+		}
+
+		// Reads see the writes of the transaction
+		raw, err := txn.First("rows", "id", key)
+		if err != nil {
+			return err
+		}
+		if expected := local[key]; (raw == nil) != (expected == nil) ||
+			(raw != nil && *raw.(*Row) != *expected) {
+			return fmt.Errorf("read of %s in a write transaction got %#v, want %#v", key, raw, expected)
+		}
+	}
+
+	if rng.Intn(5) == 0 {
+		txn.Abort()
+		return nil
+	}
+	if err := txn.Insert("version", &Version{ID: versionID, Value: v + 1}); err != nil {
+		return err
+	}
+	txn.Commit()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.commits[v+1]; ok {
+		return fmt.Errorf("two transactions committed version %d", v+1)
+	}
+	h.commits[v+1] = ops
+	return nil
+}
+
+// read runs a read transaction checking the indexes against each other, and
+// records the state it saw.
+func (h *harness) read(rng *rand.Rand) error {
+	txn := h.db.Txn(false)
+	v, err := version(txn)
+	if err != nil {
+		return err
+	}
+	rows, err := scan(txn)
+	if err != nil {
+		return err
+	}
+
+	// Every group holds the rows of the group, in key order
+	for _, group := range groups {
+		iter, err := txn.Get("rows", "group", group)
+		if err != nil {
+			return err
+		}
+		var got, expected []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			row := *raw.(*Row)
+			if row != rows[row.Key] || row.Group != group {
+				return fmt.Errorf("group index of %s has %#v, want %#v", group, row, rows[row.Key])
+			}
+			got = append(got, row.Key)
+		}
+		for key, row := range rows {
+			if row.Group == group {
+				expected = append(expected, key)
+			}
+		}
+		sort.Strings(expected)
+		if !reflect.DeepEqual(got, expected) {
+			return fmt.Errorf("group index of %s has %v, want %v", group, got, expected)
+		}
+	}
+
+	// A range scan of the values returns them in order
+	min := rng.Intn(100) - 50
+	iter, err := txn.LowerBound("rows", "value", min)
+	if err != nil {
+		return err
+	}
+	count, prev := 0, min
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		row := *raw.(*Row)
+		if row != rows[row.Key] || row.Value < prev {
+			return fmt.Errorf("value index from %d has %#v after %d", min, row, prev)
+		}
+		count, prev = count+1, row.Value
+	}
+	expected := 0
+	for _, row := range rows {
+		if row.Value >= min {
+			expected++
+		}
+	}
+	if count != expected {
+		return fmt.Errorf("value index from %d has %d rows, want %d", min, count, expected)
+	}
+
+	// The snapshot doesn't change while others commit
+	runtime.Gosched()
+	if again, err := version(txn); err != nil || again != v {
+		return fmt.Errorf("version changed from %d to %d in a read transaction: %v", v, again, err)
+	}
+	again, err := scan(txn)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(rows, again) {
+		return fmt.Errorf("rows changed in a read transaction at version %d", v)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.observations = append(h.observations, observation{version: v, rows: rows})
+	return nil
+}
+
+// scan returns the rows seen by the transaction, checking that the primary
+// index is in key order.
+func scan(txn *memdb.Txn) (map[string]Row, error) {
+	iter, err := txn.Get("rows", "id")
+	if err != nil {
+		return nil, err
+	}
+	rows := make(map[string]Row)
+	prev := ""
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		row := *raw.(*Row)
+		if row.Key <= prev {
+			return nil, fmt.Errorf("primary index has %s after %s", row.Key, prev)
+		}
+		rows[row.Key] = row
+		prev = row.Key
+	}
+	return rows, nil
+}
+
+// verify replays the committed transactions in order, and checks that every
+// observation matches the state at its version.
+func (h *harness) verify() error {
+	sort.Slice(h.observations, func(i, j int) bool {
+		return h.observations[i].version < h.observations[j].version
+	})
+	state := make(map[string]Row)
+	next := 0
+	for v := uint64(0); next < len(h.observations); v++ {
+		if v > 0 {
+			ops, ok := h.commits[v]
+			if !ok {
+				return fmt.Errorf("version %d was observed but never committed", v)
+			}
+			for _, op := range ops {
+				_, exists := state[op.key]
+				switch {
+				case op.row != nil:
+					state[op.key] = *op.row
+				case op.found != exists:
+					return fmt.Errorf("delete of %s at version %d found it: %v, want %v", op.key, v, op.found, exists)
+				default:
+					delete(state, op.key)
+				}
+			}
+		}
+		for ; next < len(h.observations) && h.observations[next].version == v; next++ {
+			if rows := h.observations[next].rows; !reflect.DeepEqual(rows, state) {
+				return fmt.Errorf("read at version %d saw %v, want %v", v, rows, state)
+			}
+		}
+	}
+
+	// The last observation is the final state, after every commit
+	if last := h.observations[len(h.observations)-1].version; last != uint64(len(h.commits)) {
+		return fmt.Errorf("final version is %d, but %d transactions committed", last, len(h.commits))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package memdbtest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	memdb "github.com/hashicorp/go-memdb"
+)
+
+func TestCheck(t *testing.T) {
+	for seed := int64(0); seed < 3; seed++ {
+		if err := Check(Config{Seed: seed}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
+
+func TestCheck_Compression(t *testing.T) {
+	newDB := func(schema *memdb.DBSchema) (*memdb.MemDB, error) {
+		schema.Tables["rows"].Compression = &memdb.CompressionSchema{
+			Codec: &memdb.JSONCodec{
+				Types: map[string]reflect.Type{"rows": reflect.TypeOf(&Row{})},
+			},
+			CacheSize: 8,
+		}
+		return memdb.NewMemDB(schema)
+	}
+	if err := Check(Config{Workers: 2, Txns: 100, Keys: 4, NewDB: newDB}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCheck_NewDBError(t *testing.T) {
+	newDB := func(schema *memdb.DBSchema) (*memdb.MemDB, error) {
+		return nil, fmt.Errorf("nope")
+	}
+	if err := Check(Config{NewDB: newDB}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,282 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+var (
+	// ErrConflict is returned when committing a transaction started with
+	// TxnOptimistic if a concurrent commit changed what it read or wrote.
+	// The transaction is aborted, and should be run again.
+	ErrConflict = fmt.Errorf("transaction conflict")
+)
+
+// Conflict describes why an optimistic transaction conflicted with the
+// commits made since it started.
+//
+// Conflict 描述乐观事务与并发提交之间的冲突。
+type Conflict struct {
+	// Tables are the tables involved in the conflict, sorted.
+	Tables []string
+
+	// Reads are the index ranges read by the transaction that were changed
+	// by concurrent commits.
+	Reads []ReadRange
+
+	// Keys are the primary keys of the objects written by the transaction
+	// that were changed by concurrent commits.
+	Keys []ConflictKey
+
+	// Schema is set if the schema was changed concurrently.
+	Schema bool
+
+	// Err is the error making the writes of the transaction invalid on the
+	// latest state, if they failed to be applied to it.
+	Err error
+}
+
+// ConflictKey is the primary key of an object involved in a Conflict.
+type ConflictKey struct {
+	Table string
+	Key   []byte
+}
+
+// ConflictFunc is called with the Conflict of each optimistic transaction
+// failing to commit with ErrConflict.
+type ConflictFunc func(*Conflict)
+
+// WithConflictHook registers a function that is called when an optimistic
+// transaction conflicts, before TryCommit returns ErrConflict. It is called
+// once the writer lock has been released, and can be used to log the hot
+// spots of a workload.
+func WithConflictHook(fn ConflictFunc) Option {
+	return func(db *MemDB) {
+		db.conflictFn = fn
+	}
+}
+
+// TxnOptimistic starts an optimistic write transaction. Unlike Txn(true), it
+// doesn't take the writer lock, so any number of optimistic transactions
+// can prepare their writes concurrently against the current state. The lock
+// is only taken by the commit, which must be made with TryCommit: if another
+// transaction committed changes to anything the optimistic transaction read
+// or wrote since it started, it is aborted and ErrConflict is returned.
+// Otherwise its changes are applied on top of the latest state.
+//
+// Reads are tracked as with TrackReads, so a conflict is only reported when
+// a concurrent commit changed the keys of an index range that was actually
+// read. Transactions that don't overlap commit without conflicts, but each
+// commit made in between still has to be replayed, so this is best suited
+// to workloads with many short transactions touching distinct objects.
+// RetryWithPolicy runs optimistic transactions when the policy has
+// Optimistic set.
+//
+// Schema changes committed concurrently always conflict, and so do
+// concurrent changes that make the writes of the transaction invalid, such
+// as an insert breaking a unique index. Errors that don't depend on the
+// concurrent commits, such as a deferred constraint broken by the
+// transaction itself, are returned as they are.
+//
+// Only the changes made to the tables of the schema are replayed, so
+// NextSequence, TryLock, Unlock and CommitIdempotent return an error in
+// optimistic transactions.
+//
+// TxnOptimistic 开启乐观写事务，提交时检测读写集冲突并返回 ErrConflict 。
+func (db *MemDB) TxnOptimistic() *Txn {
+	root := db.getRoot()
+	txn := &Txn{
+		db:         db,
+		write:      true,
+		optimistic: true,
+		base:       root,
+		schema:     rootSchema(root),
+		rootTxn:    root.Txn(),
+		started:    db.clock.Now(),
+		dualWrites: db.getDualWrites(),
+	}
+	txn.TrackReads()
+	txn.TrackChanges()
+	return txn
+}
+
+// commitOptimistic takes the writer lock and commits an optimistic
+// transaction, either directly if nothing was committed since it started,
+// or by replaying its changes on the latest state.
+func (txn *Txn) commitOptimistic() error {
+	db := txn.db
+	db.writer.Lock()
+	root := db.getRoot()
+	if root == txn.base {
+		// The transaction now holds the writer lock like any other, and
+		// dual-writes as the others do now
+		txn.optimistic = false
+		txn.dualWrites = db.getDualWrites()
+		return txn.commit()
+	}
+
+	if conflict := txn.conflicts(root); conflict != nil {
+		db.writer.Unlock()
+		db.reportConflict(conflict)
+		return ErrConflict
+	}
+
+	// Constraints the transaction breaks by itself are its own error
+	if err := txn.checkDeferred(); err != nil {
+		db.writer.Unlock()
+		return err
+	}
+
+	replay := &Txn{
+		db:         db,
+		write:      true,
+		schema:     txn.schema,
+		rootTxn:    root.Txn(),
+		started:    txn.started,
+		after:      txn.after,
+		dualWrites: db.getDualWrites(),
+	}
+	if db.wal != nil || db.hasSubscribers() || replay.dualWrites.mirrors() {
+		replay.TrackChanges()
+	}
+
+	// The writes are replayed in the order they were made, so they only
+	// fail to apply if the commits made since the transaction started
+	// broke one of the constraints they satisfied
+	err := replay.applyChanges(txn.mutations())
+	if err == nil {
+		err = replay.checkDeferred()
+	}
+	conflicted := err != nil
+	if err == nil {
+		err = replay.commit()
+	}
+	if err != nil {
+		// A panic during the replay aborts it, which already released
+		// the writer lock
+		if replay.rootTxn != nil {
+			replay.rootTxn = nil
+			db.writer.Unlock()
+		}
+		replay.deleteBlobs(false)
+		if !conflicted {
+			return err
+		}
+		db.logger.Debug("optimistic transaction failed to replay", "error", err)
+		conflict := &Conflict{Err: err}
+		for _, change := range txn.Changes() {
+			conflict.addTable(change.Table)
+		}
+		db.reportConflict(conflict)
+		return ErrConflict
+	}
+
+	// The replay stored the objects again
+	txn.deleteBlobs(false)
+	txn.rootTxn = nil
+	txn.modified = nil
+	return nil
+}
+
+// applyChanges makes the changes of another transaction.
+func (txn *Txn) applyChanges(changes Changes) error {
+	for _, change := range changes {
+		var err error
+		if change.After != nil {
+			err = txn.Insert(change.Table, change.After)
+		} else {
+			err = txn.deleteByKey(change.Table, change.primaryKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conflicts returns the conflict if the commits made since the transaction
+// started, which led to the given root, changed what the transaction read or
+// wrote, or nil otherwise.
+func (txn *Txn) conflicts(root *iradix.Tree) *Conflict {
+	if rootSchema(root) != txn.schema {
+		return &Conflict{Schema: true}
+	}
+	conflict := &Conflict{}
+	base := &Txn{db: txn.db, schema: txn.schema, rootTxn: txn.base.Txn()}
+	latest := &Txn{db: txn.db, schema: txn.schema, rootTxn: root.Txn()}
+
+	for i := range txn.reads {
+		read := &txn.reads[i]
+		if isSystemTable(read.Table) && !isStoredSystemTable(read.Table) {
+			continue
+		}
+		before := base.readableIndex(read.Table, read.Index).Root()
+		after := latest.readableIndex(read.Table, read.Index).Root()
+		if !sameRange(before, after, read) {
+			conflict.Reads = append(conflict.Reads, *read)
+			conflict.addTable(read.Table)
+		}
+	}
+
+	for _, change := range txn.Changes() {
+		before, _ := base.readableIndex(change.Table, id).Get(change.primaryKey)
+		after, _ := latest.readableIndex(change.Table, id).Get(change.primaryKey)
+		if (before == nil) != (after == nil) || (before != nil && !sameObject(before, after)) {
+			conflict.Keys = append(conflict.Keys, ConflictKey{Table: change.Table, Key: change.primaryKey})
+			conflict.addTable(change.Table)
+		}
+	}
+	if conflict.Tables == nil {
+		return nil
+	}
+	return conflict
+}
+
+// addTable adds a table to the sorted tables of the conflict.
+func (c *Conflict) addTable(table string) {
+	i := sort.SearchStrings(c.Tables, table)
+	if i < len(c.Tables) && c.Tables[i] == table {
+		return
+	}
+	c.Tables = append(c.Tables, "")
+	copy(c.Tables[i+1:], c.Tables[i:])
+	c.Tables[i] = table
+}
+
+// reportConflict calls the conflict hook of the DB, if any.
+func (db *MemDB) reportConflict(conflict *Conflict) {
+	if db.conflictFn != nil {
+		db.conflictFn(conflict)
+	}
+}
+
+// sameRange returns true if both versions of an index hold the same entries
+// within the range.
+func sameRange(a, b *iradix.Node, read *ReadRange) bool {
+	if a == b {
+		return true
+	}
+	ia, ib := a.Iterator(), b.Iterator()
+	if read.Start != nil {
+		ia.SeekLowerBound(read.Start)
+		ib.SeekLowerBound(read.Start)
+	}
+	for {
+		ka, va, oka := ia.Next()
+		kb, vb, okb := ib.Next()
+		oka = oka && read.Contains(ka)
+		okb = okb && read.Contains(kb)
+		if oka != okb {
+			return false
+		}
+		if !oka {
+			return true
+		}
+		if !bytes.Equal(ka, kb) || !sameObject(va, vb) {
+			return false
+		}
+	}
+}
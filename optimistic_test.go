@@ -0,0 +1,319 @@
+package memdb
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTxnOptimistic_Conflict(t *testing.T) {
+	db := testDB(t)
+
+	// Both transactions read the object before writing it
+	update := func(txn *Txn, foo string) {
+		raw, err := txn.First("main", "id", "a")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		obj := &TestObject{ID: "a", Foo: foo, Qux: []string{"1"}}
+		if raw != nil {
+			obj.Bar = raw.(*TestObject).Bar + 1
+		}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn1, txn2 := db.TxnOptimistic(), db.TxnOptimistic()
+	update(txn1, "x")
+	update(txn2, "y")
+	if err := txn1.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn2.TryCommit(); err != ErrConflict {
+		t.Fatalf("bad: %v", err)
+	}
+	if raw, _ := db.Txn(false).First("main", "id", "a"); raw.(*TestObject).Foo != "x" {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if counts := db.AbortCounts(); counts[AbortConflict] != 1 {
+		t.Fatalf("bad: %#v", counts)
+	}
+
+	// The writer lock was released
+	db.Txn(true).Abort()
+}
+
+func TestTxnOptimistic_Disjoint(t *testing.T) {
+	db := testDB(t)
+
+	txn1, txn2 := db.TxnOptimistic(), db.TxnOptimistic()
+	if err := txn1.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw, err := txn2.First("main", "id", "b"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if err := txn2.Insert("main", &TestObject{ID: "b", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn1.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn2.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	iter, err := db.Txn(false).Get("main", "foo", "x")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	num := 0
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		num++
+	}
+	if num != 2 {
+		t.Fatalf("bad: %d", num)
+	}
+}
+
+func TestTxnOptimistic_Retry(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["hits"] = CounterTableSchema("hits")
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	policy := RetryPolicy{
+		MaxBackoff: time.Millisecond,
+		Optimistic: true,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				err := RetryWithPolicy(db, policy, func(txn *Txn) error {
+					_, err := txn.Increment("hits", "home", 1)
+					return err
+				})
+				if err != nil {
+					t.Errorf("err: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, err := db.Txn(false).CounterValue("hits", "home"); err != nil || value != 160 {
+		t.Fatalf("bad: %d %v", value, err)
+	}
+}
+
+func TestTxnOptimistic_ConflictHook(t *testing.T) {
+	var conflicts []*Conflict
+	db, err := NewMemDB(testValidSchema(), WithConflictHook(func(c *Conflict) {
+		conflicts = append(conflicts, c)
+	}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn1, txn2 := db.TxnOptimistic(), db.TxnOptimistic()
+	if err := txn1.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn2.First("main", "foo", "x"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn2.Insert("main", &TestObject{ID: "a", Foo: "y", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn1.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("bad: %#v", conflicts)
+	}
+	if err := txn2.TryCommit(); err != ErrConflict {
+		t.Fatalf("bad: %v", err)
+	}
+
+	if len(conflicts) != 1 {
+		t.Fatalf("bad: %#v", conflicts)
+	}
+	c := conflicts[0]
+	if len(c.Tables) != 1 || c.Tables[0] != "main" || c.Schema || c.Err != nil {
+		t.Fatalf("bad: %#v", c)
+	}
+	if len(c.Reads) != 1 || c.Reads[0].Index != "foo" {
+		t.Fatalf("bad: %#v", c.Reads)
+	}
+	if len(c.Keys) != 1 || c.Keys[0].Table != "main" || string(c.Keys[0].Key) != "a\x00" {
+		t.Fatalf("bad: %#v", c.Keys)
+	}
+}
+
+func TestTxnOptimistic_ReplayPanic(t *testing.T) {
+	explode := false
+	schema := testValidSchema()
+	schema.Tables["main"].Indexes["hook"] = &IndexSchema{
+		Name:         "hook",
+		AllowMissing: true,
+		Indexer: &FuncIndexer{
+			FromObjectFn: func(obj interface{}) (bool, []byte, error) {
+				if explode {
+					panic("boom")
+				}
+				return false, nil, nil
+			},
+			FromArgsFn: func(args ...interface{}) ([]byte, error) {
+				return nil, nil
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn1, txn2 := db.TxnOptimistic(), db.TxnOptimistic()
+	if err := txn1.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn2.Insert("main", &TestObject{ID: "b", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn1.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The replay of txn2 panics, which releases the writer lock once
+	explode = true
+	if err := txn2.TryCommit(); err != ErrConflict {
+		t.Fatalf("bad: %v", err)
+	}
+	explode = false
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "c", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestTxnOptimistic_Sequence(t *testing.T) {
+	db := testIDGenDB(t, &SequenceGenerator{}, &UintFieldIndex{Field: "ID"})
+
+	// The sequences aren't part of the changes replayed by the commit, so
+	// they can't be advanced
+	txn := db.TxnOptimistic()
+	if _, err := txn.NextSequence("tickets"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := txn.InsertID("tickets", &testTicket{}); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	wtxn := db.Txn(true)
+	n, err := wtxn.NextSequence("tickets")
+	if err != nil || n != 1 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	wtxn.Commit()
+}
+
+func TestTxnOptimistic_TryLock(t *testing.T) {
+	db := testDB(t)
+
+	// Two concurrent lockers would both see the lock free, so neither
+	// can take it
+	txn1, txn2 := db.TxnOptimistic(), db.TxnOptimistic()
+	for i, txn := range []*Txn{txn1, txn2} {
+		owner := []string{"alice", "bob"}[i]
+		if ok, err := txn.TryLock("k", owner, 0); err == nil || ok {
+			t.Fatalf("bad: %v %v", ok, err)
+		}
+		if err := txn.Unlock("k", owner); err == nil || err == ErrNotFound {
+			t.Fatalf("bad: %v", err)
+		}
+		if err := txn.TryCommit(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if raw, _ := db.Txn(false).First(LocksTable, "id", "k"); raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	if ok, err := db.TryLock("k", "alice", 0); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+}
+
+func TestTxnOptimistic_CommitIdempotent(t *testing.T) {
+	db := testDB(t)
+
+	// Neither of two concurrent commits of the key is applied
+	txn1, txn2 := db.TxnOptimistic(), db.TxnOptimistic()
+	for i, txn := range []*Txn{txn1, txn2} {
+		obj := testObj()
+		obj.ID = []string{"a", "b"}[i]
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if record, dup, err := txn.CommitIdempotent("key", nil); err == nil || dup || record != nil {
+			t.Fatalf("bad: %#v %v %v", record, dup, err)
+		}
+	}
+
+	rtxn := db.Txn(false)
+	if raw, _ := rtxn.First("main", "id", "a"); raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if raw, _ := rtxn.First(IdempotencyTable, "id", "key"); raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// Regular write transactions still record the key
+	txn := db.Txn(true)
+	if _, dup, err := txn.CommitIdempotent("key", nil); err != nil || dup {
+		t.Fatalf("bad: %v %v", dup, err)
+	}
+}
+
+func TestTxnOptimistic_ReplayOrder(t *testing.T) {
+	db := testForeignKeyDB(t, Restrict)
+
+	// The last write of the team comes after the member referencing it
+	txn := db.TxnOptimistic()
+	if err := txn.Insert("teams", &testTeam{ID: "3", Name: "green"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("members", &testMember{ID: "d", Team: "green"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("teams", &testTeam{ID: "3", Name: "green"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A commit that doesn't overlap makes the transaction replay
+	other := db.Txn(true)
+	if err := other.Insert("teams", &testTeam{ID: "4", Name: "yellow"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	other.Commit()
+
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw, err := db.Txn(false).First("members", "team", "green")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw == nil || raw.(*testMember).ID != "d" {
+		t.Fatalf("bad: %#v", raw)
+	}
+}
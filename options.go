@@ -0,0 +1,15 @@
+package memdb
+
+// Option is used to configure optional behavior of a MemDB when it is
+// created with NewMemDB.
+type Option func(*MemDB)
+
+// WithClock sets the Clock used by the MemDB. A nil clock leaves the default
+// system clock in place.
+func WithClock(clock Clock) Option {
+	return func(db *MemDB) {
+		if clock != nil {
+			db.clock = clock
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package memdb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OutboxEvent is a row of an outbox table. Events are written with
+// Txn.PublishEvent in the same transaction as the changes they describe, so
+// an event exists if and only if its changes were committed.
+type OutboxEvent struct {
+	// ID orders the events of an outbox. IDs start at 1 and are never
+	// reused by the same MemDB, though there may be gaps.
+	ID      uint64
+	Topic   string
+	Payload interface{}
+	Created time.Time
+}
+
+// OutboxTableSchema returns the schema for an outbox table with the given
+// name, to be added to the DBSchema of the MemDB.
+//
+// OutboxTableSchema 返回发件箱表的模式，需要加入到数据库模式中。
+func OutboxTableSchema(name string) *TableSchema {
+	return &TableSchema{
+		Name: name,
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &UintFieldIndex{Field: "ID"},
+			},
+		},
+	}
+}
+
+// PublishEvent adds an event to the given outbox table. The event is only
+// visible to the Outbox dispatcher once the transaction commits, and is
+// discarded along with the other changes if it aborts.
+func (txn *Txn) PublishEvent(table, topic string, payload interface{}) error {
+	last, err := txn.Last(table, id)
+	if err != nil {
+		return err
+	}
+
+	var lastID uint64
+	if last != nil {
+		prev, ok := last.(*OutboxEvent)
+		if !ok {
+			return fmt.Errorf("table '%s' is not an outbox", table)
+		}
+		lastID = prev.ID
+	}
+
+	// IDs handed out by aborted transactions are skipped, which is fine
+	// since they only need to be increasing.
+	db := txn.db
+	db.outboxLock.Lock()
+	if db.outboxIDs == nil {
+		db.outboxIDs = make(map[string]uint64)
+	}
+	if lastID < db.outboxIDs[table] {
+		lastID = db.outboxIDs[table]
+	}
+	db.outboxIDs[table] = lastID + 1
+	db.outboxLock.Unlock()
+
+	event := &OutboxEvent{
+		ID:      lastID + 1,
+		Topic:   topic,
+		Payload: payload,
+		Created: db.clock.Now(),
+	}
+	return txn.Insert(table, event)
+}
+
+// OutboxHandler is called by an Outbox for every event. Returning nil
+// acknowledges the event, which deletes it from the outbox. If an error is
+// returned the event is kept and delivered again on the next drain.
+type OutboxHandler func(event *OutboxEvent) error
+
+// Outbox delivers the events of an outbox table to a handler, in order and
+// at least once: an event is only deleted after the handler acknowledged it,
+// so an event may be delivered again if the handler fails, or if the process
+// stops in between.
+//
+// Outbox 将发件箱表中的事件按顺序投递给处理函数，保证至少投递一次。
+type Outbox struct {
+	db      *MemDB
+	table   string
+	handler OutboxHandler
+
+	// l serializes drains so events aren't delivered concurrently.
+	l sync.Mutex
+}
+
+// NewOutbox returns an Outbox that delivers the events of the given table
+// to handler. Events are delivered on calls to Drain, or periodically once
+// Start is called.
+func NewOutbox(db *MemDB, table string, handler OutboxHandler) (*Outbox, error) {
+	if _, ok := db.getSchema().Tables[table]; !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+	if handler == nil {
+		return nil, fmt.Errorf("missing outbox handler")
+	}
+	return &Outbox{
+		db:      db,
+		table:   table,
+		handler: handler,
+	}, nil
+}
+
+// Start drains the outbox every interval using a background job of the
+// MemDB, until the MemDB is closed. Failed deliveries are retried on the
+// next interval.
+func (o *Outbox) Start(interval time.Duration) {
+	o.db.startJob("outbox "+o.table, interval, func(time.Time) {
+		o.Drain()
+	})
+}
+
+// Drain delivers the pending events in order, deleting each one once the
+// handler acknowledges it. It stops at the first event the handler fails
+// to process and returns the error, so later events are never delivered
+// ahead of it. The number of acknowledged events is returned.
+func (o *Outbox) Drain() (int, error) {
+	o.l.Lock()
+	defer o.l.Unlock()
+
+	// Work from a snapshot of the pending events; anything published
+	// meanwhile is picked up by the next drain.
+	txn := o.db.Txn(false)
+	iter, err := txn.Get(o.table, id)
+	if err != nil {
+		return 0, err
+	}
+
+	acked := 0
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		event := raw.(*OutboxEvent)
+		if err := o.handler(event); err != nil {
+			return acked, fmt.Errorf("failed to deliver event %d: %v", event.ID, err)
+		}
+
+		wtxn := o.db.Txn(true)
+		if err := wtxn.Delete(o.table, event); err != nil && err != ErrNotFound {
+			wtxn.Abort()
+			return acked, err
+		}
+		if err := wtxn.TryCommit(); err != nil {
+			return acked, err
+		}
+		acked++
+	}
+	return acked, nil
+}
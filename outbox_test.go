@@ -0,0 +1,128 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testOutboxDB(t *testing.T, opts ...Option) *MemDB {
+	schema := testValidSchema()
+	schema.Tables["outbox"] = OutboxTableSchema("outbox")
+	db, err := NewMemDB(schema, opts...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return db
+}
+
+func TestOutbox(t *testing.T) {
+	db := testOutboxDB(t)
+
+	// Events of aborted transactions are discarded
+	txn := db.Txn(true)
+	if err := txn.PublishEvent("outbox", "created", "x"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Abort()
+
+	txn = db.Txn(true)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "a", Foo: "foo", Qux: []string{"a"}},
+		&TestObject{ID: "b", Foo: "foo", Qux: []string{"b"}},
+	} {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := txn.PublishEvent("outbox", "created", obj.ID); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := txn.PublishEvent("main", "created", "c"); err == nil {
+		t.Fatalf("expected error for non-outbox table")
+	}
+	txn.Commit()
+
+	// The handler fails the second event once
+	var delivered []interface{}
+	fail := true
+	outbox, err := NewOutbox(db, "outbox", func(event *OutboxEvent) error {
+		delivered = append(delivered, event.Payload)
+		if event.ID == 3 && fail {
+			fail = false
+			return fmt.Errorf("unavailable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	n, err := outbox.Drain()
+	if err == nil || n != 1 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	n, err = outbox.Drain()
+	if err != nil || n != 1 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	if !reflect.DeepEqual(delivered, []interface{}{"a", "b", "b"}) {
+		t.Fatalf("bad: %#v", delivered)
+	}
+
+	// Acknowledged events are deleted, but their IDs are not reused. The
+	// ID taken by the aborted transaction is skipped.
+	txn = db.Txn(true)
+	if raw, err := txn.First("outbox", "id"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if err := txn.PublishEvent("outbox", "deleted", "a"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw, err := txn.First("outbox", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if event := raw.(*OutboxEvent); event.ID != 4 {
+		t.Fatalf("bad: %#v", event)
+	}
+	txn.Commit()
+
+	if _, err := NewOutbox(db, "nope", func(*OutboxEvent) error { return nil }); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestOutbox_Start(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	db := testOutboxDB(t, WithClock(clock), WithDeterministicMode())
+	defer db.Close()
+
+	var delivered []*OutboxEvent
+	outbox, err := NewOutbox(db, "outbox", func(event *OutboxEvent) error {
+		delivered = append(delivered, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	outbox.Start(time.Second)
+
+	txn := db.Txn(true)
+	if err := txn.PublishEvent("outbox", "created", "a"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	db.Tick()
+	if len(delivered) != 0 {
+		t.Fatalf("bad: %#v", delivered)
+	}
+
+	clock.Advance(time.Second)
+	db.Tick()
+	if len(delivered) != 1 || delivered[0].ID != 1 || !delivered[0].Created.Equal(time.Unix(0, 0)) {
+		t.Fatalf("bad: %#v", delivered)
+	}
+}
@@ -0,0 +1,58 @@
+package memdb
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned by the write methods of a Txn when they recover
+// from a panic, for example in an indexer's FromObject or in a hook. The
+// transaction is aborted before it is returned, so none of its writes are
+// visible and the writer lock is released.
+//
+// PanicError 表示写操作中发生 panic ，事务已被回滚。
+type PanicError struct {
+	// Op is the method that panicked, such as "insert" or "commit".
+	Op string
+
+	// Value is the value the panic was raised with, and Stack the stack
+	// trace of the panicking goroutine.
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic during %s, transaction aborted: %v", e.Op, e.Value)
+}
+
+// WithRepanic makes transactions panic again with the original value once
+// they have recovered from a panic and aborted, instead of returning a
+// PanicError.
+func WithRepanic() Option {
+	return func(db *MemDB) {
+		db.repanic = true
+	}
+}
+
+// recoverPanic must be deferred by the write methods of Txn. If the method
+// panics, the transaction is aborted and the panic is stored in err as a
+// PanicError, or raised again if the DB is configured to.
+func (txn *Txn) recoverPanic(op string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	// Commit raises the PanicError returned by TryCommit, which is passed
+	// on as is.
+	perr, ok := r.(*PanicError)
+	if !ok {
+		perr = &PanicError{Op: op, Value: r, Stack: debug.Stack()}
+	}
+	txn.AbortWithReason(perr)
+	txn.db.logger.Error("recovered from panic, transaction aborted", "op", op, "panic", r)
+	if txn.db.repanic {
+		panic(r)
+	}
+	*err = perr
+}
@@ -0,0 +1,106 @@
+package memdb
+
+import (
+	"testing"
+)
+
+// panicIndex is an indexer that panics for objects with the given ID.
+type panicIndex struct {
+	StringFieldIndex
+	ID string
+}
+
+func (p *panicIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	if obj.(*TestObject).ID == p.ID {
+		panic("boom")
+	}
+	return p.StringFieldIndex.FromObject(obj)
+}
+
+func testPanicDB(t *testing.T, opts ...Option) *MemDB {
+	schema := testValidSchema()
+	schema.Tables["main"].Indexes["foo"].Indexer = &panicIndex{
+		StringFieldIndex: StringFieldIndex{Field: "Foo"},
+		ID:               "bad",
+	}
+	db, err := NewMemDB(schema, opts...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return db
+}
+
+func TestTxn_Insert_Panic(t *testing.T) {
+	db := testPanicDB(t)
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "good", Foo: "abc", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err := txn.Insert("main", &TestObject{ID: "bad", Foo: "abc", Qux: []string{"a"}})
+	perr, ok := err.(*PanicError)
+	if !ok || perr.Op != "insert" || perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	// The transaction was aborted, and the writer lock released
+	txn = db.Txn(true)
+	raw, err := txn.First("main", "id", "good")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+	txn.Abort()
+}
+
+func TestTxn_TryCommit_Panic(t *testing.T) {
+	db := testPanicDB(t)
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "good", Foo: "abc", Qux: []string{"a"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Defer(func() { panic("hook") })
+	err := txn.TryCommit()
+	if perr, ok := err.(*PanicError); !ok || perr.Op != "commit" || perr.Value != "hook" {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	// The deferred functions run after the commit, so it went through
+	raw, err := db.Txn(false).First("main", "id", "good")
+	if err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// Commit panics with the error, but the DB stays usable
+	txn = db.Txn(true)
+	txn.Defer(func() { panic("hook") })
+	func() {
+		defer func() {
+			if _, ok := recover().(*PanicError); !ok {
+				t.Fatalf("expected panic")
+			}
+		}()
+		txn.Commit()
+	}()
+	db.Txn(true).Abort()
+}
+
+func TestTxn_Repanic(t *testing.T) {
+	db := testPanicDB(t, WithRepanic())
+
+	txn := db.Txn(true)
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("bad: %#v", r)
+			}
+		}()
+		txn.Insert("main", &TestObject{ID: "bad", Foo: "abc", Qux: []string{"a"}})
+	}()
+
+	// The transaction was still aborted first
+	db.Txn(true).Abort()
+}
@@ -0,0 +1,181 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Patch updates the fields of an object without the caller having to copy
+// it. The object with the given primary key is looked up, copied, and the
+// named fields of the copy are set to the values of patch before it is
+// inserted in place of the original, which is left untouched. The updated
+// copy is returned, or ErrNotFound if there is no such object.
+//
+// Objects must be pointers to structs. Patch values are converted to the
+// type of their field where Go allows it, so values decoded from JSON work:
+// float64 numbers can set integer fields if they are whole, []interface{}
+// can set slices, and map[string]interface{} patches a nested struct
+// recursively, like a JSON merge patch. A nil value sets the zero value.
+//
+// Patch 按字段名更新对象的副本并替换原对象，适用于仅知道变更字段的调用方（如 JSON 合并补丁）。
+func (txn *Txn) Patch(table string, id interface{}, patch map[string]interface{}) (interface{}, error) {
+	if !txn.write {
+		return nil, fmt.Errorf("cannot update in read-only transaction")
+	}
+
+	existing, err := txn.First(table, "id", id)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, ErrNotFound
+	}
+
+	v := reflect.ValueOf(existing)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot patch object of type %T, want a pointer to a struct", existing)
+	}
+	updated := reflect.New(v.Elem().Type())
+	updated.Elem().Set(v.Elem())
+	if err := patchStruct(updated.Elem(), patch); err != nil {
+		return nil, err
+	}
+
+	// The primary key identifies the object being patched
+	obj := updated.Interface()
+	idIndexer := txn.schema.Tables[table].Indexes["id"].Indexer.(SingleIndexer)
+	_, oldID, err := idIndexer.FromObject(existing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build primary index: %v", err)
+	}
+	_, newID, err := idIndexer.FromObject(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build primary index: %v", err)
+	}
+	if !bytes.Equal(oldID, newID) {
+		return nil, fmt.Errorf("patch changed the primary key")
+	}
+
+	if err := txn.Insert(table, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// patchStruct sets the fields of the addressable struct value.
+func patchStruct(v reflect.Value, patch map[string]interface{}) error {
+	for name, value := range patch {
+		field := v.FieldByName(name)
+		if !field.IsValid() {
+			return fmt.Errorf("field '%s' for %s is invalid", name, v.Type())
+		}
+		if !field.CanSet() {
+			return fmt.Errorf("field '%s' for %s can't be set", name, v.Type())
+		}
+		if err := patchValue(field, value); err != nil {
+			return fmt.Errorf("field '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// patchValue sets field to value, converting it if needed.
+func patchValue(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	val := reflect.ValueOf(value)
+	typ := field.Type()
+
+	switch {
+	case val.Type().AssignableTo(typ):
+		field.Set(val)
+		return nil
+
+	case val.Kind() == reflect.Map && val.Type().Key().Kind() == reflect.String &&
+		(typ.Kind() == reflect.Struct || (typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Struct)):
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("can't patch a struct with %T", value)
+		}
+		// Nested structs are copied too, so the original is not modified
+		structType := typ
+		if typ.Kind() == reflect.Ptr {
+			structType = typ.Elem()
+		}
+		copied := reflect.New(structType)
+		if typ.Kind() == reflect.Ptr && !field.IsNil() {
+			copied.Elem().Set(field.Elem())
+		} else if typ.Kind() == reflect.Struct {
+			copied.Elem().Set(field)
+		}
+		if err := patchStruct(copied.Elem(), nested); err != nil {
+			return err
+		}
+		if typ.Kind() == reflect.Ptr {
+			field.Set(copied)
+		} else {
+			field.Set(copied.Elem())
+		}
+		return nil
+
+	case val.Kind() == reflect.Slice && typ.Kind() == reflect.Slice:
+		slice := reflect.MakeSlice(typ, val.Len(), val.Len())
+		for i := 0; i < val.Len(); i++ {
+			if err := patchValue(slice.Index(i), val.Index(i).Interface()); err != nil {
+				return fmt.Errorf("index %d: %v", i, err)
+			}
+		}
+		field.Set(slice)
+		return nil
+
+	case isFloatKind(val.Kind()) && (isIntKind(typ.Kind()) || isUintKind(typ.Kind())):
+		f := val.Float()
+		if f != math.Trunc(f) {
+			return fmt.Errorf("%v is not a whole number", f)
+		}
+		if isUintKind(typ.Kind()) && f < 0 {
+			return fmt.Errorf("%v is negative", f)
+		}
+		field.Set(val.Convert(typ))
+		if field.Convert(val.Type()).Float() != f {
+			return fmt.Errorf("%v overflows %s", f, typ)
+		}
+		return nil
+
+	case val.Type().ConvertibleTo(typ) && val.Kind() == typ.Kind():
+		// Named types with the same underlying kind, such as a string
+		// for a field of a string enum type.
+		field.Set(val.Convert(typ))
+		return nil
+
+	case (isIntKind(val.Kind()) || isUintKind(val.Kind())) &&
+		(isIntKind(typ.Kind()) || isUintKind(typ.Kind()) || isFloatKind(typ.Kind())):
+		if isIntKind(val.Kind()) && val.Int() < 0 && isUintKind(typ.Kind()) {
+			return fmt.Errorf("%v is negative", value)
+		}
+		field.Set(val.Convert(typ))
+		if !isFloatKind(typ.Kind()) && field.Convert(val.Type()).Interface() != value {
+			return fmt.Errorf("%v overflows %s", value, typ)
+		}
+		return nil
+	}
+	return fmt.Errorf("can't set %s from %T", typ, value)
+}
+
+func isIntKind(k reflect.Kind) bool {
+	_, ok := IsIntType(k)
+	return ok
+}
+
+func isUintKind(k reflect.Kind) bool {
+	_, ok := IsUintType(k)
+	return ok
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
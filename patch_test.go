@@ -0,0 +1,105 @@
+package memdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type testPatchNested struct {
+	Name string
+	Size uint16
+}
+
+type testPatchObject struct {
+	ID     string
+	Count  int
+	Tags   []string
+	Nested testPatchNested
+	Ptr    *testPatchNested
+}
+
+func TestTxn_Patch(t *testing.T) {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"objs": &TableSchema{
+				Name: "objs",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+	orig := &testPatchObject{
+		ID:     "a",
+		Count:  1,
+		Tags:   []string{"x"},
+		Nested: testPatchNested{Name: "n", Size: 1},
+		Ptr:    &testPatchNested{Name: "p", Size: 2},
+	}
+	if err := txn.Insert("objs", orig); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A JSON merge patch as decoded by encoding/json
+	var patch map[string]interface{}
+	body := `{"Count": 5, "Tags": ["y", "z"], "Nested": {"Size": 7}, "Ptr": {"Name": "q"}}`
+	if err := json.Unmarshal([]byte(body), &patch); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err := txn.Patch("objs", "a", patch)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expect := &testPatchObject{
+		ID:     "a",
+		Count:  5,
+		Tags:   []string{"y", "z"},
+		Nested: testPatchNested{Name: "n", Size: 7},
+		Ptr:    &testPatchNested{Name: "q", Size: 2},
+	}
+	if !reflect.DeepEqual(out, expect) {
+		t.Fatalf("bad: %#v", out)
+	}
+	raw, err := txn.First("objs", "id", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw != out {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// The original object is untouched
+	if orig.Count != 1 || orig.Ptr.Name != "p" || orig.Tags[0] != "x" {
+		t.Fatalf("bad: %#v", orig)
+	}
+
+	// Invalid patches
+	for _, patch := range []map[string]interface{}{
+		{"Missing": 1},
+		{"Count": 1.5},
+		{"Nested": map[string]interface{}{"Size": float64(1 << 20)}},
+		{"Tags": "nope"},
+		{"ID": "b"},
+	} {
+		if _, err := txn.Patch("objs", "a", patch); err == nil {
+			t.Fatalf("expected error for %#v", patch)
+		}
+	}
+
+	if _, err := txn.Patch("objs", "nope", patch); err != ErrNotFound {
+		t.Fatalf("bad: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package memdb
+
+import "fmt"
+
+// GetByPK returns the object with the given primary key, or nil if there is
+// none. Tables with a composite primary key, whose id index is a
+// CompoundIndex such as (namespace, name), take one part per sub-index.
+func (txn *Txn) GetByPK(table string, parts ...interface{}) (interface{}, error) {
+	return txn.First(table, id, parts...)
+}
+
+// GetByPKPrefix returns the objects of a table with a composite primary key
+// whose leading parts equal the given ones, in key order. For a table keyed
+// by (namespace, name), this lists the objects of a namespace. Unlike a scan
+// of the id_prefix index, the last part must match in full, so namespace
+// "a" doesn't include the objects of namespace "ab".
+func (txn *Txn) GetByPKPrefix(table string, parts ...interface{}) (ResultIterator, error) {
+	tableSchema, ok := txn.tableSchema(table)
+	if !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+	compound, ok := tableSchema.Indexes[id].Indexer.(*CompoundIndex)
+	if !ok {
+		return nil, fmt.Errorf("table '%s' doesn't have a composite primary key", table)
+	}
+	if len(parts) > len(compound.Indexes) {
+		return nil, fmt.Errorf("more arguments than index fields")
+	}
+
+	var prefix []byte
+	for i, part := range parts {
+		val, err := compound.partFromArgs(i, part)
+		if err != nil {
+			return nil, err
+		}
+		prefix = append(prefix, val...)
+	}
+
+	txn.trackPrefixRead(table, id, prefix)
+	if txn.isInlineTable(table) {
+		if iter := txn.getInline(table, prefix); iter != nil {
+			return iter, nil
+		}
+	}
+	indexIter := txn.readableIndex(table, id).Root().Iterator()
+	watchCh := indexIter.SeekPrefixWatch(prefix)
+	return &radixIterator{
+		iter:    indexIter,
+		pos:     cursorPos{table: table, index: id, prefix: prefix, key: prefix},
+		watchCh: watchCh,
+		opts:    txn.iteratorOptions(table, id),
+	}, nil
+}
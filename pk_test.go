@@ -0,0 +1,79 @@
+package memdb
+
+import "testing"
+
+func TestTxn_GetByPK(t *testing.T) {
+	type service struct {
+		Namespace string
+		Name      string
+		Port      int
+	}
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"services": &TableSchema{
+				Name: "services",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:   "id",
+						Unique: true,
+						Indexer: &CompoundIndex{
+							Indexes: []Indexer{
+								&StringFieldIndex{Field: "Namespace"},
+								&StringFieldIndex{Field: "Name"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The parts of the keys don't run into each other
+	a := &service{Namespace: "a", Name: "bc"}
+	b := &service{Namespace: "ab", Name: "c"}
+	txn := db.Txn(true)
+	txn.TrackChanges()
+	for _, obj := range []*service{a, b, &service{Namespace: "a", Name: "bc", Port: 80}} {
+		if err := txn.Insert("services", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	changes := txn.Changes()
+	if len(changes) != 2 || changes[0].Table != "services" || changes[0].After.(*service).Namespace != "ab" ||
+		changes[1].Before != nil || changes[1].After.(*service).Port != 80 {
+		t.Fatalf("bad: %#v", changes)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	raw, err := txn.GetByPK("services", "ab", "c")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw != b {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if _, err := txn.GetByPK("services", "ab"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	iter, err := txn.GetByPKPrefix("services", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw := iter.Next(); raw.(*service).Port != 80 {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if raw := iter.Next(); raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	schema.Tables["services"].Indexes["id"].Indexer.(*CompoundIndex).AllowMissing = true
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
@@ -0,0 +1,211 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// DefaultPositionSpacing is the gap left between rows when a PositionList
+// assigns positions from scratch.
+const DefaultPositionSpacing = 1 << 16
+
+// PositionList maintains user sortable lists stored in a table. Each row
+// holds the name of its list and a uint64 position, and Index orders the
+// rows by both, for example a CompoundIndex of a StringFieldIndex over the
+// list name followed by a UintFieldIndex over a uint64 position field. The
+// encoded position must be the last 8 bytes of the index value.
+//
+// Rows are moved by giving them a position halfway between their new
+// neighbours, so a move usually only rewrites the moved row. When there is
+// no gap left between the neighbours, the whole list is renumbered with
+// Spacing between rows, in the same transaction.
+//
+// PositionList 用于维护可由用户排序的列表，支持在两行之间插入（重新排序）。
+type PositionList struct {
+	Table string
+	Index string
+
+	// Position returns the position of a row, and SetPosition returns a
+	// copy of the row with the given position. Rows must not be modified
+	// in place.
+	Position    func(obj interface{}) uint64
+	SetPosition func(obj interface{}, pos uint64) interface{}
+
+	// Spacing is the gap between renumbered rows. If zero,
+	// DefaultPositionSpacing is used.
+	Spacing uint64
+}
+
+// MoveAfter inserts obj into its list, or moves it if it is already there,
+// so that it directly follows after. If after is nil, obj is moved to the
+// front of the list. Only the list of obj is read and written, so after must
+// be in the same list.
+func (p *PositionList) MoveAfter(txn *Txn, obj, after interface{}) error {
+	rows, err := p.list(txn, obj)
+	if err != nil {
+		return err
+	}
+	i := 0
+	if after != nil {
+		if i, err = p.find(txn, rows, after); err != nil {
+			return err
+		}
+		i++
+	}
+	return p.place(txn, rows, i, obj)
+}
+
+// MoveBefore inserts obj into its list, or moves it if it is already there,
+// so that it directly precedes before. If before is nil, obj is moved to the
+// end of the list.
+func (p *PositionList) MoveBefore(txn *Txn, obj, before interface{}) error {
+	rows, err := p.list(txn, obj)
+	if err != nil {
+		return err
+	}
+	i := len(rows)
+	if before != nil {
+		if i, err = p.find(txn, rows, before); err != nil {
+			return err
+		}
+	}
+	return p.place(txn, rows, i, obj)
+}
+
+// place inserts obj with a position that puts it at index i among the
+// ordered rows of its list, which exclude obj itself.
+func (p *PositionList) place(txn *Txn, rows []interface{}, i int, obj interface{}) error {
+	pos, ok := p.between(rows, i)
+	if !ok {
+		if err := p.renumber(txn, rows); err != nil {
+			return err
+		}
+		pos, _ = p.between(rows, i)
+	}
+	return txn.Insert(p.Table, p.SetPosition(obj, pos))
+}
+
+// between returns a position between the rows at i-1 and i, and false if
+// there is no room left.
+func (p *PositionList) between(rows []interface{}, i int) (uint64, bool) {
+	var lo uint64
+	if i > 0 {
+		lo = p.Position(rows[i-1])
+	}
+	hi := uint64(math.MaxUint64)
+	if i < len(rows) {
+		hi = p.Position(rows[i])
+	} else if lo <= math.MaxUint64-2*p.spacing() {
+		hi = lo + 2*p.spacing()
+	}
+	if hi <= lo || hi-lo < 2 {
+		return 0, false
+	}
+	return lo + (hi-lo)/2, true
+}
+
+// renumber rewrites the rows with evenly spaced positions, updating the
+// rows slice in place.
+func (p *PositionList) renumber(txn *Txn, rows []interface{}) error {
+	if uint64(len(rows)+1) > math.MaxUint64/p.spacing() {
+		return fmt.Errorf("too many rows to renumber list")
+	}
+	for i, row := range rows {
+		pos := uint64(i+1) * p.spacing()
+		if p.Position(row) == pos {
+			continue
+		}
+		rows[i] = p.SetPosition(row, pos)
+		if err := txn.Insert(p.Table, rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns the rows in the list of obj ordered by position, without
+// obj itself.
+func (p *PositionList) list(txn *Txn, obj interface{}) ([]interface{}, error) {
+	tableSchema, ok := txn.schema.Tables[p.Table]
+	if !ok {
+		return nil, fmt.Errorf("invalid table '%s'", p.Table)
+	}
+	indexSchema, ok := tableSchema.Indexes[p.Index]
+	if !ok {
+		return nil, fmt.Errorf("invalid index '%s'", p.Index)
+	}
+	indexer, ok := indexSchema.Indexer.(SingleIndexer)
+	if !ok {
+		return nil, fmt.Errorf("index '%s' must be a SingleIndexer", p.Index)
+	}
+
+	ok, val, err := indexer.FromObject(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build index '%s': %v", p.Index, err)
+	}
+	if !ok || len(val) < 8 {
+		return nil, fmt.Errorf("object missing position index '%s'", p.Index)
+	}
+	_, objID, err := tableSchema.Indexes[id].Indexer.(SingleIndexer).FromObject(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build primary index: %v", err)
+	}
+
+	prefix := val[:len(val)-8]
+	var rows []interface{}
+	txn.trackPrefixRead(p.Table, p.Index, prefix)
+	iter := txn.readableIndex(p.Table, p.Index).Root().Iterator()
+	iter.SeekPrefix(prefix)
+	for key, raw, ok := iter.Next(); ok; key, raw, ok = iter.Next() {
+		row, err := txn.resolve(p.Table, raw)
+		if err != nil {
+			return nil, err
+		}
+		_, rowID, err := p.id(txn, row)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build primary index: %v", err)
+		}
+
+		// Rows of other lists may share the prefix, but then the rest of
+		// their key is longer than a position, and the primary key for
+		// non-unique indexes.
+		size := len(prefix) + 8
+		if !indexSchema.Unique {
+			size += len(rowID)
+		}
+		if len(key) != size || bytes.Equal(rowID, objID) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// find returns the index of the row among rows, by primary key.
+func (p *PositionList) find(txn *Txn, rows []interface{}, row interface{}) (int, error) {
+	_, target, err := p.id(txn, row)
+	if err != nil {
+		return 0, err
+	}
+	for i, candidate := range rows {
+		if _, val, _ := p.id(txn, candidate); bytes.Equal(val, target) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("row is not in the same list")
+}
+
+// id returns the primary key of a row.
+func (p *PositionList) id(txn *Txn, obj interface{}) (bool, []byte, error) {
+	indexer := txn.schema.Tables[p.Table].Indexes[id].Indexer.(SingleIndexer)
+	return indexer.FromObject(obj)
+}
+
+// spacing returns the gap used when renumbering.
+func (p *PositionList) spacing() uint64 {
+	if p.Spacing == 0 {
+		return DefaultPositionSpacing
+	}
+	return p.Spacing
+}
@@ -0,0 +1,119 @@
+package memdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testItem struct {
+	ID   string
+	List string
+	Pos  uint64
+}
+
+func TestPositionList(t *testing.T) {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"items": &TableSchema{
+				Name: "items",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"position": &IndexSchema{
+						Name: "position",
+						Indexer: &CompoundIndex{
+							Indexes: []Indexer{
+								&StringFieldIndex{Field: "List"},
+								&UintFieldIndex{Field: "Pos"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	list := &PositionList{
+		Table: "items",
+		Index: "position",
+		Position: func(obj interface{}) uint64 {
+			return obj.(*testItem).Pos
+		},
+		SetPosition: func(obj interface{}, pos uint64) interface{} {
+			item := *obj.(*testItem)
+			item.Pos = pos
+			return &item
+		},
+		Spacing: 4,
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	get := func(id string) interface{} {
+		raw, err := txn.First("items", "id", id)
+		if err != nil || raw == nil {
+			t.Fatalf("bad: %#v %v", raw, err)
+		}
+		return raw
+	}
+	order := func(name string) []string {
+		iter, err := txn.Get("items", "position_prefix", name)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var out []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			if item := raw.(*testItem); item.List == name {
+				out = append(out, item.ID)
+			}
+		}
+		return out
+	}
+
+	// Append to the end, and insert at the front
+	for _, id := range []string{"a", "b", "c"} {
+		if err := list.MoveBefore(txn, &testItem{ID: id, List: "todo"}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := list.MoveAfter(txn, &testItem{ID: "z", List: "todo"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out := order("todo"); !reflect.DeepEqual(out, []string{"z", "a", "b", "c"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// Another list is kept apart
+	if err := list.MoveBefore(txn, &testItem{ID: "x", List: "done"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Repeatedly moving between the same rows runs out of room, which
+	// renumbers the list
+	for i := 0; i < 5; i++ {
+		if err := list.MoveAfter(txn, get("c"), get("z")); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := list.MoveAfter(txn, get("b"), get("z")); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if out := order("todo"); !reflect.DeepEqual(out, []string{"z", "b", "c", "a"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := order("done"); !reflect.DeepEqual(out, []string{"x"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	if err := list.MoveAfter(txn, get("a"), get("x")); err == nil {
+		t.Fatalf("expected error for row of another list")
+	}
+}
@@ -0,0 +1,194 @@
+package memdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// QueueItem is an object of a queue table.
+type QueueItem struct {
+	// ID orders the items of the queue. IDs start at 1 and are never
+	// reused by the same MemDB.
+	ID       uint64
+	Value    interface{}
+	Enqueued time.Time
+
+	// ClaimedUntil is when the claim of the consumer that dequeued the item
+	// expires, after which the item is delivered again. It is zero for
+	// items that were never dequeued or were released.
+	ClaimedUntil time.Time
+
+	// Attempts is the number of times the item was dequeued. It identifies
+	// the claim when the item is acknowledged.
+	Attempts int
+}
+
+// QueueTableSchema returns the schema for a queue table with the given name,
+// to be added to the DBSchema of the MemDB. A queue table is a work queue
+// for competing consumers: items are added with Txn.Enqueue and claimed in
+// order with Txn.Dequeue, which hides them from other consumers for a
+// visibility timeout. An item that isn't acknowledged with Txn.Ack before
+// its claim expires, for example because its consumer crashed, is delivered
+// again. Items are also indexed by the expiry of their claim, so Dequeue
+// finds the next item to claim without skipping the claimed ones.
+//
+// QueueTableSchema 返回工作队列表的模式，出队时按可见性超时认领条目。
+func QueueTableSchema(name string) *TableSchema {
+	return &TableSchema{
+		Name: name,
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &UintFieldIndex{Field: "ID"},
+			},
+			queueClaimIndex: &IndexSchema{
+				Name:   queueClaimIndex,
+				Unique: true,
+				Indexer: &CompoundIndex{
+					Indexes: []Indexer{
+						&TimeFieldIndexer{Field: "ClaimedUntil"},
+						&UintFieldIndex{Field: "ID"},
+					},
+					Nullable: []bool{true},
+				},
+			},
+		},
+	}
+}
+
+// queueClaimIndex is the index of queue tables ordering the items by the
+// expiry of their claim, the unclaimed ones first, and then by ID.
+const queueClaimIndex = "claim"
+
+// Enqueue adds a value at the end of a queue table and returns the ID of
+// the new item.
+func (txn *Txn) Enqueue(table string, value interface{}) (uint64, error) {
+	last, err := txn.Last(table, id)
+	if err != nil {
+		return 0, err
+	}
+	var lastID uint64
+	if last != nil {
+		prev, ok := last.(*QueueItem)
+		if !ok {
+			return 0, fmt.Errorf("table '%s' is not a queue", table)
+		}
+		lastID = prev.ID
+	}
+
+	item := &QueueItem{
+		ID:       txn.db.nextTableID(table, lastID),
+		Value:    value,
+		Enqueued: txn.db.clock.Now(),
+	}
+	if err := txn.Insert(table, item); err != nil {
+		return 0, err
+	}
+	return item.ID, nil
+}
+
+// Dequeue claims the next item of a queue table that isn't claimed by
+// another consumer, and returns it. Items whose claim expired are delivered
+// again first, in the order their claims expired, and then the items never
+// claimed or released, oldest first. The claim lasts for the visibility
+// timeout, within which the item must be acknowledged with Ack. A zero
+// visibility deletes the item instead, for consumers that don't need to be
+// able to recover from crashes. Nil is returned if no item is available.
+//
+// The claim is made in the transaction, so it only takes effect once the
+// transaction commits, and concurrent consumers never claim the same item.
+func (txn *Txn) Dequeue(table string, visibility time.Duration) (*QueueItem, error) {
+	now := txn.db.clock.Now()
+	item, err := txn.nextClaimable(table, now)
+	if item == nil || err != nil {
+		return nil, err
+	}
+
+	if visibility == 0 {
+		return item, txn.Delete(table, item)
+	}
+	claimed := *item
+	claimed.ClaimedUntil = now.Add(visibility)
+	claimed.Attempts++
+	if err := txn.Insert(table, &claimed); err != nil {
+		return nil, err
+	}
+	return &claimed, nil
+}
+
+// nextClaimable returns the item Dequeue claims next, or nil if every item
+// is claimed. It takes a lookup of the claim index for the earliest claim,
+// and another for the oldest unclaimed item if that claim didn't expire.
+func (txn *Txn) nextClaimable(table string, now time.Time) (*QueueItem, error) {
+	// The zero time sorts before any claim, while unclaimed items are nulls
+	// sorting before it
+	iter, err := txn.LowerBound(table, queueClaimIndex, time.Time{}, uint64(0))
+	if err != nil {
+		return nil, err
+	}
+	raw := iter.Next()
+	if raw != nil {
+		item, ok := raw.(*QueueItem)
+		if !ok {
+			return nil, fmt.Errorf("table '%s' is not a queue", table)
+		}
+		if !item.ClaimedUntil.After(now) {
+			return item, nil
+		}
+	}
+
+	raw, err = txn.First(table, queueClaimIndex+"_prefix", nil)
+	if raw == nil || err != nil {
+		return nil, err
+	}
+	item, ok := raw.(*QueueItem)
+	if !ok {
+		return nil, fmt.Errorf("table '%s' is not a queue", table)
+	}
+	return item, nil
+}
+
+// Ack acknowledges a dequeued item, which deletes it from the queue table.
+// It returns an error if the claim of the item expired and it was dequeued
+// again since, as the item then belongs to another consumer. ErrNotFound is
+// returned if the item was already acknowledged.
+func (txn *Txn) Ack(table string, item *QueueItem) error {
+	current, err := txn.claimedItem(table, item)
+	if err != nil {
+		return err
+	}
+	return txn.Delete(table, current)
+}
+
+// Release gives up the claim on a dequeued item, making it available to
+// other consumers right away.
+func (txn *Txn) Release(table string, item *QueueItem) error {
+	current, err := txn.claimedItem(table, item)
+	if err != nil {
+		return err
+	}
+	released := *current
+	released.ClaimedUntil = time.Time{}
+	return txn.Insert(table, &released)
+}
+
+// claimedItem returns the current version of a dequeued item, or an error
+// if the claim was taken over by another consumer.
+func (txn *Txn) claimedItem(table string, item *QueueItem) (*QueueItem, error) {
+	raw, err := txn.First(table, id, item.ID)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, ErrNotFound
+	}
+	current, ok := raw.(*QueueItem)
+	if !ok {
+		return nil, fmt.Errorf("table '%s' is not a queue", table)
+	}
+	if current.Attempts != item.Attempts {
+		return nil, fmt.Errorf("claim of item %d in queue '%s' expired", item.ID, table)
+	}
+	return current, nil
+}
@@ -0,0 +1,172 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTxn_Queue(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	schema := testValidSchema()
+	schema.Tables["jobs"] = QueueTableSchema("jobs")
+	db, err := NewMemDB(schema, WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, value := range []string{"a", "b", "c"} {
+		if _, err := txn.Enqueue("jobs", value); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Consumers get the items in order and skip the claimed ones
+	dequeue := func(visibility time.Duration) *QueueItem {
+		txn := db.Txn(true)
+		item, err := txn.Dequeue("jobs", visibility)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		txn.Commit()
+		return item
+	}
+	first := dequeue(time.Minute)
+	if first == nil || first.Value != "a" || first.Attempts != 1 {
+		t.Fatalf("bad: %#v", first)
+	}
+	if second := dequeue(time.Minute); second == nil || second.Value != "b" {
+		t.Fatalf("bad: %#v", second)
+	}
+
+	// An expired claim is delivered again, and the late ack is refused
+	clock.Advance(2 * time.Minute)
+	again := dequeue(time.Minute)
+	if again == nil || again.ID != first.ID || again.Attempts != 2 {
+		t.Fatalf("bad: %#v", again)
+	}
+	txn = db.Txn(true)
+	if err := txn.Ack("jobs", first); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := txn.Ack("jobs", again); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Ack("jobs", again); err != ErrNotFound {
+		t.Fatalf("bad: %v", err)
+	}
+	txn.Commit()
+
+	// Released items are available right away
+	third := dequeue(time.Minute)
+	if third == nil || third.Value != "b" {
+		t.Fatalf("bad: %#v", third)
+	}
+	txn = db.Txn(true)
+	if err := txn.Release("jobs", third); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// Dequeuing without visibility deletes the items
+	for _, want := range []string{"b", "c"} {
+		if item := dequeue(0); item == nil || item.Value != want {
+			t.Fatalf("bad: %#v", item)
+		}
+	}
+	if item := dequeue(time.Minute); item != nil {
+		t.Fatalf("bad: %#v", item)
+	}
+
+	// IDs are not reused once the queue is empty
+	txn = db.Txn(true)
+	if id, err := txn.Enqueue("jobs", "d"); err != nil || id != 4 {
+		t.Fatalf("bad: %d %v", id, err)
+	}
+	txn.Commit()
+}
+
+func TestTxn_Queue_ExpiredClaim(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	schema := testValidSchema()
+	schema.Tables["jobs"] = QueueTableSchema("jobs")
+	db, err := NewMemDB(schema, WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for i := 0; i < 100; i++ {
+		if _, err := txn.Enqueue("jobs", i); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	dequeue := func(visibility time.Duration) *QueueItem {
+		txn := db.Txn(true)
+		item, err := txn.Dequeue("jobs", visibility)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		txn.Commit()
+		return item
+	}
+	first := dequeue(time.Minute)
+	clock.Advance(30 * time.Second)
+	for i := 1; i < 50; i++ {
+		if item := dequeue(time.Minute); item == nil || item.Value != i {
+			t.Fatalf("bad: %#v", item)
+		}
+	}
+
+	// The claimed items are not visited to find the next one
+	txn = db.Txn(true)
+	txn.TrackQueryStats()
+	item, err := txn.Dequeue("jobs", time.Minute)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if item == nil || item.Value != 50 {
+		t.Fatalf("bad: %#v", item)
+	}
+	for _, stats := range txn.QueryStats() {
+		if stats.Visited > 1 {
+			t.Fatalf("bad: %#v", stats)
+		}
+	}
+	txn.Abort()
+
+	// Once the first claim expires, another consumer claims the item ahead
+	// of the unclaimed ones
+	clock.Advance(45 * time.Second)
+	second := dequeue(time.Minute)
+	if second == nil || second.ID != first.ID || second.Attempts != 2 {
+		t.Fatalf("bad: %#v", second)
+	}
+
+	// The first consumer can no longer ack or release the item, which
+	// stays claimed by the second one
+	txn = db.Txn(true)
+	if err := txn.Ack("jobs", first); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := txn.Release("jobs", first); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Commit()
+	if item := dequeue(time.Minute); item == nil || item.Value != 50 {
+		t.Fatalf("bad: %#v", item)
+	}
+
+	// The second consumer can
+	txn = db.Txn(true)
+	if err := txn.Release("jobs", second); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if item := dequeue(time.Minute); item == nil || item.ID != first.ID || item.Attempts != 3 {
+		t.Fatalf("bad: %#v", item)
+	}
+}
@@ -0,0 +1,154 @@
+package memdb
+
+import (
+	"bytes"
+	"strings"
+)
+
+// ReadRange is a range of keys of an index read by a transaction, from
+// Start up to but not including End. A nil Start is the beginning of the
+// index and a nil End is its end. The keys are those of the radix tree of
+// the index, so for non-unique indexes they are followed by the primary key
+// of the object.
+type ReadRange struct {
+	Table string
+	Index string
+	Start []byte
+	End   []byte
+}
+
+// Contains returns true if the key is within the range.
+func (r *ReadRange) Contains(key []byte) bool {
+	if r.Start != nil && bytes.Compare(key, r.Start) < 0 {
+		return false
+	}
+	return r.End == nil || bytes.Compare(key, r.End) < 0
+}
+
+// TrackReads enables read tracking for the transaction. Reads made after
+// it is called are recorded as the ranges of index keys they looked at,
+// and can be retrieved using Reads. A response cache can keep the ranges
+// along with a response computed in the transaction, and drop the response
+// once MemDB.Invalidates reports that the changes of a later commit fall
+// within them. Reads are recorded conservatively: a lookup through a prefix
+// covers every key starting with it, and operations that walk an index in
+// ways that are hard to bound, such as Traverse, cover the whole index. As
+// with other Txn methods it's not safe to call this from a different
+// goroutine than the one using the transaction.
+//
+// TrackReads 记录事务读取过的索引键范围，用于精确地使缓存失效。
+func (txn *Txn) TrackReads() {
+	if txn.reads == nil {
+		txn.reads = make([]ReadRange, 0, 1)
+	}
+}
+
+// Reads returns the ranges of index keys read by the transaction since
+// TrackReads was called, in the order they were read. It returns nil if
+// read tracking is not enabled.
+func (txn *Txn) Reads() []ReadRange {
+	return txn.reads
+}
+
+// trackRead records a read of the keys of an index from start up to end,
+// if read tracking is enabled. The index may have the "_prefix" suffix.
+func (txn *Txn) trackRead(table, index string, start, end []byte) {
+	if txn.reads == nil {
+		return
+	}
+	index = strings.TrimSuffix(index, "_prefix")
+	for _, r := range txn.reads {
+		if r.Table == table && r.Index == index && bytes.Equal(r.Start, start) &&
+			bytes.Equal(r.End, end) && (r.End == nil) == (end == nil) {
+			return
+		}
+	}
+	txn.reads = append(txn.reads, ReadRange{
+		Table: table,
+		Index: index,
+		Start: start,
+		End:   end,
+	})
+}
+
+// trackPrefixRead records a read of the keys of an index starting with the
+// prefix.
+func (txn *Txn) trackPrefixRead(table, index string, prefix []byte) {
+	if txn.reads == nil {
+		return
+	}
+	if len(prefix) == 0 {
+		txn.trackRead(table, index, nil, nil)
+		return
+	}
+	txn.trackRead(table, index, prefix, prefixEnd(prefix))
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// the prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Invalidates returns true if any of the changes, as returned by
+// Txn.Changes, modified a key within the read ranges, as returned by
+// Txn.Reads. Both the old and the new index keys of the changed objects are
+// checked, so objects moving into or out of a range are caught.
+//
+// Invalidates 判断变更是否落在读取范围内。
+func (db *MemDB) Invalidates(changes Changes, reads []ReadRange) bool {
+	for _, change := range changes {
+		for i := range reads {
+			read := &reads[i]
+			if read.Table != change.Table {
+				continue
+			}
+			tableSchema, ok := db.getSchema().Tables[read.Table]
+			if !ok {
+				continue
+			}
+			indexSchema, ok := tableSchema.Indexes[read.Index]
+			if !ok {
+				continue
+			}
+			for _, obj := range []interface{}{change.Before, change.After} {
+				if obj != nil && readContainsObject(read, tableSchema, indexSchema, obj) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// readContainsObject returns true if one of the keys of the object in the
+// index is within the range. Objects whose keys can't be built are assumed
+// to be within it.
+func readContainsObject(read *ReadRange, tableSchema *TableSchema, indexSchema *IndexSchema, obj interface{}) bool {
+	ok, vals, err := indexValues(indexSchema, obj)
+	if err != nil {
+		return true
+	}
+	if !ok {
+		return false
+	}
+	var idVal []byte
+	if !indexSchema.Unique {
+		if _, idVal, err = tableSchema.Indexes[id].Indexer.(SingleIndexer).FromObject(obj); err != nil {
+			return true
+		}
+	}
+	for _, val := range vals {
+		if read.Contains(append(append([]byte(nil), val...), idVal...)) {
+			return true
+		}
+	}
+	return false
+}
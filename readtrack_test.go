@@ -0,0 +1,109 @@
+package memdb
+
+import "testing"
+
+func TestTxn_TrackReads(t *testing.T) {
+	db := testDB(t)
+	txn := db.Txn(true)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "a", Foo: "x", Qux: []string{"1"}},
+		&TestObject{ID: "b", Foo: "y", Qux: []string{"2"}},
+	} {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Reads aren't recorded until tracking is enabled
+	txn = db.Txn(false)
+	if _, err := txn.First("main", "id", "a"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if txn.Reads() != nil {
+		t.Fatalf("bad: %#v", txn.Reads())
+	}
+
+	txn.TrackReads()
+	if _, err := txn.First("main", "id", "a"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn.Get("main", "foo", "x"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn.Get("main", "foo", "x"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	reads := txn.Reads()
+	if len(reads) != 2 || reads[0].Index != "id" || string(reads[0].Start) != "a\x00" || string(reads[0].End) != "a\x01" ||
+		reads[1].Index != "foo" || string(reads[1].Start) != "x\x00" {
+		t.Fatalf("bad: %#v", reads)
+	}
+
+	invalidates := func(fn func(txn *Txn)) bool {
+		wtxn := db.Txn(true)
+		wtxn.TrackChanges()
+		fn(wtxn)
+		changes := wtxn.Changes()
+		wtxn.Abort()
+		return db.Invalidates(changes, reads)
+	}
+	insert := func(obj *TestObject) func(txn *Txn) {
+		return func(txn *Txn) {
+			if err := txn.Insert("main", obj); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+
+	// Objects outside of the ranges don't invalidate the reads
+	if invalidates(insert(&TestObject{ID: "c", Foo: "z", Qux: []string{"3"}})) {
+		t.Fatalf("should not invalidate")
+	}
+	if invalidates(insert(&TestObject{ID: "b", Foo: "y", Qux: []string{"4"}})) {
+		t.Fatalf("should not invalidate")
+	}
+
+	// Objects within them do, including ones that move into them
+	if !invalidates(insert(&TestObject{ID: "a", Foo: "x", Qux: []string{"5"}})) {
+		t.Fatalf("should invalidate")
+	}
+	if !invalidates(insert(&TestObject{ID: "b", Foo: "x", Qux: []string{"2"}})) {
+		t.Fatalf("should invalidate")
+	}
+	if !invalidates(func(txn *Txn) {
+		if _, err := txn.DeleteAll("main", "id_prefix", ""); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}) {
+		t.Fatalf("should invalidate")
+	}
+
+	// Range scans are bounded on one side only
+	txn = db.Txn(false)
+	txn.TrackReads()
+	if _, err := txn.LowerBound("main", "id", "b"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	reads = txn.Reads()
+	if len(reads) != 1 || string(reads[0].Start) != "b\x00" || reads[0].End != nil {
+		t.Fatalf("bad: %#v", reads)
+	}
+	if !reads[0].Contains([]byte("zzz")) || reads[0].Contains([]byte("a")) {
+		t.Fatalf("bad: %#v", reads[0])
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	cases := map[string]string{
+		"a":        "b",
+		"a\xff":    "b",
+		"a\x00":    "a\x01",
+		"\xff\xff": "",
+	}
+	for prefix, expect := range cases {
+		if end := prefixEnd([]byte(prefix)); string(end) != expect {
+			t.Fatalf("bad: %q: %q", prefix, end)
+		}
+	}
+}
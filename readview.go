@@ -0,0 +1,58 @@
+package memdb
+
+import (
+	"runtime"
+	"sync/atomic"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// ReadView is a read-only transaction along with the index of the commit
+// whose state it reads. A commit swaps the root of the DB before advancing
+// the commit index, so a reader loading both separately may pair a root
+// with the index of another commit. ReadView retries until it gets a
+// matching pair, which keeps readers correct whatever the order in which
+// writers publish their state.
+//
+// ReadView 是一个只读事务，以及它读取的状态所对应的提交序号。
+type ReadView struct {
+	*Txn
+	index uint64
+}
+
+// ReadView returns a read-only transaction on the latest committed state,
+// along with the index of that commit.
+func (db *MemDB) ReadView() *ReadView {
+	root, index := db.loadRoot()
+	return &ReadView{
+		Txn: &Txn{
+			db:      db,
+			schema:  rootSchema(root),
+			rootTxn: root.Txn(),
+		},
+		index: index,
+	}
+}
+
+// Index returns the index of the commit whose state the view reads, as
+// reported by CommitInfo.
+func (v *ReadView) Index() uint64 {
+	return v.index
+}
+
+// loadRoot returns the root of the DB and the index of the commit that
+// made it. It waits for a commit swapping the root to finish, and loads
+// both again if one was made in the meantime.
+func (db *MemDB) loadRoot() (*iradix.Tree, uint64) {
+	for {
+		seq := atomic.LoadUint64(&db.rootSeq)
+		if seq%2 == 0 {
+			root := db.getRoot()
+			index := atomic.LoadUint64(&db.commitIndex)
+			if atomic.LoadUint64(&db.rootSeq) == seq {
+				return root, index
+			}
+		}
+		runtime.Gosched()
+	}
+}
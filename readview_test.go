@@ -0,0 +1,56 @@
+package memdb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMemDB_ReadView(t *testing.T) {
+	db := testDB(t)
+	if view := db.ReadView(); view.Index() != 0 {
+		t.Fatalf("bad: %d", view.Index())
+	}
+
+	// Every commit writes its own index, which views must agree with
+	const commits = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= commits; i++ {
+			txn := db.Txn(true)
+			obj := &TestObject{ID: "a", Foo: strconv.Itoa(i), Qux: []string{"1"}}
+			if err := txn.Insert("main", obj); err != nil {
+				t.Errorf("err: %v", err)
+				txn.Abort()
+				return
+			}
+			txn.Commit()
+		}
+	}()
+
+	for {
+		view := db.ReadView()
+		raw, err := view.First("main", "id", "a")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		foo := "0"
+		if raw != nil {
+			foo = raw.(*TestObject).Foo
+		}
+		if foo != strconv.FormatUint(view.Index(), 10) {
+			t.Fatalf("bad: %s at index %d", foo, view.Index())
+		}
+		if view.Index() == commits {
+			break
+		}
+	}
+	wg.Wait()
+
+	// Views are read-only
+	if err := db.ReadView().Insert("main", &TestObject{ID: "b"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
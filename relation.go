@@ -0,0 +1,313 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RelationSchema declares an edge from the rows of one table to the rows of
+// another, or of the same, table. A row of From is related to the rows of To
+// whose values for ToIndex equal any of its values for FromIndex, so both
+// indexes must encode values the same way. For example, a StringSliceFieldIndex
+// over the names of the services a service depends on can be related to the
+// StringFieldIndex over service names.
+//
+// RelationSchema 声明表之间的关系（边），用于 Txn.Traverse 遍历。
+type RelationSchema struct {
+	// Name of the relation. This must match the key in the Relations map
+	// of the DBSchema.
+	Name string
+
+	From      string
+	FromIndex string
+	To        string
+	ToIndex   string
+}
+
+// Validate is used to validate the relation against the schema of the
+// database.
+func (s *RelationSchema) Validate(schema *DBSchema) error {
+	if s.Name == "" {
+		return fmt.Errorf("missing relation name")
+	}
+	if strings.Contains(s.Name, "*") {
+		return fmt.Errorf("relation name can't contain '*'")
+	}
+	for _, ref := range [][2]string{{s.From, s.FromIndex}, {s.To, s.ToIndex}} {
+		tableSchema, ok := schema.Tables[ref[0]]
+		if !ok {
+			return fmt.Errorf("invalid table '%s'", ref[0])
+		}
+		if _, ok := tableSchema.Indexes[ref[1]]; !ok {
+			return fmt.Errorf("invalid index '%s' for table '%s'", ref[1], ref[0])
+		}
+	}
+	return nil
+}
+
+// traverseStep is a parsed element of the path given to Traverse.
+type traverseStep struct {
+	relation *RelationSchema
+
+	// maxHops is the number of times the relation is followed, or zero to
+	// follow it until no new rows are found.
+	maxHops int
+}
+
+// parseTraverseStep parses a path element: a relation name, optionally
+// followed by "*" to repeat it without limit, or by "*N" to repeat it up to
+// N times.
+func (txn *Txn) parseTraverseStep(elem string) (traverseStep, error) {
+	name, hops := elem, "1"
+	if i := strings.IndexByte(elem, '*'); i >= 0 {
+		name, hops = elem[:i], elem[i+1:]
+		if hops == "" {
+			hops = "0"
+		}
+	}
+
+	relation, ok := txn.schema.Relations[name]
+	if !ok {
+		return traverseStep{}, fmt.Errorf("invalid relation '%s'", name)
+	}
+	maxHops, err := strconv.Atoi(hops)
+	if err != nil || maxHops < 0 {
+		return traverseStep{}, fmt.Errorf("invalid depth in path element '%s'", elem)
+	}
+	if maxHops != 1 && relation.From != relation.To {
+		return traverseStep{}, fmt.Errorf("relation '%s' can't be repeated as it leads to another table", name)
+	}
+	return traverseStep{relation, maxHops}, nil
+}
+
+// Traverse walks the relations given by path, starting from the given row
+// of the table, and returns an iterator over the rows reached by the last
+// element of the path. Each element names a relation from the schema, whose
+// From table must be the table reached by the previous element.
+//
+// An element may be suffixed with "*" to follow a relation from a table to
+// itself repeatedly, yielding every row reached at any depth, or with "*N"
+// to limit the depth to N. Rows are only visited once per element, so
+// cycles are cut and each row is returned at most once. For example, with
+// a "parent" relation from folders to their subfolders, Traverse("folders",
+// root, "parent*") returns all descendants of root.
+//
+// The returned iterator's watch channel fires when any index read by the
+// traversal changes. When the traversal reads more than one index, the
+// iterator is a WatchSetIterator whose watch channel is backed by a
+// goroutine, so it must be closed if the channel may never fire.
+//
+// Traverse 从给定行出发，沿 path 中声明的关系遍历，返回最后一步到达的行。
+func (txn *Txn) Traverse(table string, start interface{}, path ...string) (ResultIterator, error) {
+	if _, ok := txn.tableSchema(table); !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+
+	ws := NewWatchSet()
+	rows := []interface{}{start}
+	for _, elem := range path {
+		step, err := txn.parseTraverseStep(elem)
+		if err != nil {
+			return nil, err
+		}
+		if step.relation.From != table {
+			return nil, fmt.Errorf("relation '%s' doesn't start from table '%s'", step.relation.Name, table)
+		}
+
+		txn.trackRead(step.relation.To, step.relation.ToIndex, nil, nil)
+		watchCh, _, _ := txn.readableIndex(step.relation.To, step.relation.ToIndex).Root().GetWatch(nil)
+		ws.Add(watchCh)
+
+		rows, err = txn.traverseStep(step, rows, step.maxHops != 1)
+		if err != nil {
+			return nil, err
+		}
+		table = step.relation.To
+	}
+
+	return &traverseIterator{rows: rows, ws: ws}, nil
+}
+
+// Closure returns an iterator over the transitive closure of a table's
+// relation to itself, starting from the given row. The related rows of a
+// row are those whose values for toIndex equal any of its values for
+// fromIndex. For example with folders that store the ID of their parent,
+// Closure("folders", "id", "parent", root, 0) returns every descendant of
+// root, and Closure("folders", "parent", "id", folder, 0) its ancestors.
+//
+// Rows up to maxDepth relations away are returned, or all of them if
+// maxDepth is zero. Each row is returned once, closest rows first, and
+// cycles, including ones back to start, are cut.
+//
+// Closure 返回表中自引用关系的传递闭包，例如某个文件夹的所有子孙。
+func (txn *Txn) Closure(table, fromIndex, toIndex string, start interface{}, maxDepth int) (ResultIterator, error) {
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+	for _, index := range []string{fromIndex, toIndex} {
+		if _, ok := tableSchema.Indexes[index]; !ok {
+			return nil, fmt.Errorf("invalid index '%s'", index)
+		}
+	}
+	if maxDepth < 0 {
+		return nil, fmt.Errorf("invalid depth %d", maxDepth)
+	}
+
+	relation := &RelationSchema{
+		Name:      fromIndex + "->" + toIndex,
+		From:      table,
+		FromIndex: fromIndex,
+		To:        table,
+		ToIndex:   toIndex,
+	}
+	// A depth of one must still cut the cycle back to start.
+	rows, err := txn.traverseStep(traverseStep{relation, maxDepth}, []interface{}{start}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	txn.trackRead(table, toIndex, nil, nil)
+	ws := NewWatchSet()
+	watchCh, _, _ := txn.readableIndex(table, toIndex).Root().GetWatch(nil)
+	ws.Add(watchCh)
+	return &traverseIterator{rows: rows, ws: ws}, nil
+}
+
+// traverseStep follows a relation from the given rows, breadth first, and
+// returns the new rows reached. If repeated is set the relation is treated
+// as a closure, which never leads back to the rows it started from.
+func (txn *Txn) traverseStep(step traverseStep, from []interface{}, repeated bool) ([]interface{}, error) {
+	relation := step.relation
+	fromSchema := txn.schema.Tables[relation.From]
+	toID := txn.schema.Tables[relation.To].Indexes[id].Indexer.(SingleIndexer)
+
+	// When following a relation repeatedly, the starting rows count as
+	// visited so that cycles back to them are cut.
+	visited := make(map[string]struct{})
+	if repeated {
+		for _, obj := range from {
+			if _, val, err := toID.FromObject(obj); err == nil {
+				visited[string(val)] = struct{}{}
+			}
+		}
+	}
+
+	var reached []interface{}
+	frontier := from
+	for hop := 1; len(frontier) > 0 && (step.maxHops == 0 || hop <= step.maxHops); hop++ {
+		var next []interface{}
+		for _, obj := range frontier {
+			ok, keys, err := indexValues(fromSchema.Indexes[relation.FromIndex], obj)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build index '%s': %v", relation.FromIndex, err)
+			}
+			if !ok {
+				continue
+			}
+
+			related, err := txn.lookupKeys(relation.To, relation.ToIndex, keys)
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range related {
+				_, val, err := toID.FromObject(row)
+				if err != nil {
+					return nil, fmt.Errorf("failed to build primary index: %v", err)
+				}
+				if _, ok := visited[string(val)]; ok {
+					continue
+				}
+				visited[string(val)] = struct{}{}
+				next = append(next, row)
+			}
+		}
+		reached = append(reached, next...)
+		frontier = next
+	}
+	return reached, nil
+}
+
+// WatchSetIterator is a ResultIterator whose results depend on several
+// indexes, such as the one returned by Traverse. Its WatchCh starts a
+// goroutine waiting for any of the indexes to change, which runs until one
+// of them does or Close is called, so callers that stop watching first must
+// call Close. Callers that already wait on a WatchSet can instead add the
+// watch channels of the indexes to it with AddWatches, which starts no
+// goroutine.
+//
+// WatchSetIterator 是依赖多个索引的 ResultIterator ，调用方需要关闭或合并其监听。
+type WatchSetIterator interface {
+	ResultIterator
+
+	// AddWatches adds the watch channels of the indexes read to the
+	// WatchSet.
+	AddWatches(ws WatchSet)
+
+	// Close stops the goroutine started by WatchCh, if any, which closes
+	// the watch channel.
+	Close()
+}
+
+// traverseIterator is the ResultIterator returned by Traverse, Search and
+// the geospatial queries. The rows are computed up front.
+type traverseIterator struct {
+	rows []interface{}
+	ws   WatchSet
+
+	watchCh <-chan struct{}
+	cancel  context.CancelFunc
+}
+
+// WatchCh returns a channel that is closed once any of the indexes read by
+// the query changes. When a single index was read its own watch channel is
+// returned. Otherwise a goroutine waits on the indexes until one of them
+// changes or Close is called.
+func (t *traverseIterator) WatchCh() <-chan struct{} {
+	if t.watchCh != nil {
+		return t.watchCh
+	}
+	if len(t.ws) == 1 {
+		for watchCh := range t.ws {
+			t.watchCh = watchCh
+		}
+		return t.watchCh
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan struct{})
+	go func(ws WatchSet) {
+		ws.WatchCtx(ctx)
+		close(ch)
+	}(t.ws)
+	t.watchCh, t.cancel = ch, cancel
+	return t.watchCh
+}
+
+// AddWatches adds the watch channels of the indexes read by the query to
+// the WatchSet.
+func (t *traverseIterator) AddWatches(ws WatchSet) {
+	for watchCh := range t.ws {
+		ws.Add(watchCh)
+	}
+}
+
+// Close stops the goroutine started by WatchCh, if any, which closes the
+// watch channel.
+func (t *traverseIterator) Close() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// Next returns the next row reached by the traversal.
+func (t *traverseIterator) Next() interface{} {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	row := t.rows[0]
+	t.rows = t.rows[1:]
+	return row
+}
@@ -0,0 +1,317 @@
+package memdb
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type testService struct {
+	ID        string
+	Name      string
+	DependsOn []string
+	Owner     string
+}
+
+func testRelationDB(t *testing.T) *MemDB {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"services": &TableSchema{
+				Name: "services",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"name": &IndexSchema{
+						Name:    "name",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "Name"},
+					},
+					"depends": &IndexSchema{
+						Name:         "depends",
+						AllowMissing: true,
+						Indexer:      &StringSliceFieldIndex{Field: "DependsOn"},
+					},
+					"owner": &IndexSchema{
+						Name:    "owner",
+						Indexer: &StringFieldIndex{Field: "Owner"},
+					},
+				},
+			},
+			"teams": &TableSchema{
+				Name: "teams",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"name": &IndexSchema{
+						Name:    "name",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "Name"},
+					},
+				},
+			},
+		},
+		Relations: map[string]*RelationSchema{
+			"depends": &RelationSchema{
+				Name:      "depends",
+				From:      "services",
+				FromIndex: "depends",
+				To:        "services",
+				ToIndex:   "name",
+			},
+			"owner": &RelationSchema{
+				Name:      "owner",
+				From:      "services",
+				FromIndex: "owner",
+				To:        "teams",
+				ToIndex:   "name",
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, svc := range []*testService{
+		&testService{ID: "1", Name: "a", DependsOn: []string{"b"}, Owner: "red"},
+		&testService{ID: "2", Name: "b", DependsOn: []string{"c"}, Owner: "blue"},
+		&testService{ID: "3", Name: "c", DependsOn: []string{"a", "d"}, Owner: "red"},
+		&testService{ID: "4", Name: "d", Owner: "blue"},
+	} {
+		if err := txn.Insert("services", svc); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	for _, team := range []string{"red", "blue"} {
+		if err := txn.Insert("teams", &testTeam{ID: team, Name: team}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	return db
+}
+
+func TestTxn_Traverse(t *testing.T) {
+	db := testRelationDB(t)
+	txn := db.Txn(false)
+	start, err := txn.First("services", "name", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	names := func(path ...string) []string {
+		iter, err := txn.Traverse("services", start, path...)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var out []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			switch obj := raw.(type) {
+			case *testService:
+				out = append(out, obj.Name)
+			case *testTeam:
+				out = append(out, obj.Name)
+			}
+		}
+		return out
+	}
+
+	cases := []struct {
+		path   []string
+		expect []string
+	}{
+		{[]string{"depends"}, []string{"b"}},
+		{[]string{"depends", "depends"}, []string{"c"}},
+		{[]string{"depends*2"}, []string{"b", "c"}},
+		{[]string{"depends*"}, []string{"b", "c", "d"}},
+		{[]string{"depends*", "owner"}, []string{"blue", "red"}},
+		{[]string{"owner"}, []string{"red"}},
+	}
+	for _, tc := range cases {
+		if out := names(tc.path...); !reflect.DeepEqual(out, tc.expect) {
+			t.Fatalf("bad: %v %#v", tc.path, out)
+		}
+	}
+
+	for _, path := range [][]string{
+		{"nope"},
+		{"owner", "depends"},
+		{"owner*"},
+		{"depends*x"},
+	} {
+		if _, err := txn.Traverse("services", start, path...); err == nil {
+			t.Fatalf("expected error for %v", path)
+		}
+	}
+}
+
+func TestTxn_Traverse_Watch(t *testing.T) {
+	db := testRelationDB(t)
+	txn := db.Txn(false)
+	start, err := txn.First("services", "name", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	iter, err := txn.Traverse("services", start, "depends*")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	wtxn := db.Txn(true)
+	if err := wtxn.Insert("services", &testService{ID: "5", Name: "e", Owner: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	wtxn.Commit()
+
+	select {
+	case <-iter.WatchCh():
+	case <-time.After(time.Second):
+		t.Fatalf("should fire")
+	}
+}
+
+func TestTxn_Traverse_WatchGoroutines(t *testing.T) {
+	db := testRelationDB(t)
+	txn := db.Txn(false)
+	start, err := txn.First("services", "name", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A traversal reading one index returns its watch channel
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		iter, err := txn.Traverse("services", start, "depends*")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		iter.WatchCh()
+	}
+	if after := runtime.NumGoroutine(); after > before+10 {
+		t.Fatalf("bad: %d goroutines before, %d after", before, after)
+	}
+
+	// One reading several indexes waits in a goroutine that Close stops
+	iter, err := txn.Traverse("services", start, "depends", "owner")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	watchCh := iter.WatchCh()
+	iter.(WatchSetIterator).Close()
+	select {
+	case <-watchCh:
+	case <-time.After(time.Second):
+		t.Fatalf("should fire")
+	}
+
+	// Its channels can be waited on by the caller instead
+	iter, err = txn.Traverse("services", start, "depends", "owner")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ws := NewWatchSet()
+	iter.(WatchSetIterator).AddWatches(ws)
+	if len(ws) != 2 {
+		t.Fatalf("bad: %d", len(ws))
+	}
+	wtxn := db.Txn(true)
+	if err := wtxn.Insert("services", &testService{ID: "5", Name: "e", Owner: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	wtxn.Commit()
+	if timeout := ws.Watch(time.After(time.Second)); timeout {
+		t.Fatalf("should fire")
+	}
+}
+
+type testFolder struct {
+	ID     string
+	Parent string
+}
+
+func TestTxn_Closure(t *testing.T) {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"folders": &TableSchema{
+				Name: "folders",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"parent": &IndexSchema{
+						Name:         "parent",
+						AllowMissing: true,
+						Indexer:      &StringFieldIndex{Field: "Parent"},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A tree under root, and a cycle between x and y
+	folders := map[string]*testFolder{}
+	txn := db.Txn(true)
+	for _, f := range []*testFolder{
+		&testFolder{ID: "root"},
+		&testFolder{ID: "a", Parent: "root"},
+		&testFolder{ID: "b", Parent: "a"},
+		&testFolder{ID: "c", Parent: "root"},
+		&testFolder{ID: "x", Parent: "y"},
+		&testFolder{ID: "y", Parent: "x"},
+	} {
+		folders[f.ID] = f
+		if err := txn.Insert("folders", f); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	ids := func(from, to string, start string, depth int) []string {
+		iter, err := txn.Closure("folders", from, to, folders[start], depth)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var out []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			out = append(out, raw.(*testFolder).ID)
+		}
+		return out
+	}
+
+	cases := []struct {
+		from, to string
+		start    string
+		depth    int
+		expect   []string
+	}{
+		{"id", "parent", "root", 0, []string{"a", "c", "b"}},
+		{"id", "parent", "root", 1, []string{"a", "c"}},
+		{"parent", "id", "b", 0, []string{"a", "root"}},
+		{"id", "parent", "x", 0, []string{"y"}},
+		{"id", "parent", "b", 0, nil},
+	}
+	for _, tc := range cases {
+		if out := ids(tc.from, tc.to, tc.start, tc.depth); !reflect.DeepEqual(out, tc.expect) {
+			t.Fatalf("bad: %#v %#v", tc, out)
+		}
+	}
+
+	if _, err := txn.Closure("folders", "id", "nope", folders["root"], 0); err == nil {
+		t.Fatalf("expected error")
+	}
+}
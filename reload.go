@@ -0,0 +1,347 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// ReloadSchema swaps in an updated schema while the DB is in use, so a long
+// running process can evolve its schema without a restart. Only additive
+// changes are accepted: new tables, new indexes on existing tables, and new
+// relations and aggregates. Everything in the current schema must be kept as
+// it is, and the change is rejected with SchemaErrors listing whatever was
+// removed or altered. Indexers are compared with reflect.DeepEqual, so the
+// ones holding functions must be carried over from the current schema rather
+// than built again.
+//
+// New indexes and aggregates are filled from the rows already stored, in a
+// write transaction that fails if a row has no value for a new index that
+// requires one. Transactions started before the reload keep using the schema
+// they started with.
+//
+// ReloadSchema 在运行时替换为更新后的模式，只接受新增表、索引等兼容的变更。
+func (db *MemDB) ReloadSchema(schema *DBSchema) error {
+	return db.changeSchema(schema, false)
+}
+
+// ApplySchemaChange is like ReloadSchema, but also accepts the removal of
+// tables, indexes, unique constraints, relations and aggregates, so a live
+// DB can be migrated without copying it. Removed tables and indexes are
+// freed, and whoever watches them is notified, but transactions started
+// before the change can still read them. Other alterations, such as an
+// index whose definition changed under the same name, are still rejected;
+// such an index can be replaced by one under a new name instead.
+//
+// ApplySchemaChange 在线变更模式，支持新增和删除表、索引等。
+func (db *MemDB) ApplySchemaChange(schema *DBSchema) error {
+	return db.changeSchema(schema, true)
+}
+
+// changeSchema does the work of ReloadSchema and ApplySchemaChange. Removals
+// are only accepted if drops is set.
+func (db *MemDB) changeSchema(schema *DBSchema, drops bool) error {
+	if err := schema.Validate(); err != nil {
+		db.logger.Error("invalid schema", "error", err)
+		return err
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	old := txn.schema
+	if errs := checkSchemaChange(old, schema, drops); len(errs) > 0 {
+		db.logger.Error("incompatible schema change", "error", errs)
+		return errs
+	}
+	txn.schema = schema
+
+	tables := make([]string, 0, len(schema.Tables))
+	for name := range schema.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	for _, name := range tables {
+		tableSchema := schema.Tables[name]
+		oldTable, existed := old.Tables[name]
+		if !existed && tableSchema.Inline {
+			txn.rootTxn.Insert(inlinePath(name), newInlineTable(nil))
+			continue
+		}
+
+		// The indexes of inline tables are generated when they are used
+		if tableSchema.Inline {
+			continue
+		}
+		for index, indexSchema := range tableSchema.Indexes {
+			if existed {
+				if _, ok := oldTable.Indexes[index]; ok {
+					continue
+				}
+			}
+			txn.rootTxn.Insert(indexPath(name, index), iradix.New())
+			if existed {
+				if err := txn.buildIndex(tableSchema, indexSchema); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for name, aggregate := range schema.Aggregates {
+		if _, ok := old.Aggregates[name]; ok {
+			continue
+		}
+		txn.writableIndex(AggregatesTable, id).Insert(aggregateKey(name), &AggregateValue{Name: name})
+		if err := txn.recomputeAggregate(aggregate); err != nil {
+			return err
+		}
+	}
+
+	var dropped droppedTrees
+	if drops {
+		dropped = txn.dropRemoved(old, schema)
+	}
+
+	if err := txn.TryCommit(); err != nil {
+		return err
+	}
+	dropped.notify()
+	db.forgetRetired(schema)
+	if schema.hasTTL() {
+		db.startTTLReaper()
+	}
+	db.logger.Info("reloaded schema", "tables", len(schema.Tables))
+	return nil
+}
+
+// droppedTrees holds the storage removed by a schema change, whose watchers
+// are notified once the change is committed.
+type droppedTrees struct {
+	trees  []*iradix.Tree
+	inline []*inlineTable
+}
+
+// notify wakes up whoever watches the dropped storage.
+func (d droppedTrees) notify() {
+	for _, tree := range d.trees {
+		closeWatches(tree)
+	}
+	for _, t := range d.inline {
+		t.invalidate()
+	}
+}
+
+// dropRemoved deletes the storage of the tables, indexes and aggregates of
+// the old schema that the new one removed.
+func (txn *Txn) dropRemoved(old, schema *DBSchema) droppedTrees {
+	var dropped droppedTrees
+	drop := func(table, index string) {
+		path := indexPath(table, index)
+		if raw, ok := txn.rootTxn.Get(path); ok {
+			dropped.trees = append(dropped.trees, raw.(*iradix.Tree))
+			txn.rootTxn.Delete(path)
+		}
+	}
+
+	for name, oldTable := range old.Tables {
+		tableSchema, kept := schema.Tables[name]
+		if oldTable.Inline {
+			if !kept {
+				if raw, ok := txn.rootTxn.Get(inlinePath(name)); ok {
+					dropped.inline = append(dropped.inline, raw.(*inlineTable))
+					txn.rootTxn.Delete(inlinePath(name))
+				}
+			}
+			continue
+		}
+		if !kept {
+			// The blobs of a dropped table are deleted once it's committed
+			if raw, ok := txn.rootTxn.Get(indexPath(name, id)); ok {
+				txn.releaseBlobs(oldTable, raw.(*iradix.Tree))
+			}
+		}
+		for index := range oldTable.Indexes {
+			if !kept {
+				drop(name, index)
+			} else if _, ok := tableSchema.Indexes[index]; !ok {
+				drop(name, index)
+			}
+		}
+	}
+
+	for name := range old.Aggregates {
+		if _, ok := schema.Aggregates[name]; !ok {
+			txn.writableIndex(AggregatesTable, id).Delete(aggregateKey(name))
+		}
+	}
+	return dropped
+}
+
+// buildIndex fills a new index of a table with the rows already stored.
+func (txn *Txn) buildIndex(tableSchema *TableSchema, indexSchema *IndexSchema) error {
+	table := tableSchema.Name
+	indexTxn := txn.writableIndex(table, indexSchema.Name)
+	iter := txn.readableIndex(table, id).Root().Iterator()
+	for idVal, raw, ok := iter.Next(); ok; idVal, raw, ok = iter.Next() {
+		obj, err := txn.resolve(table, raw)
+		if err != nil {
+			return err
+		}
+		ok, vals, err := indexValues(indexSchema, obj)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", indexSchema.Name, err)
+		}
+		if !ok {
+			if indexSchema.AllowMissing || indexSchema.NullDistinct || !indexSchema.covers(obj) {
+				continue
+			}
+			return fmt.Errorf("missing value for index '%s'", indexSchema.Name)
+		}
+
+		for _, val := range vals {
+			if !indexSchema.Unique {
+				val = append(val, idVal...)
+			} else if _, exists := indexTxn.Get(val); exists && indexSchema.NullDistinct {
+				return fmt.Errorf("unique index '%s' violated: value already exists", indexSchema.Name)
+			}
+			indexTxn.Insert(val, raw)
+		}
+	}
+	return nil
+}
+
+// checkSchemaChange returns the changes from the old schema to the new one
+// that can't be applied. Removals are accepted if drops is set.
+func checkSchemaChange(old, schema *DBSchema, drops bool) SchemaErrors {
+	var errs SchemaErrors
+
+	tables := make([]string, 0, len(old.Tables))
+	for name := range old.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	for _, name := range tables {
+		oldTable := old.Tables[name]
+		tableSchema, ok := schema.Tables[name]
+		if !ok {
+			if !drops {
+				errs = append(errs, &SchemaError{Table: name, Err: fmt.Errorf("table was removed")})
+			}
+			continue
+		}
+		errs = append(errs, checkTableChange(oldTable, tableSchema, drops)...)
+	}
+
+	for _, constraint := range schema.UniqueConstraints {
+		var existing *UniqueConstraintSchema
+		for _, oldConstraint := range old.UniqueConstraints {
+			if oldConstraint.Name == constraint.Name {
+				existing = oldConstraint
+			}
+		}
+		if existing != nil {
+			if !reflect.DeepEqual(existing, constraint) {
+				errs = append(errs, &SchemaError{Kind: "unique constraint", Name: constraint.Name,
+					Err: fmt.Errorf("constraint was changed")})
+			}
+			continue
+		}
+		// Rows already stored were never checked against a new constraint
+		for table := range constraint.Indexes {
+			if _, ok := old.Tables[table]; ok {
+				errs = append(errs, &SchemaError{Kind: "unique constraint", Name: constraint.Name,
+					Err: fmt.Errorf("constraint can't be added over existing table '%s'", table)})
+				break
+			}
+		}
+	}
+	for _, oldConstraint := range old.UniqueConstraints {
+		found := false
+		for _, constraint := range schema.UniqueConstraints {
+			found = found || constraint.Name == oldConstraint.Name
+		}
+		if !found && !drops {
+			errs = append(errs, &SchemaError{Kind: "unique constraint", Name: oldConstraint.Name,
+				Err: fmt.Errorf("constraint was removed")})
+		}
+	}
+
+	for name, oldRelation := range old.Relations {
+		relation, ok := schema.Relations[name]
+		switch {
+		case !ok:
+			if !drops {
+				errs = append(errs, &SchemaError{Kind: "relation", Name: name, Err: fmt.Errorf("relation was removed")})
+			}
+		case !reflect.DeepEqual(oldRelation, relation):
+			errs = append(errs, &SchemaError{Kind: "relation", Name: name, Err: fmt.Errorf("relation was changed")})
+		}
+	}
+	for name, oldAggregate := range old.Aggregates {
+		aggregate, ok := schema.Aggregates[name]
+		switch {
+		case !ok:
+			if !drops {
+				errs = append(errs, &SchemaError{Kind: "aggregate", Name: name, Err: fmt.Errorf("aggregate was removed")})
+			}
+		case !reflect.DeepEqual(oldAggregate, aggregate):
+			errs = append(errs, &SchemaError{Kind: "aggregate", Name: name, Err: fmt.Errorf("aggregate was changed")})
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := errs[i], errs[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return errs
+}
+
+// checkTableChange returns the changes to a table that can't be applied.
+// Removed indexes are accepted if drops is set.
+func checkTableChange(old, tableSchema *TableSchema, drops bool) SchemaErrors {
+	var errs SchemaErrors
+	report := func(index string, err error) {
+		errs = append(errs, &SchemaError{Table: old.Name, Index: index, Err: err})
+	}
+
+	oldDesc, desc := old.describe(), tableSchema.describe()
+	if oldDesc.Inline != desc.Inline || oldDesc.Singleton != desc.Singleton ||
+		oldDesc.Set != desc.Set || oldDesc.Counter != desc.Counter ||
+		oldDesc.Log != desc.Log || oldDesc.LargeObjects != desc.LargeObjects ||
+		oldDesc.Compressed != desc.Compressed {
+		report("", fmt.Errorf("storage of the table was changed"))
+	}
+	if !reflect.DeepEqual(oldDesc.Cascades, desc.Cascades) {
+		report("", fmt.Errorf("cascades were changed"))
+	}
+	if !reflect.DeepEqual(oldDesc.ForeignKeys, desc.ForeignKeys) {
+		report("", fmt.Errorf("foreign keys were changed"))
+	}
+
+	indexes := make([]string, 0, len(old.Indexes))
+	for name := range old.Indexes {
+		indexes = append(indexes, name)
+	}
+	sort.Strings(indexes)
+	for _, name := range indexes {
+		oldIndex := old.Indexes[name]
+		index, ok := tableSchema.Indexes[name]
+		switch {
+		case !ok:
+			if !drops {
+				report(name, fmt.Errorf("index was removed"))
+			}
+		case oldIndex.Unique != index.Unique || oldIndex.AllowMissing != index.AllowMissing ||
+			oldIndex.NullDistinct != index.NullDistinct ||
+			!reflect.DeepEqual(oldIndex.Indexer, index.Indexer):
+			report(name, fmt.Errorf("index was changed"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,177 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemDB_ReloadSchema(t *testing.T) {
+	db := testDB(t)
+	txn := db.Txn(true)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "a", Foo: "x", Bar: 1, Qux: []string{"1"}},
+		&TestObject{ID: "b", Foo: "y", Bar: 2, Qux: []string{"2"}},
+	} {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	before := db.Txn(false)
+
+	// Incompatible changes are all reported, and nothing changes
+	schema := testValidSchema()
+	delete(schema.Tables["main"].Indexes, "qux")
+	schema.Tables["main"].Indexes["foo"].Indexer = &StringFieldIndex{Field: "Foo", Lowercase: true}
+	err := db.ReloadSchema(schema)
+	errs, ok := err.(SchemaErrors)
+	if !ok || len(errs) != 2 || errs[0].Index != "foo" || errs[1].Index != "qux" {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// New indexes must have a value for the stored rows
+	schema = testValidSchema()
+	schema.Tables["main"].Indexes["fu"] = &IndexSchema{
+		Name:    "fu",
+		Indexer: &StringFieldIndex{Field: "Fu"},
+	}
+	if err := db.ReloadSchema(schema); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := db.Txn(false).Get("main", "fu"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Additive changes go through, filling the new index
+	schema = testValidSchema()
+	schema.Tables["main"].Indexes["bar"] = &IndexSchema{
+		Name:    "bar",
+		Indexer: &IntFieldIndex{Field: "Bar"},
+	}
+	schema.Tables["other"] = &TableSchema{
+		Name: "other",
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &StringFieldIndex{Field: "ID"},
+			},
+		},
+	}
+	if err := db.ReloadSchema(schema); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "c", Foo: "z", Bar: 2, Qux: []string{"3"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("other", &TestObject{ID: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	iter, err := txn.Get("main", "bar", 2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		ids = append(ids, obj.(*TestObject).ID)
+	}
+	if len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+		t.Fatalf("bad: %#v", ids)
+	}
+	if raw, err := txn.First("other", "id", "a"); err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// Transactions started earlier keep their schema
+	if _, err := before.Get("main", "bar", 2); err == nil {
+		t.Fatalf("expected error")
+	}
+	if raw, err := before.First("main", "id", "a"); err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
+
+func TestMemDB_ApplySchemaChange(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["other"] = &TableSchema{
+		Name: "other",
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &StringFieldIndex{Field: "ID"},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	obj := &TestObject{ID: "a", Foo: "x", Bar: 1, Qux: []string{"1"}}
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("other", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	before := db.Txn(false)
+	ws := NewWatchSet()
+	watch, _, err := before.FirstWatch("other", "id", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ws.Add(watch)
+
+	// Drop a table and an index, and add an index
+	schema = testValidSchema()
+	delete(schema.Tables["main"].Indexes, "qux")
+	schema.Tables["main"].Indexes["bar"] = &IndexSchema{
+		Name:    "bar",
+		Indexer: &IntFieldIndex{Field: "Bar"},
+	}
+	if err := db.ReloadSchema(schema); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := db.ApplySchemaChange(schema); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if timeout := ws.Watch(time.After(time.Second)); timeout {
+		t.Fatalf("should fire")
+	}
+
+	txn = db.Txn(false)
+	if raw, err := txn.First("main", "bar", 1); err != nil || raw != obj {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if _, err := txn.First("main", "qux", "1"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := txn.First("other", "id", "a"); err == nil {
+		t.Fatalf("expected error")
+	}
+	for _, path := range [][]byte{indexPath("main", "qux"), indexPath("other", "id")} {
+		if _, ok := db.getRoot().Get(path); ok {
+			t.Fatalf("tree should be freed: %s", path)
+		}
+	}
+
+	// Older transactions still see the dropped data
+	if raw, err := before.First("other", "id", "a"); err != nil || raw != obj {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// Changed indexes are still rejected
+	schema = testValidSchema()
+	schema.Tables["main"].Indexes["foo"].Indexer = &StringFieldIndex{Field: "Foo", Lowercase: true}
+	if err := db.ApplySchemaChange(schema); err == nil {
+		t.Fatalf("expected error")
+	}
+}
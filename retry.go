@@ -0,0 +1,128 @@
+package memdb
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how Retry re-executes a write transaction.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the transaction is attempted
+	// before the last error is returned. Zero means no limit.
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff bound the delay before each retry. The
+	// delay doubles with every attempt, and a random delay between zero and
+	// that bound is used so that concurrent retries spread out.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Limiter, if set, is used to admit each attempt with AdmitTxn. A
+	// throttled attempt is retried like any other retryable error.
+	Limiter *TokenBucket
+
+	// Optimistic runs the attempts in transactions started with
+	// TxnOptimistic, which are retried when they conflict.
+	Optimistic bool
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by Retry.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 10,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  time.Second,
+}
+
+// IsRetryable returns true if a write that failed with err may succeed when
+// it is attempted again. This is the case for ErrThrottled, ErrConflict and
+// for errors with a Temporary method returning true.
+func IsRetryable(err error) bool {
+	if err == ErrThrottled || err == ErrConflict {
+		return true
+	}
+	if temp, ok := err.(interface{ Temporary() bool }); ok {
+		return temp.Temporary()
+	}
+	return false
+}
+
+// Retry runs fn in a write transaction using the DefaultRetryPolicy. See
+// RetryWithPolicy.
+func Retry(db *MemDB, fn func(txn *Txn) error) error {
+	return RetryWithPolicy(db, DefaultRetryPolicy, fn)
+}
+
+// RetryWithPolicy runs fn in a write transaction, committing it if fn
+// returns nil. If fn returns an error the transaction is aborted, and if
+// the error is retryable according to IsRetryable, fn is run again in a
+// fresh transaction after a jittered backoff. Other errors, or the last
+// error once the policy's attempts are exhausted, are returned as they are.
+//
+// fn may be run several times, so it must not have side effects outside of
+// the transaction.
+//
+// RetryWithPolicy 在写事务中执行 fn ，遇到可重试的错误时按退避策略重新执行。
+func RetryWithPolicy(db *MemDB, policy RetryPolicy, fn func(txn *Txn) error) error {
+	backoff := policy.MinBackoff
+	for attempt := 1; ; attempt++ {
+		var err error
+		if policy.Optimistic {
+			err = runOptimisticTxn(db, policy.Limiter, fn)
+		} else {
+			err = runTxn(db, policy.Limiter, fn)
+		}
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		var delay time.Duration
+		if backoff > 0 {
+			delay = time.Duration(rand.Int63n(int64(backoff) + 1))
+		}
+		<-db.clock.After(delay)
+
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// runTxn makes a single attempt at running fn in a write transaction.
+func runTxn(db *MemDB, limiter *TokenBucket, fn func(txn *Txn) error) error {
+	var txn *Txn
+	if limiter != nil {
+		var err error
+		if txn, err = db.AdmitTxn(limiter); err != nil {
+			return err
+		}
+	} else {
+		txn = db.Txn(true)
+	}
+	defer txn.Abort()
+
+	if err := fn(txn); err != nil {
+		txn.AbortWithReason(err)
+		return err
+	}
+	return txn.TryCommit()
+}
+
+// runOptimisticTxn makes a single attempt at running fn in an optimistic
+// write transaction.
+func runOptimisticTxn(db *MemDB, limiter *TokenBucket, fn func(txn *Txn) error) error {
+	if limiter != nil && !limiter.Allow(db.clock.Now()) {
+		return ErrThrottled
+	}
+	txn := db.TxnOptimistic()
+	defer txn.Abort()
+
+	if err := fn(txn); err != nil {
+		txn.AbortWithReason(err)
+		return err
+	}
+	return txn.TryCommit()
+}
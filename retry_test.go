@@ -0,0 +1,84 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestRetry(t *testing.T) {
+	db := testDB(t)
+
+	// Retryable errors are retried and the final attempt is committed
+	attempts := 0
+	err := Retry(db, func(txn *Txn) error {
+		attempts++
+		if err := txn.Insert("main", testObj()); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return temporaryError{}
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("bad: %d %v", attempts, err)
+	}
+	if raw, err := db.Txn(false).First("main", "id", testObj().ID); err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// Other errors are returned right away, and the changes discarded
+	attempts = 0
+	err = Retry(db, func(txn *Txn) error {
+		attempts++
+		if _, err := txn.DeleteAll("main", "id"); err != nil {
+			return err
+		}
+		return fmt.Errorf("permanent")
+	})
+	if err == nil || err.Error() != "permanent" || attempts != 1 {
+		t.Fatalf("bad: %d %v", attempts, err)
+	}
+	if raw, err := db.Txn(false).First("main", "id", testObj().ID); err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
+
+func TestRetryWithPolicy(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	db, err := NewMemDB(testValidSchema(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Attempts are limited
+	attempts := 0
+	policy := RetryPolicy{MaxAttempts: 4}
+	err = RetryWithPolicy(db, policy, func(txn *Txn) error {
+		attempts++
+		return temporaryError{}
+	})
+	if err != (temporaryError{}) || attempts != 4 {
+		t.Fatalf("bad: %d %v", attempts, err)
+	}
+
+	// Throttled attempts are retried too
+	policy.Limiter = NewTokenBucket(0, 1)
+	attempts = 0
+	err = RetryWithPolicy(db, policy, func(txn *Txn) error {
+		attempts++
+		return temporaryError{}
+	})
+	if err != ErrThrottled || attempts != 1 {
+		t.Fatalf("bad: %d %v", attempts, err)
+	}
+	if stats := policy.Limiter.Stats(); stats.Admitted != 1 || stats.Throttled != 3 {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
@@ -0,0 +1,154 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// SavepointID identifies a savepoint of a transaction.
+type SavepointID int
+
+// savepoint is the state of a write transaction when Savepoint was called.
+type savepoint struct {
+	root       *iradix.Tree
+	schema     *DBSchema
+	modified   map[tableIndex]*iradix.Tree
+	changes    Changes
+	after      int
+	stored     int
+	released   int
+	limitOps   int
+	limitBytes int
+}
+
+// Savepoint records the current state of a write transaction, so that the
+// writes made after it can be undone with RollbackTo while keeping the
+// earlier ones. Savepoints are cheap, as the indexes are immutable trees
+// that are shared with the transaction.
+//
+// Savepoint 记录写事务的当前状态，之后可以用 RollbackTo 撤销部分写入。
+func (txn *Txn) Savepoint() (SavepointID, error) {
+	if !txn.write {
+		return 0, fmt.Errorf("cannot create a savepoint in read-only transaction")
+	}
+	if txn.rootTxn == nil {
+		return 0, fmt.Errorf("transaction is closed")
+	}
+
+	// Committing the trees makes later writes copy their nodes, so the
+	// trees are left as they are now.
+	sp := &savepoint{
+		root:       txn.rootTxn.CommitOnly(),
+		schema:     txn.schema,
+		modified:   make(map[tableIndex]*iradix.Tree, len(txn.modified)),
+		changes:    append(Changes(nil), txn.changes...),
+		after:      len(txn.after),
+		limitOps:   txn.limitOps,
+		limitBytes: txn.limitBytes,
+	}
+	if txn.blobs != nil {
+		sp.stored, sp.released = len(txn.blobs.stored), len(txn.blobs.released)
+	}
+	for key, subTxn := range txn.modified {
+		sp.modified[key] = subTxn.CommitOnly()
+	}
+	txn.savepoints = append(txn.savepoints, sp)
+	return SavepointID(len(txn.savepoints) - 1), nil
+}
+
+// RollbackTo undoes the writes made since the savepoint was created, along
+// with their tracked changes and the functions registered with Defer. The
+// savepoints created after it are discarded, while the savepoint itself can
+// be rolled back to again.
+//
+// The indexes written both before and after the savepoint are rolled back by
+// comparing them with their version at the savepoint, which takes time in
+// proportion to their size. Watchers of the objects whose writes were undone
+// may be notified when the transaction commits.
+func (txn *Txn) RollbackTo(id SavepointID) error {
+	if !txn.write || txn.rootTxn == nil {
+		return fmt.Errorf("transaction is closed")
+	}
+	if id < 0 || int(id) >= len(txn.savepoints) {
+		return fmt.Errorf("invalid savepoint %d", id)
+	}
+	sp := txn.savepoints[id]
+	txn.savepoints = txn.savepoints[:id+1]
+
+	// Indexes first written after the savepoint are dropped altogether. The
+	// others keep their transaction, so the writes made before the
+	// savepoint are still notified on commit.
+	for key, subTxn := range txn.modified {
+		tree, ok := sp.modified[key]
+		if !ok {
+			delete(txn.modified, key)
+			continue
+		}
+		restoreTree(subTxn, tree)
+	}
+
+	txn.rootTxn = sp.root.Txn()
+	txn.schema = sp.schema
+	txn.rootTree, txn.roots = nil, nil
+	if txn.changes != nil {
+		txn.changes = append(make(Changes, 0, len(sp.changes)+1), sp.changes...)
+	}
+	txn.after = txn.after[:sp.after]
+	if txn.blobs != nil {
+		// The blobs stored since the savepoint are no longer referenced,
+		// and the ones released since then are again
+		txn.db.deleteBlobs(txn.blobs.stored[sp.stored:])
+		txn.blobs.stored = txn.blobs.stored[:sp.stored]
+		txn.blobs.released = txn.blobs.released[:sp.released]
+	}
+	txn.limitOps = sp.limitOps
+	txn.limitBytes = sp.limitBytes
+	return nil
+}
+
+// restoreTree writes to the transaction until its tree holds the same
+// entries as the given tree.
+func restoreTree(indexTxn *iradix.Txn, tree *iradix.Tree) {
+	current := indexTxn.CommitOnly()
+	if current.Root() == tree.Root() {
+		return
+	}
+	var deletes [][]byte
+	var inserts []inlineRow
+	ic, it := current.Root().Iterator(), tree.Root().Iterator()
+	kc, vc, okc := ic.Next()
+	kt, vt, okt := it.Next()
+	for okc || okt {
+		cmp := 0
+		switch {
+		case !okt:
+			cmp = -1
+		case !okc:
+			cmp = 1
+		default:
+			cmp = bytes.Compare(kc, kt)
+		}
+		switch {
+		case cmp < 0:
+			deletes = append(deletes, kc)
+			kc, vc, okc = ic.Next()
+		case cmp > 0:
+			inserts = append(inserts, inlineRow{key: kt, obj: vt})
+			kt, vt, okt = it.Next()
+		default:
+			if !sameObject(vc, vt) {
+				inserts = append(inserts, inlineRow{key: kt, obj: vt})
+			}
+			kc, vc, okc = ic.Next()
+			kt, vt, okt = it.Next()
+		}
+	}
+	for _, key := range deletes {
+		indexTxn.Delete(key)
+	}
+	for _, row := range inserts {
+		indexTxn.Insert(row.key, row.obj)
+	}
+}
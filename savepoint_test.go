@@ -0,0 +1,74 @@
+package memdb
+
+import "testing"
+
+func TestTxn_Savepoint(t *testing.T) {
+	db := testDB(t)
+
+	txn := db.Txn(true)
+	txn.TrackChanges()
+	a := &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}
+	if err := txn.Insert("main", a); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sp, err := txn.Savepoint()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	deferred := false
+	txn.Defer(func() { deferred = true })
+	if err := txn.Insert("main", &TestObject{ID: "b", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "y", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.RollbackTo(sp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.RollbackTo(sp + 1); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Only the writes made before the savepoint are left
+	if raw, err := txn.First("main", "id", "b"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if raw, err := txn.First("main", "foo", "x"); err != nil || raw != a {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if raw, err := txn.First("main", "foo", "y"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if changes := txn.Changes(); len(changes) != 1 || changes[0].After != a {
+		t.Fatalf("bad: %#v", changes)
+	}
+
+	// The transaction can go on and be rolled back to the same savepoint
+	if err := txn.Insert("main", &TestObject{ID: "c", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.RollbackTo(sp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", &TestObject{ID: "d", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if deferred {
+		t.Fatalf("deferred function should be discarded")
+	}
+
+	iter, err := db.Txn(false).Get("main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		ids = append(ids, raw.(*TestObject).ID)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "d" {
+		t.Fatalf("bad: %#v", ids)
+	}
+}
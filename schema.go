@@ -1,6 +1,10 @@
 package memdb
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 // DBSchema is the schema to use for the full database with a MemDB instance.
 //
@@ -15,29 +19,173 @@ type DBSchema struct {
 	//
 	// Tables 是此数据库中的一组表，key 是表名，必须与 TableSchema 中的名称匹配。
 	Tables map[string]*TableSchema
+
+	// UniqueConstraints declares index values that must be unique across
+	// several tables.
+	UniqueConstraints []*UniqueConstraintSchema
+
+	// Relations declares the edges between tables that Txn.Traverse can
+	// follow. The key is the relation name and must match the Name in
+	// RelationSchema.
+	Relations map[string]*RelationSchema
+
+	// Aggregates declares values maintained over the rows of tables. The
+	// key is the aggregate name and must match the Name in AggregateSchema.
+	Aggregates map[string]*AggregateSchema
 }
 
-// Validate validates the schema.
+// Validate validates the schema. It returns the first problem found, as a
+// *SchemaError.
 func (s *DBSchema) Validate() error {
+	if errs := s.validate(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// ValidateAll validates the schema like Validate, but carries on past the
+// first problem so that all of them can be fixed in one pass. The returned
+// error is a SchemaErrors listing every problem, ordered by table, or nil.
+//
+// ValidateAll 校验模式并返回所有问题，而不是在第一个错误处停止。
+func (s *DBSchema) ValidateAll() error {
+	if errs := s.validate(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate returns all of the problems with the schema. Items that depend
+// on an invalid table, such as its cascades, are not checked.
+func (s *DBSchema) validate() SchemaErrors {
 	if s == nil {
-		return fmt.Errorf("schema is nil")
+		return SchemaErrors{{Err: fmt.Errorf("schema is nil")}}
 	}
 
+	var errs SchemaErrors
 	if len(s.Tables) == 0 {
-		return fmt.Errorf("schema has no tables defined")
+		errs = append(errs, &SchemaError{Err: fmt.Errorf("schema has no tables defined")})
 	}
 
-	for name, table := range s.Tables {
+	names := make([]string, 0, len(s.Tables))
+	for name := range s.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		table := s.Tables[name]
+		if table == nil {
+			errs = append(errs, &SchemaError{Table: name, Err: fmt.Errorf("missing table schema")})
+			continue
+		}
 		if name != table.Name {
-			return fmt.Errorf("table name mis-match for '%s'", name)
+			errs = append(errs, &SchemaError{Table: name, Err: fmt.Errorf("table name mis-match for '%s'", name)})
 		}
 
-		if err := table.Validate(); err != nil {
-			return fmt.Errorf("table %q: %s", name, err)
+		if isSystemTable(name) {
+			errs = append(errs, &SchemaError{
+				Table: name,
+				Err:   fmt.Errorf("table name '%s' uses reserved prefix '%s'", name, systemTablePrefix),
+			})
+		}
+
+		tableErrs := table.validate()
+		for _, err := range tableErrs {
+			err.Table = name
+		}
+		errs = append(errs, tableErrs...)
+		if len(tableErrs) > 0 {
+			continue
+		}
+
+		for _, cascade := range table.Cascades {
+			if err := cascade.Validate(s, table); err != nil {
+				errs = append(errs, &SchemaError{Table: name, Kind: "cascade to", Name: cascade.ChildTable, Err: err})
+			}
+		}
+		for _, fk := range table.ForeignKeys {
+			if err := fk.Validate(s, table); err != nil {
+				errs = append(errs, &SchemaError{Table: name, Kind: "foreign key", Name: fk.Index, Err: err})
+			}
 		}
 	}
 
-	return nil
+	for _, constraint := range s.UniqueConstraints {
+		if err := constraint.Validate(s); err != nil {
+			errs = append(errs, &SchemaError{Kind: "unique constraint", Name: constraint.Name, Err: err})
+		}
+	}
+
+	relations := make([]string, 0, len(s.Relations))
+	for name := range s.Relations {
+		relations = append(relations, name)
+	}
+	sort.Strings(relations)
+	for _, name := range relations {
+		relation := s.Relations[name]
+		if name != relation.Name {
+			errs = append(errs, &SchemaError{Kind: "relation", Name: name, Err: fmt.Errorf("relation name mis-match for '%s'", name)})
+		} else if err := relation.Validate(s); err != nil {
+			errs = append(errs, &SchemaError{Kind: "relation", Name: name, Err: err})
+		}
+	}
+
+	aggregates := make([]string, 0, len(s.Aggregates))
+	for name := range s.Aggregates {
+		aggregates = append(aggregates, name)
+	}
+	sort.Strings(aggregates)
+	for _, name := range aggregates {
+		aggregate := s.Aggregates[name]
+		if name != aggregate.Name {
+			errs = append(errs, &SchemaError{Kind: "aggregate", Name: name, Err: fmt.Errorf("aggregate name mis-match for '%s'", name)})
+		} else if err := aggregate.Validate(s); err != nil {
+			errs = append(errs, &SchemaError{Kind: "aggregate", Name: name, Err: err})
+		}
+	}
+
+	return errs
+}
+
+// SchemaError is a problem with a schema. Table and Index locate the
+// problem when it is with a table or one of its indexes, while Kind and Name
+// locate other items of the schema, such as a "relation" or a "cascade to"
+// a child table.
+type SchemaError struct {
+	Table string
+	Index string
+	Kind  string
+	Name  string
+	Err   error
+}
+
+func (e *SchemaError) Error() string {
+	var path []string
+	if e.Table != "" {
+		path = append(path, fmt.Sprintf("table %q", e.Table))
+	}
+	if e.Index != "" {
+		path = append(path, fmt.Sprintf("index %q", e.Index))
+	}
+	if e.Kind != "" {
+		path = append(path, fmt.Sprintf("%s %q", e.Kind, e.Name))
+	}
+	return strings.Join(append(path, e.Err.Error()), ": ")
+}
+
+// SchemaErrors is the list of problems returned by DBSchema.ValidateAll.
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d schema errors: %s", len(e), strings.Join(msgs, "; "))
 }
 
 // TableSchema is the schema for a single table.
@@ -51,47 +199,191 @@ type TableSchema struct {
 	// Indexes 是表的索引集合。
 	// key 是索引的唯一名称，必须与 IndexSchema 中的名称匹配。
 	Indexes map[string]*IndexSchema
+
+	// LargeObjects optionally stores large objects of the table outside of
+	// the radix trees. See LargeObjectSchema for details.
+	LargeObjects *LargeObjectSchema
+
+	// Compression optionally stores the objects of the table serialized and
+	// compressed. See CompressionSchema for details.
+	Compression *CompressionSchema
+
+	// Cascades declares child tables whose references to this table are
+	// rewritten when the referenced key of a row changes.
+	Cascades []*CascadeSchema
+
+	// ForeignKeys declares the references of the rows of this table to
+	// rows of other tables, which transactions keep valid.
+	ForeignKeys []*ForeignKeySchema
+
+	// Inline hints that the table stays tiny, such as a table of config
+	// singletons. Its rows are stored as one sorted slice rather than a
+	// radix tree per index, and the index trees are only built when the
+	// table is read or written through them, once per version of the
+	// table. Every write copies the whole table, so this only pays off
+	// for a handful of rows.
+	Inline bool
+
+	// Singleton marks a table holding at most one object, which is read and
+	// written without a key. Singleton tables must be created with
+	// SingletonTableSchema.
+	Singleton bool
+
+	// Set marks a table holding only string keys, which are added and
+	// removed without any object. Set tables must be created with
+	// SetTableSchema.
+	Set bool
+
+	// Counter marks a table holding int64 counters, which are changed with
+	// Txn.Increment. Counter tables must be created with CounterTableSchema.
+	Counter bool
+
+	// Log makes the table an append-only log with the given retention. Log
+	// tables must be created with LogTableSchema.
+	Log *LogRetention
+
+	// IDGenerator optionally assigns primary keys to the objects inserted
+	// without one. IDField names the field holding the key, and defaults
+	// to the Field of the id index's indexer.
+	IDGenerator IDGenerator
+	IDField     string
+
+	// TTL optionally makes the objects of the table expire. See TTLSchema
+	// for details.
+	TTL *TTLSchema
 }
 
-// Validate is used to validate the table schema
+// Validate is used to validate the table schema. It returns the first
+// problem found, as a *SchemaError.
 func (s *TableSchema) Validate() error {
+	if errs := s.validate(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// validate returns all of the problems with the table schema.
+func (s *TableSchema) validate() SchemaErrors {
+	var errs SchemaErrors
+	report := func(index string, err error) {
+		errs = append(errs, &SchemaError{Index: index, Err: err})
+	}
 
 	// 表名非空
 	if s.Name == "" {
-		return fmt.Errorf("missing table name")
+		report("", fmt.Errorf("missing table name"))
 	}
 
 	// 索引非空
 	if len(s.Indexes) == 0 {
-		return fmt.Errorf("missing table indexes for '%s'", s.Name)
+		report("", fmt.Errorf("missing table indexes for '%s'", s.Name))
 	}
 
 	// 至少要包含 ID 索引
-	if _, ok := s.Indexes["id"]; !ok {
-		return fmt.Errorf("must have id index")
-	}
+	if idSchema, ok := s.Indexes["id"]; !ok || idSchema == nil {
+		report("", fmt.Errorf("must have id index"))
+	} else {
+		// ID 索引必须是唯一索引
+		if !idSchema.Unique {
+			report("", fmt.Errorf("id index must be unique"))
+		}
 
-	// ID 索引必须是唯一索引
-	if !s.Indexes["id"].Unique {
-		return fmt.Errorf("id index must be unique")
-	}
+		// ID 索引必须是单值索引
+		if _, ok := idSchema.Indexer.(SingleIndexer); !ok {
+			report("", fmt.Errorf("id index must be a SingleIndexer"))
+		}
+
+		// Composite primary keys need every part
+		if compound, ok := idSchema.Indexer.(*CompoundIndex); ok && compound.AllowMissing {
+			report("", fmt.Errorf("id index can't be a CompoundIndex with AllowMissing"))
+		}
 
-	// ID 索引必须是单值索引
-	if _, ok := s.Indexes["id"].Indexer.(SingleIndexer); !ok {
-		return fmt.Errorf("id index must be a SingleIndexer")
+		// Every object needs a primary key
+		if idSchema.Condition != nil {
+			report("", fmt.Errorf("id index can't have a condition"))
+		}
+
+		if idSchema.Deprecated {
+			report("", fmt.Errorf("id index can't be deprecated"))
+		}
 	}
 
 	// 校验各个索引合法性
-	for name, index := range s.Indexes {
+	indexes := make([]string, 0, len(s.Indexes))
+	for name := range s.Indexes {
+		indexes = append(indexes, name)
+	}
+	sort.Strings(indexes)
+	for _, name := range indexes {
+		index := s.Indexes[name]
+		if index == nil {
+			report(name, fmt.Errorf("missing index schema"))
+			continue
+		}
 		if name != index.Name {
-			return fmt.Errorf("index name mis-match for '%s'", name)
+			report(name, fmt.Errorf("index name mis-match for '%s'", name))
 		}
 		if err := index.Validate(); err != nil {
-			return fmt.Errorf("index %q: %s", name, err)
+			report(name, err)
 		}
 	}
 
-	return nil
+	if s.LargeObjects != nil {
+		if err := s.LargeObjects.Validate(); err != nil {
+			report("", err)
+		}
+	}
+
+	if s.Inline && s.LargeObjects != nil {
+		report("", fmt.Errorf("inline table can't store large objects"))
+	}
+
+	if s.Compression != nil {
+		if err := s.Compression.Validate(); err != nil {
+			report("", err)
+		}
+		if s.Inline || s.LargeObjects != nil {
+			report("", fmt.Errorf("compressed table can't be inline or store large objects"))
+		}
+	}
+
+	if s.Singleton {
+		if err := s.validateSingleton(); err != nil {
+			report("", err)
+		}
+	}
+
+	if s.Set {
+		if err := s.validateSet(); err != nil {
+			report("", err)
+		}
+	}
+
+	if s.Counter {
+		if err := s.validateCounter(); err != nil {
+			report("", err)
+		}
+	}
+
+	if s.Log != nil {
+		if err := s.validateLog(); err != nil {
+			report("", err)
+		}
+	}
+
+	if s.IDGenerator != nil {
+		if err := s.validateIDGenerator(); err != nil {
+			report("", err)
+		}
+	}
+
+	if s.TTL != nil {
+		if err := s.TTL.Validate(); err != nil {
+			report("", err)
+		}
+	}
+
+	return errs
 }
 
 // IndexSchema is the schema for an index. An index defines how a table is queried.
@@ -114,10 +406,67 @@ type IndexSchema struct {
 	// 唯一索引
 	Unique  bool
 
+	// NullDistinct makes a unique index enforce its uniqueness: inserting
+	// an object whose value is already held by another object fails. Like
+	// NULL in a SQL unique index, objects without a value, such as an empty
+	// string, are exempt from the check and skipped by the index, so any
+	// number of them may exist.
+	//
+	// 唯一且空值互不冲突
+	NullDistinct bool
+
+	// Deferred makes a unique index check its uniqueness against the final
+	// state of the transaction when it commits, rather than on each write,
+	// so that objects can swap their values. TryCommit fails if two objects
+	// still share a value. Objects without a value are exempt if the index
+	// is NullDistinct too.
+	//
+	// 延迟到提交时检查唯一性
+	Deferred bool
+
+	// Condition, if set, makes the index partial: only the objects for
+	// which it returns true are indexed, the others being skipped as if
+	// they had no value. A query such as "all unfinished jobs" can then use
+	// a small index of its own rather than filter a large one. The
+	// condition must only depend on the object.
+	//
+	// 部分索引，只索引满足条件的对象
+	Condition func(obj interface{}) bool
+
+	// Deprecated marks an index that is being retired. It is still kept up
+	// to date, but queries against it log a warning, or fail if the DB was
+	// created with WithStrictDeprecation, until it is removed with DropIndex.
+	//
+	// 已废弃的索引
+	Deprecated bool
+
 	// 索引对象
 	Indexer Indexer
 }
 
+// CaseInsensitiveUniqueIndex returns the schema of a unique index over a
+// string field, such as a username or hostname, that ignores case: looking
+// up "Alice" finds the object inserted as "alice", and inserting an object
+// whose value only differs in case from another object's fails. Objects
+// keep the value they were inserted with, and objects with an empty value
+// are exempt.
+//
+// CaseInsensitiveUniqueIndex 返回忽略大小写的唯一索引模式，对象保留原始大小写。
+func CaseInsensitiveUniqueIndex(name, field string) *IndexSchema {
+	return &IndexSchema{
+		Name:         name,
+		Unique:       true,
+		NullDistinct: true,
+		Indexer:      &EscapedStringFieldIndex{Field: field, FoldCase: true},
+	}
+}
+
+// covers returns true if the object satisfies the condition of the index,
+// if any.
+func (s *IndexSchema) covers(obj interface{}) bool {
+	return s.Condition == nil || s.Condition(obj)
+}
+
 func (s *IndexSchema) Validate() error {
 	// 索引名非空
 	if s.Name == "" {
@@ -134,5 +483,14 @@ func (s *IndexSchema) Validate() error {
 	default:
 		return fmt.Errorf("indexer for '%s' must be a SingleIndexer or MultiIndexer", s.Name)
 	}
-	return nil
+	if s.NullDistinct && !s.Unique {
+		return fmt.Errorf("null distinct index '%s' must be unique", s.Name)
+	}
+	if s.Deferred && !s.Unique {
+		return fmt.Errorf("deferred index '%s' must be unique", s.Name)
+	}
+	if _, ok := s.Indexer.(*FullTextIndex); ok && s.Unique {
+		return fmt.Errorf("full-text index '%s' can't be unique", s.Name)
+	}
+	return validateIndexer(s.Indexer)
 }
@@ -1,6 +1,9 @@
 package memdb
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func testValidSchema() *DBSchema {
 	return &DBSchema{
@@ -49,6 +52,51 @@ func TestDBSchema_Validate(t *testing.T) {
 	}
 }
 
+func TestDBSchema_ValidateAll(t *testing.T) {
+	valid := testValidSchema()
+	if err := valid.ValidateAll(); err != nil {
+		t.Fatalf("should validate: %v", err)
+	}
+
+	s := testValidSchema()
+	s.Tables["main"].Indexes["foo"].Name = "bar"
+	s.Tables["main"].Indexes["qux"].Indexer = nil
+	s.Tables["other"] = &TableSchema{Name: "other"}
+	s.Relations = map[string]*RelationSchema{
+		"rel": &RelationSchema{Name: "nope"},
+	}
+
+	err := s.ValidateAll()
+	errs, ok := err.(SchemaErrors)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	expect := []SchemaError{
+		{Table: "main", Index: "foo"},
+		{Table: "main", Index: "qux"},
+		{Table: "other"},
+		{Table: "other"},
+		{Kind: "relation", Name: "rel"},
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("bad: %v", errs)
+	}
+	for i, e := range expect {
+		if errs[i].Table != e.Table || errs[i].Index != e.Index || errs[i].Kind != e.Kind || errs[i].Name != e.Name {
+			t.Fatalf("bad: %d: %#v", i, errs[i])
+		}
+	}
+	if msg := errs[0].Error(); msg != `table "main": index "foo": index name mis-match for 'foo'` {
+		t.Fatalf("bad: %s", msg)
+	}
+
+	// Validate stops at the first problem
+	err = s.Validate()
+	if serr, ok := err.(*SchemaError); !ok || serr.Error() != errs[0].Error() {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
 func TestTableSchema_Validate(t *testing.T) {
 	s := &TableSchema{}
 	err := s.Validate()
@@ -105,3 +153,39 @@ func TestIndexSchema_Validate(t *testing.T) {
 		t.Fatalf("should validate: %v", err)
 	}
 }
+
+type testValidatingIndexer struct {
+	StringFieldIndex
+	err error
+}
+
+func (v *testValidatingIndexer) Validate() error {
+	return v.err
+}
+
+func TestIndexSchema_Validate_Indexer(t *testing.T) {
+	bad := &testValidatingIndexer{err: fmt.Errorf("bad indexer")}
+	for _, indexer := range []Indexer{
+		bad,
+		&CompoundIndex{Indexes: []Indexer{&StringFieldIndex{Field: "ID"}, bad}},
+		&CompoundMultiIndex{Indexes: []Indexer{bad}},
+		&HMACIndex{Indexer: bad, Key: []byte("key")},
+		&FieldPathIndexer{Path: "Address", Indexer: bad},
+		&CompoundIndex{Indexes: []Indexer{&FieldPathIndexer{Path: "Address..City"}}},
+		&HMACIndex{Indexer: &EnumFieldIndex{Field: "Status"}, Key: []byte("key")},
+	} {
+		s := &IndexSchema{Name: "foo", Indexer: indexer}
+		if err := s.Validate(); err == nil {
+			t.Fatalf("should not validate: %#v", indexer)
+		}
+	}
+
+	bad.err = nil
+	s := &IndexSchema{
+		Name:    "foo",
+		Indexer: &CompoundIndex{Indexes: []Indexer{bad, &HMACIndex{Indexer: bad, Key: []byte("key")}}},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
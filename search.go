@@ -0,0 +1,263 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// FullTextIndex is used to extract a string or []string field from an
+// object using reflection and builds an inverted index on the words of its
+// text, so that Txn.Search can find the objects containing words of a query
+// without scanning the table. Words are split on anything but letters and
+// digits, and lowercased.
+//
+// The index holds one value per distinct word, so Get finds the objects
+// containing a single word, given in any case, and a prefix lookup finds the
+// objects containing words starting with a prefix.
+//
+// FullTextIndex 为文本字段建立倒排索引，用于 Txn.Search 全文检索。
+type FullTextIndex struct {
+	Field string
+
+	// Stemming reduces English words to their stem, so that "running" and
+	// "runs" both match "run". The stemmer only strips common suffixes, and
+	// isn't applied to prefix lookups.
+	Stemming bool
+
+	// Tokenizer, if set, splits the text into words instead of the default
+	// tokenizer. The words are lowercased, and stemmed if Stemming is set.
+	Tokenizer func(text string) []string
+}
+
+func (f *FullTextIndex) FromObject(obj interface{}) (bool, [][]byte, error) {
+	words, err := f.objectWords(obj)
+	if err != nil {
+		return false, nil, err
+	}
+
+	seen := make(map[string]struct{}, len(words))
+	vals := make([][]byte, 0, len(words))
+	for _, word := range words {
+		if _, ok := seen[word]; ok {
+			continue
+		}
+		seen[word] = struct{}{}
+		vals = append(vals, []byte(word+"\x00"))
+	}
+	return len(vals) != 0, vals, nil
+}
+
+func (f *FullTextIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	words := f.words(arg)
+	if len(words) != 1 {
+		return nil, fmt.Errorf("argument must be a single word: %q", arg)
+	}
+	return []byte(words[0] + "\x00"), nil
+}
+
+func (f *FullTextIndex) PrefixFromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	return []byte(strings.ToLower(arg)), nil
+}
+
+// Validate checks the settings of the index.
+func (f *FullTextIndex) Validate() error {
+	if f.Field == "" {
+		return fmt.Errorf("missing full-text field")
+	}
+	return nil
+}
+
+// objectWords returns the words of the text of the object, with repeats.
+func (f *FullTextIndex) objectWords(obj interface{}) ([]string, error) {
+	v := reflect.ValueOf(obj)
+	v = reflect.Indirect(v) // Dereference the pointer if any
+
+	fv := v.FieldByName(f.Field)
+	if !fv.IsValid() {
+		return nil, fmt.Errorf("field '%s' for %#v is invalid", f.Field, obj)
+	}
+
+	switch {
+	case fv.Kind() == reflect.String:
+		return f.words(fv.String()), nil
+	case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+		var words []string
+		for i := 0; i < fv.Len(); i++ {
+			words = append(words, f.words(fv.Index(i).String())...)
+		}
+		return words, nil
+	default:
+		return nil, fmt.Errorf("field %q is of type %v; want a string or []string", f.Field, fv.Type())
+	}
+}
+
+// words splits the text into normalized words.
+func (f *FullTextIndex) words(text string) []string {
+	var words []string
+	if f.Tokenizer != nil {
+		words = f.Tokenizer(text)
+	} else {
+		words = strings.FieldsFunc(text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+	}
+
+	out := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if f.Stemming {
+			word = stem(word)
+		}
+		if word != "" {
+			out = append(out, word)
+		}
+	}
+	return out
+}
+
+// stem strips the common inflectional suffixes of an English word: plurals,
+// -ed, -ing and -ly. It keeps at least three letters of the word, and words
+// such as "speed" whole.
+func stem(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "eed"):
+		return word
+	case strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	}
+	for _, suffix := range []string{"ing", "ed", "ly"} {
+		base := strings.TrimSuffix(word, suffix)
+		if base == word || len(base) < 3 || !strings.ContainsAny(base, "aeiouy") {
+			continue
+		}
+		// Undouble the final consonant, as in "running"
+		if n := len(base); suffix != "ly" && base[n-1] == base[n-2] && !strings.ContainsAny(base[n-1:], "aeiouylsz") {
+			base = base[:n-1]
+		}
+		return base
+	}
+	return word
+}
+
+// Search returns the objects of the table containing any word of the query
+// in the text indexed by a FullTextIndex, most relevant first. Objects are
+// ranked by TF-IDF: words count more the more often they appear in the
+// object, and the fewer objects contain them. Ties are broken in the order
+// of the primary index.
+//
+// The results are computed up front. The watch channel of the iterator is
+// closed when the index changes.
+//
+// Search 使用全文索引检索包含查询词的对象，按相关度排序返回。
+func (txn *Txn) Search(table, index, query string) (ResultIterator, error) {
+	tableSchema, ok := txn.tableSchema(table)
+	if !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+	indexSchema, ok := tableSchema.Indexes[index]
+	if !ok {
+		return nil, fmt.Errorf("invalid index '%s'", index)
+	}
+	text, ok := indexSchema.Indexer.(*FullTextIndex)
+	if !ok {
+		return nil, fmt.Errorf("index '%s' is not a full-text index", index)
+	}
+	total, err := txn.Len(table)
+	if err != nil {
+		return nil, err
+	}
+	idIndexer := tableSchema.Indexes[id].Indexer.(SingleIndexer)
+
+	// Find the objects containing each word, and how rare the word is
+	type match struct {
+		obj   interface{}
+		key   []byte
+		score float64
+	}
+	matches := make(map[string]*match)
+	idf := make(map[string]float64)
+	var terms []string
+	for _, word := range text.words(query) {
+		if _, ok := idf[word]; ok {
+			continue
+		}
+		terms = append(terms, word)
+		rows, err := txn.lookupKeys(table, index, [][]byte{[]byte(word + "\x00")})
+		if err != nil {
+			return nil, err
+		}
+		idf[word] = math.Log(1 + float64(total)/float64(len(rows)+1))
+		for _, obj := range rows {
+			_, key, err := idIndexer.FromObject(obj)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build primary index: %v", err)
+			}
+			if _, ok := matches[string(key)]; !ok {
+				matches[string(key)] = &match{obj: obj, key: key}
+			}
+		}
+	}
+
+	// Score the objects by the frequency of the words of the query
+	results := make([]*match, 0, len(matches))
+	for _, m := range matches {
+		words, err := text.objectWords(m.obj)
+		if err != nil {
+			return nil, err
+		}
+		freq := make(map[string]int)
+		for _, word := range words {
+			if _, ok := idf[word]; ok {
+				freq[word]++
+			}
+		}
+		for _, word := range terms {
+			if n := freq[word]; n > 0 {
+				m.score += (1 + math.Log(float64(n))) * idf[word]
+			}
+		}
+		results = append(results, m)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return bytes.Compare(results[i].key, results[j].key) < 0
+	})
+
+	rows := make([]interface{}, len(results))
+	for i, m := range results {
+		rows[i] = m.obj
+	}
+	txn.trackRead(table, index, nil, nil)
+	ws := NewWatchSet()
+	watchCh, _, _ := txn.readableIndex(table, index).Root().GetWatch(nil)
+	ws.Add(watchCh)
+	return &traverseIterator{rows: rows, ws: ws}, nil
+}
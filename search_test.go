@@ -0,0 +1,207 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testDoc struct {
+	ID   string
+	Body string
+	Tags []string
+}
+
+func testSearchDB(t *testing.T) *MemDB {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"docs": &TableSchema{
+				Name: "docs",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"body": &IndexSchema{
+						Name:         "body",
+						AllowMissing: true,
+						Indexer:      &FullTextIndex{Field: "Body", Stemming: true},
+					},
+					"tags": &IndexSchema{
+						Name:         "tags",
+						AllowMissing: true,
+						Indexer:      &FullTextIndex{Field: "Tags"},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for _, doc := range []*testDoc{
+		&testDoc{ID: "a", Body: "The quick brown fox jumps over the lazy dog", Tags: []string{"Animals"}},
+		&testDoc{ID: "b", Body: "A fox, a fox! Foxes everywhere.", Tags: []string{"animals", "alarm"}},
+		&testDoc{ID: "c", Body: "Running a database in memory", Tags: []string{"Databases"}},
+		&testDoc{ID: "d", Body: "The dog runs to the database"},
+		&testDoc{ID: "e"},
+	} {
+		if err := txn.Insert("docs", doc); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	return db
+}
+
+func searchIDs(t *testing.T, txn *Txn, index, query string) string {
+	t.Helper()
+	iter, err := txn.Search("docs", index, query)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		ids = append(ids, obj.(*testDoc).ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestTxn_Search(t *testing.T) {
+	db := testSearchDB(t)
+	txn := db.Txn(false)
+
+	cases := []struct {
+		index, query, expected string
+	}{
+		// Repeated words rank higher
+		{"body", "fox", "b,a"},
+		// Stems match, and rarer words count more
+		{"body", "run DOG", "d,a,c"},
+		{"body", "databases", "c,d"},
+		{"body", "the the", "a,d"},
+		{"body", "cat", ""},
+		{"body", "", ""},
+		{"tags", "animals", "a,b"},
+		{"tags", "animal", ""},
+	}
+	for _, c := range cases {
+		if got := searchIDs(t, txn, c.index, c.query); got != c.expected {
+			t.Fatalf("%s %q: bad: %s", c.index, c.query, got)
+		}
+	}
+
+	// Single words and prefixes can be looked up directly
+	iter, err := txn.Get("docs", "body", "Jumping")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if obj := iter.Next(); obj == nil || obj.(*testDoc).ID != "a" || iter.Next() != nil {
+		t.Fatalf("bad: %#v", obj)
+	}
+	if _, err := txn.Get("docs", "body", "two words"); err == nil {
+		t.Fatalf("expected error")
+	}
+	iter, err = txn.Get("docs", "tags_prefix", "A")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		ids = append(ids, obj.(*testDoc).ID)
+	}
+	if fmt.Sprint(ids) != "[b a b]" {
+		t.Fatalf("bad: %v", ids)
+	}
+
+	if _, err := txn.Search("docs", "id", "a"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := txn.Search("docs", "nope", "a"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := txn.Search("nope", "body", "a"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestTxn_Search_Writes(t *testing.T) {
+	db := testSearchDB(t)
+	iter, err := db.Txn(false).Search("docs", "body", "fox")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Write transactions search their own writes
+	txn := db.Txn(true)
+	if err := txn.Insert("docs", &testDoc{ID: "b", Body: "no more animals"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("docs", &testDoc{ID: "f", Body: "Foxy, foxy"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := searchIDs(t, txn, "body", "fox foxy"); got != "f,a" {
+		t.Fatalf("bad: %s", got)
+	}
+	txn.Commit()
+
+	select {
+	case <-iter.WatchCh():
+	case <-time.After(time.Second):
+		t.Fatalf("should be notified")
+	}
+}
+
+func TestFullTextIndex_Stem(t *testing.T) {
+	for word, expected := range map[string]string{
+		"run":       "run",
+		"runs":      "run",
+		"running":   "run",
+		"jumped":    "jump",
+		"flies":     "fly",
+		"classes":   "class",
+		"class":     "class",
+		"status":    "status",
+		"speed":     "speed",
+		"quickly":   "quick",
+		"string":    "string",
+		"thing":     "thing",
+		"filling":   "fill",
+		"databases": "database",
+	} {
+		if got := stem(word); got != expected {
+			t.Fatalf("%s: bad: %s", word, got)
+		}
+	}
+}
+
+func TestFullTextIndex_Validate(t *testing.T) {
+	for _, s := range []*IndexSchema{
+		&IndexSchema{Name: "text", Indexer: &FullTextIndex{}},
+		&IndexSchema{Name: "text", Unique: true, Indexer: &FullTextIndex{Field: "Body"}},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Fatalf("expected error: %#v", s)
+		}
+	}
+
+	// Custom tokenizers split the words
+	indexer := &FullTextIndex{
+		Field:     "Body",
+		Tokenizer: func(text string) []string { return strings.Split(text, ";") },
+	}
+	ok, vals, err := indexer.FromObject(&testDoc{Body: "New York;Paris;new york"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || len(vals) != 2 || string(vals[0]) != "new york\x00" || string(vals[1]) != "paris\x00" {
+		t.Fatalf("bad: %q", vals)
+	}
+	if _, _, err := indexer.FromObject(&TestObject{Bar: 1}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,127 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SequenceValue is the row type of the SequencesTable. Value is the last
+// number handed out by the sequence of Table.
+type SequenceValue struct {
+	Table string
+	Value uint64
+}
+
+// NextSequence advances the sequence of the given table and returns its
+// new value. Sequences start at 1 and are stored in the MemDB, so they are
+// versioned with the data: numbers taken by a transaction that is aborted
+// are handed out again. Sequences are saved in snapshots, streamed to
+// replicas by ServeBootstrap and logged in the write-ahead log configured
+// with WithWAL, so they carry on from where they were when the DB is
+// restored or reopened.
+//
+// Sequences can't be advanced in optimistic transactions, whose commits
+// only replay the changes made to the tables of the schema.
+//
+// NextSequence 递增表的序列并返回新值，序列随事务提交或回滚。
+func (txn *Txn) NextSequence(table string) (uint64, error) {
+	current, err := txn.sequence(table)
+	if err != nil {
+		return 0, err
+	}
+	txn.setSequence(table, current+1)
+	return current + 1, nil
+}
+
+// sequence returns the current value of the table's sequence.
+func (txn *Txn) sequence(table string) (uint64, error) {
+	if !txn.write {
+		return 0, fmt.Errorf("cannot advance sequence in read-only transaction")
+	}
+	if txn.optimistic {
+		return 0, fmt.Errorf("cannot advance sequence in optimistic transaction")
+	}
+	if _, ok := txn.schema.Tables[table]; !ok {
+		return 0, fmt.Errorf("invalid table '%s'", table)
+	}
+	if err := txn.checkWritable(SequencesTable); err != nil {
+		return 0, err
+	}
+	raw, ok := txn.writableIndex(SequencesTable, id).Get(sequenceKey(table))
+	if !ok {
+		return 0, nil
+	}
+	return raw.(*SequenceValue).Value, nil
+}
+
+// setSequence stores a new value for the table's sequence.
+func (txn *Txn) setSequence(table string, value uint64) {
+	txn.writableIndex(SequencesTable, id).Insert(sequenceKey(table), &SequenceValue{
+		Table: table,
+		Value: value,
+	})
+}
+
+// sequenceValues returns the sequences of the given tables that were ever
+// advanced, skipping the tables missing from the schema.
+func (txn *Txn) sequenceValues(tables []string) []*SequenceValue {
+	var seqs []*SequenceValue
+	index := txn.readableIndex(SequencesTable, id)
+	for _, table := range tables {
+		if _, ok := txn.schema.Tables[table]; !ok {
+			continue
+		}
+		if raw, ok := index.Get(sequenceKey(table)); ok {
+			seqs = append(seqs, raw.(*SequenceValue))
+		}
+	}
+	return seqs
+}
+
+// encodeSequence encodes the value of a sequence for snapshots and the
+// write-ahead log.
+func encodeSequence(value uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return tmp[:binary.PutUvarint(tmp[:], value)]
+}
+
+// decodeSequence decodes a value written by encodeSequence.
+func decodeSequence(data []byte) (uint64, error) {
+	value, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid sequence value")
+	}
+	return value, nil
+}
+
+// sequenceKey returns the key of a table's sequence in the SequencesTable.
+func sequenceKey(table string) []byte {
+	return append([]byte(table), 0)
+}
+
+// SequenceGenerator is an IDGenerator assigning the values of the table's
+// sequence, as given by Txn.NextSequence, to integer primary keys. Objects
+// inserted with a key of their own may get ahead of the sequence, so it is
+// moved past the largest key of the table first, which requires the id
+// index to order keys numerically: it must be a UintFieldIndex or an
+// IntFieldIndexer, not an IntFieldIndex, whose values don't sort in numeric
+// order. This also keeps keys unique when the sequence starts over after a
+// snapshot is restored. Unlike a SequentialGenerator, the keys of deleted
+// objects are never reused.
+type SequenceGenerator struct{}
+
+func (g *SequenceGenerator) NextID(txn *Txn, table string) (interface{}, error) {
+	current, err := txn.sequence(table)
+	if err != nil {
+		return nil, err
+	}
+	max, err := txn.lastIntegerID(table)
+	if err != nil {
+		return nil, err
+	}
+	if max > current {
+		current = max
+	}
+	txn.setSequence(table, current+1)
+	return current + 1, nil
+}
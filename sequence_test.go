@@ -0,0 +1,89 @@
+package memdb
+
+import "testing"
+
+func TestTxn_NextSequence(t *testing.T) {
+	db := testIDGenDB(t, &SequenceGenerator{}, &UintFieldIndex{Field: "ID"})
+
+	next := func(txn *Txn) uint64 {
+		n, err := txn.NextSequence("tickets")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return n
+	}
+
+	txn := db.Txn(true)
+	if n := next(txn); n != 1 {
+		t.Fatalf("bad: %d", n)
+	}
+	if n := next(txn); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+	txn.Commit()
+
+	// Aborted transactions don't advance the sequence
+	txn = db.Txn(true)
+	if n := next(txn); n != 3 {
+		t.Fatalf("bad: %d", n)
+	}
+	txn.Abort()
+
+	txn = db.Txn(true)
+	if n := next(txn); n != 3 {
+		t.Fatalf("bad: %d", n)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	raw, err := txn.First(SequencesTable, "id", "tickets")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if value := raw.(*SequenceValue); value.Value != 3 {
+		t.Fatalf("bad: %#v", value)
+	}
+	if _, err := txn.NextSequence("tickets"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	txn = db.Txn(true)
+	if _, err := txn.NextSequence("nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+}
+
+func TestTxn_InsertID_Sequence(t *testing.T) {
+	db := testIDGenDB(t, &SequenceGenerator{}, &UintFieldIndex{Field: "ID"})
+
+	insert := func(txn *Txn, ticket *testTicket) uint64 {
+		key, err := txn.InsertID("tickets", ticket)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return key.(uint64)
+	}
+
+	txn := db.Txn(true)
+	if key := insert(txn, &testTicket{}); key != 1 {
+		t.Fatalf("bad: %d", key)
+	}
+
+	// Explicit keys move the sequence past them
+	if key := insert(txn, &testTicket{ID: 5}); key != 5 {
+		t.Fatalf("bad: %d", key)
+	}
+	if key := insert(txn, &testTicket{}); key != 6 {
+		t.Fatalf("bad: %d", key)
+	}
+
+	// Keys of deleted objects aren't reused
+	if err := txn.Delete("tickets", &testTicket{ID: 6}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if key := insert(txn, &testTicket{}); key != 7 {
+		t.Fatalf("bad: %d", key)
+	}
+	txn.Commit()
+}
@@ -0,0 +1,144 @@
+// Package server exposes a MemDB to external processes over HTTP.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	memdb "github.com/hashicorp/go-memdb"
+)
+
+// WatchEvent is sent to watchers whenever a watched table changes. Index is
+// the index of the commit that made the changes, and Changes holds the rows
+// it modified in the table, in the order they were made.
+//
+// The first event of a stream has the Index of the commit the stream starts
+// after. It has no changes, unless the stream was requested with a
+// snapshot: it then inserts every row of the table as of that commit, so a
+// client that applies the changes of every event keeps an exact copy of it.
+type WatchEvent struct {
+	Table   string        `json:"table"`
+	Index   uint64        `json:"index"`
+	Changes []ChangeEvent `json:"changes,omitempty"`
+}
+
+// ChangeEvent is a single row change within a WatchEvent. Before is null for
+// an insert and After is null for a delete.
+type ChangeEvent struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// WatchHandler streams changes to a table as server-sent events. The table is
+// given by the "table" query parameter. An event is sent as soon as the
+// connection is established and then for every commit that modifies the
+// table, until the client disconnects. Setting the "snapshot" query
+// parameter to true makes the first event carry the rows of the table, as
+// described for WatchEvent.
+//
+// No commit is ever skipped. If the client falls too far behind, an "error"
+// event is sent and the stream ends; the client must then watch the table
+// again with a snapshot.
+//
+// WatchHandler 以 server-sent events 的形式推送表的变更。
+type WatchHandler struct {
+	db *memdb.MemDB
+}
+
+// NewWatchHandler returns a WatchHandler serving watches on the given DB.
+func NewWatchHandler(db *memdb.MemDB) *WatchHandler {
+	return &WatchHandler{db: db}
+}
+
+func (h *WatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	table := r.URL.Query().Get("table")
+	if table == "" {
+		http.Error(w, "missing table", http.StatusBadRequest)
+		return
+	}
+	var snapshot bool
+	if raw := r.URL.Query().Get("snapshot"); raw != "" {
+		var err error
+		if snapshot, err = strconv.ParseBool(raw); err != nil {
+			http.Error(w, "invalid snapshot", http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, err := h.db.Subscribe(r.Context(), table, memdb.SubscribeOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer sub.Close()
+
+	first := WatchEvent{Table: table, Index: sub.StartIndex()}
+	if snapshot {
+		if first.Changes, err = snapshotRows(sub.StartView(), table); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := writeEvent(w, "change", first); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for commit := range sub.Changes() {
+		event := WatchEvent{Table: table, Index: commit.Index}
+		for _, change := range commit.Changes {
+			event.Changes = append(event.Changes, ChangeEvent{Before: change.Before, After: change.After})
+		}
+		if err := writeEvent(w, "change", event); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	// The client is gone if the request's context is done, otherwise it
+	// fell behind.
+	if err := sub.Err(); err != nil && r.Context().Err() == nil {
+		writeEvent(w, "error", map[string]string{"error": err.Error()})
+		flusher.Flush()
+	}
+}
+
+// snapshotRows returns the rows of the table in the view as inserts.
+func snapshotRows(view *memdb.ReadView, table string) ([]ChangeEvent, error) {
+	iter, err := view.Get(table, "id")
+	if err != nil {
+		return nil, err
+	}
+	var rows []ChangeEvent
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		rows = append(rows, ChangeEvent{After: obj})
+	}
+	return rows, memdb.IteratorErr(iter)
+}
+
+// writeEvent writes a single server-sent event with a JSON payload.
+func writeEvent(w http.ResponseWriter, name string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	memdb "github.com/hashicorp/go-memdb"
+)
+
+type testObject struct {
+	ID string
+}
+
+func testDB(t *testing.T) *memdb.MemDB {
+	db, err := memdb.NewMemDB(&memdb.DBSchema{
+		Tables: map[string]*memdb.TableSchema{
+			"main": &memdb.TableSchema{
+				Name: "main",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "ID"},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return db
+}
+
+// readEvent reads the next server-sent event and decodes its payload.
+func readEvent(t *testing.T, r *bufio.Reader) WatchEvent {
+	var event WatchEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data: ") {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		if line == "" {
+			return event
+		}
+	}
+}
+
+func TestWatchHandler(t *testing.T) {
+	db := testDB(t)
+	srv := httptest.NewServer(NewWatchHandler(db))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?table=nope")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "?table=main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("bad: %s", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// The current state is sent right away
+	if event := readEvent(t, r); event.Table != "main" || event.Index != 0 || len(event.Changes) != 0 {
+		t.Fatalf("bad: %#v", event)
+	}
+
+	eventCh := make(chan WatchEvent, 1)
+	go func() {
+		eventCh <- readEvent(t, r)
+	}()
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &testObject{ID: "foo"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	select {
+	case event := <-eventCh:
+		if event.Table != "main" || event.Index != 1 || len(event.Changes) != 1 {
+			t.Fatalf("bad: %#v", event)
+		}
+		change := event.Changes[0]
+		after, ok := change.After.(map[string]interface{})
+		if change.Before != nil || !ok || after["ID"] != "foo" {
+			t.Fatalf("bad: %#v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+
+	// A delete carries the row removed
+	txn = db.Txn(true)
+	if err := txn.Delete("main", &testObject{ID: "foo"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	event := readEvent(t, r)
+	if event.Index != 2 || len(event.Changes) != 1 || event.Changes[0].After != nil {
+		t.Fatalf("bad: %#v", event)
+	}
+	if before, ok := event.Changes[0].Before.(map[string]interface{}); !ok || before["ID"] != "foo" {
+		t.Fatalf("bad: %#v", event.Changes[0])
+	}
+}
+
+func TestWatchHandler_Snapshot(t *testing.T) {
+	db := testDB(t)
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b"} {
+		if err := txn.Insert("main", &testObject{ID: id}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	srv := httptest.NewServer(NewWatchHandler(db))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?table=main&snapshot=nope")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "?table=main&snapshot=true")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+
+	// The rows as of the start of the stream are inserted by the first event
+	event := readEvent(t, r)
+	if event.Index != 1 || len(event.Changes) != 2 {
+		t.Fatalf("bad: %#v", event)
+	}
+	for i, id := range []string{"a", "b"} {
+		change := event.Changes[i]
+		if after, ok := change.After.(map[string]interface{}); change.Before != nil || !ok || after["ID"] != id {
+			t.Fatalf("bad: %#v", change)
+		}
+	}
+
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &testObject{ID: "c"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if event := readEvent(t, r); event.Index != 2 || len(event.Changes) != 1 {
+		t.Fatalf("bad: %#v", event)
+	}
+}
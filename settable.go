@@ -0,0 +1,156 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// setIndex is the primary index of a set table. The objects of a set table
+// are the keys themselves, stored as strings, which are indexed as they are
+// so that any prefix of a key finds it.
+type setIndex struct{}
+
+func (s *setIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	key, ok := obj.(string)
+	if !ok {
+		return false, nil, fmt.Errorf("set member must be a string, not %T", obj)
+	}
+	return true, []byte(key), nil
+}
+
+func (s *setIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	key, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	return []byte(key), nil
+}
+
+func (s *setIndex) PrefixFromArgs(args ...interface{}) ([]byte, error) {
+	return s.FromArgs(args...)
+}
+
+// SetTableSchema returns the schema for a set table with the given name, to
+// be added to the DBSchema of the MemDB. A set table only holds string keys,
+// such as the entries of a deny-list, which are written with Txn.SetAdd and
+// Txn.SetRemove and read with Txn.SetContains and Txn.SetPrefix. No object
+// is stored besides the key, so it takes much less memory than a table of
+// structs holding just a key.
+//
+// SetTableSchema 返回集合表的模式，集合表只保存字符串键，用于成员判断。
+func SetTableSchema(name string) *TableSchema {
+	return &TableSchema{
+		Name: name,
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &setIndex{},
+			},
+		},
+		Set: true,
+	}
+}
+
+// validateSet checks that a table marked as a set was built by
+// SetTableSchema.
+func (s *TableSchema) validateSet() error {
+	idSchema, ok := s.Indexes[id]
+	if !ok || len(s.Indexes) != 1 {
+		return fmt.Errorf("set table must only have the id index")
+	}
+	if _, ok := idSchema.Indexer.(*setIndex); !ok {
+		return fmt.Errorf("set table must be created with SetTableSchema")
+	}
+	if s.Singleton || s.LargeObjects != nil || s.Compression != nil {
+		return fmt.Errorf("set table can't be a singleton or store large or compressed objects")
+	}
+	return nil
+}
+
+// SetAdd adds a key to a set table, and returns true if it wasn't already
+// in the set.
+func (txn *Txn) SetAdd(table, key string) (bool, error) {
+	found, err := txn.SetContains(table, key)
+	if err != nil || found {
+		return false, err
+	}
+	if err := txn.Insert(table, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetRemove removes a key from a set table, and returns true if it was in
+// the set.
+func (txn *Txn) SetRemove(table, key string) (bool, error) {
+	found, err := txn.SetContains(table, key)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := txn.Delete(table, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetContains returns true if the key is in a set table.
+func (txn *Txn) SetContains(table, key string) (bool, error) {
+	if err := txn.checkSet(table); err != nil {
+		return false, err
+	}
+	raw, err := txn.First(table, id, key)
+	return raw != nil, err
+}
+
+// SetIterator iterates over the keys of a set table.
+type SetIterator struct {
+	iter ResultIterator
+}
+
+// WatchCh returns a channel that is closed when the keys change.
+func (i *SetIterator) WatchCh() <-chan struct{} {
+	return i.iter.WatchCh()
+}
+
+// WatchCtx waits for the keys to change, or for the context to be done.
+func (i *SetIterator) WatchCtx(ctx context.Context) error {
+	return WatchCtx(ctx, i.iter.WatchCh())
+}
+
+// Next returns the next key, and false once there are no more.
+func (i *SetIterator) Next() (string, bool) {
+	raw := i.iter.Next()
+	if raw == nil {
+		return "", false
+	}
+	return raw.(string), true
+}
+
+// SetPrefix returns an iterator over the keys of a set table starting with
+// the given prefix, in order. An empty prefix iterates over the whole set.
+func (txn *Txn) SetPrefix(table, prefix string) (*SetIterator, error) {
+	if err := txn.checkSet(table); err != nil {
+		return nil, err
+	}
+	iter, err := txn.Get(table, id+"_prefix", prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &SetIterator{iter: iter}, nil
+}
+
+// checkSet returns an error unless the table is a set table.
+func (txn *Txn) checkSet(table string) error {
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+	if !tableSchema.Set {
+		return fmt.Errorf("table '%s' is not a set table", table)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package memdb
+
+import "testing"
+
+func TestTxn_SetTable(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["deny"] = SetTableSchema("deny")
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, key := range []string{"10.0.0.1", "10.0.0.2", "192.168.0.1"} {
+		if added, err := txn.SetAdd("deny", key); err != nil || !added {
+			t.Fatalf("bad: %v %v", added, err)
+		}
+	}
+	if added, err := txn.SetAdd("deny", "10.0.0.1"); err != nil || added {
+		t.Fatalf("bad: %v %v", added, err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(true)
+	if removed, err := txn.SetRemove("deny", "10.0.0.2"); err != nil || !removed {
+		t.Fatalf("bad: %v %v", removed, err)
+	}
+	if removed, err := txn.SetRemove("deny", "10.0.0.2"); err != nil || removed {
+		t.Fatalf("bad: %v %v", removed, err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	if found, err := txn.SetContains("deny", "10.0.0.1"); err != nil || !found {
+		t.Fatalf("bad: %v %v", found, err)
+	}
+	if found, err := txn.SetContains("deny", "10.0.0.2"); err != nil || found {
+		t.Fatalf("bad: %v %v", found, err)
+	}
+
+	iter, err := txn.SetPrefix("deny", "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var keys []string
+	for key, ok := iter.Next(); ok; key, ok = iter.Next() {
+		keys = append(keys, key)
+	}
+	if len(keys) != 2 || keys[0] != "10.0.0.1" || keys[1] != "192.168.0.1" {
+		t.Fatalf("bad: %#v", keys)
+	}
+	iter, err = txn.SetPrefix("deny", "192.")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if key, ok := iter.Next(); !ok || key != "192.168.0.1" {
+		t.Fatalf("bad: %v %v", key, ok)
+	}
+	if _, ok := iter.Next(); ok {
+		t.Fatalf("should be done")
+	}
+
+	if _, err := txn.SetContains("main", "a"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Set tables can't have other indexes
+	schema.Tables["deny"].Indexes["foo"] = &IndexSchema{
+		Name:    "foo",
+		Indexer: &StringFieldIndex{Field: "Foo"},
+	}
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,90 @@
+package memdb
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// cacheLinePad is the size of the padding keeping hot fields on cache lines
+// of their own. It covers two cache lines, since some CPUs prefetch cache
+// lines in pairs.
+const cacheLinePad = 128
+
+// ShardOptions configures how the state shared by all transactions is spread
+// over cache lines, to reduce contention between cores.
+type ShardOptions struct {
+	// WriterShards is the number of shards of the writer lock. Transactions
+	// started with TxnTables only take a shard for reading, picked by the
+	// tables they lock, so they don't all update the same lock, while
+	// Txn(true) takes every shard.
+	WriterShards int
+}
+
+// WithSharding sets the sharding of the writer lock. The default of one
+// shard is best on machines with few cores.
+//
+// WithSharding 设置写锁的分片数，以减少多核之间的缓存行争用。
+func WithSharding(opts ShardOptions) Option {
+	return func(db *MemDB) {
+		if opts.WriterShards > 1 {
+			db.writer.shards = make([]paddedRWMutex, opts.WriterShards-1)
+		}
+	}
+}
+
+// paddedRWMutex is a shard of the writer lock on a cache line of its own.
+type paddedRWMutex struct {
+	sync.RWMutex
+	_ [cacheLinePad]byte
+}
+
+// setRoot publishes a new root of the DB.
+func (db *MemDB) setRoot(root *iradix.Tree) {
+	atomic.StorePointer(&db.root, unsafe.Pointer(root))
+}
+
+// writerLock is the writer lock of the DB, which may be sharded. Its zero
+// value is an unlocked lock with a single shard.
+type writerLock struct {
+	base   sync.RWMutex
+	shards []paddedRWMutex
+}
+
+// Lock takes every shard for writing.
+func (w *writerLock) Lock() {
+	w.base.Lock()
+	for i := range w.shards {
+		w.shards[i].Lock()
+	}
+}
+
+// Unlock releases every shard.
+func (w *writerLock) Unlock() {
+	for i := len(w.shards) - 1; i >= 0; i-- {
+		w.shards[i].Unlock()
+	}
+	w.base.Unlock()
+}
+
+// rlock takes the shard for the given tables for reading and returns it.
+// Transactions on different tables usually take different shards.
+func (w *writerLock) rlock(tables []string) *sync.RWMutex {
+	l := &w.base
+	if n := uint32(len(w.shards)) + 1; n > 1 {
+		var hint uint32
+		for _, table := range tables {
+			h := fnv.New32a()
+			h.Write([]byte(table))
+			hint ^= h.Sum32()
+		}
+		if i := hint % n; i > 0 {
+			l = &w.shards[i-1].RWMutex
+		}
+	}
+	l.RLock()
+	return l
+}
@@ -0,0 +1,88 @@
+package memdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemDB_Sharding(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["hits"] = CounterTableSchema("hits")
+	db, err := NewMemDB(schema, WithSharding(ShardOptions{WriterShards: 8}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Transactions on tables hold a shard of the writer lock each, and the
+	// global writer waits for all of them
+	var txns []*Txn
+	for i := 0; i < 16; i++ {
+		table := []string{"main", "hits"}[i%2]
+		doneCh := make(chan *Txn)
+		go func() {
+			txn, err := db.TxnTables(table)
+			if err != nil {
+				t.Errorf("err: %v", err)
+			}
+			doneCh <- txn
+		}()
+		txn := <-doneCh
+		txns = append(txns, txn)
+		txn.Abort()
+	}
+	held, err := db.TxnTables("main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	writerCh := make(chan struct{})
+	go func() {
+		db.Txn(true).Abort()
+		close(writerCh)
+	}()
+	select {
+	case <-writerCh:
+		t.Fatalf("writer should wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	held.Abort()
+	select {
+	case <-writerCh:
+	case <-time.After(time.Second):
+		t.Fatalf("writer should proceed")
+	}
+}
+
+func BenchmarkMemDB_TxnTablesParallel(b *testing.B) {
+	for _, shards := range []int{1, 8} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			schema := &DBSchema{Tables: map[string]*TableSchema{}}
+			for i := 0; i < 64; i++ {
+				name := fmt.Sprintf("hits%d", i)
+				schema.Tables[name] = CounterTableSchema(name)
+			}
+			db, err := NewMemDB(schema, WithSharding(ShardOptions{WriterShards: shards}))
+			if err != nil {
+				b.Fatalf("err: %v", err)
+			}
+
+			var next uint32
+			var nextLock sync.Mutex
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				nextLock.Lock()
+				table := fmt.Sprintf("hits%d", next%64)
+				next++
+				nextLock.Unlock()
+				for pb.Next() {
+					txn, err := db.TxnTables(table)
+					if err != nil {
+						b.Fatalf("err: %v", err)
+					}
+					txn.Abort()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package memdb
+
+import "fmt"
+
+// singletonKey is the primary key of the only row of a singleton table.
+var singletonKey = []byte{0}
+
+// singletonIndex is the primary index of a singleton table, which gives
+// every object the same key so that there is at most one row.
+type singletonIndex struct{}
+
+func (s *singletonIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	return true, singletonKey, nil
+}
+
+func (s *singletonIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("singleton index takes no arguments")
+	}
+	return singletonKey, nil
+}
+
+// SingletonTableSchema returns the schema for a singleton table with the
+// given name, to be added to the DBSchema of the MemDB. A singleton table
+// holds at most one object, such as a cluster-wide config blob, which is
+// read with Txn.GetSingleton and written with Txn.SetSingleton without any
+// key. The table is stored inline.
+//
+// SingletonTableSchema 返回单例表的模式，单例表最多只有一行，无需主键即可读写。
+func SingletonTableSchema(name string) *TableSchema {
+	return &TableSchema{
+		Name: name,
+		Indexes: map[string]*IndexSchema{
+			"id": &IndexSchema{
+				Name:    "id",
+				Unique:  true,
+				Indexer: &singletonIndex{},
+			},
+		},
+		Inline:    true,
+		Singleton: true,
+	}
+}
+
+// validateSingleton checks that a table marked as a singleton was built by
+// SingletonTableSchema.
+func (s *TableSchema) validateSingleton() error {
+	idSchema, ok := s.Indexes[id]
+	if !ok || len(s.Indexes) != 1 {
+		return fmt.Errorf("singleton table must only have the id index")
+	}
+	if _, ok := idSchema.Indexer.(*singletonIndex); !ok {
+		return fmt.Errorf("singleton table must be created with SingletonTableSchema")
+	}
+	return nil
+}
+
+// GetSingleton returns the object of a singleton table, or nil if it was
+// never set.
+func (txn *Txn) GetSingleton(table string) (interface{}, error) {
+	_, obj, err := txn.GetSingletonWatch(table)
+	return obj, err
+}
+
+// GetSingletonWatch is like GetSingleton, but also returns a channel that
+// is closed when the object changes.
+func (txn *Txn) GetSingletonWatch(table string) (<-chan struct{}, interface{}, error) {
+	if err := txn.checkSingleton(table); err != nil {
+		return nil, nil, err
+	}
+	return txn.FirstWatch(table, id)
+}
+
+// SetSingleton replaces the object of a singleton table. Setting it to nil
+// deletes the object.
+func (txn *Txn) SetSingleton(table string, obj interface{}) error {
+	if err := txn.checkSingleton(table); err != nil {
+		return err
+	}
+	if obj != nil {
+		return txn.Insert(table, obj)
+	}
+
+	existing, err := txn.First(table, id)
+	if err != nil || existing == nil {
+		return err
+	}
+	return txn.Delete(table, existing)
+}
+
+// checkSingleton returns an error unless the table is a singleton table.
+func (txn *Txn) checkSingleton(table string) error {
+	tableSchema, ok := txn.schema.Tables[table]
+	if !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+	if !tableSchema.Singleton {
+		return fmt.Errorf("table '%s' is not a singleton table", table)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Region   string
+	Replicas int
+}
+
+func TestTxn_Singleton(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["config"] = SingletonTableSchema("config")
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(false)
+	watch, obj, err := txn.GetSingletonWatch("config")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if obj != nil {
+		t.Fatalf("bad: %#v", obj)
+	}
+
+	wtxn := db.Txn(true)
+	if err := wtxn.SetSingleton("config", &testConfig{Region: "eu", Replicas: 3}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := wtxn.SetSingleton("config", &testConfig{Region: "us", Replicas: 5}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	wtxn.Commit()
+
+	select {
+	case <-watch:
+	case <-time.After(time.Second):
+		t.Fatalf("should fire")
+	}
+
+	obj, err = db.Txn(false).GetSingleton("config")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if cfg := obj.(*testConfig); cfg.Region != "us" || cfg.Replicas != 5 {
+		t.Fatalf("bad: %#v", cfg)
+	}
+
+	// Setting nil deletes the object
+	wtxn = db.Txn(true)
+	if err := wtxn.SetSingleton("config", nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	wtxn.Commit()
+	if obj, err := db.Txn(false).GetSingleton("config"); err != nil || obj != nil {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+
+	if _, err := db.Txn(false).GetSingleton("main"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestTableSchema_Validate_Singleton(t *testing.T) {
+	table := SingletonTableSchema("config")
+	if err := table.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	table.Indexes["other"] = &IndexSchema{
+		Name:    "other",
+		Indexer: &StringFieldIndex{Field: "Region"},
+	}
+	if err := table.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+
+	table = testValidSchema().Tables["main"]
+	table.Singleton = true
+	if err := table.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
@@ -0,0 +1,843 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"sort"
+	"sync/atomic"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+const (
+	// snapshotFormat is the current layout version of snapshot files. It is
+	// bumped whenever the layout changes; readers for older layouts are kept
+	// around so older files can still be restored.
+	//
+	// Format 1 is a plain sequence of records. Format 2 groups the records
+	// into checksummed chunks followed by a trailer. Format 3 adds
+	// incremental snapshots, which may contain delete records. Format 4
+	// adds the values of the sequences of the tables.
+	snapshotFormat = 4
+
+	// Record types found in the body of a snapshot.
+	snapshotRecordTable  = 'T'
+	snapshotRecordRow    = 'R'
+	snapshotRecordDelete = 'D'
+	snapshotRecordEnd    = 'E'
+
+	// snapshotRecordSequence holds the value of the sequence of a table. It
+	// is also used by the write-ahead log.
+	snapshotRecordSequence = 'S'
+
+	// Framing used by format 2 snapshots.
+	snapshotChunk   = 'C'
+	snapshotTrailer = 'Z'
+)
+
+// maxBytesLength bounds the lengths read by readBytes. The lengths come
+// from files and connections, so a corrupt one must not make the reader
+// allocate any amount of memory.
+const maxBytesLength = 1 << 30
+
+// snapshotCRC is the table used to checksum snapshot chunks.
+var snapshotCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// snapshotMagic identifies a MemDB snapshot file.
+var snapshotMagic = []byte("memdbsnp")
+
+// Migration upgrades the encoded form of a row from a snapshot written at
+// one data version to the next. It may also rename the table the row
+// belongs to.
+type Migration func(table string, data []byte) (string, []byte, error)
+
+// WithCodec sets the Codec used to serialize objects when saving snapshots.
+func WithCodec(codec Codec) Option {
+	return func(db *MemDB) {
+		db.codec = codec
+	}
+}
+
+// WithDataVersion sets the application defined version of the data that is
+// recorded in snapshots. When restoring a snapshot written with an older
+// data version, the migrations registered with WithMigration are applied to
+// bring every row up to this version.
+func WithDataVersion(version uint64) Option {
+	return func(db *MemDB) {
+		db.dataVersion = version
+	}
+}
+
+// WithMigration registers the migration that upgrades rows from the given
+// data version to the next one.
+func WithMigration(from uint64, fn Migration) Option {
+	return func(db *MemDB) {
+		if db.migrations == nil {
+			db.migrations = make(map[uint64]Migration)
+		}
+		db.migrations[from] = fn
+	}
+}
+
+// snapshotHeader is written at the start of every snapshot, right after the
+// magic bytes. It is length-prefixed so that newer formats can append fields
+// that older readers will skip.
+type snapshotHeader struct {
+	// Format is the layout version of the snapshot.
+	Format uint64
+
+	// DataVersion is the application defined version of the data.
+	DataVersion uint64
+
+	// Incremental is set for snapshots that only hold the changes made
+	// since the snapshot taken at BaseIndex.
+	Incremental bool
+
+	// Index is the commit index of the DB when the snapshot was taken, and
+	// BaseIndex the one of the base of an incremental snapshot.
+	Index     uint64
+	BaseIndex uint64
+}
+
+// SaveSnapshot writes a point-in-time snapshot of every table to w, using
+// the Codec configured with WithCodec. The snapshot can be loaded with
+// RestoreSnapshot.
+//
+// SaveSnapshot 将数据库的时间点快照写入 w 。
+func (db *MemDB) SaveSnapshot(w io.Writer) error {
+	return db.writeSnapshot(w, snapshotHeader{}, nil, writeSnapshotTable)
+}
+
+// SaveTableSnapshot writes a point-in-time snapshot of only the given tables
+// to w. This allows large, rarely changing tables to be kept on disk and
+// restored with RestoreTables, while the other tables are rebuilt from their
+// source of truth.
+//
+// SaveTableSnapshot 仅将给定表的时间点快照写入 w 。
+func (db *MemDB) SaveTableSnapshot(w io.Writer, tables ...string) error {
+	if len(tables) == 0 {
+		return fmt.Errorf("no tables given")
+	}
+	for _, table := range tables {
+		if _, ok := db.getSchema().Tables[table]; !ok {
+			return fmt.Errorf("invalid table '%s'", table)
+		}
+	}
+	return db.writeSnapshot(w, snapshotHeader{}, func(*Txn) []string { return tables }, writeSnapshotTable)
+}
+
+// writeSnapshotTable writes every row of the table.
+func writeSnapshotTable(txn *Txn, table string, chunks *snapshotChunkWriter) error {
+	iter := txn.readableIndex(table, id).Root().Iterator()
+	for _, raw, ok := iter.Next(); ok; _, raw, ok = iter.Next() {
+		if err := chunks.writeObject(txn, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveIncrementalSnapshot writes only the rows that were inserted, updated
+// or deleted since base, which must be a Snapshot of this DB taken earlier,
+// typically when the previous full or incremental snapshot was saved. The
+// tables are compared by walking their primary indexes side by side, so no
+// objects are encoded except for the changed ones.
+//
+// Incremental snapshots are loaded with ApplyIncrementalSnapshot on top of
+// a DB restored from the full snapshot they are based on, followed by any
+// earlier increments in the chain.
+//
+// The schema may have changed since base: tables added since then are
+// written in full, and the rows of tables dropped since then are written as
+// deletions, which ApplyIncrementalSnapshot skips if the DB dropped the
+// table too.
+//
+// SaveIncrementalSnapshot 仅写入自 base 以来发生变化的行。
+func (db *MemDB) SaveIncrementalSnapshot(w io.Writer, base *MemDB) error {
+	baseView := base.ReadView()
+	header := snapshotHeader{
+		Incremental: true,
+		BaseIndex:   baseView.Index(),
+	}
+	baseTxn := baseView.Txn
+	tables := func(txn *Txn) []string {
+		var tables []string
+		for table := range txn.schema.Tables {
+			tables = append(tables, table)
+		}
+		for table := range baseTxn.schema.Tables {
+			if _, ok := txn.schema.Tables[table]; !ok {
+				tables = append(tables, table)
+			}
+		}
+		return tables
+	}
+	return db.writeSnapshot(w, header, tables, func(txn *Txn, table string, chunks *snapshotChunkWriter) error {
+		oldIter := baseTxn.primaryRoot(table).Iterator()
+		newIter := txn.primaryRoot(table).Iterator()
+
+		oldKey, oldObj, oldOk := oldIter.Next()
+		newKey, newObj, newOk := newIter.Next()
+		for oldOk || newOk {
+			cmp := 0
+			switch {
+			case !oldOk:
+				cmp = 1
+			case !newOk:
+				cmp = -1
+			default:
+				cmp = bytes.Compare(oldKey, newKey)
+			}
+
+			switch {
+			case cmp < 0:
+				// Only in the base, so it was deleted
+				if err := chunks.writeDelete(oldKey); err != nil {
+					return err
+				}
+				oldKey, oldObj, oldOk = oldIter.Next()
+
+			case cmp > 0:
+				// Only in the DB, so it was inserted
+				if err := chunks.writeObject(txn, newObj); err != nil {
+					return err
+				}
+				newKey, newObj, newOk = newIter.Next()
+
+			default:
+				// Objects are never modified in place, so an update
+				// always stores a different object.
+				if !sameObject(oldObj, newObj) {
+					if err := chunks.writeObject(txn, newObj); err != nil {
+						return err
+					}
+				}
+				oldKey, oldObj, oldOk = oldIter.Next()
+				newKey, newObj, newOk = newIter.Next()
+			}
+		}
+		return nil
+	})
+}
+
+// writeSnapshot writes the header and then calls fn for the tables returned
+// by tables in turn to write their records. All the tables of the schema are
+// written if tables is nil. The records are read from a ReadView, whose
+// commit index is the Index of the header.
+func (db *MemDB) writeSnapshot(w io.Writer, header snapshotHeader, tables func(*Txn) []string,
+	fn func(*Txn, string, *snapshotChunkWriter) error) error {
+	if db.codec == nil {
+		return fmt.Errorf("a codec is required to save snapshots")
+	}
+
+	view := db.ReadView()
+	txn := view.Txn
+
+	buf := bufio.NewWriterSize(w, streamChunkSize)
+	header.Format = snapshotFormat
+	header.DataVersion = db.dataVersion
+	header.Index = view.Index()
+	if err := writeSnapshotHeader(buf, header); err != nil {
+		return err
+	}
+
+	var names []string
+	if tables != nil {
+		names = append(names, tables(txn)...)
+	} else {
+		for table := range txn.schema.Tables {
+			names = append(names, table)
+		}
+	}
+	sort.Strings(names)
+
+	chunks := &snapshotChunkWriter{w: buf, codec: db.codec}
+	for _, table := range names {
+		if err := db.injectFault(FaultSnapshot, table); err != nil {
+			return err
+		}
+		if err := chunks.writeTable(table); err != nil {
+			return err
+		}
+		if err := fn(txn, table, chunks); err != nil {
+			return err
+		}
+	}
+
+	// The sequences are kept in a system table, so they are written after
+	// the rows of every table
+	for _, seq := range txn.sequenceValues(names) {
+		if err := chunks.writeSequence(seq); err != nil {
+			return err
+		}
+	}
+
+	if err := chunks.close(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// primaryRoot returns the root of the primary index of a table, which is
+// empty if the table is not in the schema of the transaction.
+func (txn *Txn) primaryRoot(table string) *iradix.Node {
+	if _, ok := txn.schema.Tables[table]; !ok {
+		return iradix.New().Root()
+	}
+	return txn.readableIndex(table, id).Root()
+}
+
+// sameObject returns true if both values are the same stored object.
+func sameObject(a, b interface{}) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb || !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// RestoreSnapshot creates a new MemDB with the given schema and options and
+// loads the snapshot from r into it. A Codec must be given with WithCodec.
+// Snapshots written by older releases, or with an older data version, are
+// upgraded while they are read.
+//
+// RestoreSnapshot 使用给定的模式创建新的 MemDB 并从 r 中加载快照。
+func RestoreSnapshot(r io.Reader, schema *DBSchema, opts ...Option) (*MemDB, error) {
+	db, err := NewMemDB(schema, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.loadSnapshot(r, false, nil); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// ApplyIncrementalSnapshot loads an incremental snapshot written by
+// SaveIncrementalSnapshot. The DB must be in the state of the snapshot the
+// increment is based on, which is checked using the commit indexes recorded
+// in the snapshots. All changes are applied in a single write transaction.
+func (db *MemDB) ApplyIncrementalSnapshot(r io.Reader) error {
+	return db.loadSnapshot(r, true, nil)
+}
+
+// RestoreTables replaces the contents of the given tables with their rows
+// from a full snapshot, such as one written by SaveTableSnapshot. Rows of
+// other tables in the snapshot are skipped, and the other tables of the DB
+// are left untouched. The tables are replaced in a single write transaction,
+// so readers never see them partially restored.
+//
+// RestoreTables 使用快照中的数据替换给定表的内容，其他表保持不变。
+func (db *MemDB) RestoreTables(r io.Reader, tables ...string) error {
+	if len(tables) == 0 {
+		return fmt.Errorf("no tables given")
+	}
+	return db.loadSnapshot(r, false, tables)
+}
+
+// loadSnapshot reads a full or incremental snapshot into the DB. If tables
+// is not nil, only those tables are emptied and then loaded.
+func (db *MemDB) loadSnapshot(r io.Reader, incremental bool, tables []string) error {
+	err := db.readSnapshot(r, incremental, tables)
+	if err != nil {
+		db.logger.Error("failed to restore snapshot",
+			"incremental", incremental, "tables", tables, "error", err)
+	}
+	return err
+}
+
+// readSnapshot does the work of loadSnapshot.
+func (db *MemDB) readSnapshot(r io.Reader, incremental bool, tables []string) error {
+	if db.codec == nil {
+		return fmt.Errorf("a codec is required to restore snapshots")
+	}
+	if err := db.injectFault(FaultSnapshot, ""); err != nil {
+		return err
+	}
+
+	buf := bufio.NewReaderSize(r, streamChunkSize)
+	header, err := readSnapshotHeader(buf)
+	if err != nil {
+		return err
+	}
+	reader, ok := snapshotReaders[header.Format]
+	if !ok {
+		return fmt.Errorf("unsupported snapshot format %d", header.Format)
+	}
+	if header.DataVersion > db.dataVersion {
+		return fmt.Errorf("snapshot data version %d is newer than %d", header.DataVersion, db.dataVersion)
+	}
+	if header.Incremental != incremental {
+		if incremental {
+			return fmt.Errorf("snapshot is not incremental")
+		}
+		return fmt.Errorf("snapshot is incremental, restore its full snapshot first")
+	}
+
+	// Tables are written in name order, so children may come before their
+	// parents: the references are checked once all the rows are restored.
+	txn := db.Txn(true)
+	txn.deferReferences = true
+	defer txn.Abort()
+
+	if current := atomic.LoadUint64(&db.commitIndex); incremental && header.BaseIndex != current {
+		return fmt.Errorf("incremental snapshot is based on commit %d, but the DB is at commit %d",
+			header.BaseIndex, current)
+	}
+
+	var restore map[string]struct{}
+	if tables != nil {
+		restore = make(map[string]struct{}, len(tables))
+		for _, table := range tables {
+			if _, err := txn.DeleteAll(table, id); err != nil {
+				return err
+			}
+			restore[table] = struct{}{}
+		}
+	}
+
+	err = reader(buf, func(kind byte, table string, data []byte) error {
+		switch kind {
+		case snapshotRecordSequence:
+			if _, ok := restore[table]; restore != nil && !ok {
+				return nil
+			}
+			if _, ok := txn.schema.Tables[table]; !ok {
+				return fmt.Errorf("invalid table '%s'", table)
+			}
+			value, err := decodeSequence(data)
+			if err != nil {
+				return err
+			}
+			txn.setSequence(table, value)
+			return nil
+
+		case snapshotRecordDelete:
+			// The rows of a table dropped since the base are deleted
+			// one by one, and there is nothing to delete if the DB
+			// dropped it as well
+			if _, ok := txn.tableSchema(table); !ok && incremental {
+				return nil
+			}
+			return txn.deleteByKey(table, data)
+
+		default:
+			table, data, err := db.migrate(header.DataVersion, table, data)
+			if err != nil {
+				return err
+			}
+			if _, ok := restore[table]; restore != nil && !ok {
+				return nil
+			}
+			obj, err := db.codec.Decode(table, data)
+			if err != nil {
+				return fmt.Errorf("failed to decode object: %v", err)
+			}
+			return txn.Insert(table, obj)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Continue numbering commits from the snapshot, so that the commit
+	// made here carries the index of the snapshot. This doesn't apply when
+	// only some tables are restored, as the others are not from the snapshot.
+	if header.Index > 0 && tables == nil {
+		atomic.StoreUint64(&db.commitIndex, header.Index-1)
+	}
+	if err := txn.TryCommit(); err != nil {
+		return err
+	}
+	db.logger.Info("restored snapshot",
+		"incremental", incremental, "tables", tables, "index", header.Index)
+	return nil
+}
+
+// deleteByKey deletes the object with the given raw primary key, if any.
+func (txn *Txn) deleteByKey(table string, key []byte) error {
+	if _, ok := txn.tableSchema(table); !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+	existing, ok := txn.writableIndex(table, id).Get(key)
+	if !ok {
+		return nil
+	}
+	existing, err := txn.resolve(table, existing)
+	if err != nil {
+		return err
+	}
+	return txn.Delete(table, existing)
+}
+
+// migrate applies the registered migrations to bring a row from the given
+// data version up to the DB's data version.
+func (db *MemDB) migrate(version uint64, table string, data []byte) (string, []byte, error) {
+	for ; version < db.dataVersion; version++ {
+		fn, ok := db.migrations[version]
+		if !ok {
+			return "", nil, fmt.Errorf("missing migration from data version %d", version)
+		}
+
+		var err error
+		table, data, err = fn(table, data)
+		if err != nil {
+			return "", nil, fmt.Errorf("migration from data version %d failed: %v", version, err)
+		}
+	}
+	return table, data, nil
+}
+
+// snapshotRecordFunc is called with each row, delete or sequence record read
+// from a snapshot. For rows data is the encoded object, for deletes it is
+// the primary key, and for sequences the value written by encodeSequence.
+type snapshotRecordFunc func(kind byte, table string, data []byte) error
+
+// snapshotReader reads the body of a snapshot and calls fn with each record.
+type snapshotReader func(r *bufio.Reader, fn snapshotRecordFunc) error
+
+// snapshotReaders holds a reader for every supported snapshot format.
+var snapshotReaders = map[uint64]snapshotReader{
+	1: readSnapshotV1,
+	2: readSnapshotV2,
+	3: readSnapshotV2,
+	4: readSnapshotV2,
+}
+
+// readSnapshotV1 reads a snapshot body made of table records, each followed
+// by the rows of the table, and terminated by an end record.
+func readSnapshotV1(r *bufio.Reader, fn snapshotRecordFunc) error {
+	var table string
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot record: %v", err)
+		}
+		if kind == snapshotRecordEnd {
+			return nil
+		}
+		if _, err := readSnapshotRecord(r, kind, &table, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// readSnapshotV2 reads a snapshot body made of chunks of records, each
+// protected by a CRC, and terminated by a trailer holding the number of
+// chunks and rows. Every chunk is verified before any of its records are
+// decoded, and a missing trailer means the snapshot was truncated.
+func readSnapshotV2(r *bufio.Reader, fn snapshotRecordFunc) error {
+	var table string
+	var chunks, rows uint64
+	for {
+		kind, err := r.ReadByte()
+		if err == io.EOF {
+			return fmt.Errorf("snapshot is truncated after %d chunks", chunks)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot chunk: %v", err)
+		}
+
+		switch kind {
+		case snapshotChunk:
+			data, err := readBytes(r)
+			if err != nil {
+				return fmt.Errorf("snapshot chunk %d is truncated or corrupt: %v", chunks, err)
+			}
+			var sum [4]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				return fmt.Errorf("snapshot chunk %d is truncated: %v", chunks, err)
+			}
+			if crc32.Checksum(data, snapshotCRC) != binary.BigEndian.Uint32(sum[:]) {
+				return fmt.Errorf("snapshot chunk %d is corrupt: checksum mismatch", chunks)
+			}
+
+			records := bufio.NewReader(bytes.NewReader(data))
+			for {
+				kind, err := records.ReadByte()
+				if err == io.EOF {
+					break
+				}
+				n, err := readSnapshotRecord(records, kind, &table, fn)
+				if err != nil {
+					return err
+				}
+				rows += n
+			}
+			chunks++
+
+		case snapshotTrailer:
+			data, err := readBytes(r)
+			if err != nil {
+				return fmt.Errorf("snapshot trailer is truncated: %v", err)
+			}
+			trailer := bytes.NewReader(data)
+			expectChunks, err := binary.ReadUvarint(trailer)
+			if err != nil {
+				return fmt.Errorf("invalid snapshot trailer: %v", err)
+			}
+			expectRows, err := binary.ReadUvarint(trailer)
+			if err != nil {
+				return fmt.Errorf("invalid snapshot trailer: %v", err)
+			}
+			if chunks != expectChunks || rows != expectRows {
+				return fmt.Errorf("snapshot is incomplete: read %d chunks and %d rows, expected %d and %d",
+					chunks, rows, expectChunks, expectRows)
+			}
+			return nil
+
+		default:
+			return fmt.Errorf("unknown snapshot chunk type %q", kind)
+		}
+	}
+}
+
+// readSnapshotRecord reads a single record of the given kind. table tracks
+// the table that rows belong to. It returns the number of rows read, which
+// includes delete records.
+func readSnapshotRecord(r *bufio.Reader, kind byte, table *string, fn snapshotRecordFunc) (uint64, error) {
+	switch kind {
+	case snapshotRecordTable:
+		name, err := readBytes(r)
+		if err != nil {
+			return 0, err
+		}
+		*table = string(name)
+		return 0, nil
+
+	case snapshotRecordRow, snapshotRecordDelete:
+		if *table == "" {
+			return 0, fmt.Errorf("snapshot row outside of a table")
+		}
+		data, err := readBytes(r)
+		if err != nil {
+			return 0, err
+		}
+		return 1, fn(kind, *table, data)
+
+	case snapshotRecordSequence:
+		name, err := readBytes(r)
+		if err != nil {
+			return 0, err
+		}
+		data, err := readBytes(r)
+		if err != nil {
+			return 0, err
+		}
+		return 0, fn(kind, string(name), data)
+
+	default:
+		return 0, fmt.Errorf("unknown snapshot record type %q", kind)
+	}
+}
+
+// snapshotChunkWriter writes records grouped into checksummed chunks, as
+// read by readSnapshotV2.
+type snapshotChunkWriter struct {
+	w      *bufio.Writer
+	codec  Codec
+	buf    bytes.Buffer
+	table  string
+	chunks uint64
+	rows   uint64
+}
+
+// writeTable starts the rows of the given table.
+func (c *snapshotChunkWriter) writeTable(table string) error {
+	c.table = table
+	c.buf.WriteByte(snapshotRecordTable)
+	writeBytes(&c.buf, []byte(table))
+	return c.maybeFlush()
+}
+
+// writeObject encodes and adds a row of the current table. raw is the value
+// stored in the indexes.
+func (c *snapshotChunkWriter) writeObject(txn *Txn, raw interface{}) error {
+	obj, err := txn.resolve(c.table, raw)
+	if err != nil {
+		return err
+	}
+	data, err := c.codec.Encode(c.table, obj)
+	if err != nil {
+		return fmt.Errorf("failed to encode object: %v", err)
+	}
+	return c.writeRecord(snapshotRecordRow, data)
+}
+
+// writeDelete adds the deletion of the row of the current table with the
+// given primary key.
+func (c *snapshotChunkWriter) writeDelete(key []byte) error {
+	return c.writeRecord(snapshotRecordDelete, key)
+}
+
+// writeSequence adds the value of the sequence of a table. Sequences are not
+// counted as rows.
+func (c *snapshotChunkWriter) writeSequence(seq *SequenceValue) error {
+	c.buf.WriteByte(snapshotRecordSequence)
+	writeBytes(&c.buf, []byte(seq.Table))
+	writeBytes(&c.buf, encodeSequence(seq.Value))
+	return c.maybeFlush()
+}
+
+// writeRecord adds a row or delete record.
+func (c *snapshotChunkWriter) writeRecord(kind byte, data []byte) error {
+	c.buf.WriteByte(kind)
+	writeBytes(&c.buf, data)
+	c.rows++
+	return c.maybeFlush()
+}
+
+// maybeFlush writes out the pending chunk once it is large enough.
+func (c *snapshotChunkWriter) maybeFlush() error {
+	if c.buf.Len() < streamChunkSize {
+		return nil
+	}
+	return c.flush()
+}
+
+// flush writes out the pending records as a chunk.
+func (c *snapshotChunkWriter) flush() error {
+	if c.buf.Len() == 0 {
+		return nil
+	}
+
+	data := c.buf.Bytes()
+	if err := c.w.WriteByte(snapshotChunk); err != nil {
+		return err
+	}
+	if err := writeBytes(c.w, data); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(data, snapshotCRC))
+	if _, err := c.w.Write(sum[:]); err != nil {
+		return err
+	}
+
+	c.buf.Reset()
+	c.chunks++
+	return nil
+}
+
+// close flushes the last chunk and writes the trailer.
+func (c *snapshotChunkWriter) close() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+
+	var trailer bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	for _, field := range []uint64{c.chunks, c.rows} {
+		n := binary.PutUvarint(tmp[:], field)
+		trailer.Write(tmp[:n])
+	}
+	if err := c.w.WriteByte(snapshotTrailer); err != nil {
+		return err
+	}
+	return writeBytes(c.w, trailer.Bytes())
+}
+
+// writeSnapshotHeader writes the magic bytes and the header.
+func writeSnapshotHeader(w *bufio.Writer, header snapshotHeader) error {
+	if _, err := w.Write(snapshotMagic); err != nil {
+		return err
+	}
+
+	var incremental uint64
+	if header.Incremental {
+		incremental = 1
+	}
+
+	var fields bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	for _, field := range []uint64{header.Format, header.DataVersion, incremental, header.Index, header.BaseIndex} {
+		n := binary.PutUvarint(tmp[:], field)
+		fields.Write(tmp[:n])
+	}
+	return writeBytes(w, fields.Bytes())
+}
+
+// readSnapshotHeader checks the magic bytes and reads the header. Any fields
+// added to the header after the ones known here are skipped, and fields
+// missing from headers written by older releases are left zero.
+func readSnapshotHeader(r *bufio.Reader) (snapshotHeader, error) {
+	var header snapshotHeader
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return header, fmt.Errorf("failed to read snapshot header: %v", err)
+	}
+	if !bytes.Equal(magic, snapshotMagic) {
+		return header, fmt.Errorf("not a snapshot")
+	}
+
+	data, err := readBytes(r)
+	if err != nil {
+		return header, fmt.Errorf("failed to read snapshot header: %v", err)
+	}
+	fields := bytes.NewReader(data)
+	if header.Format, err = binary.ReadUvarint(fields); err != nil {
+		return header, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if header.DataVersion, err = binary.ReadUvarint(fields); err != nil {
+		return header, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+
+	var incremental uint64
+	for _, field := range []*uint64{&incremental, &header.Index, &header.BaseIndex} {
+		if fields.Len() == 0 {
+			break
+		}
+		if *field, err = binary.ReadUvarint(fields); err != nil {
+			return header, fmt.Errorf("invalid snapshot header: %v", err)
+		}
+	}
+	header.Incremental = incremental != 0
+	return header, nil
+}
+
+// writeBytes writes a uvarint length followed by the data.
+func writeBytes(w io.Writer, data []byte) error {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(len(data)))
+	if _, err := w.Write(tmp[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readBytes reads data written by writeBytes. Lengths over maxBytesLength
+// are reported as corrupt, and large data is read as it arrives rather than
+// allocated up front, so a length beyond the end of the input fails without
+// allocating it.
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read length: %v", err)
+	}
+	if size > maxBytesLength {
+		return nil, fmt.Errorf("corrupt length %d", size)
+	}
+	if size <= streamChunkSize {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read data: %v", err)
+		}
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to read data: %v", err)
+	}
+	return buf.Bytes(), nil
+}
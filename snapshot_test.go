@@ -0,0 +1,636 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testCodec() Codec {
+	return &JSONCodec{
+		Types: map[string]reflect.Type{"main": reflect.TypeOf(&TestObject{})},
+	}
+}
+
+func testSnapshotDB(t *testing.T, opts ...Option) (*MemDB, []*TestObject) {
+	db, err := NewMemDB(testValidSchema(), opts...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	objs := []*TestObject{
+		&TestObject{ID: "a", Foo: "foo", Qux: []string{"a"}},
+		&TestObject{ID: "b", Foo: "bar", Qux: []string{"b"}},
+	}
+	txn := db.Txn(true)
+	for _, obj := range objs {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	return db, objs
+}
+
+func TestMemDB_SaveRestoreSnapshot(t *testing.T) {
+	db, objs := testSnapshotDB(t, WithCodec(testCodec()))
+
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	db2, err := RestoreSnapshot(&buf, testValidSchema(), WithCodec(testCodec()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db2.Txn(false)
+	for _, obj := range objs {
+		raw, err := txn.First("main", "foo", obj.Foo)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !reflect.DeepEqual(raw, obj) {
+			t.Fatalf("bad: %#v %#v", raw, obj)
+		}
+	}
+
+	// A codec is required
+	if err := testDB(t).SaveSnapshot(&buf); err == nil {
+		t.Fatalf("expected error without codec")
+	}
+}
+
+func TestMemDB_RestoreSnapshot_Migration(t *testing.T) {
+	db, _ := testSnapshotDB(t, WithCodec(testCodec()))
+
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	snapshot := buf.Bytes()
+
+	// Rows are upgraded from version 0 to version 2
+	upper := func(table string, data []byte) (string, []byte, error) {
+		var obj TestObject
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return "", nil, err
+		}
+		obj.Foo = strings.ToUpper(obj.Foo)
+		data, err := json.Marshal(&obj)
+		return table, data, err
+	}
+	suffix := func(table string, data []byte) (string, []byte, error) {
+		var obj TestObject
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return "", nil, err
+		}
+		obj.Foo += "-v2"
+		data, err := json.Marshal(&obj)
+		return table, data, err
+	}
+	db2, err := RestoreSnapshot(bytes.NewReader(snapshot), testValidSchema(),
+		WithCodec(testCodec()),
+		WithDataVersion(2),
+		WithMigration(0, upper),
+		WithMigration(1, suffix))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw, err := db2.Txn(false).First("main", "id", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if foo := raw.(*TestObject).Foo; foo != "FOO-v2" {
+		t.Fatalf("bad: %s", foo)
+	}
+
+	// A missing step in the chain is an error
+	_, err = RestoreSnapshot(bytes.NewReader(snapshot), testValidSchema(),
+		WithCodec(testCodec()),
+		WithDataVersion(2),
+		WithMigration(0, upper))
+	if err == nil || !strings.Contains(err.Error(), "missing migration") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Snapshots from a newer data version can't be restored
+	buf.Reset()
+	db3, _ := testSnapshotDB(t, WithCodec(testCodec()), WithDataVersion(3))
+	if err := db3.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = RestoreSnapshot(&buf, testValidSchema(), WithCodec(testCodec()), WithDataVersion(2))
+	if err == nil {
+		t.Fatalf("expected error for newer data version")
+	}
+}
+
+func TestMemDB_RestoreSnapshot_Header(t *testing.T) {
+	_, err := RestoreSnapshot(strings.NewReader("garbage!garbage!"), testValidSchema(), WithCodec(testCodec()))
+	if err == nil || !strings.Contains(err.Error(), "not a snapshot") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Unknown formats are rejected
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := writeSnapshotHeader(w, snapshotHeader{Format: 99}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	w.Flush()
+	_, err = RestoreSnapshot(&buf, testValidSchema(), WithCodec(testCodec()))
+	if err == nil || !strings.Contains(err.Error(), "unsupported snapshot format") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Extra header fields from newer releases are skipped, and the older
+	// unchunked format can still be read
+	buf.Reset()
+	buf.Write(snapshotMagic)
+	writeBytes(&buf, []byte{1, 0, 0, 0, 0, 42})
+	buf.WriteByte(snapshotRecordEnd)
+	if _, err := RestoreSnapshot(&buf, testValidSchema(), WithCodec(testCodec())); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestMemDB_RestoreSnapshot_Corrupt(t *testing.T) {
+	db, err := NewMemDB(testValidSchema(), WithCodec(testCodec()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Write enough rows to span several chunks
+	txn := db.Txn(true)
+	for i := 0; i < 2000; i++ {
+		obj := &TestObject{
+			ID:  fmt.Sprintf("obj-%04d", i),
+			Foo: strings.Repeat("x", 50),
+			Qux: []string{"a"},
+		}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	snapshot := buf.Bytes()
+
+	db2, err := RestoreSnapshot(bytes.NewReader(snapshot), testValidSchema(), WithCodec(testCodec()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := db2.Txn(false).indexLen("main", "id"); n != 2000 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Flip a byte in the middle of the data
+	corrupt := append([]byte(nil), snapshot...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	_, err = RestoreSnapshot(bytes.NewReader(corrupt), testValidSchema(), WithCodec(testCodec()))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Drop the trailer
+	truncated := snapshot[:len(snapshot)-4]
+	_, err = RestoreSnapshot(bytes.NewReader(truncated), testValidSchema(), WithCodec(testCodec()))
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Cut off in the middle of a chunk
+	truncated = snapshot[:len(snapshot)/2]
+	_, err = RestoreSnapshot(bytes.NewReader(truncated), testValidSchema(), WithCodec(testCodec()))
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestMemDB_IncrementalSnapshot(t *testing.T) {
+	db, objs := testSnapshotDB(t, WithCodec(testCodec()))
+
+	var full bytes.Buffer
+	if err := db.SaveSnapshot(&full); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	base := db.Snapshot()
+
+	// Update one object, delete the other and insert a new one
+	updated := &TestObject{ID: "a", Foo: "baz", Qux: []string{"a"}}
+	inserted := &TestObject{ID: "c", Foo: "zip", Qux: []string{"c"}}
+	txn := db.Txn(true)
+	if err := txn.Insert("main", updated); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Delete("main", objs[1]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", inserted); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	var incr bytes.Buffer
+	if err := db.SaveIncrementalSnapshot(&incr, base); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	base = db.Snapshot()
+
+	// A second increment that changes nothing but the commit index
+	db.Txn(true).Commit()
+	var incr2 bytes.Buffer
+	if err := db.SaveIncrementalSnapshot(&incr2, base); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Increments can't be restored on their own
+	if _, err := RestoreSnapshot(bytes.NewReader(incr.Bytes()), testValidSchema(), WithCodec(testCodec())); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	db2, err := RestoreSnapshot(&full, testValidSchema(), WithCodec(testCodec()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Increments must be applied in order
+	err = db2.ApplyIncrementalSnapshot(bytes.NewReader(incr2.Bytes()))
+	if err == nil || !strings.Contains(err.Error(), "based on commit") {
+		t.Fatalf("bad: %v", err)
+	}
+	if err := db2.ApplyIncrementalSnapshot(&incr); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := db2.ApplyIncrementalSnapshot(&incr2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn = db2.Txn(false)
+	iter, err := txn.Get("main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var out []interface{}
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		out = append(out, raw)
+	}
+	expect := []interface{}{updated, inserted}
+	if !reflect.DeepEqual(out, expect) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	raw, err := txn.Last(CommitsTable, "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw.(*CommitInfo).Index != db.Snapshot().commitIndex {
+		t.Fatalf("bad: %#v", raw)
+	}
+}
+
+func TestMemDB_IncrementalSnapshot_SchemaChange(t *testing.T) {
+	schema := func(tables ...string) *DBSchema {
+		s := testValidSchema()
+		for _, table := range tables {
+			s.Tables[table] = testValidSchema().Tables["main"]
+			s.Tables[table].Name = table
+		}
+		return s
+	}
+	codec := &JSONCodec{
+		Types: map[string]reflect.Type{
+			"main":    reflect.TypeOf(&TestObject{}),
+			"dropped": reflect.TypeOf(&TestObject{}),
+			"added":   reflect.TypeOf(&TestObject{}),
+		},
+	}
+	db, err := NewMemDB(schema("dropped"), WithCodec(codec))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	obj := &TestObject{ID: "a", Foo: "foo", Qux: []string{"a"}}
+	txn := db.Txn(true)
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("dropped", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	var full bytes.Buffer
+	if err := db.SaveSnapshot(&full); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	base := db.Snapshot()
+
+	// One table is added and the other dropped after the base
+	if err := db.ApplySchemaChange(schema("added")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn = db.Txn(true)
+	if err := txn.Insert("added", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	var incr bytes.Buffer
+	if err := db.SaveIncrementalSnapshot(&incr, base); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The increment applies to a DB holding both tables
+	db2, err := RestoreSnapshot(&full, schema("dropped", "added"), WithCodec(codec))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := db2.ApplyIncrementalSnapshot(&incr); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn = db2.Txn(false)
+	for table, expect := range map[string]interface{}{"main": obj, "dropped": nil, "added": obj} {
+		raw, err := txn.First(table, "id", "a")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if expect == nil && raw != nil || expect != nil && !reflect.DeepEqual(raw, expect) {
+			t.Fatalf("bad: %s %#v", table, raw)
+		}
+	}
+}
+
+func TestMemDB_SnapshotSequences(t *testing.T) {
+	db, _ := testSnapshotDB(t, WithCodec(testCodec()))
+	txn := db.Txn(true)
+	for i := 0; i < 3; i++ {
+		if _, err := txn.NextSequence("main"); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	db2, err := RestoreSnapshot(&buf, testValidSchema(), WithCodec(testCodec()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The sequence carries on from the snapshot
+	txn = db2.Txn(true)
+	defer txn.Abort()
+	if n, err := txn.NextSequence("main"); err != nil || n != 4 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+}
+
+func TestMemDB_RestoreTables(t *testing.T) {
+	schema := func() *DBSchema {
+		schema := testValidSchema()
+		other := testValidSchema().Tables["main"]
+		other.Name = "other"
+		schema.Tables["other"] = other
+		return schema
+	}
+	codec := &JSONCodec{
+		Types: map[string]reflect.Type{
+			"main":  reflect.TypeOf(&TestObject{}),
+			"other": reflect.TypeOf(&TestObject{}),
+		},
+	}
+	fill := func(db *MemDB, table string, ids ...string) {
+		txn := db.Txn(true)
+		for _, id := range ids {
+			obj := &TestObject{ID: id, Foo: table, Qux: []string{id}}
+			if err := txn.Insert(table, obj); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		txn.Commit()
+	}
+	ids := func(db *MemDB, table string) []string {
+		txn := db.Txn(false)
+		iter, err := txn.Get(table, "id")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var out []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			out = append(out, raw.(*TestObject).ID)
+		}
+		return out
+	}
+
+	db, err := NewMemDB(schema(), WithCodec(codec))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fill(db, "main", "a", "b")
+	fill(db, "other", "x")
+
+	var buf bytes.Buffer
+	if err := db.SaveTableSnapshot(&buf, "nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := db.SaveTableSnapshot(&buf, "main"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	snap := buf.Bytes()
+
+	// Restoring replaces the table but leaves the others alone
+	db2, err := NewMemDB(schema(), WithCodec(codec))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fill(db2, "main", "c")
+	fill(db2, "other", "y", "z")
+	if err := db2.RestoreTables(bytes.NewReader(snap), "main"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out := ids(db2, "main"); !reflect.DeepEqual(out, []string{"a", "b"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids(db2, "other"); !reflect.DeepEqual(out, []string{"y", "z"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// A table missing from the snapshot is left empty
+	if err := db2.RestoreTables(bytes.NewReader(snap), "other"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out := ids(db2, "other"); out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out := ids(db2, "main"); !reflect.DeepEqual(out, []string{"a", "b"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	if err := db2.RestoreTables(bytes.NewReader(snap)); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestMemDB_SnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memdb")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.snap")
+
+	db, objs := testSnapshotDB(t, WithCodec(testCodec()))
+	if err := db.SaveSnapshotFile(path); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A failed save keeps the previous snapshot
+	if err := testDB(t).SaveSnapshotFile(path); err == nil {
+		t.Fatalf("expected error without codec")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("bad: %#v", files)
+	}
+
+	db2, err := RestoreSnapshotFile(path, testValidSchema(), WithCodec(testCodec()))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw, err := db2.Txn(false).First("main", "id", objs[1].ID)
+	if err != nil || !reflect.DeepEqual(raw, objs[1]) {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	if _, err := RestoreSnapshotFile(filepath.Join(dir, "missing"), testValidSchema(), WithCodec(testCodec())); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestMemDB_RestoreSnapshot_ForeignKeys(t *testing.T) {
+	codec := &JSONCodec{
+		Types: map[string]reflect.Type{
+			"teams":   reflect.TypeOf(&testTeam{}),
+			"members": reflect.TypeOf(&testMember{}),
+		},
+	}
+	save := func(schema *DBSchema) *bytes.Buffer {
+		db, err := NewMemDB(schema, WithCodec(codec))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		txn := db.Txn(true)
+		if err := txn.Insert("teams", &testTeam{ID: "1", Name: "red"}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := txn.Insert("members", &testMember{ID: "a", Team: "red"}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if schema.Tables["members"].ForeignKeys == nil {
+			if err := txn.Insert("members", &testMember{ID: "b", Team: "blue"}); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		txn.Commit()
+
+		var buf bytes.Buffer
+		if err := db.SaveSnapshot(&buf); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return &buf
+	}
+
+	// The children are restored before their parents
+	db, err := RestoreSnapshot(save(testForeignKeySchema(Restrict)), testForeignKeySchema(Restrict), WithCodec(codec))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw, err := db.Txn(false).First("members", "team", "red")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw == nil || raw.(*testMember).ID != "a" {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// Broken references are still refused
+	schema := testForeignKeySchema(Restrict)
+	schema.Tables["members"].ForeignKeys = nil
+	_, err = RestoreSnapshot(save(schema), testForeignKeySchema(Restrict), WithCodec(codec))
+	if err == nil || !strings.Contains(err.Error(), "foreign key 'team' violated") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestMemDB_RestoreSnapshot_BadLength(t *testing.T) {
+	header := func() *bytes.Buffer {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		if err := writeSnapshotHeader(w, snapshotHeader{Format: snapshotFormat}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		w.Flush()
+		return &buf
+	}
+	uvarint := func(v uint64) []byte {
+		var tmp [binary.MaxVarintLen64]byte
+		return tmp[:binary.PutUvarint(tmp[:], v)]
+	}
+
+	// A chunk holding a single record with the given length and no data
+	chunkWithRecord := func(kind byte, length uint64) []byte {
+		data := append([]byte{kind}, uvarint(length)...)
+		if kind == snapshotRecordRow {
+			data = append(append([]byte{snapshotRecordTable}, uvarint(4)...), append([]byte("main"), data...)...)
+		}
+		buf := header()
+		buf.WriteByte(snapshotChunk)
+		buf.Write(uvarint(uint64(len(data))))
+		buf.Write(data)
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc32.Checksum(data, snapshotCRC))
+		buf.Write(sum[:])
+		return buf.Bytes()
+	}
+	chunkLength := func(length uint64) []byte {
+		buf := header()
+		buf.WriteByte(snapshotChunk)
+		buf.Write(uvarint(length))
+		return buf.Bytes()
+	}
+
+	cases := []struct {
+		name   string
+		data   []byte
+		expect string
+	}{
+		{"huge chunk", chunkLength(1 << 62), "corrupt length"},
+		{"large chunk", chunkLength(maxBytesLength + 1), "corrupt length"},
+		{"chunk past the end", chunkLength(1 << 29), "unexpected EOF"},
+		{"huge table name", chunkWithRecord(snapshotRecordTable, 1<<62), "corrupt length"},
+		{"huge row", chunkWithRecord(snapshotRecordRow, 1<<62), "corrupt length"},
+		{"row past the end", chunkWithRecord(snapshotRecordRow, 1<<20), "unexpected EOF"},
+	}
+	for _, c := range cases {
+		_, err := RestoreSnapshot(bytes.NewReader(c.data), testValidSchema(), WithCodec(testCodec()))
+		if err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Fatalf("%s: bad: %v", c.name, err)
+		}
+	}
+}
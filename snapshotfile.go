@@ -0,0 +1,65 @@
+package memdb
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SaveSnapshotFile writes a snapshot of every table to the file at path, as
+// SaveSnapshot does. The snapshot is written to a temporary file in the same
+// directory which is synced and then renamed over path, so a crash while
+// saving never leaves a partial snapshot behind and the previous one stays
+// usable.
+//
+// SaveSnapshotFile 将快照原子地写入磁盘文件。
+func (db *MemDB) SaveSnapshotFile(path string) (err error) {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	w := bufio.NewWriterSize(f, streamChunkSize)
+	if err := db.SaveSnapshot(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename itself survives a crash. Not every
+	// platform supports this, so failures are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// RestoreSnapshotFile creates a new MemDB from the snapshot in the file at
+// path, as RestoreSnapshot does.
+func RestoreSnapshotFile(path string, schema *DBSchema, opts ...Option) (*MemDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return RestoreSnapshot(f, schema, opts...)
+}
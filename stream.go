@@ -0,0 +1,68 @@
+package memdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// streamChunkSize is the size of the buffer used to write out encoded rows.
+// Rows are flushed to the underlying writer whenever the buffer fills.
+const streamChunkSize = 64 * 1024
+
+// StreamTable encodes every row of the given table, in primary key order,
+// and writes it to w. Each row is written as its encoded length (as a
+// uvarint) followed by the bytes produced by the codec. Rows are written out
+// in chunks as the table is walked, so no intermediate copy of the table is
+// built regardless of its size. Use ReadTableStream to decode the output.
+//
+// StreamTable 按主键顺序将表中的所有行编码后分块写入 w ，不会构建中间切片。
+func (txn *Txn) StreamTable(table string, codec Codec, w io.Writer) error {
+	if _, ok := txn.tableSchema(table); !ok {
+		return fmt.Errorf("invalid table '%s'", table)
+	}
+
+	buf := bufio.NewWriterSize(w, streamChunkSize)
+
+	txn.trackRead(table, id, nil, nil)
+	iter := txn.readableIndex(table, id).Root().Iterator()
+	for _, raw, ok := iter.Next(); ok; _, raw, ok = iter.Next() {
+		obj, err := txn.resolve(table, raw)
+		if err != nil {
+			return err
+		}
+		data, err := codec.Encode(table, obj)
+		if err != nil {
+			return fmt.Errorf("failed to encode object: %v", err)
+		}
+		if err := writeBytes(buf, data); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// ReadTableStream decodes the rows written by StreamTable from r and calls fn
+// with each object in turn. It stops at the first error returned by fn.
+func ReadTableStream(r io.Reader, table string, codec Codec, fn func(obj interface{}) error) error {
+	buf := bufio.NewReaderSize(r, streamChunkSize)
+	for {
+		if _, err := buf.Peek(1); err == io.EOF {
+			return nil
+		}
+
+		// Each row gets a slice of its own, as codecs may keep it
+		data, err := readBytes(buf)
+		if err != nil {
+			return fmt.Errorf("failed to read row: %v", err)
+		}
+
+		obj, err := codec.Decode(table, data)
+		if err != nil {
+			return fmt.Errorf("failed to decode object: %v", err)
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package memdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTxn_StreamTable(t *testing.T) {
+	db := testDB(t)
+	codec := &JSONCodec{
+		Types: map[string]reflect.Type{"main": reflect.TypeOf(&TestObject{})},
+	}
+
+	txn := db.Txn(true)
+	var objs []interface{}
+	for _, id := range []string{"a", "b", "c"} {
+		obj := &TestObject{ID: id, Foo: "foo", Qux: []string{id}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		objs = append(objs, obj)
+	}
+	txn.Commit()
+
+	var buf bytes.Buffer
+	txn = db.Txn(false)
+	if err := txn.StreamTable("main", codec, &buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.StreamTable("nope", codec, &buf); err == nil {
+		t.Fatalf("expected error for invalid table")
+	}
+
+	var out []interface{}
+	err := ReadTableStream(&buf, "main", codec, func(obj interface{}) error {
+		out = append(out, obj)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(out, objs) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// Truncated streams are detected
+	buf.Reset()
+	if err := txn.StreamTable("main", codec, &buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-1])
+	err = ReadTableStream(truncated, "main", codec, func(obj interface{}) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected error for truncated stream")
+	}
+
+	// Corrupt lengths are refused rather than allocated
+	var tmp [binary.MaxVarintLen64]byte
+	corrupt := bytes.NewReader(tmp[:binary.PutUvarint(tmp[:], 1<<62)])
+	err = ReadTableStream(corrupt, "main", codec, func(obj interface{}) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "corrupt length") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+// testBytesCodec stores byte slices as they are, and keeps the data it
+// decodes.
+type testBytesCodec struct{}
+
+func (testBytesCodec) Encode(table string, obj interface{}) ([]byte, error) {
+	return obj.([]byte), nil
+}
+
+func (testBytesCodec) Decode(table string, data []byte) (interface{}, error) {
+	return data, nil
+}
+
+func TestReadTableStream_KeepsRows(t *testing.T) {
+	var buf bytes.Buffer
+	for _, row := range []string{"aaaa", "bbbb"} {
+		if err := writeBytes(&buf, []byte(row)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	var out []string
+	var rows [][]byte
+	err := ReadTableStream(&buf, "raw", testBytesCodec{}, func(obj interface{}) error {
+		rows = append(rows, obj.([]byte))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, row := range rows {
+		out = append(out, string(row))
+	}
+	if !reflect.DeepEqual(out, []string{"aaaa", "bbbb"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+}
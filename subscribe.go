@@ -0,0 +1,316 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultSubscribeBuffer is the number of commits buffered for a
+// Subscription when SubscribeOptions.BufferSize is zero.
+const DefaultSubscribeBuffer = 64
+
+var (
+	// ErrSlowSubscriber is returned by Subscription.Err when the
+	// subscription was ended because its buffer was full.
+	ErrSlowSubscriber = fmt.Errorf("subscriber fell behind")
+)
+
+// SubscribeOptions controls the buffering of a Subscription.
+type SubscribeOptions struct {
+	// BufferSize is the number of commits that can wait to be received.
+	BufferSize int
+
+	// Block makes commits wait for room in the buffer of the subscription,
+	// slowing writers down to the pace of the consumer. By default a
+	// subscription whose buffer is full is ended with ErrSlowSubscriber
+	// instead, so that it never holds up writes.
+	Block bool
+}
+
+// CommitChanges holds the changes a committed transaction made to the
+// tables of a Subscription.
+type CommitChanges struct {
+	// Index is the commit index of the transaction, as in CommitInfo. It
+	// grows with every delivery, but skips the commits that didn't change
+	// the subscribed tables.
+	Index uint64
+
+	// Seq numbers the deliveries of the subscription from 1, without gaps,
+	// so a consumer can check that it processed every one of them.
+	Seq uint64
+
+	Changes Changes
+}
+
+// Subscription delivers the changes of every commit made after it was
+// created, in commit order. It is created with MemDB.Subscribe.
+//
+// A subscription never skips a commit: if one can't be delivered, the
+// subscription ends instead. Reading the state of StartView and applying
+// the changes delivered in order gives the state of the subscribed tables
+// after each commit, which lets consumers process every change exactly
+// once.
+//
+// Subscription 按提交顺序投递每次提交的变更，可用于构建 CDC 管道。
+type Subscription struct {
+	db    *MemDB
+	table string
+	block bool
+
+	ch     chan CommitChanges
+	doneCh chan struct{}
+	once   sync.Once
+
+	// sendLock is held by commits while they deliver to ch, so that ch is
+	// never closed under them.
+	sendLock sync.Mutex
+
+	// ctx is checked by commits in deterministic mode, where no goroutine
+	// waits for it.
+	ctx context.Context
+
+	// view is the state the subscription starts from, and seq the number
+	// of commits delivered, which is only changed by commits.
+	view *ReadView
+	seq  uint64
+
+	// id numbers the subscription in the WatchersTable.
+	id uint64
+
+	// err is the reason the subscription ended. It is guarded by the
+	// subsLock of the MemDB, as is the closing of ch, which also takes the
+	// sendLock.
+	err error
+}
+
+// Subscribe returns a Subscription to the changes committed to the given
+// table, or to all tables if table is empty, until ctx is done, the
+// Subscription is closed or the MemDB is closed. Changes to the system tables
+// are never delivered.
+//
+// Every commit made after Subscribe returns is delivered, except commits that
+// changed none of the subscribed tables. Subscribe waits for the current
+// write transaction, if any, to finish, so it must not be called while
+// holding one.
+//
+// In deterministic mode no goroutine is started: changes are still delivered
+// by the commits themselves, in commit order, and a subscription whose
+// context is done ends at the next commit.
+func (db *MemDB) Subscribe(ctx context.Context, table string, opts SubscribeOptions) (*Subscription, error) {
+	if table != "" {
+		if _, ok := db.getSchema().Tables[table]; !ok {
+			return nil, fmt.Errorf("invalid table '%s'", table)
+		}
+	}
+	size := opts.BufferSize
+	if size <= 0 {
+		size = DefaultSubscribeBuffer
+	}
+	s := &Subscription{
+		db:     db,
+		table:  table,
+		block:  opts.Block,
+		ch:     make(chan CommitChanges, size),
+		doneCh: make(chan struct{}),
+		ctx:    ctx,
+	}
+
+	db.jobsLock.Lock()
+	if db.shutdown {
+		db.jobsLock.Unlock()
+		return nil, fmt.Errorf("database is closed")
+	}
+	if db.shutdownCh == nil {
+		db.shutdownCh = make(chan struct{})
+	}
+	shutdownCh := db.shutdownCh
+	if !db.deterministic {
+		db.jobsWg.Add(1)
+	}
+	db.jobsLock.Unlock()
+
+	// Write transactions only track their changes if there are subscribers
+	// when they start, so none may be in flight while subscribing.
+	db.writer.Lock()
+	s.view = db.ReadView()
+	db.subsLock.Lock()
+	db.watchers++
+	s.id = db.watchers
+	db.subscribers = append(db.subscribers, s)
+	db.subsLock.Unlock()
+	db.writer.Unlock()
+
+	if !db.deterministic {
+		go s.run(ctx, shutdownCh)
+	}
+	return s, nil
+}
+
+// StartIndex returns the index of the last commit made before the
+// subscription started. Every commit with a greater index that changed the
+// subscribed tables is delivered.
+func (s *Subscription) StartIndex() uint64 {
+	return s.view.Index()
+}
+
+// StartView returns a read-only view of the state the subscription starts
+// from, which is the state after the commit of StartIndex.
+func (s *Subscription) StartView() *ReadView {
+	return s.view
+}
+
+// Changes returns the channel the changes are delivered on. It is closed
+// when the subscription ends.
+func (s *Subscription) Changes() <-chan CommitChanges {
+	return s.ch
+}
+
+// Err returns why the subscription ended: the error of its context, or
+// ErrSlowSubscriber. It is nil while the subscription is active, and if it
+// was closed or the MemDB was.
+func (s *Subscription) Err() error {
+	s.db.subsLock.Lock()
+	defer s.db.subsLock.Unlock()
+	return s.err
+}
+
+// Close ends the subscription. Changes that were buffered can still be
+// received from the channel, which is then closed.
+func (s *Subscription) Close() {
+	s.stop(nil)
+}
+
+// run ends the subscription once it is done.
+func (s *Subscription) run(ctx context.Context, shutdownCh chan struct{}) {
+	defer s.db.jobsWg.Done()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-shutdownCh:
+	case <-s.doneCh:
+	}
+	s.stop(err)
+}
+
+// stop removes the subscription from the MemDB and closes its channel. A
+// commit blocked on the subscription is released first.
+func (s *Subscription) stop(err error) {
+	s.once.Do(func() { close(s.doneCh) })
+
+	s.db.subsLock.Lock()
+	defer s.db.subsLock.Unlock()
+	s.stopLocked(err)
+}
+
+// stopLocked removes the subscription from the MemDB and closes its channel.
+// The subsLock of the MemDB must be held.
+func (s *Subscription) stopLocked(err error) {
+	s.once.Do(func() { close(s.doneCh) })
+
+	db := s.db
+	for i, sub := range db.subscribers {
+		if sub != s {
+			continue
+		}
+		db.subscribers = append(db.subscribers[:i], db.subscribers[i+1:]...)
+		if s.err == nil {
+			s.err = err
+		}
+		s.sendLock.Lock()
+		close(s.ch)
+		s.sendLock.Unlock()
+		return
+	}
+}
+
+// hasSubscribers returns true if changes must be tracked for subscribers.
+func (db *MemDB) hasSubscribers() bool {
+	db.subsLock.Lock()
+	defer db.subsLock.Unlock()
+	return len(db.subscribers) > 0
+}
+
+// publishChanges delivers the changes of the transaction, committed with
+// the given index, to the subscribers. It is called with the writer lock
+// or the commit lock held, so commits are delivered in order. The
+// subscribers are copied under the subsLock, which is released before
+// delivering, so a consumer waited on by a blocking subscription doesn't
+// hold up the rest of the DB.
+func (txn *Txn) publishChanges(index uint64) {
+	db := txn.db
+	db.subsLock.Lock()
+	if len(db.subscribers) == 0 || txn.changes == nil {
+		db.subsLock.Unlock()
+		return
+	}
+	subscribers := append([]*Subscription(nil), db.subscribers...)
+	db.subsLock.Unlock()
+
+	changes := txn.Changes()
+	for _, s := range subscribers {
+		if db.deterministic && s.ctx.Err() != nil {
+			s.stop(s.ctx.Err())
+			continue
+		}
+
+		var filtered Changes
+		for _, change := range changes {
+			if isSystemTable(change.Table) {
+				continue
+			}
+			if s.table == "" || change.Table == s.table {
+				filtered = append(filtered, change)
+			}
+		}
+		if len(filtered) == 0 {
+			continue
+		}
+
+		s.seq++
+		if s.send(CommitChanges{Index: index, Seq: s.seq, Changes: filtered}) {
+			continue
+		}
+		db.logger.Warn("subscriber fell behind, subscription ended",
+			"table", s.table, "buffer", cap(s.ch))
+		db.subsLock.Lock()
+		s.err = ErrSlowSubscriber
+		db.subsLock.Unlock()
+		s.once.Do(func() { close(s.doneCh) })
+		if db.deterministic {
+			s.stop(nil)
+		}
+	}
+}
+
+// send delivers an event to the subscription, waiting for room in its
+// buffer if it blocks. It returns false if the buffer of a subscription
+// that doesn't block is full. Nothing is delivered once the subscription
+// is done.
+func (s *Subscription) send(event CommitChanges) bool {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	// ch is closed after doneCh, and only under the sendLock
+	select {
+	case <-s.doneCh:
+		return true
+	default:
+	}
+	if s.block {
+		select {
+		case s.ch <- event:
+		case <-s.doneCh:
+		}
+		return true
+	}
+	select {
+	case s.ch <- event:
+	case <-s.doneCh:
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,285 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemDB_Subscribe(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+
+	if _, err := db.Subscribe(context.Background(), "nope", SubscribeOptions{}); err == nil {
+		t.Fatalf("expected error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := db.Subscribe(ctx, "main", SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	obj := &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(true)
+	if err := txn.Delete("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	for _, created := range []bool{true, false} {
+		select {
+		case event := <-sub.Changes():
+			if len(event.Changes) != 1 || event.Changes[0].Created() != created ||
+				event.Changes[0].Deleted() == created {
+				t.Fatalf("bad: %#v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-sub.Changes():
+		if ok {
+			t.Fatalf("expected closed channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	if err := sub.Err(); err != context.Canceled {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestMemDB_Subscribe_SlowSubscriber(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+
+	sub, err := db.Subscribe(context.Background(), "", SubscribeOptions{BufferSize: 1})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		txn := db.Txn(true)
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: "x", Qux: []string{"1"}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		txn.Commit()
+	}
+
+	// The buffered commit is still delivered before the channel closes
+	var events []CommitChanges
+	for event := range sub.Changes() {
+		events = append(events, event)
+	}
+	if len(events) != 1 || events[0].Index != 1 {
+		t.Fatalf("bad: %#v", events)
+	}
+	if err := sub.Err(); err != ErrSlowSubscriber {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestMemDB_Subscribe_Block(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+
+	sub, err := db.Subscribe(context.Background(), "main", SubscribeOptions{BufferSize: 1, Block: true})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for _, id := range []string{"a", "b", "c"} {
+			txn := db.Txn(true)
+			txn.Insert("main", &TestObject{ID: id, Foo: "x", Qux: []string{"1"}})
+			txn.Commit()
+		}
+	}()
+
+	// Checking the subscription while a commit waits for room doesn't
+	// wait for the commit
+	time.Sleep(10 * time.Millisecond)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sub.Err()
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	var indexes []uint64
+	for len(indexes) < 3 {
+		select {
+		case event := <-sub.Changes():
+			indexes = append(indexes, event.Index)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+	<-doneCh
+	if indexes[0] != 1 || indexes[1] != 2 || indexes[2] != 3 {
+		t.Fatalf("bad: %#v", indexes)
+	}
+
+	sub.Close()
+	if _, ok := <-sub.Changes(); ok {
+		t.Fatalf("expected closed channel")
+	}
+	if err := sub.Err(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestMemDB_Subscribe_Ordering(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["hits"] = CounterTableSchema("hits")
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer db.Close()
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "start", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	sub, err := db.Subscribe(context.Background(), "main", SubscribeOptions{Block: true})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if sub.StartIndex() != 1 {
+		t.Fatalf("bad: %d", sub.StartIndex())
+	}
+	if raw, err := sub.StartView().First("main", "id", "start"); err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// Concurrent writers commit to the subscribed table and to another one
+	const writers, commits = 4, 25
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			for i := 0; i < commits; i++ {
+				txn := db.Txn(true)
+				obj := &TestObject{ID: fmt.Sprintf("%d-%d", w, i), Foo: "x", Qux: []string{"1"}}
+				if err := txn.Insert("main", obj); err != nil {
+					t.Errorf("err: %v", err)
+				}
+				txn.Commit()
+
+				txn, err := db.TxnTables("hits")
+				if err != nil {
+					t.Errorf("err: %v", err)
+					return
+				}
+				if _, err := txn.Increment("hits", "all", 1); err != nil {
+					t.Errorf("err: %v", err)
+				}
+				txn.Commit()
+			}
+		}(w)
+	}
+
+	// Deliveries are numbered without gaps, in commit order
+	var last uint64
+	for seq := uint64(1); seq <= writers*commits; seq++ {
+		select {
+		case event := <-sub.Changes():
+			if event.Seq != seq || event.Index <= last || event.Index <= sub.StartIndex() {
+				t.Fatalf("bad: %#v after %d", event, last)
+			}
+			last = event.Index
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+	if last == writers*commits+1 {
+		t.Fatalf("commits to other tables should be skipped")
+	}
+}
+
+func TestMemDB_Subscribe_DeterministicMode(t *testing.T) {
+	db, err := NewMemDB(testValidSchema(), WithDeterministicMode())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := db.Subscribe(ctx, "main", SubscribeOptions{BufferSize: 1})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	slow, err := db.Subscribe(context.Background(), "main", SubscribeOptions{BufferSize: 1})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	insert := func(id string) {
+		txn := db.Txn(true)
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: "x", Qux: []string{"1"}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		txn.Commit()
+	}
+
+	// The changes are delivered by the commit itself
+	insert("a")
+	select {
+	case event := <-sub.Changes():
+		if event.Seq != 1 || len(event.Changes) != 1 {
+			t.Fatalf("bad: %#v", event)
+		}
+	default:
+		t.Fatalf("should be delivered")
+	}
+
+	// A full buffer ends the subscription right away
+	insert("b")
+	if err := slow.Err(); err != ErrSlowSubscriber {
+		t.Fatalf("bad: %v", err)
+	}
+	var events []CommitChanges
+	for event := range slow.Changes() {
+		events = append(events, event)
+	}
+	if len(events) != 1 || events[0].Seq != 1 {
+		t.Fatalf("bad: %#v", events)
+	}
+
+	// A done context ends the subscription at the next commit
+	<-sub.Changes()
+	cancel()
+	insert("c")
+	if _, ok := <-sub.Changes(); ok {
+		t.Fatalf("expected closed channel")
+	}
+	if err := sub.Err(); err != context.Canceled {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Closing the DB ends the remaining subscriptions
+	sub, err = db.Subscribe(context.Background(), "", SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	db.Close()
+	if _, ok := <-sub.Changes(); ok {
+		t.Fatalf("expected closed channel")
+	}
+}
@@ -0,0 +1,437 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+const (
+	// systemTablePrefix is reserved for the tables that are maintained by
+	// MemDB itself. User schemas may not declare tables with this prefix.
+	// The colon keeps it clear of the names schemas usually use.
+	systemTablePrefix = "memdb:"
+
+	// TablesTable is a read-only virtual table holding one TableInfo per
+	// table in the schema.
+	TablesTable = "memdb:tables"
+
+	// IndexesTable is a read-only virtual table holding one IndexInfo per
+	// index in the schema.
+	IndexesTable = "memdb:indexes"
+
+	// CommitsTable is a read-only virtual table holding a CommitInfo for
+	// each of the most recent write transactions committed to the MemDB.
+	CommitsTable = "memdb:commits"
+
+	// StatsTable is a read-only virtual table holding one StatInfo per
+	// statistic of the MemDB.
+	StatsTable = "memdb:stats"
+
+	// WatchersTable is a read-only virtual table holding one WatcherInfo
+	// per active Subscription and started LiveQuery.
+	WatchersTable = "memdb:watchers"
+
+	// IdempotencyTable is a read-only table holding an IdempotencyRecord for
+	// every key committed with Txn.CommitIdempotent. Unlike the other system
+	// tables its rows are stored in the MemDB, so they are versioned along
+	// with the data.
+	IdempotencyTable = "memdb:idempotency"
+
+	// AggregatesTable is a read-only table holding an AggregateValue for
+	// every aggregate in the schema. Like the IdempotencyTable, its rows are
+	// stored in the MemDB.
+	AggregatesTable = "memdb:aggregates"
+
+	// SequencesTable is a read-only table holding a SequenceValue for every
+	// table whose sequence was advanced with Txn.NextSequence. Its rows are
+	// stored in the MemDB.
+	SequencesTable = "memdb:sequences"
+
+	// LocksTable is a read-only table holding a LockInfo for every advisory
+	// lock taken with Txn.TryLock. Its rows are stored in the MemDB.
+	LocksTable = "memdb:locks"
+
+	// recentCommits is the number of CommitInfo records kept for the
+	// CommitsTable.
+	recentCommits = 64
+)
+
+// TableInfo describes a table in the schema, along with its current size.
+// It is the row type of the TablesTable virtual table.
+type TableInfo struct {
+	Name    string
+	Indexes []string
+	Rows    int
+}
+
+// IndexInfo describes an index in the schema, along with the number of
+// entries it currently holds. It is the row type of the IndexesTable virtual
+// table.
+type IndexInfo struct {
+	Table        string
+	Name         string
+	Unique       bool
+	AllowMissing bool
+	Indexer      string
+	Entries      int
+}
+
+// CommitInfo describes a committed write transaction. It is the row type of
+// the CommitsTable virtual table.
+type CommitInfo struct {
+	Index  uint64
+	Time   time.Time
+	Tables []string
+
+	// Objects is the number of objects inserted, updated or deleted by the
+	// commit, and Duration how long its transaction held the writer lock.
+	Objects  int
+	Duration time.Duration
+}
+
+// StatInfo is a statistic of the MemDB. It is the row type of the StatsTable
+// virtual table. The statistics are:
+//
+//   - "commit_index": the index of the last commit
+//   - "subscriptions": the number of active subscriptions
+//   - "live_queries": the number of live queries started and not stopped
+//   - "decode_cache.<table>.hits", ".misses", ".evictions" and ".objects":
+//     the DecodeCacheStats of the tables with a decode cache
+type StatInfo struct {
+	Name  string
+	Value int64
+}
+
+// WatcherInfo describes a Subscription or a started LiveQuery. It is the row
+// type of the WatchersTable virtual table. Watch channels of iterators are
+// not tracked by the MemDB, so they aren't listed.
+type WatcherInfo struct {
+	// ID numbers the watchers of the MemDB from 1, in the order they
+	// started.
+	ID uint64
+
+	// Kind is "subscription" or "live_query".
+	Kind string
+
+	// Table is the table watched, which is empty for a subscription to
+	// every table.
+	Table string
+}
+
+// systemSchema is the schema of the virtual system tables. Rows for these
+// tables are generated on demand when they are read, so the usual index
+// lookups (First, Get, LowerBound, ...) all work against them. The generated
+// indexes are not part of the radix root, so their watch channels never fire.
+//
+// systemSchema 是虚拟系统表的模式，读取时按需生成数据行，因此可以像普通表一样查询。
+var systemSchema = &DBSchema{
+	Tables: map[string]*TableSchema{
+		TablesTable: &TableSchema{
+			Name: TablesTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &StringFieldIndex{Field: "Name"},
+				},
+			},
+		},
+		IndexesTable: &TableSchema{
+			Name: IndexesTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:   "id",
+					Unique: true,
+					Indexer: &CompoundIndex{
+						Indexes: []Indexer{
+							&StringFieldIndex{Field: "Table"},
+							&StringFieldIndex{Field: "Name"},
+						},
+					},
+				},
+				"table": &IndexSchema{
+					Name:    "table",
+					Indexer: &StringFieldIndex{Field: "Table"},
+				},
+			},
+		},
+		CommitsTable: &TableSchema{
+			Name: CommitsTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &UintFieldIndex{Field: "Index"},
+				},
+			},
+		},
+		StatsTable: &TableSchema{
+			Name: StatsTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &StringFieldIndex{Field: "Name"},
+				},
+			},
+		},
+		WatchersTable: &TableSchema{
+			Name: WatchersTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &UintFieldIndex{Field: "ID"},
+				},
+				"table": &IndexSchema{
+					Name:         "table",
+					AllowMissing: true,
+					Indexer:      &StringFieldIndex{Field: "Table"},
+				},
+			},
+		},
+		IdempotencyTable: &TableSchema{
+			Name: IdempotencyTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &StringFieldIndex{Field: "Key"},
+				},
+			},
+		},
+		AggregatesTable: &TableSchema{
+			Name: AggregatesTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &StringFieldIndex{Field: "Name"},
+				},
+			},
+		},
+		SequencesTable: &TableSchema{
+			Name: SequencesTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &StringFieldIndex{Field: "Table"},
+				},
+			},
+		},
+		LocksTable: &TableSchema{
+			Name: LocksTable,
+			Indexes: map[string]*IndexSchema{
+				"id": &IndexSchema{
+					Name:    "id",
+					Unique:  true,
+					Indexer: &StringFieldIndex{Field: "Key"},
+				},
+			},
+		},
+	},
+}
+
+// isSystemTable returns true if the table name is reserved for MemDB.
+func isSystemTable(table string) bool {
+	return strings.HasPrefix(table, systemTablePrefix)
+}
+
+// isStoredSystemTable returns true for the system tables whose rows are kept
+// in the radix root instead of being generated on demand.
+func isStoredSystemTable(table string) bool {
+	return table == IdempotencyTable || table == AggregatesTable || table == SequencesTable ||
+		table == LocksTable
+}
+
+// tableSchema returns the schema for the given table, including the virtual
+// system tables.
+func (txn *Txn) tableSchema(table string) (*TableSchema, bool) {
+	if isSystemTable(table) {
+		tableSchema, ok := systemSchema.Tables[table]
+		return tableSchema, ok
+	}
+	tableSchema, ok := txn.schema.Tables[table]
+	return tableSchema, ok
+}
+
+// systemIndex returns a read transaction over the given index of a virtual
+// system table. The rows are generated once per transaction so that repeated
+// queries see a consistent view.
+func (txn *Txn) systemIndex(table, index string) *iradix.Txn {
+	key := tableIndex{table, index}
+	if tree, ok := txn.system[key]; ok {
+		return tree.Txn()
+	}
+
+	tableSchema := systemSchema.Tables[table]
+	indexSchema := tableSchema.Indexes[index]
+	idIndexer := tableSchema.Indexes[id].Indexer.(SingleIndexer)
+
+	indexTxn := iradix.New().Txn()
+	for _, obj := range txn.systemRows(table) {
+		_, idVal, err := idIndexer.FromObject(obj)
+		if err != nil {
+			panic(fmt.Errorf("failed to build primary index for %s: %v", table, err))
+		}
+		ok, val, err := indexSchema.Indexer.(SingleIndexer).FromObject(obj)
+		if err != nil {
+			panic(fmt.Errorf("failed to build index '%s' for %s: %v", index, table, err))
+		}
+		if !ok {
+			continue
+		}
+		if !indexSchema.Unique {
+			val = append(val, idVal...)
+		}
+		indexTxn.Insert(val, obj)
+	}
+	tree := indexTxn.CommitOnly()
+
+	if txn.system == nil {
+		txn.system = make(map[tableIndex]*iradix.Tree)
+	}
+	txn.system[key] = tree
+	return tree.Txn()
+}
+
+// systemRows generates the rows of a virtual system table as seen by this
+// transaction.
+func (txn *Txn) systemRows(table string) []interface{} {
+	var rows []interface{}
+	switch table {
+	case TablesTable:
+		for name, tableSchema := range txn.schema.Tables {
+			info := &TableInfo{
+				Name: name,
+				Rows: txn.indexLen(name, id),
+			}
+			for indexName := range tableSchema.Indexes {
+				info.Indexes = append(info.Indexes, indexName)
+			}
+			sort.Strings(info.Indexes)
+			rows = append(rows, info)
+		}
+
+	case IndexesTable:
+		for name, tableSchema := range txn.schema.Tables {
+			for indexName, indexSchema := range tableSchema.Indexes {
+				rows = append(rows, &IndexInfo{
+					Table:        name,
+					Name:         indexName,
+					Unique:       indexSchema.Unique,
+					AllowMissing: indexSchema.AllowMissing,
+					Indexer:      reflect.TypeOf(indexSchema.Indexer).String(),
+					Entries:      txn.indexLen(name, indexName),
+				})
+			}
+		}
+
+	case CommitsTable:
+		for _, info := range txn.db.recentCommits() {
+			rows = append(rows, info)
+		}
+
+	case StatsTable:
+		for _, info := range txn.db.stats() {
+			rows = append(rows, info)
+		}
+
+	case WatchersTable:
+		for _, info := range txn.db.watcherInfos() {
+			rows = append(rows, info)
+		}
+	}
+	return rows
+}
+
+// stats returns the rows of the StatsTable.
+func (db *MemDB) stats() []*StatInfo {
+	db.subsLock.Lock()
+	subscriptions, liveQueries := len(db.subscribers), len(db.liveQueries)
+	db.subsLock.Unlock()
+
+	stats := []*StatInfo{
+		{Name: "commit_index", Value: int64(atomic.LoadUint64(&db.commitIndex))},
+		{Name: "subscriptions", Value: int64(subscriptions)},
+		{Name: "live_queries", Value: int64(liveQueries)},
+	}
+	for _, cache := range db.DecodeCacheStats() {
+		prefix := "decode_cache." + cache.Table + "."
+		stats = append(stats,
+			&StatInfo{Name: prefix + "hits", Value: int64(cache.Hits)},
+			&StatInfo{Name: prefix + "misses", Value: int64(cache.Misses)},
+			&StatInfo{Name: prefix + "evictions", Value: int64(cache.Evictions)},
+			&StatInfo{Name: prefix + "objects", Value: int64(cache.Objects)},
+		)
+	}
+	return stats
+}
+
+// watcherInfos returns the rows of the WatchersTable.
+func (db *MemDB) watcherInfos() []*WatcherInfo {
+	db.subsLock.Lock()
+	defer db.subsLock.Unlock()
+
+	var infos []*WatcherInfo
+	for _, s := range db.subscribers {
+		infos = append(infos, &WatcherInfo{ID: s.id, Kind: "subscription", Table: s.table})
+	}
+	for _, q := range db.liveQueries {
+		infos = append(infos, &WatcherInfo{ID: q.id, Kind: "live_query", Table: q.table})
+	}
+	return infos
+}
+
+// indexLen returns the number of entries in the given index as seen by this
+// transaction.
+func (txn *Txn) indexLen(table, index string) int {
+	return txn.readableIndex(table, index).CommitOnly().Len()
+}
+
+// recentCommits returns the most recent commits, oldest first.
+func (db *MemDB) recentCommits() []*CommitInfo {
+	commits, _ := db.commits.Load().([]*CommitInfo)
+	return commits
+}
+
+// recordCommit appends a CommitInfo for the given modified indexes, number
+// of changed objects and transaction start time, and returns it. This must
+// only be called while holding the writer lock.
+func (db *MemDB) recordCommit(modified map[tableIndex]*iradix.Txn, objects int, started time.Time) *CommitInfo {
+	seen := make(map[string]struct{})
+	info := &CommitInfo{
+		Index:   atomic.AddUint64(&db.commitIndex, 1),
+		Time:    db.clock.Now(),
+		Objects: objects,
+	}
+	info.Duration = info.Time.Sub(started)
+	if db.commitHistograms != nil {
+		db.commitHistograms.observe(objects, info.Duration)
+	}
+	for key := range modified {
+		if _, ok := seen[key.Table]; !ok {
+			seen[key.Table] = struct{}{}
+			info.Tables = append(info.Tables, key.Table)
+		}
+	}
+	sort.Strings(info.Tables)
+
+	// Copy on write so readers can load the slice without locking.
+	old := db.recentCommits()
+	if len(old) >= recentCommits {
+		old = old[len(old)-recentCommits+1:]
+	}
+	commits := make([]*CommitInfo, 0, len(old)+1)
+	commits = append(commits, old...)
+	commits = append(commits, info)
+	db.commits.Store(commits)
+	return info
+}
@@ -0,0 +1,174 @@
+package memdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTxn_SystemTables(t *testing.T) {
+	db := testDB(t)
+
+	txn := db.Txn(true)
+	obj := testObj()
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Uncommitted writes are visible to the writer
+	raw, err := txn.First(TablesTable, "id", "main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	info := raw.(*TableInfo)
+	if info.Rows != 1 {
+		t.Fatalf("bad: %#v", info)
+	}
+	if !reflect.DeepEqual(info.Indexes, []string{"foo", "id", "qux"}) {
+		t.Fatalf("bad: %#v", info.Indexes)
+	}
+
+	// System tables are read-only
+	if err := txn.Insert(TablesTable, info); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := txn.Delete(TablesTable, info); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	defer txn.Abort()
+
+	// Look up the indexes of a table
+	iter, err := txn.Get(IndexesTable, "table", "main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var names []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		index := raw.(*IndexInfo)
+		names = append(names, index.Name)
+		if index.Name == "qux" && index.Entries != len(obj.Qux) {
+			t.Fatalf("bad: %#v", index)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"foo", "id", "qux"}) {
+		t.Fatalf("bad: %#v", names)
+	}
+
+	raw, err = txn.First(IndexesTable, "id", "main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if index := raw.(*IndexInfo); !index.Unique || index.Indexer != "*memdb.StringFieldIndex" {
+		t.Fatalf("bad: %#v", index)
+	}
+
+	// The commit should have been recorded
+	raw, err = txn.Last(CommitsTable, "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	commit := raw.(*CommitInfo)
+	if commit.Index != 1 || !reflect.DeepEqual(commit.Tables, []string{"main"}) {
+		t.Fatalf("bad: %#v", commit)
+	}
+}
+
+func TestDBSchema_Validate_SystemTable(t *testing.T) {
+	schema := testValidSchema()
+	table := schema.Tables["main"]
+	delete(schema.Tables, "main")
+	table.Name = TablesTable
+	schema.Tables[TablesTable] = table
+
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate, reserved name")
+	}
+}
+
+func TestDBSchema_Validate_UnderscoreTable(t *testing.T) {
+	schema := testValidSchema()
+	table := schema.Tables["main"]
+	delete(schema.Tables, "main")
+	table.Name = "__main"
+	schema.Tables["__main"] = table
+
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestTxn_SystemTables_StatsAndWatchers(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+
+	sub, err := db.Subscribe(context.Background(), "main", SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	q, err := NewLiveQuery(db, "main", func(txn *Txn) (ResultIterator, error) {
+		return txn.Get("main", "id")
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	q.Start()
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", testObj()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	stats := make(map[string]int64)
+	iter, err := txn.Get(StatsTable, "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		stat := raw.(*StatInfo)
+		stats[stat.Name] = stat.Value
+	}
+	expected := map[string]int64{"commit_index": 1, "subscriptions": 1, "live_queries": 1}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("bad: %#v", stats)
+	}
+
+	iter, err = txn.Get(WatchersTable, "table", "main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var watchers []WatcherInfo
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		watchers = append(watchers, *raw.(*WatcherInfo))
+	}
+	expectedWatchers := []WatcherInfo{
+		{ID: 1, Kind: "subscription", Table: "main"},
+		{ID: 2, Kind: "live_query", Table: "main"},
+	}
+	if !reflect.DeepEqual(watchers, expectedWatchers) {
+		t.Fatalf("bad: %#v", watchers)
+	}
+
+	// Stopped watchers are removed
+	sub.Close()
+	q.Stop()
+	deadline := time.Now().Add(time.Second)
+	for {
+		raw, err := db.Txn(false).First(WatchersTable, "id")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if raw == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bad: %#v", raw)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
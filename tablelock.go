@@ -0,0 +1,178 @@
+package memdb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// TxnTables starts a write transaction that may only write to the given
+// tables. Instead of the writer lock taken by Txn(true), it locks these
+// tables along with those its writes may touch: the tables of the foreign
+// keys, cascades and unique constraints they are part of, the
+// AggregatesTable if an aggregate covers them, and the SequencesTable if
+// their IDGenerator is a SequenceGenerator. Transactions locking
+// disjoint sets of tables run concurrently, and only their commits are
+// serialized. A transaction started with Txn(true) still excludes all the
+// others.
+//
+// Reads of the tables that aren't locked see the state they had when the
+// transaction started, even if another transaction commits changes to them
+// meanwhile. Writes to them return an error. Txn.NextSequence needs the
+// SequencesTable to be given, and CommitIdempotent the IdempotencyTable.
+// Changes to the schema require a transaction started with Txn(true).
+//
+// TxnTables 开启只锁定指定表的写事务，写入不相交表集合的事务可以并行执行。
+func (db *MemDB) TxnTables(tables ...string) (*Txn, error) {
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no tables to lock")
+	}
+	schema := db.getSchema()
+	for _, table := range tables {
+		if isStoredSystemTable(table) {
+			continue
+		}
+		if _, ok := schema.Tables[table]; !ok {
+			return nil, fmt.Errorf("invalid table '%s'", table)
+		}
+	}
+
+	// The shared writer lock keeps the dual writes from changing, so the
+	// new tables of those in progress can be locked along with the old ones
+	shared := db.writer.rlock(tables)
+	dualWrites := db.getDualWrites()
+	locked := schema.lockedTables(tables)
+	var mirrored []string
+	for table := range locked {
+		if to, ok := dualWrites.mirrorTo(table); ok && !locked[to] {
+			mirrored = append(mirrored, to)
+		}
+	}
+	if len(mirrored) > 0 {
+		locked = schema.lockedTables(append(append([]string(nil), tables...), mirrored...))
+	}
+	names := make([]string, 0, len(locked))
+	for table := range locked {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	// The locks are taken in a stable order so transactions can't deadlock
+	mutexes := make([]*sync.Mutex, len(names))
+	for i, table := range names {
+		mutexes[i] = db.tableLock(table)
+		mutexes[i].Lock()
+	}
+
+	// The root is read once the tables are locked, so it has the latest
+	// commits made to them.
+	root := db.getRoot()
+	txn := &Txn{
+		db:         db,
+		write:      true,
+		schema:     rootSchema(root),
+		rootTxn:    root.Txn(),
+		started:    db.clock.Now(),
+		tables:     locked,
+		locked:     mutexes,
+		shared:     shared,
+		dualWrites: dualWrites,
+	}
+	if db.wal != nil || db.hasSubscribers() || dualWrites.mirrors() {
+		txn.TrackChanges()
+	}
+	return txn, nil
+}
+
+// tableLock returns the lock of a table for TxnTables.
+func (db *MemDB) tableLock(table string) *sync.Mutex {
+	db.tableLocksLock.Lock()
+	defer db.tableLocksLock.Unlock()
+	if db.tableLocks == nil {
+		db.tableLocks = make(map[string]*sync.Mutex)
+	}
+	l, ok := db.tableLocks[table]
+	if !ok {
+		l = new(sync.Mutex)
+		db.tableLocks[table] = l
+	}
+	return l
+}
+
+// lockedTables returns the tables that must be locked to write to the
+// given tables, which are those whose objects may be read or written to
+// keep the schema's constraints.
+func (s *DBSchema) lockedTables(tables []string) map[string]bool {
+	locked := make(map[string]bool)
+	pending := append([]string(nil), tables...)
+	add := func(table string) {
+		if !locked[table] {
+			pending = append(pending, table)
+		}
+	}
+	for len(pending) > 0 {
+		table := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if locked[table] {
+			continue
+		}
+		locked[table] = true
+
+		tableSchema, ok := s.Tables[table]
+		if !ok {
+			continue
+		}
+		for _, cascade := range tableSchema.Cascades {
+			add(cascade.ChildTable)
+		}
+		for _, fk := range tableSchema.ForeignKeys {
+			add(fk.ParentTable)
+		}
+		for name, other := range s.Tables {
+			for _, fk := range other.ForeignKeys {
+				if fk.ParentTable == table {
+					add(name)
+				}
+			}
+		}
+		for _, constraint := range s.UniqueConstraints {
+			if _, ok := constraint.Indexes[table]; ok {
+				for other := range constraint.Indexes {
+					add(other)
+				}
+			}
+		}
+		for _, aggregate := range s.Aggregates {
+			if aggregate.Table == table {
+				add(AggregatesTable)
+			}
+		}
+		if _, ok := tableSchema.IDGenerator.(*SequenceGenerator); ok {
+			add(SequencesTable)
+		}
+	}
+	return locked
+}
+
+// checkWritable returns an error if the transaction didn't lock the table.
+func (txn *Txn) checkWritable(table string) error {
+	if txn.tables != nil && !txn.tables[table] {
+		return fmt.Errorf("table '%s' is not locked by the transaction", table)
+	}
+	if to, ok := txn.dualWrites.retiredTo(table); ok {
+		return fmt.Errorf("table '%s' was migrated to '%s'", table, to)
+	}
+	return nil
+}
+
+// releaseWriter releases the locks taken by a write transaction.
+func (txn *Txn) releaseWriter() {
+	if txn.tables == nil {
+		txn.db.writer.Unlock()
+		return
+	}
+	for i := len(txn.locked) - 1; i >= 0; i-- {
+		txn.locked[i].Unlock()
+	}
+	txn.shared.RUnlock()
+}
@@ -0,0 +1,110 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemDB_TxnTables(t *testing.T) {
+	schema := testValidSchema()
+	schema.Tables["hits"] = CounterTableSchema("hits")
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := db.TxnTables("nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Transactions on disjoint tables run concurrently
+	txn1, err := db.TxnTables("main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn2, err := db.TxnTables("hits")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn1.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn1.Increment("hits", "home", 1); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, err := txn2.Increment("hits", "home", 1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn2.Commit()
+	txn1.Commit()
+
+	// Both commits are kept
+	txn := db.Txn(false)
+	if raw, err := txn.First("main", "id", "a"); err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	if value, err := txn.CounterValue("hits", "home"); err != nil || value != 1 {
+		t.Fatalf("bad: %d %v", value, err)
+	}
+
+	// Overlapping transactions, and global ones, wait for each other
+	txn1, err = db.TxnTables("main", "hits")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		txn, err := db.TxnTables("hits")
+		if err != nil {
+			t.Errorf("err: %v", err)
+			return
+		}
+		txn.Abort()
+		db.Txn(true).Abort()
+	}()
+	select {
+	case <-doneCh:
+		t.Fatalf("should wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	txn1.Abort()
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestMemDB_TxnTables_Sequences(t *testing.T) {
+	db := testDB(t)
+
+	txn, err := db.TxnTables("main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn.NextSequence("main"); err == nil {
+		t.Fatalf("expected error")
+	}
+	txn.Abort()
+
+	txn, err = db.TxnTables("main", SequencesTable)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if seq, err := txn.NextSequence("main"); err != nil || seq != 1 {
+		t.Fatalf("bad: %d %v", seq, err)
+	}
+	txn.Commit()
+}
+
+func TestDBSchema_LockedTables(t *testing.T) {
+	schema := testForeignKeySchema(Cascade)
+	locked := schema.lockedTables([]string{"teams"})
+	if len(locked) != 2 || !locked["teams"] || !locked["members"] {
+		t.Fatalf("bad: %#v", locked)
+	}
+	locked = schema.lockedTables([]string{"members"})
+	if len(locked) != 2 || !locked["teams"] {
+		t.Fatalf("bad: %#v", locked)
+	}
+}
@@ -0,0 +1,240 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// DefaultTTLInterval is how often expired objects are deleted, unless set
+// with WithTTLInterval.
+const DefaultTTLInterval = time.Minute
+
+// timeType is the type of the expiration fields of TTLSchema, and of the
+// fields of a TimeFieldIndexer.
+var timeType = reflect.TypeOf(time.Time{})
+
+// TTLSchema makes the objects of a table expire. A background job deletes
+// the objects whose expiration time has passed, in a write transaction of
+// its own, so the watch channels of the deleted objects fire as they would
+// for any other delete. Expired objects remain visible until the job runs.
+//
+// The job finds the expired objects in a read transaction, and only takes
+// the writer lock to expire them. It reads every object of the table unless
+// the table has an index on the expiration field with a TimeFieldIndexer,
+// in which case it only reads the expired ones, so the interval it runs at
+// should otherwise be chosen according to the size of the tables.
+//
+// TTLSchema 为表配置过期时间，由后台任务定期删除过期的对象。
+type TTLSchema struct {
+	// Field names the time.Time field holding when an object expires.
+	// Objects whose field is the zero time never expire.
+	Field string
+
+	// Expire optionally keeps expired objects, replacing them with the copy
+	// it returns instead of deleting them. The copy must have a zero
+	// expiration time, or it is expired again on every run of the job.
+	Expire func(obj interface{}) (interface{}, error)
+}
+
+// Validate is used to validate the TTL schema.
+func (s *TTLSchema) Validate() error {
+	if s.Field == "" {
+		return fmt.Errorf("missing TTL field")
+	}
+	return nil
+}
+
+// WithTTLInterval sets how often the objects of the tables with a TTLSchema
+// are checked for expiration.
+func WithTTLInterval(interval time.Duration) Option {
+	return func(db *MemDB) {
+		db.ttlInterval = interval
+	}
+}
+
+// startTTLReaper starts the background job deleting expired objects, unless
+// it is already running. The job covers every table of the current schema,
+// including those added later on with ReloadSchema.
+func (db *MemDB) startTTLReaper() {
+	db.ttlReaper.Do(func() {
+		interval := db.ttlInterval
+		if interval <= 0 {
+			interval = DefaultTTLInterval
+		}
+		db.startJob("ttl reaper", interval, func(now time.Time) {
+			if _, err := db.ReapExpired(now); err != nil {
+				db.logger.Error("failed to delete expired objects", "error", err)
+			}
+		})
+	})
+}
+
+// hasTTL returns true if a table of the schema has a TTLSchema.
+func (s *DBSchema) hasTTL() bool {
+	for _, tableSchema := range s.Tables {
+		if tableSchema.TTL != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ReapExpired deletes the objects of the tables with a TTLSchema that expire
+// at or before now, or replaces them for tables with an Expire function, and
+// returns how many expired. It is called by the background job, and can be
+// called directly to expire objects right away.
+//
+// The expired objects are looked for in a read transaction, so writers
+// aren't held up by the scan, and only those found are expired in a write
+// transaction, which checks again that they are still expired.
+func (db *MemDB) ReapExpired(now time.Time) (int, error) {
+	read := db.Txn(false)
+	var tables []string
+	for name, tableSchema := range read.schema.Tables {
+		if tableSchema.TTL != nil {
+			tables = append(tables, name)
+		}
+	}
+	sort.Strings(tables)
+
+	candidates := make(map[string][][]byte)
+	found := false
+	for _, table := range tables {
+		keys, err := read.expiredKeys(read.schema.Tables[table], now)
+		if err != nil {
+			return 0, err
+		}
+		candidates[table] = keys
+		found = found || len(keys) > 0
+	}
+	if !found {
+		return 0, nil
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	num := 0
+	for _, table := range tables {
+		tableSchema, ok := txn.schema.Tables[table]
+		if !ok || tableSchema.TTL == nil {
+			continue
+		}
+		for _, key := range candidates[table] {
+			expired, err := txn.expireObject(tableSchema, key, now)
+			if err != nil {
+				txn.AbortWithReason(err)
+				return 0, err
+			}
+			if expired {
+				num++
+			}
+		}
+	}
+	if num == 0 {
+		return 0, nil
+	}
+	if err := txn.TryCommit(); err != nil {
+		return 0, err
+	}
+	db.logger.Debug("deleted expired objects", "count", num)
+	return num, nil
+}
+
+// expireObject deletes or replaces the object with the given primary key if
+// it still expires at or before now, and returns true if it did.
+func (txn *Txn) expireObject(tableSchema *TableSchema, key []byte, now time.Time) (bool, error) {
+	table := tableSchema.Name
+	raw, ok := txn.readableIndex(table, id).Get(key)
+	if !ok {
+		return false, nil
+	}
+	obj, err := txn.resolve(table, raw)
+	if err != nil {
+		return false, err
+	}
+	if expired, err := isExpired(tableSchema, obj, now); err != nil || !expired {
+		return false, err
+	}
+
+	if expire := tableSchema.TTL.Expire; expire != nil {
+		updated, err := expire(obj)
+		if err == nil {
+			err = txn.Insert(table, updated)
+		}
+		return err == nil, err
+	}
+	err = txn.Delete(table, obj)
+	return err == nil, err
+}
+
+// expiredKeys returns the primary keys of the objects of the table that
+// expire at or before now. If the table has an index on the expiration
+// field, only the objects up to now are read from it; otherwise every
+// object of the table is read.
+func (txn *Txn) expiredKeys(tableSchema *TableSchema, now time.Time) ([][]byte, error) {
+	table := tableSchema.Name
+	index := tableSchema.ttlIndex()
+	if index == "" {
+		index = id
+	}
+	idIndexer := tableSchema.Indexes[id].Indexer.(SingleIndexer)
+
+	var keys [][]byte
+	iter := txn.readableIndex(table, index).Root().Iterator()
+	for key, raw, ok := iter.Next(); ok; key, raw, ok = iter.Next() {
+		obj, err := txn.resolve(table, raw)
+		if err != nil {
+			return nil, err
+		}
+		expired, err := isExpired(tableSchema, obj, now)
+		if err != nil {
+			return nil, err
+		}
+		if !expired {
+			// The index lists the objects in the order they expire
+			if index != id {
+				break
+			}
+			continue
+		}
+		if index != id {
+			if _, key, err = idIndexer.FromObject(obj); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ttlIndex returns the name of an index of the table on its expiration
+// field, or an empty string if there is none.
+func (s *TableSchema) ttlIndex() string {
+	var names []string
+	for name, indexSchema := range s.Indexes {
+		indexer, ok := indexSchema.Indexer.(*TimeFieldIndexer)
+		if ok && indexer.Field == s.TTL.Field {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// isExpired returns true if the object of the table expires at or before
+// now.
+func isExpired(tableSchema *TableSchema, obj interface{}, now time.Time) (bool, error) {
+	field := tableSchema.TTL.Field
+	fv := reflect.Indirect(reflect.ValueOf(obj)).FieldByName(field)
+	if !fv.IsValid() || fv.Type() != timeType {
+		return false, fmt.Errorf("TTL field '%s' of table '%s' is not a time.Time", field, tableSchema.Name)
+	}
+	expires := fv.Interface().(time.Time)
+	return !expires.IsZero() && !now.Before(expires), nil
+}
@@ -0,0 +1,152 @@
+package memdb
+
+import (
+	"testing"
+	"time"
+)
+
+type testSession struct {
+	ID      string
+	Expires time.Time
+}
+
+func testTTLSchema() *DBSchema {
+	return &DBSchema{
+		Tables: map[string]*TableSchema{
+			"sessions": &TableSchema{
+				Name: "sessions",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+				},
+				TTL: &TTLSchema{Field: "Expires"},
+			},
+		},
+	}
+}
+
+func TestMemDB_TTL(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewManualClock(start)
+	db, err := NewMemDB(testTTLSchema(), WithClock(clock), WithDeterministicMode(),
+		WithTTLInterval(time.Second))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, s := range []*testSession{
+		&testSession{ID: "a", Expires: start.Add(time.Second)},
+		&testSession{ID: "b", Expires: start.Add(time.Hour)},
+		&testSession{ID: "c"},
+	} {
+		if err := txn.Insert("sessions", s); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	ws := NewWatchSet()
+	watch, raw, err := db.Txn(false).FirstWatch("sessions", "id", "a")
+	if err != nil || raw == nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	ws.Add(watch)
+
+	// Nothing expires until the job runs
+	if n := db.Tick(); n != 0 {
+		t.Fatalf("bad: %d", n)
+	}
+	clock.Advance(time.Second)
+	if n := db.Tick(); n != 1 {
+		t.Fatalf("bad: %d", n)
+	}
+	if timeout := ws.Watch(time.After(time.Second)); timeout {
+		t.Fatalf("should fire")
+	}
+
+	txn = db.Txn(false)
+	for id, expect := range map[string]bool{"a": false, "b": true, "c": true} {
+		raw, err := txn.First("sessions", "id", id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if (raw != nil) != expect {
+			t.Fatalf("bad: %s %#v", id, raw)
+		}
+	}
+
+	// Objects can be expired directly
+	if n, err := db.ReapExpired(start.Add(2 * time.Hour)); err != nil || n != 1 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+
+	// The field must be a time
+	schema := testTTLSchema()
+	schema.Tables["sessions"].TTL.Field = "ID"
+	db, err = NewMemDB(schema, WithDeterministicMode())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn = db.Txn(true)
+	if err := txn.Insert("sessions", &testSession{ID: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if _, err := db.ReapExpired(time.Now()); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	schema.Tables["sessions"].TTL.Field = ""
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestMemDB_TTL_Index(t *testing.T) {
+	schema := testTTLSchema()
+	schema.Tables["sessions"].Indexes["expires"] = &IndexSchema{
+		Name:         "expires",
+		AllowMissing: true,
+		Indexer:      &TimeFieldIndexer{Field: "Expires"},
+	}
+	db, err := NewMemDB(schema, WithDeterministicMode())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	start := time.Unix(1000, 0)
+	txn := db.Txn(true)
+	for _, s := range []*testSession{
+		&testSession{ID: "a", Expires: start.Add(2 * time.Second)},
+		&testSession{ID: "b", Expires: start.Add(time.Hour)},
+		&testSession{ID: "c"},
+		&testSession{ID: "d", Expires: start.Add(time.Second)},
+	} {
+		if err := txn.Insert("sessions", s); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// The objects are read from the index in the order they expire
+	if n, err := db.ReapExpired(start.Add(2 * time.Second)); err != nil || n != 2 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+	txn = db.Txn(false)
+	for id, expect := range map[string]bool{"a": false, "b": true, "c": true, "d": false} {
+		raw, err := txn.First("sessions", "id", id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if (raw != nil) != expect {
+			t.Fatalf("bad: %s %#v", id, raw)
+		}
+	}
+	if n, err := db.ReapExpired(start.Add(2 * time.Second)); err != nil || n != 0 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+}
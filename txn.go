@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"sync"
 	"sync/atomic"
-	"unsafe"
+	"time"
 
 	iradix "github.com/hashicorp/go-immutable-radix"
 )
@@ -31,13 +32,87 @@ type Txn struct {
 	db      *MemDB
 	write   bool
 	rootTxn *iradix.Txn
-	after   []func()
+
+	// schema is the schema of the DB when the transaction started.
+	schema *DBSchema
+
+	after []func()
 
 	// changes is used to track the changes performed during the transaction.
 	// If it is nil at transaction start then changes are not tracked.
 	changes Changes
 
+	// reads records the index ranges read by the transaction, if read
+	// tracking is enabled with TrackReads.
+	reads []ReadRange
+
+	// queryStats records the read amplification of the iterators created
+	// by the transaction, if enabled with TrackQueryStats.
+	queryStats []*QueryStats
+
 	modified map[tableIndex]*iradix.Txn
+
+	// system caches the generated indexes of the virtual system tables.
+	system map[tableIndex]*iradix.Tree
+
+	// root and rootTree cache the first index tree looked up in rootTxn,
+	// which only changes on commit, so hot indexes skip building the path
+	// and walking the root tree on every operation. roots caches the
+	// others, and is only allocated once a second index is looked up.
+	root     tableIndex
+	rootTree *iradix.Tree
+	roots    map[tableIndex]*iradix.Tree
+
+	// writeStats and indexWrites count the writes made by the transaction
+	// when write stats are enabled.
+	writeStats  *WriteStats
+	indexWrites map[tableIndex]*IndexWriteStats
+
+	// iterMode is the mode of the iterators created by the transaction.
+	iterMode IterationMode
+
+	// usageTick picks the queries sampled for index usage.
+	usageTick uint64
+
+	// limitOps and limitBytes are the size of the transaction, checked
+	// against the TxnLimits of the DB.
+	limitOps   int
+	limitBytes int
+
+	// started is when a write transaction acquired the writer lock.
+	started time.Time
+
+	// optimistic is set for transactions started with TxnOptimistic until
+	// their commit takes the writer lock. base is the root they started
+	// from.
+	optimistic bool
+	base       *iradix.Tree
+
+	// tables holds the tables a transaction started with TxnTables may
+	// write to, locked the locks it holds on them, and shared the shard of
+	// the writer lock it holds for reading.
+	tables map[string]bool
+	locked []*sync.Mutex
+	shared *sync.RWMutex
+
+	// savepoints holds the states recorded with Savepoint.
+	savepoints []*savepoint
+
+	// blobs holds the large objects stored, deleted or replaced by a write
+	// transaction.
+	blobs *blobWrites
+
+	// dualWrites holds the dual writes in progress when a write
+	// transaction started, which can't change until it ends.
+	dualWrites *dualWriteState
+
+	// deferred holds the checks of the deferred constraints, made at
+	// commit.
+	deferred *deferredChecks
+
+	// deferReferences makes every foreign key deferred, for restores that
+	// write tables in any order.
+	deferReferences bool
 }
 
 // TrackChanges enables change tracking for the transaction. If called at any
@@ -57,6 +132,11 @@ func (txn *Txn) TrackChanges() {
 // modified index will be returned.
 func (txn *Txn) readableIndex(table, index string) *iradix.Txn {
 
+	// System tables are generated on demand
+	if isSystemTable(table) && !isStoredSystemTable(table) {
+		return txn.systemIndex(table, index)
+	}
+
 	// Look for existing transaction
 	if txn.write && txn.modified != nil {
 		key := tableIndex{table, index}
@@ -67,12 +147,39 @@ func (txn *Txn) readableIndex(table, index string) *iradix.Txn {
 	}
 
 	// Create a read transaction
-	path := indexPath(table, index)
-	raw, _ := txn.rootTxn.Get(path)
-	indexTxn := raw.(*iradix.Tree).Txn()
+	indexTxn := txn.indexTree(table, index).Txn()
 	return indexTxn
 }
 
+// indexTree returns the tree of the given index in a table, as of the start
+// of the transaction.
+func (txn *Txn) indexTree(table, index string) *iradix.Tree {
+	key := tableIndex{table, index}
+	if txn.rootTree != nil && txn.root == key {
+		return txn.rootTree
+	}
+	if tree, ok := txn.roots[key]; ok {
+		return tree
+	}
+
+	var tree *iradix.Tree
+	if tableSchema, ok := txn.schema.Tables[table]; ok && tableSchema.Inline {
+		tree = txn.inlineTable(table).tree(tableSchema, index)
+	} else {
+		raw, _ := txn.rootTxn.Get(indexPath(table, index))
+		tree = raw.(*iradix.Tree)
+	}
+	if txn.rootTree == nil {
+		txn.root, txn.rootTree = key, tree
+		return tree
+	}
+	if txn.roots == nil {
+		txn.roots = make(map[tableIndex]*iradix.Tree)
+	}
+	txn.roots[key] = tree
+	return tree
+}
+
 // writableIndex returns a transaction usable for modifying the
 // given index in a table.
 //
@@ -92,16 +199,14 @@ func (txn *Txn) writableIndex(table, index string) *iradix.Txn {
 
 	// Start a new transaction
 
-	// 查询 table.index 的 tree 索引结构
-	raw, _ := txn.rootTxn.Get(indexPath(table, index))
-
-	// 创建 tree 上的事务对象
-	indexTxn := raw.(*iradix.Tree).Txn()
+	// 查询 table.index 的 tree 索引结构，并创建 tree 上的事务对象
+	indexTxn := txn.indexTree(table, index).Txn()
 
 	// If we are the primary DB, enable mutation tracking. Snapshots should
 	// not notify, otherwise we will trigger watches on the primary DB when
-	// the writes will not be visible.
-	indexTxn.TrackMutate(txn.db.primary)
+	// the writes will not be visible. The generated trees of inline tables
+	// are notified when their version is replaced instead.
+	indexTxn.TrackMutate(txn.db.primary && !txn.isInlineTable(table))
 
 	// Keep this open for the duration of the txn
 	// 保存事务对象
@@ -112,6 +217,13 @@ func (txn *Txn) writableIndex(table, index string) *iradix.Txn {
 // Abort is used to cancel this transaction.
 // This is a noop for read transactions.
 func (txn *Txn) Abort() {
+	txn.AbortWithReason(nil)
+}
+
+// AbortWithReason is like Abort, but records why the transaction was
+// cancelled. The reason is classified with ClassifyAbort, counted in
+// AbortCounts and passed to the hook registered with WithAbortHook.
+func (txn *Txn) AbortWithReason(reason error) {
 
 	// Noop for a read transaction
 	if !txn.write {
@@ -127,37 +239,132 @@ func (txn *Txn) Abort() {
 	txn.rootTxn = nil
 	txn.modified = nil
 	txn.changes = nil
+	txn.writeStats = nil
+	txn.indexWrites = nil
+	txn.deferred = nil
 
 	// Release the writer lock since this is invalid
-	txn.db.writer.Unlock()
+	if !txn.optimistic {
+		txn.releaseWriter()
+	}
+
+	// Delete the blobs no committed object references
+	txn.deleteBlobs(false)
+
+	txn.db.recordAbort(&AbortInfo{
+		Kind:     ClassifyAbort(reason),
+		Reason:   reason,
+		Objects:  txn.limitOps,
+		Duration: txn.db.clock.Now().Sub(txn.started),
+	})
 }
 
 // Commit is used to finalize this transaction.
 // This is a noop for read transactions.
+//
+// The commit can fail, such as when a deferred constraint is violated or
+// the changes can't be written to the write-ahead log configured with
+// WithWAL. The transaction is then aborted, so none of its changes are
+// applied, and Commit panics with the error. Transactions that can fail this
+// way, such as those of a durable MemDB, should be committed with TryCommit,
+// which returns the error instead. If a hook run by the commit panics, the
+// transaction is cleaned up as described for TryCommit and Commit panics
+// with the *PanicError.
 func (txn *Txn) Commit() {
+	if err := txn.TryCommit(); err != nil {
+		panic(err)
+	}
+}
+
+// TryCommit is like Commit, but recovers from panics raised during the
+// commit, such as by functions registered with Defer or by alarm or write
+// stats callbacks. The transaction is aborted if it was not committed yet,
+// and the writer lock is released either way, so the DB stays usable. The
+// panic is returned as a *PanicError. The transaction is also aborted, and
+// the error returned, if its changes can't be written to the write-ahead
+// log configured with WithWAL.
+func (txn *Txn) TryCommit() (err error) {
+	defer txn.recoverPanic("commit", &err)
+	if err := txn.commit(); err != nil {
+		txn.AbortWithReason(err)
+		return err
+	}
+	return nil
+}
+
+// commit does the work of Commit.
+func (txn *Txn) commit() error {
 
 	// Noop for a read transaction
 	//
 	// 读事务直接 return
 	if !txn.write {
-		return
+		return nil
 	}
 
 	// Check if already aborted or committed
 	if txn.rootTxn == nil {
-		return
+		return nil
+	}
+	if err := txn.db.injectFault(FaultCommit, ""); err != nil {
+		return err
+	}
+
+	// Optimistic transactions take the writer lock now
+	if txn.optimistic {
+		return txn.commitOptimistic()
+	}
+
+	// Copy the writes to tables being migrated, so they are logged too
+	if err := txn.mirrorDualWrites(); err != nil {
+		return err
+	}
+
+	// Check the deferred constraints against the final state
+	if err := txn.checkDeferred(); err != nil {
+		return err
+	}
+
+	// Log the changes before they become visible
+	if err := txn.appendWAL(); err != nil {
+		return err
+	}
+
+	// Transactions locking tables commit concurrently, so their indexes
+	// are applied to the latest root
+	if txn.tables != nil {
+		txn.db.commitLock.Lock()
+		txn.rootTxn = txn.db.getRoot().Txn()
 	}
 
 	// Commit each sub-transaction scoped to (table, index)
 	for key, subTxn := range txn.modified {
+		if txn.isInlineTable(key.Table) {
+			continue
+		}
 		path := indexPath(key.Table, key.Index)
 		final := subTxn.CommitOnly()
+		if txn.db.writeStatsFn != nil {
+			before, _ := txn.rootTxn.Get(path)
+			tree, _ := before.(*iradix.Tree)
+			txn.countCopied(key, tree, final)
+		}
 		txn.rootTxn.Insert(path, final)
 	}
+	replaced := txn.commitInline(txn.rootTxn)
+	if raw, _ := txn.rootTxn.Get(schemaPath); raw != txn.schema {
+		txn.rootTxn.Insert(schemaPath, txn.schema)
+	}
 
 	// Update the root of the DB
 	newRoot := txn.rootTxn.CommitOnly()
-	atomic.StorePointer(&txn.db.root, unsafe.Pointer(newRoot))
+	atomic.AddUint64(&txn.db.rootSeq, 1)
+	txn.db.setRoot(newRoot)
+	commit := txn.db.recordCommit(txn.modified, txn.limitOps, txn.started)
+	atomic.AddUint64(&txn.db.rootSeq, 1)
+	writeStats := txn.finalWriteStats(commit.Index)
+	txn.recordIndexWrites()
+	alarms := txn.checkAlarms(newRoot, commit)
 
 	// Now issue all of the mutation updates (this is safe to call
 	// even if mutation tracking isn't enabled); we do this after
@@ -167,19 +374,41 @@ func (txn *Txn) Commit() {
 		subTxn.Notify()
 	}
 	txn.rootTxn.Notify()
+	if txn.db.primary {
+		for _, t := range replaced {
+			t.invalidate()
+		}
+	}
+
+	txn.publishChanges(commit.Index)
 
 	// Clear the txn
 	txn.rootTxn = nil
 	txn.modified = nil
 
 	// Release the writer lock since this is invalid
-	txn.db.writer.Unlock()
+	if txn.tables != nil {
+		txn.db.commitLock.Unlock()
+	}
+	txn.releaseWriter()
+
+	// Delete the blobs of the deleted and replaced objects
+	txn.deleteBlobs(true)
+
+	// Report the write stats, if enabled
+	if writeStats != nil {
+		txn.db.writeStatsFn(writeStats)
+	}
+	for _, event := range alarms {
+		event.Alarm.Fn(event)
+	}
 
 	// Run the deferred functions, if any
 	for i := len(txn.after); i > 0; i-- {
 		fn := txn.after[i-1]
 		fn()
 	}
+	return nil
 }
 
 // Insert is used to add or update an object into the given table.
@@ -187,18 +416,40 @@ func (txn *Txn) Commit() {
 // When updating an object, the obj provided should be a copy rather
 // than a value updated in-place. Modifying values in-place that are already
 // inserted into MemDB is not supported behavior.
-func (txn *Txn) Insert(table string, obj interface{}) error {
+func (txn *Txn) Insert(table string, obj interface{}) (err error) {
+	defer txn.recoverPanic("insert", &err)
+	if err := txn.insert(table, obj); err != nil {
+		return err
+	}
+	return txn.checkLimits()
+}
+
+// insert does the work of Insert.
+func (txn *Txn) insert(table string, obj interface{}) error {
 	// 是否可写
 	if !txn.write {
 		return fmt.Errorf("cannot insert in read-only transaction")
 	}
+	if err := txn.checkWritable(table); err != nil {
+		return err
+	}
+	if isSystemTable(table) {
+		return fmt.Errorf("cannot insert in read-only system table '%s'", table)
+	}
 
 	// Get the table schema
-	tableSchema, ok := txn.db.schema.Tables[table]
+	tableSchema, ok := txn.schema.Tables[table]
 	if !ok {
 		return fmt.Errorf("invalid table '%s'", table)
 	}
 
+	// Assign a generated primary ID if the object has none
+	if tableSchema.IDGenerator != nil {
+		if err := txn.assignID(tableSchema, obj); err != nil {
+			return err
+		}
+	}
+
 	// Get the primary ID of the object
 	idSchema := tableSchema.Indexes[id]
 	idIndexer := idSchema.Indexer.(SingleIndexer)
@@ -219,6 +470,46 @@ func (txn *Txn) Insert(table string, obj interface{}) error {
 	// 检查主键是否已经存在
 	idTxn := txn.writableIndex(table, id)
 	existing, update := idTxn.Get(idVal)
+	existingStored := existing
+	if update {
+		if existing, err = txn.resolve(table, existing); err != nil {
+			return err
+		}
+	}
+	if update && tableSchema.Log != nil {
+		return fmt.Errorf("entries of log table '%s' can't be updated", table)
+	}
+
+	// Refuse values that are already used by other tables
+	if err := txn.checkUniqueConstraints(table, obj); err != nil {
+		return err
+	}
+	if err := txn.checkUniqueIndexes(tableSchema, obj, idVal); err != nil {
+		return err
+	}
+
+	// Refuse broken references before writing anything
+	if update {
+		if err := txn.checkKeptReferences(tableSchema, existing, obj, idVal); err != nil {
+			return err
+		}
+	}
+	if err := txn.checkForeignKeys(tableSchema, obj, []interface{}{obj}); err != nil {
+		return err
+	}
+
+	if err := txn.db.injectFault(FaultIndex, table); err != nil {
+		return err
+	}
+
+	// Large objects may be stored outside of the indexes
+	stored, err := txn.externalize(tableSchema, obj)
+	if err != nil {
+		return err
+	}
+	if update {
+		txn.releaseBlob(tableSchema, existingStored)
+	}
 
 	// On an update, there is an existing object with the given primary ID.
 	// We do the update by deleting the current object and inserting the new object.
@@ -237,14 +528,7 @@ func (txn *Txn) Insert(table string, obj interface{}) error {
 		)
 
 		// 从 obj 中提取索引值 vals
-		switch indexer := indexSchema.Indexer.(type) {
-		case SingleIndexer:
-			var val []byte
-			ok, val, err = indexer.FromObject(obj)
-			vals = [][]byte{val}
-		case MultiIndexer:
-			ok, vals, err = indexer.FromObject(obj)
-		}
+		ok, vals, err = indexValues(indexSchema, obj)
 		if err != nil {
 			return fmt.Errorf("failed to build index '%s': %v", indexName, err)
 		}
@@ -267,38 +551,67 @@ func (txn *Txn) Insert(table string, obj interface{}) error {
 			)
 
 			// 从 existing 中提取索引值 valsExist
-			switch indexer := indexSchema.Indexer.(type) {
-			case SingleIndexer:
-				var valExist []byte
-				okExist, valExist, err = indexer.FromObject(existing)
-				valsExist = [][]byte{valExist}
-			case MultiIndexer:
-				okExist, valsExist, err = indexer.FromObject(existing)
-			}
+			okExist, valsExist, err = indexValues(indexSchema, existing)
 			if err != nil {
 				return fmt.Errorf("failed to build index '%s': %v", indexName, err)
 			}
 
-			// 从索引中删除这些 valsExist
-			if okExist {
-				for i, valExist := range valsExist {
+			// Handle non-unique index by computing a unique index.
+			// This is done by appending the primary key which must
+			// be unique anyways.
+			if okExist && !indexSchema.Unique {
+				for i := range valsExist {
+					valsExist[i] = append(valsExist[i], idVal...)
+				}
+			}
 
-					// Handle non-unique index by computing a unique index.
-					// This is done by appending the primary key which must
-					// be unique anyways.
-					//
-					//
-					if !indexSchema.Unique {
-						valExist = append(valExist, idVal...)
+			// If the values didn't change nothing is deleted, and the
+			// entries only need to point to the new object, unless it's
+			// the same one. Deferred indexes may have lost entries to
+			// other objects, so they take the long way.
+			if ok && okExist && !indexSchema.Deferred && sameValues(vals, valsExist) {
+				if !sameObject(stored, existingStored) {
+					for _, val := range vals {
+						indexTxn.Insert(val, stored)
 					}
+					txn.countWrite(table, indexName, len(vals), 0)
+					txn.countBytes(vals)
+				}
+				continue
+			}
+
+			// Index values that the new object still has don't need to
+			// be deleted, wherever they appear among its values.
+			var keep map[string]struct{}
+			if ok && len(vals) > 1 {
+				keep = make(map[string]struct{}, len(vals))
+				for _, val := range vals {
+					keep[string(val)] = struct{}{}
+				}
+			}
+
+			// 从索引中删除这些 valsExist
+			if okExist {
+				for _, valExist := range valsExist {
 
 					// If we are writing to the same index with the same value,
 					// we can avoid the delete as the insert will overwrite the
 					// value anyways.
 					//
 					// 如果是相同的值，可以不必删除，由插入来覆盖。
-					if i >= len(vals) || !bytes.Equal(valExist, vals[i]) {
-						indexTxn.Delete(valExist)
+					same := ok && len(vals) == 1 && bytes.Equal(vals[0], valExist)
+					if keep != nil {
+						_, same = keep[string(valExist)]
+					}
+					if !same {
+						owns, err := txn.ownsEntry(table, indexSchema, indexTxn, valExist, idVal)
+						if err != nil {
+							return err
+						}
+						if owns {
+							indexTxn.Delete(valExist)
+							txn.countWrite(table, indexName, 0, 1)
+						}
 					}
 				}
 			}
@@ -307,18 +620,30 @@ func (txn *Txn) Insert(table string, obj interface{}) error {
 		// If there is no index value,
 		// either this is an error or an expected case and we can skip updating
 		if !ok {
-			if indexSchema.AllowMissing {
+			if indexSchema.AllowMissing || indexSchema.NullDistinct || !indexSchema.covers(obj) {
 				continue
 			} else {
 				return fmt.Errorf("missing value for index '%s'", indexName)
 			}
 		}
 
-		// Update the value of the index
+		// Objects losing their entries of a deferred unique index to this
+		// one must give up the value before the commit
+		if indexSchema.Deferred {
+			if err := txn.deferDisplaced(table, indexTxn, vals, idVal); err != nil {
+				return err
+			}
+		}
+
+		// Update the value of the index. Entries whose key didn't change are
+		// still written, since they must point to the new object.
 		for _, val := range vals {
-			indexTxn.Insert(val, obj)
+			indexTxn.Insert(val, stored)
 		}
+		txn.countWrite(table, indexName, len(vals), 0)
+		txn.countBytes(vals)
 	}
+	txn.countObject()
 
 
 	///
@@ -331,18 +656,59 @@ func (txn *Txn) Insert(table string, obj interface{}) error {
 		})
 	}
 
+	// Keep the aggregates over the table up to date
+	if err := txn.updateAggregates(table, existing, obj); err != nil {
+		return err
+	}
+
+	// Rewrite the references of child rows if the key changed
+	if update {
+		if err := txn.cascadeUpdate(tableSchema, existing, obj); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// sameValues returns true if both sets of index values are the same, in the
+// same order.
+func sameValues(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // Delete is used to delete a single object from the given table.
 // This object must already exist in the table.
-func (txn *Txn) Delete(table string, obj interface{}) error {
+func (txn *Txn) Delete(table string, obj interface{}) (err error) {
+	defer txn.recoverPanic("delete", &err)
+	if err := txn.delete(table, obj); err != nil {
+		return err
+	}
+	return txn.checkLimits()
+}
+
+// delete does the work of Delete.
+func (txn *Txn) delete(table string, obj interface{}) error {
 	if !txn.write {
 		return fmt.Errorf("cannot delete in read-only transaction")
 	}
+	if err := txn.checkWritable(table); err != nil {
+		return err
+	}
+	if isSystemTable(table) {
+		return fmt.Errorf("cannot delete in read-only system table '%s'", table)
+	}
 
 	// Get the table schema
-	tableSchema, ok := txn.db.schema.Tables[table]
+	tableSchema, ok := txn.schema.Tables[table]
 	if !ok {
 		return fmt.Errorf("invalid table '%s'", table)
 	}
@@ -364,6 +730,14 @@ func (txn *Txn) Delete(table string, obj interface{}) error {
 	if !ok {
 		return ErrNotFound
 	}
+	existingStored := existing
+	if existing, err = txn.resolve(table, existing); err != nil {
+		return err
+	}
+
+	if err := txn.db.injectFault(FaultIndex, table); err != nil {
+		return err
+	}
 
 	// Remove the object from all the indexes
 	for name, indexSchema := range tableSchema.Indexes {
@@ -377,14 +751,7 @@ func (txn *Txn) Delete(table string, obj interface{}) error {
 			vals [][]byte
 			err  error
 		)
-		switch indexer := indexSchema.Indexer.(type) {
-		case SingleIndexer:
-			var val []byte
-			ok, val, err = indexer.FromObject(existing)
-			vals = [][]byte{val}
-		case MultiIndexer:
-			ok, vals, err = indexer.FromObject(existing)
-		}
+		ok, vals, err = indexValues(indexSchema, existing)
 		if err != nil {
 			return fmt.Errorf("failed to build index '%s': %v", name, err)
 		}
@@ -392,14 +759,25 @@ func (txn *Txn) Delete(table string, obj interface{}) error {
 			// Handle non-unique index by computing a unique index.
 			// This is done by appending the primary key which must
 			// be unique anyways.
+			deleted := 0
 			for _, val := range vals {
 				if !indexSchema.Unique {
 					val = append(val, idVal...)
 				}
-				indexTxn.Delete(val)
+				owns, err := txn.ownsEntry(table, indexSchema, indexTxn, val, idVal)
+				if err != nil {
+					return err
+				}
+				if owns {
+					indexTxn.Delete(val)
+					deleted++
+				}
 			}
+			txn.countWrite(table, name, 0, deleted)
 		}
 	}
+	txn.countObject()
+	txn.releaseBlob(tableSchema, existingStored)
 	if txn.changes != nil {
 		txn.changes = append(txn.changes, Change{
 			Table:      table,
@@ -408,55 +786,131 @@ func (txn *Txn) Delete(table string, obj interface{}) error {
 			primaryKey: idVal,
 		})
 	}
-	return nil
+	if err := txn.updateAggregates(table, existing, nil); err != nil {
+		return err
+	}
+	return txn.deleteReferences(tableSchema, existing)
 }
 
 // DeletePrefix is used to delete an entire subtree based on a prefix.
 // The given index must be a prefix index, and will be used to perform a scan and enumerate the set of objects to delete.
 // These will be removed from all other indexes, and then a special prefix operation will delete the objects from the given index in an efficient subtree delete operation.
 // This is useful when you have a very large number of objects indexed by the given index, along with a much smaller number of entries in the other indexes for those objects.
-func (txn *Txn) DeletePrefix(table string, prefix_index string, prefix string) (bool, error) {
+// An empty prefix on the primary index, "id_prefix", empties every index of the table at once instead.
+// Any other prefix on the primary index drops the subtree of the objects from it without reading them, and records their deletion in the changes lazily, so a table with no other index only walks the subtree to count them; their entries in the other indexes are removed one at a time.
+// With a prefix on any other index, each object is also read and recorded in the changes one at a time.
+func (txn *Txn) DeletePrefix(table string, prefix_index string, prefix string) (ok bool, err error) {
+	defer txn.recoverPanic("delete prefix", &err)
+	num, err := txn.deletePrefix(table, prefix_index, prefix)
+	if err != nil {
+		return num > 0, err
+	}
+	return num > 0, txn.checkLimits()
+}
+
+// DeletePrefixCount is like DeletePrefix, but returns the number of objects
+// deleted. As with DeletePrefix, the deletions are recorded in the changes
+// of the transaction.
+//
+// DeletePrefixCount 按前缀整体删除子树，并返回删除的对象数。
+func (txn *Txn) DeletePrefixCount(table, prefix_index, prefix string) (num int, err error) {
+	defer txn.recoverPanic("delete prefix", &err)
+	if num, err = txn.deletePrefix(table, prefix_index, prefix); err != nil {
+		return num, err
+	}
+	return num, txn.checkLimits()
+}
+
+// deletePrefix does the work of DeletePrefix, and returns the number of
+// objects deleted.
+func (txn *Txn) deletePrefix(table string, prefix_index string, prefix string) (int, error) {
 	if !txn.write {
-		return false, fmt.Errorf("cannot delete in read-only transaction")
+		return 0, fmt.Errorf("cannot delete in read-only transaction")
+	}
+	if err := txn.checkWritable(table); err != nil {
+		return 0, err
+	}
+	if isSystemTable(table) {
+		return 0, fmt.Errorf("cannot delete in read-only system table '%s'", table)
 	}
 
 	if !strings.HasSuffix(prefix_index, "_prefix") {
-		return false, fmt.Errorf("Index name for DeletePrefix must be a prefix index, Got %v ", prefix_index)
+		return 0, fmt.Errorf("Index name for DeletePrefix must be a prefix index, Got %v ", prefix_index)
 	}
 
 	deletePrefixIndex := strings.TrimSuffix(prefix_index, "_prefix")
 
-	// Get an iterator over all of the keys with the given prefix.
-	entries, err := txn.Get(table, prefix_index, prefix)
+	// References to the deleted objects are handled one object at a time
+	if len(txn.referencesTo(table)) > 0 {
+		return txn.deleteAll(table, prefix_index, prefix)
+	}
+
+	// Deleting everything from the primary index drops the whole table
+	if deletePrefixIndex == id && prefix == "" {
+		if _, ok := txn.schema.Tables[table]; !ok {
+			return 0, fmt.Errorf("invalid table '%s'", table)
+		}
+		return txn.truncate(table)
+	}
+
+	// The subtree deleted is the one the prefix index is searched with,
+	// which the indexer may have normalized.
+	_, prefixVal, err := txn.getIndexValue(table, prefix_index, prefix)
 	if err != nil {
-		return false, fmt.Errorf("failed kvs lookup: %s", err)
+		return 0, fmt.Errorf("failed kvs lookup: %s", err)
 	}
+
 	// Get the table schema
-	tableSchema, ok := txn.db.schema.Tables[table]
+	tableSchema, ok := txn.schema.Tables[table]
 	if !ok {
-		return false, fmt.Errorf("invalid table '%s'", table)
+		return 0, fmt.Errorf("invalid table '%s'", table)
+	}
+	if deletePrefixIndex == id {
+		return txn.deleteIDPrefix(tableSchema, prefixVal)
 	}
 
-	foundAny := false
+	// Get an iterator over all of the keys with the given prefix.
+	entries, err := txn.Get(table, prefix_index, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed kvs lookup: %s", err)
+	}
+
+	// An object appears once for each of its values under the prefix on a
+	// multi-value index, but is only deleted once.
+	var seen map[string]struct{}
+	if _, multi := tableSchema.Indexes[deletePrefixIndex].Indexer.(MultiIndexer); multi {
+		seen = make(map[string]struct{})
+	}
+
+	entryCount := 0
+	found := 0
 	for entry := entries.Next(); entry != nil; entry = entries.Next() {
-		if !foundAny {
-			foundAny = true
-		}
+		entryCount++
+
 		// Get the primary ID of the object
 		idSchema := tableSchema.Indexes[id]
 		idIndexer := idSchema.Indexer.(SingleIndexer)
 		ok, idVal, err := idIndexer.FromObject(entry)
 		if err != nil {
-			return false, fmt.Errorf("failed to build primary index: %v", err)
+			return found, fmt.Errorf("failed to build primary index: %v", err)
 		}
 		if !ok {
-			return false, fmt.Errorf("object missing primary index")
+			return found, fmt.Errorf("object missing primary index")
 		}
-		if txn.changes != nil {
-			// Record the deletion
-			idTxn := txn.writableIndex(table, id)
-			existing, ok := idTxn.Get(idVal)
-			if ok {
+		if seen != nil {
+			if _, dup := seen[string(idVal)]; dup {
+				continue
+			}
+			seen[string(idVal)] = struct{}{}
+		}
+		idTxn := txn.writableIndex(table, id)
+		if existing, ok := idTxn.Get(idVal); ok {
+			txn.releaseBlob(tableSchema, existing)
+			if txn.changes != nil {
+				// Record the deletion
+				if existing, err = txn.resolve(table, existing); err != nil {
+					return found, err
+				}
 				txn.changes = append(txn.changes, Change{
 					Table:      table,
 					Before:     existing,
@@ -465,62 +919,168 @@ func (txn *Txn) DeletePrefix(table string, prefix_index string, prefix string) (
 				})
 			}
 		}
-		// Remove the object from all the indexes except the given prefix index
-		for name, indexSchema := range tableSchema.Indexes {
-			if name == deletePrefixIndex {
-				continue
-			}
-			indexTxn := txn.writableIndex(table, name)
+		// Remove the object from all the indexes, except for its entries
+		// under the prefix, which go with the subtree
+		if err := txn.deleteEntries(tableSchema, entry, idVal, deletePrefixIndex, prefixVal); err != nil {
+			return found, err
+		}
+		txn.countObject()
+		found++
 
-			// Handle the update by deleting from the index first
-			var (
-				ok   bool
-				vals [][]byte
-				err  error
-			)
-			switch indexer := indexSchema.Indexer.(type) {
-			case SingleIndexer:
-				var val []byte
-				ok, val, err = indexer.FromObject(entry)
-				vals = [][]byte{val}
-			case MultiIndexer:
-				ok, vals, err = indexer.FromObject(entry)
+	}
+	if err := IteratorErr(entries); err != nil {
+		return found, err
+	}
+	if entryCount > 0 {
+		indexTxn := txn.writableIndex(table, deletePrefixIndex)
+		ok = indexTxn.DeletePrefix(prefixVal)
+		if !ok {
+			panic(fmt.Errorf("prefix %v matched some entries but DeletePrefix did not delete any ", prefix))
+		}
+		txn.countWrite(table, deletePrefixIndex, 0, entryCount)
+		if err := txn.recomputeAggregates(table); err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+// deleteEntries removes the entries of an object from the indexes of the
+// table, except for its entries under the prefix of the given index, which
+// are deleted with their subtree.
+func (txn *Txn) deleteEntries(tableSchema *TableSchema, obj interface{}, idVal []byte, index string, prefix []byte) error {
+	table := tableSchema.Name
+	for name, indexSchema := range tableSchema.Indexes {
+		indexTxn := txn.writableIndex(table, name)
+
+		// Handle the update by deleting from the index first
+		ok, vals, err := indexValues(indexSchema, obj)
+		if err != nil {
+			return fmt.Errorf("failed to build index '%s': %v", name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		deleted := 0
+		for _, val := range vals {
+			// Handle non-unique index by computing a unique index.
+			// This is done by appending the primary key which must
+			// be unique anyways.
+			if !indexSchema.Unique {
+				val = append(val, idVal...)
 			}
+			if name == index && bytes.HasPrefix(val, prefix) {
+				continue
+			}
+			owns, err := txn.ownsEntry(table, indexSchema, indexTxn, val, idVal)
 			if err != nil {
-				return false, fmt.Errorf("failed to build index '%s': %v", name, err)
+				return err
 			}
-
-			if ok {
-				// Handle non-unique index by computing a unique index.
-				// This is done by appending the primary key which must
-				// be unique anyways.
-				for _, val := range vals {
-					if !indexSchema.Unique {
-						val = append(val, idVal...)
-					}
-					indexTxn.Delete(val)
-				}
+			if !owns {
+				continue
 			}
+			indexTxn.Delete(val)
+			deleted++
 		}
-
+		txn.countWrite(table, name, 0, deleted)
 	}
-	if foundAny {
-		indexTxn := txn.writableIndex(table, deletePrefixIndex)
-		ok = indexTxn.DeletePrefix([]byte(prefix))
-		if !ok {
-			panic(fmt.Errorf("prefix %v matched some entries but DeletePrefix did not delete any ", prefix))
+	return nil
+}
+
+// deleteIDPrefix deletes the objects whose primary key starts with the given
+// prefix. Their subtree of the primary index is dropped in a single
+// operation, and as with truncate, the deletions are recorded as the
+// detached primary index and only expanded when Changes is called, unless the
+// objects have to be resolved. Only the entries of the objects in the other
+// indexes, if the table has any, are deleted one at a time.
+func (txn *Txn) deleteIDPrefix(tableSchema *TableSchema, prefix []byte) (int, error) {
+	table := tableSchema.Name
+	txn.trackPrefixRead(table, id, prefix)
+	before := txn.readableIndex(table, id).CommitOnly()
+	resolve := txn.resolver(table)
+	perObject := len(tableSchema.Indexes) > 1 || (txn.changes != nil && resolve != nil)
+
+	num := 0
+	var err error
+	before.Root().WalkPrefix(prefix, func(idVal []byte, obj interface{}) bool {
+		num++
+		txn.releaseBlob(tableSchema, obj)
+		if !perObject {
+			return false
 		}
-		return true, nil
+		if resolve != nil {
+			if obj, err = resolve(obj); err != nil {
+				return true
+			}
+			if txn.changes != nil {
+				txn.changes = append(txn.changes, Change{
+					Table:      table,
+					Before:     obj,
+					After:      nil,
+					primaryKey: idVal,
+				})
+			}
+		}
+		err = txn.deleteEntries(tableSchema, obj, idVal, id, prefix)
+		return err != nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return false, nil
+	if num == 0 {
+		return 0, nil
+	}
+	if txn.changes != nil && resolve == nil {
+		txn.changes = append(txn.changes, Change{
+			Table:      table,
+			primaryKey: prefix,
+			truncated:  before,
+		})
+	}
+
+	txn.writableIndex(table, id).DeletePrefix(prefix)
+	txn.countWrite(table, id, 0, num)
+	txn.countObjects(num)
+	return num, txn.recomputeAggregates(table)
 }
 
 // DeleteAll is used to delete all the objects in a given table
-// matching the constraints on the index
-func (txn *Txn) DeleteAll(table, index string, args ...interface{}) (int, error) {
+// matching the constraints on the index. Deleting with an empty prefix of
+// the primary index, as in DeleteAll(table, "id_prefix", ""), empties the
+// whole table at once, and any other prefix of the primary index deletes
+// its subtree at once as DeletePrefix does. Any other constraints delete the
+// objects one by one.
+func (txn *Txn) DeleteAll(table, index string, args ...interface{}) (num int, err error) {
+	defer txn.recoverPanic("delete all", &err)
+	if num, err = txn.deleteAll(table, index, args...); err != nil {
+		return num, err
+	}
+	return num, txn.checkLimits()
+}
+
+// deleteAll does the work of DeleteAll.
+func (txn *Txn) deleteAll(table, index string, args ...interface{}) (int, error) {
 	if !txn.write {
 		return 0, fmt.Errorf("cannot delete in read-only transaction")
 	}
+	if err := txn.checkWritable(table); err != nil {
+		return 0, err
+	}
+
+	// A prefix matching all of the primary index drops the whole table
+	if index == id+"_prefix" && !isSystemTable(table) {
+		_, val, err := txn.getIndexValue(table, index, args...)
+		if err != nil {
+			return 0, err
+		}
+		if len(txn.referencesTo(table)) == 0 {
+			if len(val) == 0 {
+				return txn.truncate(table)
+			}
+			return txn.deleteIDPrefix(txn.schema.Tables[table], val)
+		}
+	}
 
 	// Get all the objects
 	iter, err := txn.Get(table, index, args...)
@@ -539,6 +1099,9 @@ func (txn *Txn) DeleteAll(table, index string, args ...interface{}) (int, error)
 
 		objs = append(objs, obj)
 	}
+	if err := IteratorErr(iter); err != nil {
+		return 0, err
+	}
 
 	// Do the deletes
 	num := 0
@@ -551,6 +1114,182 @@ func (txn *Txn) DeleteAll(table, index string, args ...interface{}) (int, error)
 	return num, nil
 }
 
+// truncate deletes every object in the table and returns how many there
+// were. Rather than deleting the objects one by one from each index, every
+// index tree is emptied in a single operation, which doesn't build index
+// values or copy paths of the trees. On the primary DB the emptied trees are
+// still walked so that watches on them fire at commit, but snapshots just
+// swap in empty trees. With change tracking, the deletions are recorded as
+// the detached primary index, and only expanded when Changes is called.
+// Objects that have to be fetched from a BlobStore or decompressed are the
+// exception, as they are resolved right away so errors can be returned.
+//
+// truncate 通过整体清空索引树来删除表中所有对象，而不是逐条删除。
+func (txn *Txn) truncate(table string) (int, error) {
+	// Reading the size of a tree is cheap, unlike counting the leaves of
+	// a transaction.
+	before := txn.readableIndex(table, id).CommitOnly()
+	num := before.Len()
+	if num == 0 {
+		return 0, nil
+	}
+	if txn.changes != nil {
+		if txn.resolver(table) == nil {
+			txn.changes = append(txn.changes, Change{
+				Table:     table,
+				truncated: before,
+			})
+		} else {
+			iter := before.Root().Iterator()
+			for key, raw, ok := iter.Next(); ok; key, raw, ok = iter.Next() {
+				obj, err := txn.resolve(table, raw)
+				if err != nil {
+					return 0, err
+				}
+				txn.changes = append(txn.changes, Change{
+					Table:      table,
+					Before:     obj,
+					After:      nil,
+					primaryKey: key,
+				})
+			}
+		}
+	}
+	txn.releaseBlobs(txn.schema.Tables[table], before)
+
+	for name := range txn.schema.Tables[table].Indexes {
+		size := txn.readableIndex(table, name).CommitOnly().Len()
+		if txn.db.primary {
+			txn.writableIndex(table, name).DeletePrefix(nil)
+		} else {
+			if txn.modified == nil {
+				txn.modified = make(map[tableIndex]*iradix.Txn)
+			}
+			txn.modified[tableIndex{table, name}] = iradix.New().Txn()
+		}
+		txn.countWrite(table, name, 0, size)
+	}
+	txn.countObjects(num)
+	txn.resetAggregates(table)
+	return num, nil
+}
+
+// expandTruncated replaces the changes recorded for truncated tables with a
+// deletion of each object they held, in place. Those tables never hold
+// objects that need resolving, see truncate.
+func (txn *Txn) expandTruncated() {
+	var cs Changes
+	for i, m := range txn.changes {
+		if m.truncated == nil {
+			if cs != nil {
+				cs = append(cs, m)
+			}
+			continue
+		}
+		if cs == nil {
+			size := len(txn.changes)
+			if m.primaryKey == nil {
+				size += m.truncated.Len()
+			}
+			cs = append(make(Changes, 0, size), txn.changes[:i]...)
+		}
+		iter := m.truncated.Root().Iterator()
+		iter.SeekPrefix(m.primaryKey)
+		for key, obj, ok := iter.Next(); ok; key, obj, ok = iter.Next() {
+			cs = append(cs, Change{
+				Table:      m.Table,
+				Before:     obj,
+				After:      nil,
+				primaryKey: key,
+			})
+		}
+	}
+	if cs != nil {
+		txn.changes = cs
+	}
+}
+
+// mutations returns every change made by the transaction so far, in the
+// order they were made and without collapsing the ones made to the same
+// object. Unlike Changes, replaying them one at a time goes through the same
+// intermediate states as the transaction, so the constraints checked along
+// the way hold.
+func (txn *Txn) mutations() Changes {
+	txn.expandTruncated()
+	return txn.changes
+}
+
+// UpdateFunc returns an updated copy of obj for UpdateWhere, or nil to leave
+// obj as it is. obj itself must not be modified.
+type UpdateFunc func(obj interface{}) (interface{}, error)
+
+// UpdateWhere is used to update all the objects in a given table matching
+// the constraints on the index. Each object is passed to fn and the copy it
+// returns is inserted in its place. The primary key of an object can't be
+// changed this way. Indexes whose values are the same for the old and new
+// copy are not deleted from. The number of objects updated is returned.
+func (txn *Txn) UpdateWhere(table, index string, args []interface{}, fn UpdateFunc) (num int, err error) {
+	defer txn.recoverPanic("update where", &err)
+	return txn.updateWhere(table, index, args, fn)
+}
+
+// updateWhere does the work of UpdateWhere.
+func (txn *Txn) updateWhere(table, index string, args []interface{}, fn UpdateFunc) (int, error) {
+	if !txn.write {
+		return 0, fmt.Errorf("cannot update in read-only transaction")
+	}
+	if err := txn.checkWritable(table); err != nil {
+		return 0, err
+	}
+
+	// Get all the objects
+	iter, err := txn.Get(table, index, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	// Put them into a slice so there are no safety concerns while actually
+	// performing the updates
+	var objs []interface{}
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		objs = append(objs, obj)
+	}
+	if err := IteratorErr(iter); err != nil {
+		return 0, err
+	}
+
+	// Do the updates
+	idIndexer := txn.schema.Tables[table].Indexes[id].Indexer.(SingleIndexer)
+	num := 0
+	for _, obj := range objs {
+		updated, err := fn(obj)
+		if err != nil {
+			return num, err
+		}
+		if updated == nil {
+			continue
+		}
+
+		_, oldID, err := idIndexer.FromObject(obj)
+		if err != nil {
+			return num, fmt.Errorf("failed to build primary index: %v", err)
+		}
+		_, newID, err := idIndexer.FromObject(updated)
+		if err != nil {
+			return num, fmt.Errorf("failed to build primary index: %v", err)
+		}
+		if !bytes.Equal(oldID, newID) {
+			return num, fmt.Errorf("update changed the primary key")
+		}
+
+		if err := txn.Insert(table, updated); err != nil {
+			return num, err
+		}
+		num++
+	}
+	return num, nil
+}
+
 // FirstWatch is used to return the first matching object for
 // the given constraints on the index along with the watch channel
 func (txn *Txn) FirstWatch(table, index string, args ...interface{}) (<-chan struct{}, interface{}, error) {
@@ -559,6 +1298,7 @@ func (txn *Txn) FirstWatch(table, index string, args ...interface{}) (<-chan str
 	if err != nil {
 		return nil, nil, err
 	}
+	txn.trackPrefixRead(table, indexSchema.Name, val)
 
 	// Get the index itself
 	indexTxn := txn.readableIndex(table, indexSchema.Name)
@@ -569,14 +1309,19 @@ func (txn *Txn) FirstWatch(table, index string, args ...interface{}) (<-chan str
 		if !ok {
 			return watch, nil, nil
 		}
-		return watch, obj, nil
+		obj, err = txn.resolve(table, obj)
+		return watch, obj, err
 	}
 
 	// Handle non-unique index by using an iterator and getting the first value
 	iter := indexTxn.Root().Iterator()
 	watch := iter.SeekPrefixWatch(val)
-	_, value, _ := iter.Next()
-	return watch, value, nil
+	_, value, ok := iter.Next()
+	if !ok {
+		return watch, nil, nil
+	}
+	value, err = txn.resolve(table, value)
+	return watch, value, err
 }
 
 // LastWatch is used to return the last matching object for
@@ -587,6 +1332,7 @@ func (txn *Txn) LastWatch(table, index string, args ...interface{}) (<-chan stru
 	if err != nil {
 		return nil, nil, err
 	}
+	txn.trackPrefixRead(table, indexSchema.Name, val)
 
 	// Get the index itself
 	indexTxn := txn.readableIndex(table, indexSchema.Name)
@@ -597,14 +1343,19 @@ func (txn *Txn) LastWatch(table, index string, args ...interface{}) (<-chan stru
 		if !ok {
 			return watch, nil, nil
 		}
-		return watch, obj, nil
+		obj, err = txn.resolve(table, obj)
+		return watch, obj, err
 	}
 
 	// Handle non-unique index by using an iterator and getting the last value
 	iter := indexTxn.Root().ReverseIterator()
 	watch := iter.SeekPrefixWatch(val)
-	_, value, _ := iter.Previous()
-	return watch, value, nil
+	_, value, ok := iter.Previous()
+	if !ok {
+		return watch, nil, nil
+	}
+	value, err = txn.resolve(table, value)
+	return watch, value, err
 }
 
 // First is used to return the first matching object for
@@ -647,9 +1398,10 @@ func (txn *Txn) LongestPrefix(table, index string, args ...interface{}) (interfa
 	}
 
 	// Find the longest prefix match with the given index.
+	txn.trackRead(table, indexSchema.Name, nil, nil)
 	indexTxn := txn.readableIndex(table, indexSchema.Name)
 	if _, value, ok := indexTxn.Root().LongestPrefix(val); ok {
-		return value, nil
+		return txn.resolve(table, value)
 	}
 	return nil, nil
 }
@@ -660,11 +1412,24 @@ func (txn *Txn) LongestPrefix(table, index string, args ...interface{}) (interfa
 // prefix iteration.
 func (txn *Txn) getIndexValue(table, index string, args ...interface{}) (*IndexSchema, []byte, error) {
 	// Get the table schema
-	tableSchema, ok := txn.db.schema.Tables[table]
+	tableSchema, ok := txn.tableSchema(table)
 	if !ok {
 		return nil, nil, fmt.Errorf("invalid table '%s'", table)
 	}
+	indexSchema, val, err := indexValue(tableSchema, index, args...)
+	if err != nil {
+		return indexSchema, val, err
+	}
+	txn.countRead(table, indexSchema.Name)
+	if indexSchema.Deprecated {
+		err = txn.queryDeprecated(table, indexSchema.Name)
+	}
+	return indexSchema, val, err
+}
 
+// indexValue does the work of getIndexValue once the schema of the table is
+// known.
+func indexValue(tableSchema *TableSchema, index string, args ...interface{}) (*IndexSchema, []byte, error) {
 	// Check for a prefix scan
 	prefixScan := false
 	if strings.HasSuffix(index, "_prefix") {
@@ -719,6 +1484,9 @@ func (txn *Txn) getIndexValue(table, index string, args ...interface{}) (*IndexS
 // by Next. However, an iterator created after a call to Insert or Delete will
 // reflect the modifications.
 //
+// Transactions using the IterateLive mode set with SetIterationMode return
+// iterators that observe the changes instead.
+//
 // When a ResultIterator is created from a write transaction, and there are already
 // modifications to the index used by the iterator, the modification cache of the
 // index will be invalidated. This may result in some additional allocations if
@@ -743,18 +1511,37 @@ type ResultIterator interface {
 // See the documentation for ResultIterator to understand the behaviour of the
 // returned ResultIterator.
 func (txn *Txn) Get(table, index string, args ...interface{}) (ResultIterator, error) {
+	// Scans of the primary index of inline tables read the rows directly
+	if !txn.iterateLive(table) && txn.isInlineTable(table) && strings.TrimSuffix(index, "_prefix") == id {
+		_, val, err := txn.getIndexValue(table, index, args...)
+		if err != nil {
+			return nil, err
+		}
+		txn.trackPrefixRead(table, index, val)
+		if iter := txn.getInline(table, val); iter != nil {
+			return iter, nil
+		}
+	}
+
 	indexIter, val, err := txn.getIndexIterator(table, index, args...)
 	if err != nil {
 		return nil, err
 	}
+	txn.trackPrefixRead(table, index, val)
 
 	// Seek the iterator to the appropriate sub-set
 	watchCh := indexIter.SeekPrefixWatch(val)
+	pos := cursorPos{table: table, index: strings.TrimSuffix(index, "_prefix"), prefix: val, key: val}
+	if txn.iterateLive(table) {
+		return txn.newLiveIterator(pos, watchCh), nil
+	}
 
 	// Create an iterator
 	iter := &radixIterator{
 		iter:    indexIter,
+		pos:     pos,
 		watchCh: watchCh,
+		opts:    txn.iteratorOptions(table, index),
 	}
 	return iter, nil
 }
@@ -771,14 +1558,22 @@ func (txn *Txn) GetReverse(table, index string, args ...interface{}) (ResultIter
 	if err != nil {
 		return nil, err
 	}
+	txn.trackPrefixRead(table, index, val)
 
 	// Seek the iterator to the appropriate sub-set
 	watchCh := indexIter.SeekPrefixWatch(val)
+	pos := cursorPos{table: table, index: strings.TrimSuffix(index, "_prefix"), prefix: val,
+		reverse: true, key: prefixEnd(val), after: true}
+	if txn.iterateLive(table) {
+		return txn.newLiveIterator(pos, watchCh), nil
+	}
 
 	// Create an iterator
 	iter := &radixReverseIterator{
 		iter:    indexIter,
+		pos:     pos,
 		watchCh: watchCh,
+		opts:    txn.iteratorOptions(table, index),
 	}
 	return iter, nil
 }
@@ -798,12 +1593,20 @@ func (txn *Txn) LowerBound(table, index string, args ...interface{}) (ResultIter
 		return nil, err
 	}
 
+	txn.trackRead(table, index, val, nil)
+	pos := cursorPos{table: table, index: strings.TrimSuffix(index, "_prefix"), key: val}
+	if txn.iterateLive(table) {
+		return txn.newLiveIterator(pos, nil), nil
+	}
+
 	// Seek the iterator to the appropriate sub-set
 	indexIter.SeekLowerBound(val)
 
 	// Create an iterator
 	iter := &radixIterator{
-		iter: indexIter,
+		iter:    indexIter,
+		pos:     pos,
+		opts:    txn.iteratorOptions(table, index),
 	}
 	return iter, nil
 }
@@ -824,12 +1627,20 @@ func (txn *Txn) ReverseLowerBound(table, index string, args ...interface{}) (Res
 		return nil, err
 	}
 
+	txn.trackRead(table, index, nil, prefixEnd(val))
+	pos := cursorPos{table: table, index: strings.TrimSuffix(index, "_prefix"), reverse: true, key: val}
+	if txn.iterateLive(table) {
+		return txn.newLiveIterator(pos, nil), nil
+	}
+
 	// Seek the iterator to the appropriate sub-set
 	indexIter.SeekReverseLowerBound(val)
 
 	// Create an iterator
 	iter := &radixReverseIterator{
-		iter: indexIter,
+		iter:    indexIter,
+		pos:     pos,
+		opts:    txn.iteratorOptions(table, index),
 	}
 	return iter, nil
 }
@@ -866,6 +1677,7 @@ func (txn *Txn) Changes() Changes {
 	if txn.changes == nil {
 		return nil
 	}
+	txn.expandTruncated()
 
 	// De-duplicate mutations by key so all take effect at the point of the last
 	// write but we keep the mutations in order.
@@ -914,8 +1726,6 @@ func (txn *Txn) Changes() Changes {
 			cs = append(cs, m)
 		}
 	}
-	// Store the de-duped version in case this is called again
-	txn.changes = cs
 	return cs
 }
 
@@ -959,43 +1769,120 @@ func (txn *Txn) Defer(fn func()) {
 	txn.after = append(txn.after, fn)
 }
 
+// iteratorOptions is the optional state of the iterators over an index,
+// which is only allocated when it's needed.
+type iteratorOptions struct {
+	// resolve, if set, converts the stored values into objects.
+	resolve func(interface{}) (interface{}, error)
+	// stats, if set, counts the entries visited.
+	stats *QueryStats
+	// err is the error that ended the iteration, if any.
+	err error
+}
+
+// iteratorOptions returns the optional state of an iterator over the given
+// index, or nil if it needs none.
+func (txn *Txn) iteratorOptions(table, index string) *iteratorOptions {
+	resolve := txn.resolver(table)
+	stats := txn.newQueryStats(table, index)
+	if resolve == nil && stats == nil {
+		return nil
+	}
+	return &iteratorOptions{resolve: resolve, stats: stats}
+}
+
+// value returns the object for a value returned by an iterator, counting it
+// if the iterator has stats. It returns nil, ending the iteration, if the
+// value can't be resolved.
+func (o *iteratorOptions) value(value interface{}) interface{} {
+	if o == nil {
+		return value
+	}
+	o.stats.visit()
+	if o.resolve != nil {
+		obj, err := o.resolve(value)
+		if err != nil {
+			o.err = err
+			return nil
+		}
+		return obj
+	}
+	return value
+}
+
+// Err returns the error that ended the iteration, if any.
+func (o *iteratorOptions) Err() error {
+	if o == nil {
+		return nil
+	}
+	return o.err
+}
+
 // radixIterator is used to wrap an underlying iradix iterator.
 // This is much more efficient than a sliceIterator as we are not
 // materializing the entire view.
 type radixIterator struct {
 	iter    *iradix.Iterator
 	watchCh <-chan struct{}
+
+	// pos is the position of the iterator, returned by Cursor.
+	pos cursorPos
+
+	// opts is nil unless the iterator resolves values or counts them.
+	opts *iteratorOptions
 }
 
 func (r *radixIterator) WatchCh() <-chan struct{} {
 	return r.watchCh
 }
 
+func (r *radixIterator) Cursor() []byte {
+	return r.pos.Cursor()
+}
+
 func (r *radixIterator) Next() interface{} {
-	_, value, ok := r.iter.Next()
+	if r.opts.Err() != nil {
+		return nil
+	}
+	key, value, ok := r.iter.Next()
 	if !ok {
 		return nil
 	}
-	return value
+	r.pos.advance(key)
+	return r.opts.value(value)
 }
 
 type radixReverseIterator struct {
 	iter    *iradix.ReverseIterator
 	watchCh <-chan struct{}
+
+	// pos is the position of the iterator, returned by Cursor.
+	pos cursorPos
+
+	// opts is nil unless the iterator resolves values or counts them.
+	opts *iteratorOptions
 }
 
 func (r *radixReverseIterator) Next() interface{} {
-	_, value, ok := r.iter.Previous()
+	if r.opts.Err() != nil {
+		return nil
+	}
+	key, value, ok := r.iter.Previous()
 	if !ok {
 		return nil
 	}
-	return value
+	r.pos.advance(key)
+	return r.opts.value(value)
 }
 
 func (r *radixReverseIterator) WatchCh() <-chan struct{} {
 	return r.watchCh
 }
 
+func (r *radixReverseIterator) Cursor() []byte {
+	return r.pos.Cursor()
+}
+
 // Snapshot creates a snapshot of the current state of the transaction.
 // Returns a new read-only transaction or nil if the transaction is already
 // aborted or committed.
@@ -1006,15 +1893,20 @@ func (txn *Txn) Snapshot() *Txn {
 
 	snapshot := &Txn{
 		db:      txn.db,
+		schema:  txn.schema,
 		rootTxn: txn.rootTxn.Clone(),
 	}
 
 	// Commit sub-transactions into the snapshot
 	for key, subTxn := range txn.modified {
+		if txn.isInlineTable(key.Table) {
+			continue
+		}
 		path := indexPath(key.Table, key.Index)
 		final := subTxn.CommitOnly()
 		snapshot.rootTxn.Insert(path, final)
 	}
+	txn.commitInline(snapshot.rootTxn)
 
 	return snapshot
 }
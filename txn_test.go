@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func testDB(t *testing.T) *MemDB {
@@ -934,6 +935,193 @@ func TestTxn_DeleteAll_Prefix(t *testing.T) {
 	}
 }
 
+func TestTxn_DeleteAll_Table(t *testing.T) {
+	db := testDB(t)
+	txn := db.Txn(true)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: "abc", Qux: []string{id}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	watch, _, err := db.Txn(false).FirstWatch("main", "qux", "b")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn = db.Txn(true)
+	txn.TrackChanges()
+	num, err := txn.DeleteAll("main", "id_prefix", "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num != 3 {
+		t.Fatalf("bad: %d", num)
+	}
+
+	// Every index is empty
+	for _, index := range []string{"id", "foo", "qux"} {
+		raw, err := txn.First("main", index+"_prefix", "")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if raw != nil {
+			t.Fatalf("bad: %s %#v", index, raw)
+		}
+	}
+
+	// Objects can be inserted again, and changes are expanded lazily
+	obj := &TestObject{ID: "b", Foo: "xyz", Qux: []string{"b"}}
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	changes := txn.Changes()
+	if len(changes) != 3 {
+		t.Fatalf("bad: %#v", changes)
+	}
+	for i, id := range []string{"a", "c", "b"} {
+		if changes[i].Before.(*TestObject).ID != id {
+			t.Fatalf("bad: %d %#v", i, changes[i])
+		}
+	}
+	if !changes[2].Updated() || changes[2].After != obj {
+		t.Fatalf("bad: %#v", changes[2])
+	}
+	txn.Commit()
+
+	select {
+	case <-watch:
+	case <-time.After(time.Second):
+		t.Fatalf("should fire")
+	}
+
+	// Nothing left to delete, other than the new object
+	txn = db.Txn(true)
+	if ok, err := txn.DeletePrefix("main", "id_prefix", ""); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	if ok, err := txn.DeletePrefix("main", "id_prefix", ""); err != nil || ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	txn.Commit()
+}
+
+func TestTxn_DeleteAll_IDPrefix(t *testing.T) {
+	db := testDB(t)
+	txn := db.Txn(true)
+	for _, id := range []string{"t1/a", "t1/b", "t2/a"} {
+		if err := txn.Insert("main", &TestObject{ID: id, Foo: "abc", Qux: []string{id}}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(true)
+	txn.TrackChanges()
+	num, err := txn.DeleteAll("main", "id_prefix", "t1/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num != 2 {
+		t.Fatalf("bad: %d", num)
+	}
+
+	// The deletions are recorded as the detached subtree
+	if len(txn.changes) != 1 || txn.changes[0].truncated == nil {
+		t.Fatalf("bad: %#v", txn.changes)
+	}
+
+	// The other indexes only hold the object left
+	for _, index := range []string{"id", "foo", "qux"} {
+		iter, err := txn.Get("main", index+"_prefix", "")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var ids []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			ids = append(ids, raw.(*TestObject).ID)
+		}
+		if !reflect.DeepEqual(ids, []string{"t2/a"}) {
+			t.Fatalf("bad: %s %#v", index, ids)
+		}
+	}
+
+	changes := txn.Changes()
+	if len(changes) != 2 {
+		t.Fatalf("bad: %#v", changes)
+	}
+	for i, id := range []string{"t1/a", "t1/b"} {
+		if !changes[i].Deleted() || changes[i].Before.(*TestObject).ID != id {
+			t.Fatalf("bad: %d %#v", i, changes[i])
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(true)
+	if ok, err := txn.DeletePrefix("main", "id_prefix", "t1/"); err != nil || ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	if ok, err := txn.DeletePrefix("main", "id_prefix", "t2/"); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	txn.Commit()
+}
+
+func TestTxn_UpdateWhere(t *testing.T) {
+	db := testDB(t)
+	txn := db.Txn(true)
+
+	objs := []*TestObject{
+		&TestObject{ID: "a", Foo: "pending", Qux: []string{"a"}},
+		&TestObject{ID: "b", Foo: "pending", Qux: []string{"b"}},
+		&TestObject{ID: "c", Foo: "done", Qux: []string{"c"}},
+	}
+	for _, obj := range objs {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Flip the status of the pending objects, skipping one of them
+	num, err := txn.UpdateWhere("main", "foo", []interface{}{"pending"}, func(raw interface{}) (interface{}, error) {
+		obj := *raw.(*TestObject)
+		if obj.ID == "b" {
+			return nil, nil
+		}
+		obj.Foo = "done"
+		return &obj, nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num != 1 {
+		t.Fatalf("bad: %d", num)
+	}
+
+	iter, err := txn.Get("main", "foo", "done")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		ids = append(ids, raw.(*TestObject).ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "c"}) {
+		t.Fatalf("bad: %#v", ids)
+	}
+
+	// The primary key can't be changed
+	_, err = txn.UpdateWhere("main", "id", []interface{}{"b"}, func(raw interface{}) (interface{}, error) {
+		obj := *raw.(*TestObject)
+		obj.ID = "d"
+		return &obj, nil
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
 func TestTxn_DeletePrefix(t *testing.T) {
 	db := testDB(t)
 	txn := db.Txn(true)
@@ -1020,6 +1208,51 @@ func verifyNoResults(t *testing.T, txn *Txn, table string, index string, value s
 	}
 }
 
+func TestTxn_DeletePrefixCount(t *testing.T) {
+	db := testDB(t)
+	txn := db.Txn(true)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "a", Foo: "a", Qux: []string{"xyz1", "xyz2"}},
+		&TestObject{ID: "b", Foo: "b", Qux: []string{"xyz1", "abc"}},
+		&TestObject{ID: "c", Foo: "c", Qux: []string{"abc"}},
+	} {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Objects with several values under the prefix are deleted once, and
+	// their other values go too
+	txn = db.Txn(true)
+	txn.TrackChanges()
+	num, err := txn.DeletePrefixCount("main", "qux_prefix", "xyz")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num != 2 {
+		t.Fatalf("bad: %d", num)
+	}
+	changes := txn.Changes()
+	if len(changes) != 2 || !changes[0].Deleted() || !changes[1].Deleted() {
+		t.Fatalf("bad: %#v", changes)
+	}
+	iter, err := txn.Get("main", "qux", "abc")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if obj := iter.Next(); obj == nil || obj.(*TestObject).ID != "c" {
+		t.Fatalf("bad: %#v", obj)
+	}
+	if obj := iter.Next(); obj != nil {
+		t.Fatalf("bad: %#v", obj)
+	}
+	if num, err := txn.DeletePrefixCount("main", "qux_prefix", "xyz"); err != nil || num != 0 {
+		t.Fatalf("bad: %d %v", num, err)
+	}
+	txn.Commit()
+}
+
 func TestTxn_InsertGet_Prefix(t *testing.T) {
 	db := testDB(t)
 	txn := db.Txn(true)
@@ -2228,3 +2461,172 @@ func assertNilError(t *testing.T, err error) {
 		t.Fatalf("expected nil error, got %v", err)
 	}
 }
+
+func BenchmarkTxn_First(b *testing.B) {
+	db, err := NewMemDB(testValidSchema())
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for i := 0; i < 1000; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("obj-%d", i), Foo: "abc", Qux: []string{"abc"}}
+		if err := txn.Insert("main", obj); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Lookups in the same transaction reuse the index trees it found,
+	// while a new transaction has to find them first.
+	for _, shared := range []bool{true, false} {
+		b.Run(fmt.Sprintf("shared=%v", shared), func(b *testing.B) {
+			txn := db.Txn(false)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !shared {
+					txn = db.Txn(false)
+				}
+				if _, err := txn.First("main", "id", "obj-500"); err != nil {
+					b.Fatalf("err: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkTxn_Get(b *testing.B) {
+	db, err := NewMemDB(testValidSchema())
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for i := 0; i < 1000; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("obj-%d", i), Foo: "abc", Qux: []string{"abc"}}
+		if err := txn.Insert("main", obj); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iter, err := db.Txn(false).Get("main", "id", "obj-500")
+		if err != nil {
+			b.Fatalf("err: %v", err)
+		}
+		if iter.Next() == nil {
+			b.Fatalf("missing object")
+		}
+	}
+}
+
+func BenchmarkTxn_InsertUpdate(b *testing.B) {
+	db, err := NewMemDB(testValidSchema())
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for i := 0; i < 1000; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("obj-%d", i), Foo: "abc", Qux: []string{"abc", "def"}}
+		if err := txn.Insert("main", obj); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	// Only a field that isn't indexed changes
+	txn = db.Txn(true)
+	defer txn.Abort()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("obj-%d", i%1000), Foo: "abc", Bar: i, Qux: []string{"abc", "def"}}
+		if err := txn.Insert("main", obj); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+	}
+}
+
+func TestTxn_PartialIndex(t *testing.T) {
+	schema := &DBSchema{
+		Tables: map[string]*TableSchema{
+			"main": &TableSchema{
+				Name: "main",
+				Indexes: map[string]*IndexSchema{
+					"id": &IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &StringFieldIndex{Field: "ID"},
+					},
+					"unfinished": &IndexSchema{
+						Name:    "unfinished",
+						Indexer: &StringFieldIndex{Field: "Foo"},
+						Condition: func(obj interface{}) bool {
+							return obj.(*TestObject).Bar == 0
+						},
+					},
+				},
+			},
+		},
+	}
+	db, err := NewMemDB(schema)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "1", Foo: "a"},
+		&TestObject{ID: "2", Foo: "a", Bar: 1},
+		&TestObject{ID: "3", Foo: "b"},
+	} {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Objects failing the condition are skipped, even without AllowMissing
+	if err := txn.InsertBatch("main", []interface{}{&TestObject{ID: "4", Foo: "a", Bar: 1}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ids := func() []string {
+		iter, err := txn.Get("main", "unfinished")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var ids []string
+		for raw := iter.Next(); raw != nil; raw = iter.Next() {
+			ids = append(ids, raw.(*TestObject).ID)
+		}
+		return ids
+	}
+	if got := ids(); !reflect.DeepEqual(got, []string{"1", "3"}) {
+		t.Fatalf("bad: %#v", got)
+	}
+
+	// Updates move objects in and out of the index
+	if err := txn.Insert("main", &TestObject{ID: "1", Foo: "a", Bar: 1}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", &TestObject{ID: "2", Foo: "a"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := ids(); !reflect.DeepEqual(got, []string{"2", "3"}) {
+		t.Fatalf("bad: %#v", got)
+	}
+
+	if err := txn.Delete("main", &TestObject{ID: "3"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := ids(); !reflect.DeepEqual(got, []string{"2"}) {
+		t.Fatalf("bad: %#v", got)
+	}
+	txn.Commit()
+
+	schema.Tables["main"].Indexes["id"].Condition = func(interface{}) bool { return true }
+	if err := schema.Validate(); err == nil {
+		t.Fatalf("should not validate")
+	}
+}
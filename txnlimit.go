@@ -0,0 +1,71 @@
+package memdb
+
+import "fmt"
+
+// TxnLimits bounds the size of write transactions, so that a runaway loop
+// fails early instead of building a huge change set before it is committed.
+// A zero field means no limit.
+type TxnLimits struct {
+	// MaxOps is the maximum number of objects a transaction may insert,
+	// update or delete.
+	MaxOps int
+
+	// MaxBytes is the maximum total size of the index entries a transaction
+	// may insert, which grows with both the number and the size of the
+	// objects written.
+	MaxBytes int
+}
+
+// WithTxnLimits sets the limits enforced on every write transaction.
+func WithTxnLimits(limits TxnLimits) Option {
+	return func(db *MemDB) {
+		db.txnLimits = limits
+	}
+}
+
+// TxnLimitError is returned by the write methods of a Txn when the
+// transaction goes over one of its TxnLimits. The write that went over the
+// limit was already partly applied, so the transaction is aborted before
+// the error is returned.
+//
+// TxnLimitError 表示写事务超出了大小限制，事务已被回滚。
+type TxnLimitError struct {
+	// Limit is the limit that was exceeded, "ops" or "bytes".
+	Limit string
+
+	// Max is the value of the limit, and Size the size the transaction
+	// reached.
+	Max  int
+	Size int
+}
+
+func (e *TxnLimitError) Error() string {
+	return fmt.Sprintf("transaction exceeded its %s limit of %d with %d, transaction aborted",
+		e.Limit, e.Max, e.Size)
+}
+
+// countBytes adds the size of index entries inserted by the transaction.
+func (txn *Txn) countBytes(vals [][]byte) {
+	for _, val := range vals {
+		txn.limitBytes += len(val)
+	}
+}
+
+// checkLimits aborts the transaction and returns a *TxnLimitError if it went
+// over the limits of the DB.
+func (txn *Txn) checkLimits() error {
+	limits := txn.db.txnLimits
+	var err *TxnLimitError
+	switch {
+	case limits.MaxOps > 0 && txn.limitOps > limits.MaxOps:
+		err = &TxnLimitError{Limit: "ops", Max: limits.MaxOps, Size: txn.limitOps}
+	case limits.MaxBytes > 0 && txn.limitBytes > limits.MaxBytes:
+		err = &TxnLimitError{Limit: "bytes", Max: limits.MaxBytes, Size: txn.limitBytes}
+	default:
+		return nil
+	}
+	txn.AbortWithReason(err)
+	txn.db.logger.Warn("transaction exceeded its limits, transaction aborted",
+		"limit", err.Limit, "max", err.Max, "size", err.Size)
+	return err
+}
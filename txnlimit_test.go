@@ -0,0 +1,108 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTxn_Limits(t *testing.T) {
+	db, err := NewMemDB(testValidSchema(), WithTxnLimits(TxnLimits{MaxOps: 3}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for i := 0; i < 3; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("%d", i), Foo: "x", Qux: []string{"q"}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	err = txn.Insert("main", &TestObject{ID: "3", Foo: "x", Qux: []string{"q"}})
+	lerr, ok := err.(*TxnLimitError)
+	if !ok || lerr.Limit != "ops" || lerr.Max != 3 || lerr.Size != 4 {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	// The transaction was aborted and the writer lock released
+	txn = db.Txn(true)
+	if raw, err := txn.First("main", "id", "0"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+	txn.Abort()
+
+	// Deletes count too
+	db, err = NewMemDB(testValidSchema(), WithTxnLimits(TxnLimits{MaxOps: 2}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn = db.Txn(true)
+	for i := 0; i < 2; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("%d", i), Foo: "x", Qux: []string{"q"}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	txn = db.Txn(true)
+	if _, err := txn.DeleteAll("main", "foo", "x"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := txn.DeleteAll("main", "id"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"q"}}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestTxn_LimitsBytes(t *testing.T) {
+	db, err := NewMemDB(testValidSchema(), WithTxnLimits(TxnLimits{MaxBytes: 64}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	defer txn.Abort()
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"q"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	big := &TestObject{ID: "b", Foo: string(make([]byte, 64)), Qux: []string{"q"}}
+	err = txn.Insert("main", big)
+	if lerr, ok := err.(*TxnLimitError); !ok || lerr.Limit != "bytes" {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestTxn_LimitsCascade(t *testing.T) {
+	for _, action := range []ForeignKeyAction{Cascade, SetNull} {
+		db, err := NewMemDB(testForeignKeySchema(action), WithTxnLimits(TxnLimits{MaxOps: 2}))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		txn := db.Txn(true)
+		for _, team := range []string{"red", "blue"} {
+			if err := txn.Insert("teams", &testTeam{ID: team, Name: team}); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		txn.Commit()
+		txn = db.Txn(true)
+		for _, id := range []string{"a", "b"} {
+			if err := txn.Insert("members", &testMember{ID: id, Team: "red"}); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		txn.Commit()
+
+		// The children go over the limit, which is reported once the whole
+		// delete is done
+		txn = db.Txn(true)
+		err = txn.Delete("teams", &testTeam{ID: "red", Name: "red"})
+		if lerr, ok := err.(*TxnLimitError); !ok || lerr.Size != 3 {
+			t.Fatalf("bad: %#v", err)
+		}
+		if raw, err := db.Txn(false).First("teams", "id", "red"); err != nil || raw == nil {
+			t.Fatalf("bad: %#v %v", raw, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+//go:build go1.18
+// +build go1.18
+
+package memdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// TypedTable gives type-safe access to a table whose objects are all of type
+// T, normally a pointer to a struct, so callers don't have to assert the
+// type of every object read from it.
+//
+// TypedTable 为对象类型为 T 的表提供类型安全的访问。
+type TypedTable[T any] struct {
+	name string
+}
+
+// NewTypedTable returns a TypedTable for the given table of the schema.
+func NewTypedTable[T any](schema *DBSchema, table string) (*TypedTable[T], error) {
+	if _, ok := schema.Tables[table]; !ok {
+		return nil, fmt.Errorf("invalid table '%s'", table)
+	}
+	return &TypedTable[T]{name: table}, nil
+}
+
+// Name returns the name of the table.
+func (t *TypedTable[T]) Name() string {
+	return t.name
+}
+
+// Txn returns a TypedTxn operating on the table within the transaction.
+func (t *TypedTable[T]) Txn(txn *Txn) *TypedTxn[T] {
+	return &TypedTxn[T]{txn: txn, table: t.name}
+}
+
+// TypedTxn is a transaction restricted to a table whose objects are of type
+// T. Its methods work like the Txn methods of the same name.
+type TypedTxn[T any] struct {
+	txn   *Txn
+	table string
+}
+
+// Insert inserts or updates an object of the table.
+func (t *TypedTxn[T]) Insert(obj T) error {
+	return t.txn.Insert(t.table, obj)
+}
+
+// Delete deletes an object of the table.
+func (t *TypedTxn[T]) Delete(obj T) error {
+	return t.txn.Delete(t.table, obj)
+}
+
+// First returns the first object matching the arguments on the index, or the
+// zero value of T if there is none.
+func (t *TypedTxn[T]) First(index string, args ...interface{}) (T, error) {
+	raw, err := t.txn.First(t.table, index, args...)
+	if err != nil || raw == nil {
+		var zero T
+		return zero, err
+	}
+	return typedObject[T](t.table, raw)
+}
+
+// Last returns the last object matching the arguments on the index, or the
+// zero value of T if there is none.
+func (t *TypedTxn[T]) Last(index string, args ...interface{}) (T, error) {
+	raw, err := t.txn.Last(t.table, index, args...)
+	if err != nil || raw == nil {
+		var zero T
+		return zero, err
+	}
+	return typedObject[T](t.table, raw)
+}
+
+// Get returns an iterator over the objects matching the arguments on the
+// index.
+func (t *TypedTxn[T]) Get(index string, args ...interface{}) (*TypedResultIterator[T], error) {
+	iter, err := t.txn.Get(t.table, index, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedResultIterator[T]{iter: iter, table: t.table}, nil
+}
+
+// GetReverse returns an iterator over the objects matching the arguments on
+// the index, in reverse order.
+func (t *TypedTxn[T]) GetReverse(index string, args ...interface{}) (*TypedResultIterator[T], error) {
+	iter, err := t.txn.GetReverse(t.table, index, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedResultIterator[T]{iter: iter, table: t.table}, nil
+}
+
+// LowerBound returns an iterator over the objects whose value on the index
+// is greater than or equal to the arguments.
+func (t *TypedTxn[T]) LowerBound(index string, args ...interface{}) (*TypedResultIterator[T], error) {
+	iter, err := t.txn.LowerBound(t.table, index, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedResultIterator[T]{iter: iter, table: t.table}, nil
+}
+
+// ReverseLowerBound returns an iterator over the objects whose value on the
+// index is less than or equal to the arguments, in reverse order.
+func (t *TypedTxn[T]) ReverseLowerBound(index string, args ...interface{}) (*TypedResultIterator[T], error) {
+	iter, err := t.txn.ReverseLowerBound(t.table, index, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedResultIterator[T]{iter: iter, table: t.table}, nil
+}
+
+// TypedResultIterator is a ResultIterator returning objects of type T.
+type TypedResultIterator[T any] struct {
+	iter  ResultIterator
+	table string
+}
+
+// WatchCh returns a channel that is closed when the results change.
+func (i *TypedResultIterator[T]) WatchCh() <-chan struct{} {
+	return i.iter.WatchCh()
+}
+
+// WatchCtx waits for the results to change, or for the context to be done.
+func (i *TypedResultIterator[T]) WatchCtx(ctx context.Context) error {
+	return WatchCtx(ctx, i.iter.WatchCh())
+}
+
+// Cursor returns the cursor of the iterator. See CursorIterator.
+func (i *TypedResultIterator[T]) Cursor() []byte {
+	return iteratorCursor(i.iter)
+}
+
+// Err returns the error that ended the iteration early, if any. See
+// ErrIterator.
+func (i *TypedResultIterator[T]) Err() error {
+	return IteratorErr(i.iter)
+}
+
+// Next returns the next object, and false once there are no more. It panics
+// if the object isn't of type T, as that means the table was given the wrong
+// type.
+func (i *TypedResultIterator[T]) Next() (T, bool) {
+	raw := i.iter.Next()
+	if raw == nil {
+		var zero T
+		return zero, false
+	}
+	obj, err := typedObject[T](i.table, raw)
+	if err != nil {
+		panic(err)
+	}
+	return obj, true
+}
+
+// typedObject asserts that an object of the table is of type T.
+func typedObject[T any](table string, raw interface{}) (T, error) {
+	obj, ok := raw.(T)
+	if !ok {
+		return obj, fmt.Errorf("object of table '%s' is a %T, not a %T", table, raw, obj)
+	}
+	return obj, nil
+}
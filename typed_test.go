@@ -0,0 +1,75 @@
+//go:build go1.18
+// +build go1.18
+
+package memdb
+
+import "testing"
+
+func TestTypedTable(t *testing.T) {
+	db := testDB(t)
+	if _, err := NewTypedTable[*TestObject](testValidSchema(), "nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+	table, err := NewTypedTable[*TestObject](testValidSchema(), "main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	main := table.Txn(txn)
+	for _, obj := range []*TestObject{
+		&TestObject{ID: "a", Foo: "x", Qux: []string{"1"}},
+		&TestObject{ID: "b", Foo: "x", Qux: []string{"2"}},
+	} {
+		if err := main.Insert(obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	main = table.Txn(db.Txn(false))
+	obj, err := main.First("id", "a")
+	if err != nil || obj == nil || obj.ID != "a" {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+	if obj, err := main.Last("foo", "x"); err != nil || obj.ID != "b" {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+	if obj, err := main.First("id", "c"); err != nil || obj != nil {
+		t.Fatalf("bad: %#v %v", obj, err)
+	}
+
+	iter, err := main.GetReverse("foo", "x")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for obj, ok := iter.Next(); ok; obj, ok = iter.Next() {
+		ids = append(ids, obj.ID)
+	}
+	if len(ids) != 2 || ids[0] != "b" || ids[1] != "a" {
+		t.Fatalf("bad: %#v", ids)
+	}
+
+	// The latest objects up to a bound are read backwards
+	iter, err = main.ReverseLowerBound("id", "az")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	latest := NewLimitIterator(iter.iter, 1)
+	if raw := latest.Next(); raw == nil || raw.(*TestObject).ID != "a" {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if raw := latest.Next(); raw != nil {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// The wrong type is reported
+	wrong, err := NewTypedTable[TestObject](testValidSchema(), "main")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := wrong.Txn(db.Txn(false)).First("id", "a"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
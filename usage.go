@@ -0,0 +1,142 @@
+package memdb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// IndexUsage reports how much an index was used since the DB was created.
+type IndexUsage struct {
+	Table string
+	Index string
+
+	// Reads estimates the number of queries against the index. The first
+	// query is always counted, so Reads is only zero for indexes that were
+	// never queried.
+	Reads uint64
+
+	// Writes is the number of index entries inserted and deleted.
+	Writes uint64
+}
+
+// WithIndexUsage enables the tracking of index usage reported by IndexUsage
+// and UnusedIndexes. To keep the cost of queries low, only one query out of
+// sampleRate is counted after the first query against an index; a rate of 1
+// or less counts every query.
+func WithIndexUsage(sampleRate int) Option {
+	return func(db *MemDB) {
+		if sampleRate < 1 {
+			sampleRate = 1
+		}
+		db.indexUsage = &indexUsage{rate: uint64(sampleRate)}
+	}
+}
+
+// indexUsage holds the usage counters of the indexes.
+type indexUsage struct {
+	rate uint64
+
+	// seed spreads the samples of transactions that only make a few
+	// queries, so they don't all land on the same queries.
+	seed uint64
+
+	// counters maps a tableIndex to its *indexCounters.
+	counters sync.Map
+}
+
+// indexCounters are the usage counters of an index, accessed atomically.
+type indexCounters struct {
+	reads  uint64
+	writes uint64
+}
+
+// index returns the counters of an index, creating them if needed.
+func (u *indexUsage) index(table, index string) *indexCounters {
+	key := tableIndex{table, index}
+	if c, ok := u.counters.Load(key); ok {
+		return c.(*indexCounters)
+	}
+	c, _ := u.counters.LoadOrStore(key, &indexCounters{})
+	return c.(*indexCounters)
+}
+
+// countRead records a query against an index if usage tracking is enabled.
+func (txn *Txn) countRead(table, index string) {
+	u := txn.db.indexUsage
+	if u == nil || isSystemTable(table) {
+		return
+	}
+	c := u.index(table, index)
+	if atomic.LoadUint64(&c.reads) == 0 {
+		atomic.AddUint64(&c.reads, 1)
+		return
+	}
+
+	if txn.usageTick == 0 {
+		txn.usageTick = atomic.AddUint64(&u.seed, 1)
+	}
+	txn.usageTick++
+	if txn.usageTick%u.rate == 0 {
+		atomic.AddUint64(&c.reads, u.rate)
+	}
+}
+
+// recordIndexWrites adds the index writes of a committed transaction to the
+// usage counters.
+func (txn *Txn) recordIndexWrites() {
+	u := txn.db.indexUsage
+	if u == nil {
+		return
+	}
+	for key, stats := range txn.indexWrites {
+		if isSystemTable(key.Table) {
+			continue
+		}
+		c := u.index(key.Table, key.Index)
+		atomic.AddUint64(&c.writes, uint64(stats.Inserts+stats.Deletes))
+	}
+}
+
+// IndexUsage returns the usage of every index of the schema, sorted by table
+// and index name. It is empty unless the DB was created with WithIndexUsage.
+func (db *MemDB) IndexUsage() []IndexUsage {
+	u := db.indexUsage
+	if u == nil {
+		return nil
+	}
+
+	var usage []IndexUsage
+	for table, tableSchema := range db.getSchema().Tables {
+		for index := range tableSchema.Indexes {
+			c := u.index(table, index)
+			usage = append(usage, IndexUsage{
+				Table:  table,
+				Index:  index,
+				Reads:  atomic.LoadUint64(&c.reads),
+				Writes: atomic.LoadUint64(&c.writes),
+			})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		a, b := usage[i], usage[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.Index < b.Index
+	})
+	return usage
+}
+
+// UnusedIndexes returns the indexes that are written but were never queried,
+// which cost memory and write amplification for nothing. The id index of a
+// table is never reported, as writes need it.
+func (db *MemDB) UnusedIndexes() []IndexUsage {
+	var unused []IndexUsage
+	for _, usage := range db.IndexUsage() {
+		if usage.Index != id && usage.Writes > 0 && usage.Reads == 0 {
+			unused = append(unused, usage)
+		}
+	}
+	return unused
+}
@@ -0,0 +1,57 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMemDB_IndexUsage(t *testing.T) {
+	db, err := NewMemDB(testValidSchema(), WithIndexUsage(10))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for i := 0; i < 5; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("%d", i), Foo: "x", Qux: []string{"a", "b"}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+
+	txn = db.Txn(false)
+	for i := 0; i < 100; i++ {
+		if _, err := txn.First("main", "foo", "x"); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	usage := db.IndexUsage()
+	if len(usage) != 3 {
+		t.Fatalf("bad: %#v", usage)
+	}
+	foo, qux := usage[0], usage[2]
+	if foo.Index != "foo" || foo.Writes != 5 || foo.Reads < 90 || foo.Reads > 110 {
+		t.Fatalf("bad: %#v", foo)
+	}
+	if qux.Index != "qux" || qux.Writes != 10 || qux.Reads != 0 {
+		t.Fatalf("bad: %#v", qux)
+	}
+	unused := db.UnusedIndexes()
+	if len(unused) != 1 || unused[0].Index != "qux" {
+		t.Fatalf("bad: %#v", unused)
+	}
+
+	// A single query is enough to count as used
+	if _, err := db.Txn(false).Get("main", "qux", "a"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unused := db.UnusedIndexes(); len(unused) != 0 {
+		t.Fatalf("bad: %#v", unused)
+	}
+
+	// Nothing is reported unless enabled
+	if usage := testDB(t).IndexUsage(); usage != nil {
+		t.Fatalf("bad: %#v", usage)
+	}
+}
@@ -0,0 +1,105 @@
+package memdb
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// ObjectVersion is the value of an object as seen by one version of the DB.
+type ObjectVersion struct {
+	// Source is the version the object was read from: "txn" for the
+	// transaction Versions was called on, "current" for the latest commit,
+	// or "snapshot:" followed by the name of a pinned snapshot.
+	Source string
+
+	// Index is the commit index of the version, or zero for the
+	// transaction. It may lag one behind for a commit made while the
+	// version was read.
+	Index uint64
+
+	// Object is the object, or nil if it doesn't exist in the version.
+	Object interface{}
+}
+
+// PinSnapshot takes a Snapshot of the DB and keeps it under the given name,
+// replacing any snapshot pinned with that name, until UnpinSnapshot is
+// called. Pinned snapshots are included in the results of Versions.
+func (db *MemDB) PinSnapshot(name string) *MemDB {
+	snap := db.Snapshot()
+	db.pinLock.Lock()
+	defer db.pinLock.Unlock()
+	if db.pinned == nil {
+		db.pinned = make(map[string]*MemDB)
+	}
+	db.pinned[name] = snap
+	return snap
+}
+
+// UnpinSnapshot releases the snapshot pinned with the given name, if any.
+func (db *MemDB) UnpinSnapshot(name string) {
+	db.pinLock.Lock()
+	defer db.pinLock.Unlock()
+	delete(db.pinned, name)
+}
+
+// Versions returns the object with the given primary key as seen by the
+// transaction, including its uncommitted writes, by the latest commit and by
+// each pinned snapshot, in that order. It is meant for debugging reports of
+// readers seeing stale values, by showing which versions still hold an old
+// one. Snapshots whose schema lacks the table are skipped.
+//
+// Versions 返回同一主键在当前事务、最新提交和各个固定快照中的值，用于排查读到旧值的问题。
+func (txn *Txn) Versions(table string, key ...interface{}) ([]ObjectVersion, error) {
+	obj, err := txn.First(table, id, key...)
+	if err != nil {
+		return nil, err
+	}
+	versions := []ObjectVersion{{Source: "txn", Object: obj}}
+
+	db := txn.db
+	current, err := versionOf(db, table, key)
+	if err != nil {
+		return nil, err
+	}
+	current.Source = "current"
+	versions = append(versions, current)
+
+	db.pinLock.Lock()
+	names := make([]string, 0, len(db.pinned))
+	for name := range db.pinned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	snaps := make([]*MemDB, len(names))
+	for i, name := range names {
+		snaps[i] = db.pinned[name]
+	}
+	db.pinLock.Unlock()
+
+	for i, snap := range snaps {
+		if _, ok := snap.getSchema().Tables[table]; !ok {
+			continue
+		}
+		version, err := versionOf(snap, table, key)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot '%s': %v", names[i], err)
+		}
+		version.Source = "snapshot:" + names[i]
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// versionOf reads the object with the given primary key from the latest
+// commit of db.
+func versionOf(db *MemDB, table string, key []interface{}) (ObjectVersion, error) {
+	// The commit index is only bumped after the root is swapped, so it is
+	// read first to never be ahead of the root.
+	index := atomic.LoadUint64(&db.commitIndex)
+	obj, err := db.Txn(false).First(table, id, key...)
+	if err != nil {
+		return ObjectVersion{}, err
+	}
+	return ObjectVersion{Index: index, Object: obj}, nil
+}
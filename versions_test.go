@@ -0,0 +1,59 @@
+package memdb
+
+import "testing"
+
+func TestTxn_Versions(t *testing.T) {
+	db := testDB(t)
+	v1 := &TestObject{ID: "a", Foo: "v1", Qux: []string{"q"}}
+	txn := db.Txn(true)
+	if err := txn.Insert("main", v1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	db.PinSnapshot("before")
+
+	v2 := &TestObject{ID: "a", Foo: "v2", Qux: []string{"q"}}
+	txn = db.Txn(true)
+	if err := txn.Insert("main", v2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// An in-flight write sees its own version
+	v3 := &TestObject{ID: "a", Foo: "v3", Qux: []string{"q"}}
+	txn = db.Txn(true)
+	defer txn.Abort()
+	if err := txn.Insert("main", v3); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	versions, err := txn.Versions("main", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expect := []ObjectVersion{
+		{Source: "txn", Object: v3},
+		{Source: "current", Index: 2, Object: v2},
+		{Source: "snapshot:before", Index: 1, Object: v1},
+	}
+	if len(versions) != len(expect) {
+		t.Fatalf("bad: %#v", versions)
+	}
+	for i := range expect {
+		if versions[i] != expect[i] {
+			t.Fatalf("bad: %d %#v", i, versions[i])
+		}
+	}
+
+	db.UnpinSnapshot("before")
+	versions, err = txn.Versions("main", "b")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Object != nil || versions[1].Object != nil {
+		t.Fatalf("bad: %#v", versions)
+	}
+
+	if _, err := txn.Versions("nope", "a"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,312 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// walMagic identifies a MemDB write-ahead log file.
+var walMagic = []byte("memdbwal")
+
+// WALOptions configures the write-ahead log enabled with WithWAL.
+type WALOptions struct {
+	// Path is the log file, which is created if it doesn't exist.
+	Path string
+
+	// NoSync skips syncing the log to disk after each commit. Commits are
+	// much faster, but the last ones may be lost if the machine crashes.
+	NoSync bool
+}
+
+// WithWAL makes the DB durable by appending the changes of every commit to
+// an append-only log, using the Codec configured with WithCodec. NewMemDB
+// replays the log when it exists, so a DB created with the same schema and
+// log gets back the state it had when it was last written.
+//
+// A commit is only made visible once its changes are logged. If the log
+// can't be written, the transaction is aborted: TryCommit returns the
+// error, while Commit panics with it, so transactions of a durable DB should
+// be committed with TryCommit. The sequences advanced by NextSequence
+// are logged too, but the other system tables are not, as with snapshots:
+// idempotency keys and advisory locks don't survive a restart.
+//
+// The log holds every commit ever made and is never compacted, so it is
+// best suited to DBs whose history stays reasonably small.
+//
+// WithWAL 启用预写日志，每次提交的变更都会追加写入日志文件，启动时重放。
+func WithWAL(opts WALOptions) Option {
+	return func(db *MemDB) {
+		db.walOptions = &opts
+	}
+}
+
+// wal is an open write-ahead log.
+type wal struct {
+	l      sync.Mutex
+	f      *os.File
+	noSync bool
+
+	// size is the size of the log up to its last complete record.
+	size int64
+}
+
+// openWAL opens the log configured for the DB and replays it. A record cut
+// short by a crash at the end of the log is discarded.
+func (db *MemDB) openWAL() error {
+	opts := db.walOptions
+	if db.codec == nil {
+		return fmt.Errorf("a codec is required for the write-ahead log")
+	}
+	f, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	size, err := db.replayWAL(f)
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if err == nil && size == 0 {
+		_, err = f.Write(walMagic)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open write-ahead log: %v", err)
+	}
+
+	if size == 0 {
+		size = int64(len(walMagic))
+	}
+	db.wal = &wal{f: f, noSync: opts.NoSync, size: size}
+	return nil
+}
+
+// replayWAL applies the records of the log, each in its own transaction,
+// and returns the size of the valid part of the log.
+func (db *MemDB) replayWAL(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat log: %v", err)
+	}
+	r := bufio.NewReaderSize(f, streamChunkSize)
+	magic := make([]byte, len(walMagic))
+	if n, err := io.ReadFull(r, magic); err != nil {
+		if n == 0 && err == io.EOF {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("invalid log header: %v", err)
+	}
+	if !bytes.Equal(magic, walMagic) {
+		return 0, fmt.Errorf("not a write-ahead log")
+	}
+
+	size := int64(len(walMagic))
+	records := 0
+	for {
+		payload, n, err := readWALRecord(r, info.Size()-size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			db.logger.Warn("discarding truncated write-ahead log record",
+				"offset", size, "error", err)
+			break
+		}
+		if err := db.applyWALRecord(payload); err != nil {
+			return 0, fmt.Errorf("failed to replay record at offset %d: %v", size, err)
+		}
+		size += n
+		records++
+	}
+	db.logger.Info("replayed write-ahead log", "records", records)
+	return size, nil
+}
+
+// readWALRecord reads a record of the log and returns its payload and its
+// size in the file. It returns io.EOF if the log ends cleanly. remaining is
+// the size of the rest of the file, which bounds the length of the record so
+// that a corrupt length is reported like a bad checksum instead of being
+// allocated.
+func readWALRecord(r *bufio.Reader, remaining int64) ([]byte, int64, error) {
+	length, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return nil, 0, err
+	}
+	if length > uint64(remaining) {
+		return nil, 0, fmt.Errorf("record length %d exceeds the rest of the log", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, snapshotCRC) != binary.BigEndian.Uint32(sum[:]) {
+		return nil, 0, fmt.Errorf("checksum mismatch")
+	}
+
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], length)
+	return payload, int64(n+len(sum)) + int64(length), nil
+}
+
+// applyWALRecord applies the changes of a logged commit.
+func (db *MemDB) applyWALRecord(payload []byte) error {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	r := bufio.NewReader(bytes.NewReader(payload))
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		table, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		data, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case snapshotRecordDelete:
+			err = txn.deleteByKey(string(table), data)
+		case snapshotRecordRow:
+			var obj interface{}
+			if obj, err = db.codec.Decode(string(table), data); err != nil {
+				return fmt.Errorf("failed to decode object: %v", err)
+			}
+			err = txn.Insert(string(table), obj)
+		case snapshotRecordSequence:
+			var value uint64
+			if value, err = decodeSequence(data); err == nil {
+				txn.setSequence(string(table), value)
+			}
+		default:
+			err = fmt.Errorf("unknown record type %q", kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return txn.TryCommit()
+}
+
+// appendWAL logs the changes of the transaction before it is committed. It
+// is a no-op if the DB has no write-ahead log. The changes are logged in the
+// order they were made rather than collapsed as Changes does, since replaying
+// the last write of each object first could break a foreign key or unique
+// index that the transaction only satisfied along the way.
+func (txn *Txn) appendWAL() error {
+	w := txn.db.wal
+	if w == nil {
+		return nil
+	}
+
+	var body bytes.Buffer
+	count := 0
+	for _, change := range txn.mutations() {
+		if isSystemTable(change.Table) {
+			continue
+		}
+		kind, data := byte(snapshotRecordDelete), change.primaryKey
+		if change.After != nil {
+			var err error
+			kind = snapshotRecordRow
+			if data, err = txn.db.codec.Encode(change.Table, change.After); err != nil {
+				return fmt.Errorf("failed to encode object: %v", err)
+			}
+		}
+		body.WriteByte(kind)
+		writeBytes(&body, []byte(change.Table))
+		writeBytes(&body, data)
+		count++
+	}
+
+	// Sequences are written to their system table without being recorded
+	// as changes, so the ones the transaction advanced are found by
+	// comparing the table with its state when the transaction started
+	var tmp [binary.MaxVarintLen64]byte
+	if seqs, ok := txn.modified[tableIndex{SequencesTable, id}]; ok {
+		before := txn.indexTree(SequencesTable, id)
+		iter := seqs.Root().Iterator()
+		for key, raw, ok := iter.Next(); ok; key, raw, ok = iter.Next() {
+			if old, _ := before.Get(key); old == raw {
+				continue
+			}
+			seq := raw.(*SequenceValue)
+			body.WriteByte(snapshotRecordSequence)
+			writeBytes(&body, []byte(seq.Table))
+			writeBytes(&body, encodeSequence(seq.Value))
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+
+	var payload bytes.Buffer
+	payload.Write(tmp[:binary.PutUvarint(tmp[:], uint64(count))])
+	payload.Write(body.Bytes())
+
+	var record bytes.Buffer
+	record.Write(tmp[:binary.PutUvarint(tmp[:], uint64(payload.Len()))])
+	binary.BigEndian.PutUint32(tmp[:4], crc32.Checksum(payload.Bytes(), snapshotCRC))
+	record.Write(tmp[:4])
+	record.Write(payload.Bytes())
+	return w.append(record.Bytes())
+}
+
+// append writes a record at the end of the log.
+func (w *wal) append(record []byte) error {
+	w.l.Lock()
+	defer w.l.Unlock()
+	if w.f == nil {
+		return fmt.Errorf("write-ahead log is closed")
+	}
+	_, err := w.f.Write(record)
+	if err == nil && !w.noSync {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		// Drop whatever part of the record was written, so that later
+		// records aren't lost behind it on replay.
+		w.f.Truncate(w.size)
+		w.f.Seek(w.size, io.SeekStart)
+		return fmt.Errorf("failed to write to write-ahead log: %v", err)
+	}
+	w.size += int64(len(record))
+	return nil
+}
+
+// close closes the log file.
+func (w *wal) close() error {
+	w.l.Lock()
+	defer w.l.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
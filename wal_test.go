@@ -0,0 +1,257 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMemDB_WAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memdb")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := WALOptions{Path: filepath.Join(dir, "wal")}
+
+	// A codec is required
+	if _, err := NewMemDB(testValidSchema(), WithWAL(opts)); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	db, err := NewMemDB(testValidSchema(), WithCodec(testCodec()), WithWAL(opts))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	objs := []*TestObject{
+		&TestObject{ID: "a", Foo: "x", Qux: []string{"1"}},
+		&TestObject{ID: "b", Foo: "y", Qux: []string{"2"}},
+		&TestObject{ID: "c", Foo: "z", Qux: []string{"3"}},
+	}
+	txn := db.Txn(true)
+	for _, obj := range objs {
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	txn = db.Txn(true)
+	if err := txn.Delete("main", objs[1]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	updated := &TestObject{ID: "c", Foo: "w", Qux: []string{"3"}}
+	if err := txn.Insert("main", updated); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	db.Close()
+
+	// Simulate a crash in the middle of a write
+	f, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	f.Write([]byte{0x20, 1, 2})
+	f.Close()
+
+	check := func(db *MemDB) {
+		txn := db.Txn(false)
+		for _, expect := range []*TestObject{objs[0], nil, updated} {
+			id := "b"
+			if expect != nil {
+				id = expect.ID
+			}
+			raw, err := txn.First("main", "id", id)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if expect == nil && raw != nil || expect != nil && !reflect.DeepEqual(raw, expect) {
+				t.Fatalf("bad: %#v %#v", raw, expect)
+			}
+		}
+	}
+	db, err = NewMemDB(testValidSchema(), WithCodec(testCodec()), WithWAL(opts))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	check(db)
+
+	// Writes after the replay go after the valid part of the log
+	txn = db.Txn(true)
+	if err := txn.Delete("main", updated); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	db.Close()
+	db, err = NewMemDB(testValidSchema(), WithCodec(testCodec()), WithWAL(opts))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw, err := db.Txn(false).First("main", "id", "c"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// A commit that can't be logged is aborted
+	db.wal.close()
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "d", Foo: "x", Qux: []string{"4"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err == nil {
+		t.Fatalf("expected error")
+	}
+	if raw, err := db.Txn(false).First("main", "id", "d"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+
+	// Commit aborts it too, and panics with the error
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "d", Foo: "x", Qux: []string{"4"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expected panic")
+			}
+		}()
+		txn.Commit()
+	}()
+	if raw, err := db.Txn(false).First("main", "id", "d"); err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
+
+func TestMemDB_WAL_CorruptLength(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memdb")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := WALOptions{Path: filepath.Join(dir, "wal")}
+
+	db, err := NewMemDB(testValidSchema(), WithCodec(testCodec()), WithWAL(opts))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	obj := &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}
+	txn := db.Txn(true)
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	db.Close()
+
+	// A tail whose length is corrupt is discarded like a torn record
+	for _, length := range []uint64{1 << 62, 1 << 30, 100} {
+		f, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var tmp [binary.MaxVarintLen64]byte
+		f.Write(tmp[:binary.PutUvarint(tmp[:], length)])
+		f.Write([]byte{1, 2, 3, 4, 5})
+		f.Close()
+
+		db, err = NewMemDB(testValidSchema(), WithCodec(testCodec()), WithWAL(opts))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		raw, err := db.Txn(false).First("main", "id", "a")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !reflect.DeepEqual(raw, obj) {
+			t.Fatalf("bad: %#v", raw)
+		}
+		db.Close()
+	}
+}
+
+func TestMemDB_WAL_Order(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memdb")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := WALOptions{Path: filepath.Join(dir, "wal")}
+	codec := &JSONCodec{
+		Types: map[string]reflect.Type{
+			"teams":   reflect.TypeOf(&testTeam{}),
+			"members": reflect.TypeOf(&testMember{}),
+		},
+	}
+
+	db, err := NewMemDB(testForeignKeySchema(Restrict), WithCodec(codec), WithWAL(opts))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The last write of the team comes after the member referencing it
+	txn := db.Txn(true)
+	if err := txn.Insert("teams", &testTeam{ID: "1", Name: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("members", &testMember{ID: "a", Team: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Insert("teams", &testTeam{ID: "1", Name: "red"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	db.Close()
+
+	db, err = NewMemDB(testForeignKeySchema(Restrict), WithCodec(codec), WithWAL(opts))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer db.Close()
+	raw, err := db.Txn(false).First("members", "team", "red")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw == nil || raw.(*testMember).ID != "a" {
+		t.Fatalf("bad: %#v", raw)
+	}
+}
+
+func TestMemDB_WAL_Sequence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memdb")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := WALOptions{Path: filepath.Join(dir, "wal")}
+
+	db, err := NewMemDB(testValidSchema(), WithCodec(testCodec()), WithWAL(opts))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn := db.Txn(true)
+	for i := 0; i < 2; i++ {
+		if _, err := txn.NextSequence("main"); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := txn.TryCommit(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	db.Close()
+
+	// The sequence carries on after a restart
+	db, err = NewMemDB(testValidSchema(), WithCodec(testCodec()), WithWAL(opts))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer db.Close()
+	txn = db.Txn(true)
+	defer txn.Abort()
+	if n, err := txn.NextSequence("main"); err != nil || n != 3 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+}
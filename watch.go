@@ -141,3 +141,16 @@ func (w WatchSet) WatchCh(ctx context.Context) <-chan error {
 
 	return triggerCh
 }
+
+// WatchCtx waits for a single watch channel, such as the one returned by
+// ResultIterator.WatchCh, to be closed. It returns nil once the channel is
+// closed, or the error of the context if it is done first. A nil channel
+// never fires.
+func WatchCtx(ctx context.Context, ch <-chan struct{}) error {
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
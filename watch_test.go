@@ -255,3 +255,29 @@ func BenchmarkWatch(b *testing.B) {
 		ws.Watch(timeoutCh)
 	}
 }
+
+func TestWatchCtx_Iterator(t *testing.T) {
+	db := testDB(t)
+	iter, err := db.Txn(false).Get("main", "id")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	filtered := NewFilterIterator(iter, func(interface{}) bool { return false })
+
+	// Nothing changed, so the context times out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := filtered.WatchCtx(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("bad: %v", err)
+	}
+
+	txn := db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "a", Foo: "x", Qux: []string{"1"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	if err := WatchCtx(context.Background(), iter.WatchCh()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
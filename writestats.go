@@ -0,0 +1,193 @@
+package memdb
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// IndexWriteStats reports the number of entries a single commit inserted
+// into and deleted from one index, and the number of radix tree nodes it
+// copied to do so. Rewriting an entry to point to a new version of an
+// object counts as an insert.
+//
+// Every entry written copies the path from the root of the index's radix
+// tree down to its leaf, unless those nodes were already copied earlier in
+// the same transaction. Indexes with many more nodes copied than entries
+// written have keys that share few prefixes, which is worth restructuring.
+type IndexWriteStats struct {
+	Table   string
+	Index   string
+	Inserts int
+	Deletes int
+
+	// Copied is the number of nodes of the index's tree after the commit
+	// that are not in the tree before it.
+	Copied int
+}
+
+// WriteStats reports the index entries written and the radix tree nodes
+// copied by a committed write transaction, sorted by table and index name.
+//
+// WriteStats 描述一次提交对各个索引的写入次数，用于诊断写放大。
+type WriteStats struct {
+	// Commit is the index of the commit, as found in the CommitsTable.
+	Commit uint64
+
+	// Objects is the number of object inserts, updates and deletes.
+	Objects int
+
+	Indexes []IndexWriteStats
+}
+
+// WriteStatsFunc is called with the WriteStats of each commit.
+type WriteStatsFunc func(*WriteStats)
+
+// WithWriteStats registers a function that is called after every commit with
+// the number of index entries the commit wrote and of tree nodes it copied,
+// so that indexes causing excessive write amplification can be identified. The function is called
+// after the writer lock has been released, so calls for commits made
+// concurrently may overlap or arrive out of order; WriteStats.Commit gives
+// their order.
+func WithWriteStats(fn WriteStatsFunc) Option {
+	return func(db *MemDB) {
+		db.writeStatsFn = fn
+	}
+}
+
+// countObject records an object mutation if write stats are enabled.
+func (txn *Txn) countObject() {
+	txn.countObjects(1)
+}
+
+// countObjects records n object mutations, for the transaction limits and
+// for write stats if they are enabled.
+func (txn *Txn) countObjects(n int) {
+	txn.limitOps += n
+	if txn.db.writeStatsFn == nil {
+		return
+	}
+	if txn.writeStats == nil {
+		txn.writeStats = &WriteStats{}
+	}
+	txn.writeStats.Objects += n
+}
+
+// countWrite records index entry inserts and deletes if write stats are
+// enabled, or alarms or index usage tracking need them.
+func (txn *Txn) countWrite(table, index string, inserts, deletes int) {
+	if txn.db.writeStatsFn == nil && len(txn.db.alarms) == 0 && txn.db.indexUsage == nil {
+		return
+	}
+	stats := txn.indexWriteStats(table, index)
+	stats.Inserts += inserts
+	stats.Deletes += deletes
+}
+
+// indexWriteStats returns the write stats of the index, creating them if
+// needed.
+func (txn *Txn) indexWriteStats(table, index string) *IndexWriteStats {
+	if txn.writeStats == nil {
+		txn.writeStats = &WriteStats{}
+	}
+	if txn.indexWrites == nil {
+		txn.indexWrites = make(map[tableIndex]*IndexWriteStats)
+	}
+
+	key := tableIndex{table, index}
+	stats, ok := txn.indexWrites[key]
+	if !ok {
+		stats = &IndexWriteStats{Table: table, Index: index}
+		txn.indexWrites[key] = stats
+	}
+	return stats
+}
+
+// countCopied records the nodes copied by the commit of an index, if write
+// stats are enabled. Comparing the trees only visits the copied nodes and
+// their children.
+func (txn *Txn) countCopied(key tableIndex, before, after *iradix.Tree) {
+	if txn.db.writeStatsFn == nil {
+		return
+	}
+	var old *iradix.Node
+	if before != nil {
+		old = before.Root()
+	}
+	if copied := copiedNodes(reflect.ValueOf(old), reflect.ValueOf(after.Root()), nil); copied > 0 {
+		txn.indexWriteStats(key.Table, key.Index).Copied += copied
+	}
+}
+
+// copiedNodes returns the number of nodes of the subtree of node, found at
+// the given path, that are not in the tree of the old root. The nodes of the
+// radix trees are not exported, so they are read with reflection. A node
+// shared by both trees is always found at the same path in each, as copying
+// a node keeps the nodes below it in place.
+func copiedNodes(old, node reflect.Value, path []byte) int {
+	if node.Pointer() == nodeAt(old, path) {
+		return 0
+	}
+	copied := 1
+	edges := node.Elem().FieldByName("edges")
+	for i := 0; i < edges.Len(); i++ {
+		child := edges.Index(i).FieldByName("node")
+		prefix := child.Elem().FieldByName("prefix").Bytes()
+		copied += copiedNodes(old, child, append(path[:len(path):len(path)], prefix...))
+	}
+	return copied
+}
+
+// nodeAt returns the address of the node of the tree found at the given
+// path, or 0 if there is none.
+func nodeAt(node reflect.Value, path []byte) uintptr {
+	for !node.IsNil() {
+		if len(path) == 0 {
+			return node.Pointer()
+		}
+		edges := node.Elem().FieldByName("edges")
+		var child reflect.Value
+		for i := 0; i < edges.Len(); i++ {
+			if byte(edges.Index(i).FieldByName("label").Uint()) == path[0] {
+				child = edges.Index(i).FieldByName("node")
+				break
+			}
+		}
+		if !child.IsValid() {
+			return 0
+		}
+		prefix := child.Elem().FieldByName("prefix").Bytes()
+		if !bytes.HasPrefix(path, prefix) {
+			return 0
+		}
+		node, path = child, path[len(prefix):]
+	}
+	return 0
+}
+
+// finalWriteStats returns the WriteStats of the transaction for the given
+// commit, or nil if write stats are not enabled.
+func (txn *Txn) finalWriteStats(commit uint64) *WriteStats {
+	if txn.db.writeStatsFn == nil {
+		return nil
+	}
+
+	stats := txn.writeStats
+	if stats == nil {
+		stats = &WriteStats{}
+	}
+	stats.Commit = commit
+	for _, index := range txn.indexWrites {
+		stats.Indexes = append(stats.Indexes, *index)
+	}
+	sort.Slice(stats.Indexes, func(i, j int) bool {
+		a, b := stats.Indexes[i], stats.Indexes[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.Index < b.Index
+	})
+	return stats
+}
@@ -0,0 +1,163 @@
+package memdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMemDB_WriteStats(t *testing.T) {
+	var reports []*WriteStats
+	db, err := NewMemDB(testValidSchema(), WithWriteStats(func(stats *WriteStats) {
+		reports = append(reports, stats)
+	}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	obj := &TestObject{
+		ID:  "my-object",
+		Foo: "abc",
+		Qux: []string{"abc1", "abc2"},
+	}
+	txn := db.Txn(true)
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// Update the object and then delete it
+	obj2 := &TestObject{
+		ID:  "my-object",
+		Foo: "xyz",
+		Qux: []string{"abc1", "abc2"},
+	}
+	txn = db.Txn(true)
+	if err := txn.Insert("main", obj2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := txn.Delete("main", obj2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// Aborted transactions are not reported
+	txn = db.Txn(true)
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Abort()
+
+	expected := []*WriteStats{
+		&WriteStats{
+			Commit:  1,
+			Objects: 1,
+			Indexes: []IndexWriteStats{
+				{Table: "main", Index: "foo", Inserts: 1, Copied: 2},
+				{Table: "main", Index: "id", Inserts: 1, Copied: 2},
+				{Table: "main", Index: "qux", Inserts: 2, Copied: 4},
+			},
+		},
+		&WriteStats{
+			Commit:  2,
+			Objects: 2,
+			Indexes: []IndexWriteStats{
+				{Table: "main", Index: "foo", Inserts: 1, Deletes: 2, Copied: 1},
+				{Table: "main", Index: "id", Inserts: 1, Deletes: 1, Copied: 1},
+				{Table: "main", Index: "qux", Inserts: 2, Deletes: 2, Copied: 1},
+			},
+		},
+	}
+	if !reflect.DeepEqual(reports, expected) {
+		t.Fatalf("bad: %#v", reports)
+	}
+}
+
+func TestMemDB_WriteStats_UnchangedIndexes(t *testing.T) {
+	var reports []*WriteStats
+	db, err := NewMemDB(testValidSchema(), WithWriteStats(func(stats *WriteStats) {
+		reports = append(reports, stats)
+	}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	obj := &TestObject{ID: "my-object", Foo: "abc", Qux: []string{"abc1", "abc2"}}
+	if err := txn.Insert("main", obj); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	// Reordering the values of a multi-value index doesn't delete them
+	txn = db.Txn(true)
+	obj2 := &TestObject{ID: "my-object", Foo: "abc", Qux: []string{"abc3", "abc2", "abc1"}}
+	if err := txn.Insert("main", obj2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+
+	expected := []IndexWriteStats{
+		{Table: "main", Index: "foo", Inserts: 1, Copied: 2},
+		{Table: "main", Index: "id", Inserts: 1, Copied: 2},
+		{Table: "main", Index: "qux", Inserts: 3, Copied: 5},
+	}
+	if !reflect.DeepEqual(reports[1].Indexes, expected) {
+		t.Fatalf("bad: %#v", reports[1].Indexes)
+	}
+
+	// The unchanged index entries point to the new object
+	raw, err := db.Txn(false).First("main", "qux", "abc1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw != obj2 {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// Inserting the same object again writes no index entries
+	txn = db.Txn(true)
+	if err := txn.Insert("main", obj2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	if len(reports[2].Indexes) != 0 {
+		t.Fatalf("bad: %#v", reports[2].Indexes)
+	}
+}
+
+func TestMemDB_WriteStats_Copied(t *testing.T) {
+	var last *WriteStats
+	db, err := NewMemDB(testValidSchema(), WithWriteStats(func(stats *WriteStats) {
+		last = stats
+	}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txn := db.Txn(true)
+	for i := 0; i < 1000; i++ {
+		obj := &TestObject{ID: fmt.Sprintf("object-%04d", i), Foo: "abc", Qux: []string{"abc"}}
+		if err := txn.Insert("main", obj); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	txn.Commit()
+	for _, stats := range last.Indexes {
+		if stats.Copied < 1000 {
+			t.Fatalf("bad: %#v", stats)
+		}
+	}
+
+	// Updating a single object only copies the paths to its entries
+	txn = db.Txn(true)
+	if err := txn.Insert("main", &TestObject{ID: "object-0500", Foo: "xyz", Qux: []string{"abc"}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txn.Commit()
+	for _, stats := range last.Indexes {
+		if stats.Copied == 0 || stats.Copied > 10 {
+			t.Fatalf("bad: %#v", stats)
+		}
+	}
+}